@@ -6,14 +6,53 @@
 package main
 
 import (
+	"flag"
+	"os"
+
 	"github.com/newrelic/nri-flex/internal/load"
 	"github.com/newrelic/nri-flex/internal/runtime"
+	"github.com/newrelic/nri-flex/internal/schema"
 )
 
 func main() {
+	command := popSubcommand(&os.Args)
+
 	runtime.CommonPreInit()
 
+	switch command {
+	case validateCommand:
+		if runtime.Validate(os.Stdout) > 0 {
+			os.Exit(1)
+		}
+		return
+	case testCommand:
+		if flag.NArg() > 0 {
+			load.Args.ConfigFile = flag.Arg(0)
+		}
+		failed, err := runtime.RunFixtureTests(os.Stdout, load.Args.Fixtures)
+		if err != nil {
+			load.Logrus.WithError(err).Fatal("flex: failed to run tests")
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
+		return
+	case schemaCommand:
+		if _, err := os.Stdout.Write(schema.Schema); err != nil {
+			load.Logrus.WithError(err).Fatal("flex: failed to write schema")
+		}
+		return
+	}
+
 	i := runtime.GetFlexRuntime()
+	if load.Args.Daemon {
+		err := runtime.RunFlexDaemon(i)
+		if err != nil {
+			load.Logrus.WithError(err).Fatal("flex: failed to run daemon")
+		}
+		return
+	}
+
 	err := runtime.RunFlex(i)
 	if err != nil {
 		load.Logrus.WithError(err).Fatal("flex: failed to run runtime")
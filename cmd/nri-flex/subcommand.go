@@ -0,0 +1,41 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import "strings"
+
+// subcommands that replace the default collection run
+const (
+	validateCommand = "validate" // strictly validate configs and exit non-zero on any problem
+	schemaCommand   = "schema"   // print the json schema of the config format
+	testCommand     = "test"     // run configs against recorded inputs and compare with the expected samples
+)
+
+var subcommands = map[string]bool{
+	validateCommand: true,
+	schemaCommand:   true,
+	testCommand:     true,
+}
+
+// popSubcommand removes a leading subcommand from args
+// flag parsing stops at the first non-flag argument, so it has to be removed before the integration parses its arguments
+// operands given right after the subcommand, eg. nri-flex test config.yml -fixtures dir, are moved after the flags
+// so they can still be read with flag.Args
+func popSubcommand(args *[]string) string {
+	if len(*args) < 2 || !subcommands[(*args)[1]] {
+		return ""
+	}
+	command := (*args)[1]
+	rest := (*args)[2:]
+
+	operands := 0
+	for operands < len(rest) && !strings.HasPrefix(rest[operands], "-") {
+		operands++
+	}
+	reordered := append([]string{(*args)[0]}, rest[operands:]...)
+	*args = append(reordered, rest[:operands]...)
+	return command
+}
@@ -0,0 +1,26 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPopSubcommand(t *testing.T) {
+	args := []string{"nri-flex", "validate", "-config_dir", "configs/"}
+	assert.Equal(t, validateCommand, popSubcommand(&args))
+	assert.Equal(t, []string{"nri-flex", "-config_dir", "configs/"}, args)
+
+	args = []string{"nri-flex", "test", "redis.yml", "-fixtures", "fixtures/"}
+	assert.Equal(t, testCommand, popSubcommand(&args))
+	assert.Equal(t, []string{"nri-flex", "-fixtures", "fixtures/", "redis.yml"}, args)
+
+	args = []string{"nri-flex", "-config_dir", "configs/"}
+	assert.Equal(t, "", popSubcommand(&args))
+	assert.Equal(t, []string{"nri-flex", "-config_dir", "configs/"}, args)
+}
@@ -80,7 +80,7 @@ func runConfigFile(t *testing.T, configFile string) []*metric.Set {
 	config.LoadFiles(&configs, files, path)
 
 	// and we run the flex integration
-	config.RunFiles(&configs)
+	config.RunFiles(nil, &configs)
 
 	// then we return the metrics generated by the integration
 	return load.Entity.Metrics
@@ -17,6 +17,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/newrelic/nri-flex/internal/inputs"
 	"github.com/newrelic/nri-flex/internal/load"
 	"github.com/newrelic/nri-flex/internal/processor"
 	"github.com/sirupsen/logrus"
@@ -109,12 +110,18 @@ func LoadFile(configs *[]load.Config, f os.FileInfo, dirPath string) error {
 		}).Error("config: failed to read config file")
 		return err
 	}
+	return LoadBytes(configs, b, f.Name(), dirPath)
+}
+
+// LoadBytes loads the configs of a config file already read into b, fileName and dirPath locate it
+func LoadBytes(configs *[]load.Config, b []byte, fileName string, dirPath string) error {
+	filePath := path.Join(dirPath, fileName)
 
 	ymlStr := string(b)
 	SubEnvVariables(&ymlStr)
 	SubTimestamps(&ymlStr, time.Now())
 
-	err = LoadV4IntegrationConfig(ymlStr, configs, f.Name(), dirPath)
+	err := LoadV4IntegrationConfig(ymlStr, configs, fileName, dirPath)
 	if err != nil {
 		// load old configuration
 		if errors.Is(err, errNoV4IntegrationsFound) {
@@ -128,7 +135,7 @@ func LoadFile(configs *[]load.Config, f os.FileInfo, dirPath string) error {
 
 			applyFlexMeta(&config)
 
-			config.FileName = f.Name()
+			config.FileName = fileName
 			config.FilePath = dirPath
 			if config.Name == "" {
 				load.Logrus.WithFields(logrus.Fields{
@@ -190,6 +197,12 @@ func checkIngestConfigs(config *load.Config) {
 	}
 }
 
+// keepRun sets the context and state of the current run of cfg on the config replacing it
+func keepRun(replacement *load.Config, cfg *load.Config) {
+	replacement.SetContext(cfg.Context())
+	replacement.SetState(cfg.State())
+}
+
 // ReadYML Unmarshals yml files
 func ReadYML(yml string) (load.Config, error) {
 	c := load.Config{}
@@ -202,6 +215,11 @@ func ReadYML(yml string) (load.Config, error) {
 
 // Run Action each config file
 func Run(yml load.Config) {
+	run(yml, new(string))
+}
+
+// run processes the apis of a config, currentAPI is set to the api being processed
+func run(yml load.Config, currentAPI *string) {
 	// samplesToMerge := map[string][]interface{}{}
 	var samplesToMerge load.SamplesToMerge
 	samplesToMerge.Data = map[string][]interface{}{}
@@ -215,6 +233,10 @@ func Run(yml load.Config) {
 
 	// intentionally handled synchronously
 	for i := range yml.APIs {
+		if yml.Context().Err() != nil {
+			break
+		}
+		*currentAPI = yml.APIs[i].Name
 		if err := runVariableProcessor(&yml); err != nil {
 			load.Logrus.WithError(err).Error("config: variable processor error")
 		}
@@ -254,15 +276,18 @@ func RunAsync(yml load.Config, samplesToMerge *load.SamplesToMerge, originalAPIN
 		"apis":       yml.APIs[0].Name,
 	}).Debug("API Async Throttle Setting: ")
 
+	var panics load.Panics
 	for i := range yml.APIs {
 		rl.Take()
 		go func(originalAPINo int, i int) {
 			defer wgapi.Done()
+			defer panics.Recover(yml.APIs[i].Name)
 			dataSets := FetchData(i, &yml, samplesToMerge)
 			processor.RunDataHandler(dataSets, samplesToMerge, i, &yml, originalAPINo)
 		}(originalAPINo, i)
 	}
 	wgapi.Wait()
+	panics.Raise()
 
 	load.Logrus.WithFields(logrus.Fields{
 		"name": yml.Name,
@@ -286,6 +311,9 @@ func RunSync(yml load.Config, samplesToMerge *load.SamplesToMerge, originalAPINo
 	_ = loadSecrets(&yml)
 
 	for i := range yml.APIs {
+		if yml.Context().Err() != nil {
+			break
+		}
 		dataSets := FetchData(i, &yml, samplesToMerge)
 		processor.RunDataHandler(dataSets, samplesToMerge, i, &yml, originalAPINo)
 	}
@@ -301,18 +329,24 @@ func RunSync(yml load.Config, samplesToMerge *load.SamplesToMerge, originalAPINo
 	// processor.ProcessSamplesMergeJoin(&samplesToMerge, &yml)
 }
 
-// RunFiles Processes yml files
-func RunFiles(configs *[]load.Config) []error {
+// RunFiles Processes yml files against state, nil for the state of the process
+func RunFiles(state *load.State, configs *[]load.Config) []error {
 	var errors []error
-	if load.Args.ProcessConfigsSync {
+	for n := range *configs {
+		(*configs)[n].SetState(state)
+	}
+	if state.Args().ProcessConfigsSync {
 		for _, cfg := range *configs {
-			err := verifyConfig(cfg)
+			err := verifyConfig(&cfg)
 			if err != nil {
 				errors = append(errors, err)
+				state.ConfigError(cfg.Name, "", err)
 			} else {
 				load.Logrus.WithFields(logrus.Fields{"name": cfg.Name}).Debug("config: running sync")
-				Run(cfg)
-				load.StatusCounterIncrement("ConfigsProcessed")
+				start := time.Now()
+				runGuarded(cfg)
+				state.ConfigRunCompleted(&cfg, start)
+				state.StatusCounterIncrement("ConfigsProcessed")
 			}
 		}
 	} else {
@@ -327,8 +361,8 @@ func RunFiles(configs *[]load.Config) []error {
 		}()
 
 		rl := ratelimit.NewUnlimited()
-		if load.Args.AsyncRate != 0 {
-			rl = ratelimit.New(load.Args.AsyncRate)
+		if state.Args().AsyncRate != 0 {
+			rl = ratelimit.New(state.Args().AsyncRate)
 		}
 		var wg sync.WaitGroup
 		wg.Add(len(*configs))
@@ -336,13 +370,16 @@ func RunFiles(configs *[]load.Config) []error {
 			rl.Take()
 			go func(cfg load.Config) {
 				defer wg.Done()
-				err := verifyConfig(cfg)
+				err := verifyConfig(&cfg)
 				if err != nil {
 					errorChannel <- err
+					state.ConfigError(cfg.Name, "", err)
 				} else {
 					load.Logrus.WithFields(logrus.Fields{"name": cfg.Name}).Debug("config: running async")
-					Run(cfg)
-					load.StatusCounterIncrement("ConfigsProcessed")
+					start := time.Now()
+					runGuarded(cfg)
+					state.ConfigRunCompleted(&cfg, start)
+					state.StatusCounterIncrement("ConfigsProcessed")
 				}
 			}(cfg)
 		}
@@ -352,14 +389,15 @@ func RunFiles(configs *[]load.Config) []error {
 	}
 
 	load.Logrus.WithFields(logrus.Fields{
-		"configs": load.StatusCounterRead("ConfigsProcessed"),
+		"configs": state.StatusCounterRead("ConfigsProcessed"),
 	}).Info("flex: completed processing configs")
 
 	return errors
 }
 
 // verifyConfig ensure the config file doesn't have anything it should not run
-func verifyConfig(cfg load.Config) error {
+// and sets the fields of its apis from their input blocks, on a copy of the apis as configs are shared between runs
+func verifyConfig(cfg *load.Config) error {
 	if strings.HasPrefix(cfg.FileName, "cd-") && !cfg.ContainerDiscovery.ReplaceComplete {
 		return fmt.Errorf("config: failed to apply discovery to config: '%s'", cfg.Name)
 	}
@@ -371,6 +409,17 @@ func verifyConfig(cfg load.Config) error {
 	if strings.Contains(ymlStr, "${auto:host}") || strings.Contains(ymlStr, "${auto:port}") {
 		return fmt.Errorf("config: cannot have 'auto' token replacements: '%s'", cfg.Name)
 	}
+	apis := make([]load.API, len(cfg.APIs))
+	for i, api := range cfg.APIs {
+		if err := inputs.Configure(&api); err != nil {
+			return fmt.Errorf("config: api %s of '%s', %v", api.Name, cfg.Name, err)
+		}
+		if err := inputs.VerifyOAuth2(*cfg, api); err != nil {
+			return fmt.Errorf("config: api %s of '%s', %v", api.Name, cfg.Name, err)
+		}
+		apis[i] = api
+	}
+	cfg.APIs = apis
 	return nil
 }
 
@@ -404,6 +453,7 @@ func runVariableProcessor(cfg *load.Config) error {
 		if err != nil {
 			return fmt.Errorf("config %s: variable processor read yml failed, error: %v", cfg.Name, err)
 		}
+		keepRun(&newCfg, cfg)
 		*cfg = newCfg
 	}
 	return nil
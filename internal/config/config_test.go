@@ -77,7 +77,7 @@ func TestConfigDir(t *testing.T) {
 	}
 	require.Empty(t, errs)
 
-	errs = RunFiles(&ymls)
+	errs = RunFiles(nil, &ymls)
 	for _, err = range errs {
 		assert.NoError(t, err)
 	}
@@ -115,7 +115,7 @@ func TestConfigFile(t *testing.T) {
 	}
 	require.Empty(t, errs)
 
-	RunFiles(&ymls)
+	RunFiles(nil, &ymls)
 
 	jsonFile, _ := ioutil.ReadFile(path.Join("..", "..", "test", "payloadsExpected", "configFile.json"))
 	var expectedOutput []metric.Set
@@ -145,7 +145,7 @@ func TestV4ConfigFile(t *testing.T) {
 	files = append(files, file)
 
 	LoadFiles(&ymls, files, filePath) // load standard configs if available
-	RunFiles(&ymls)
+	RunFiles(nil, &ymls)
 
 	jsonFile, _ := ioutil.ReadFile(path.Join("..", "..", "test", "payloadsExpected", "configFileV4.json"))
 	var expectedOutput []metric.Set
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/newrelic/nri-flex/internal/inputs"
 	"github.com/newrelic/nri-flex/internal/load"
@@ -18,54 +19,32 @@ import (
 
 var lookupsRegex = regexp.MustCompile(`\${lookup\.([^:]+):([^}]+)}`)
 
-// FetchData fetches data from various inputs
-// Also handles paginated responses for HTTP requests (tested against NR APIs)
+// FetchData fetches data from the registered input that matches the api
 func FetchData(apiNo int, yml *load.Config, samplesToMerge *load.SamplesToMerge) []interface{} {
 	load.Logrus.WithFields(logrus.Fields{
 		"name": yml.Name,
 	}).Debug("fetch: collect data")
 
 	api := yml.APIs[apiNo]
-	file := api.File
-	reqURL := api.URL
-
-	doLoop := true
 	var dataStore []interface{}
 
 	continueProcessing := FetchLookups(yml, apiNo, samplesToMerge)
 
-	if continueProcessing {
-		if file != "" {
-			err := inputs.ProcessFile(&dataStore, yml, apiNo)
-			if err != nil {
-				load.Logrus.WithFields(logrus.Fields{
-					"name": yml.Name,
-					"file": file,
-				}).WithError(err).Error("fetch: failed to process file")
-			}
-		} else if api.Cache != "" {
-			if yml.Datastore[api.Cache] != nil {
-				dataStore = yml.Datastore[api.Cache]
-			}
-		} else if api.Ingest {
-			if yml.Datastore["IngestData"] != nil {
-				dataStore = yml.Datastore["IngestData"]
-			}
-		} else if len(api.Commands) > 0 && api.Database == "" && api.DBConn == "" {
-			inputs.RunCommands(&dataStore, yml, apiNo)
-		} else if reqURL != "" {
-			inputs.RunHTTP(&dataStore, &doLoop, yml, api, &reqURL)
-		} else if api.Database != "" && api.DBConn != "" {
-			inputs.ProcessQueries(&dataStore, yml, apiNo)
-		} else if api.Scp.Host != "" {
-			err := inputs.RunScpWithTimeout(&dataStore, yml, api)
-			if err != nil {
-				load.Logrus.WithFields(logrus.Fields{
-					"name": yml.Name,
-					"host": api.Scp.Host,
-				}).WithError(err).Error("fetch: failed to process remote file")
-			}
+	if input := inputs.Lookup(api); continueProcessing && input != nil {
+		fetchStart := time.Now()
+		if err := input.Fetch(&dataStore, yml, apiNo); err != nil {
+			load.Logrus.WithFields(logrus.Fields{
+				"name":  yml.Name,
+				"api":   api.Name,
+				"input": input.Name(),
+			}).WithError(err).Error("fetch: failed to fetch data")
+			yml.State().ConfigError(yml.Name, api.Name, err)
 		}
+		fetchDurationMs := time.Since(fetchStart).Milliseconds()
+		yml.State().APIStatusUpdate(yml.Name, api.Name, func(status *load.APIStatus) {
+			status.InputType = input.Name()
+			status.FetchDurationMs += fetchDurationMs
+		})
 	}
 
 	// cache output into datastore for later use
@@ -126,6 +105,7 @@ func FetchLookups(cfg *load.Config, apiNo int, samplesToMerge *load.SamplesToMer
 		VariableStore:    cfg.VariableStore,
 		CustomAttributes: cfg.CustomAttributes,
 	}
+	keepRun(&lookupConfig, cfg)
 
 	for _, newAPI := range newAPIs {
 		if strings.Contains(newAPI, "${lookup") {
@@ -281,19 +261,17 @@ func automaticLookup(tmpCfgStr string, cfg *load.Config, apiNo int) []string {
 			continue
 		}
 
-		for _, entity := range load.Integration.Entities {
-			for _, sample := range entity.Metrics {
-				if sample.Metrics["event_type"] == eventType { // if the event matches create a new sample
-					create, sampleStr := createLookupSample(tmpCfgStr, eventType, sample.Metrics, &dedupeCheck, cfg.APIs[apiNo].DedupeLookups)
-					if create {
-						newAPIs = append(newAPIs, sampleStr)
-					}
+		for _, sample := range cfg.State().MetricSets() {
+			if sample.Metrics["event_type"] == eventType { // if the event matches create a new sample
+				create, sampleStr := createLookupSample(tmpCfgStr, eventType, sample.Metrics, &dedupeCheck, cfg.APIs[apiNo].DedupeLookups)
+				if create {
+					newAPIs = append(newAPIs, sampleStr)
 				}
 			}
 		}
 
 		// checked ignored data
-		for _, sample := range load.IgnoredIntegrationData {
+		for _, sample := range cfg.State().IgnoredSamples() {
 			if sample["event_type"] == eventType { // if the event matches create a new sample
 				create, sampleStr := createLookupSample(tmpCfgStr, eventType, sample, &dedupeCheck, cfg.APIs[apiNo].DedupeLookups)
 				if create {
@@ -0,0 +1,98 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/nri-flex/internal/load"
+	"github.com/sirupsen/logrus"
+)
+
+// error types of the flexError event
+const (
+	runErrorPanic   = "panic"
+	runErrorTimeout = "timeout"
+)
+
+// runGuarded runs a config within its time budget, and stops it when the context of the config is done
+// a panic or an exceeded timeout is reported as a flexError event naming the config and api, and does not affect other configs
+func runGuarded(cfg load.Config) {
+	ctx, cancel := context.WithCancel(cfg.Context())
+	timeout := configTimeout(cfg)
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	defer cancel()
+	cfg.SetContext(ctx)
+
+	var currentAPI string
+	defer func() {
+		if r := recover(); r != nil {
+			p := load.NewPanic(currentAPI, r)
+			load.Logrus.WithFields(logrus.Fields{
+				"name":  cfg.Name,
+				"api":   p.API,
+				"stack": string(p.Stack),
+			}).Errorf("config: recovered from panic, %v", p.Value)
+			reportRunError(cfg.State(), cfg.Name, p.API, runErrorPanic, p)
+		}
+	}()
+
+	run(cfg, &currentAPI)
+
+	if timeout > 0 && ctx.Err() == context.DeadlineExceeded {
+		load.Logrus.WithFields(logrus.Fields{
+			"name":    cfg.Name,
+			"api":     currentAPI,
+			"timeout": timeout,
+		}).Error("config: run exceeded its timeout, remaining apis were skipped")
+		reportRunError(cfg.State(), cfg.Name, currentAPI, runErrorTimeout, fmt.Errorf("run exceeded its timeout of %v", timeout))
+	}
+}
+
+// configTimeout returns the time budget of a config run, the config timeout takes precedence over config_timeout
+// zero means no time budget
+func configTimeout(cfg load.Config) time.Duration {
+	value := cfg.Timeout
+	if value == "" {
+		value = cfg.State().Args().ConfigTimeout
+	}
+	if value == "" {
+		return 0
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		load.Logrus.WithFields(logrus.Fields{
+			"name":    cfg.Name,
+			"timeout": value,
+		}).Warn("config: invalid timeout, running without one")
+		return 0
+	}
+	return timeout
+}
+
+// reportRunError creates a flexError event for a config run that failed
+func reportRunError(state *load.State, config string, api string, errorType string, err error) {
+	state.ConfigError(config, api, err)
+	state.StatusCounterIncrement("EventCount")
+	state.StatusCounterIncrement("flexError")
+
+	errorMetricSet := state.Entity().NewMetricSet("flexError")
+	setRunErrorMetric(errorMetricSet, "config", config)
+	setRunErrorMetric(errorMetricSet, "api", api)
+	setRunErrorMetric(errorMetricSet, "errorType", errorType)
+	setRunErrorMetric(errorMetricSet, "error", err.Error())
+}
+
+func setRunErrorMetric(metricSet *metric.Set, name string, value string) {
+	if err := metricSet.SetMetric(name, value, metric.ATTRIBUTE); err != nil {
+		load.Logrus.WithError(err).Error("config: failed to set flexError metric")
+	}
+}
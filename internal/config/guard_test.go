@@ -0,0 +1,124 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package config
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/nri-flex/internal/load"
+)
+
+// samplesByEventType returns the attributes of the samples of an event type
+func samplesByEventType(eventType string) []metric.Set {
+	var samples []metric.Set
+	for _, sample := range load.Entity.Metrics {
+		if sample.Metrics["event_type"] == eventType {
+			samples = append(samples, *sample)
+		}
+	}
+	return samples
+}
+
+func TestRunFilesRecoversFromPanic(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses unix commands")
+	}
+	for _, async := range []bool{false, true} {
+		load.Refresh()
+		load.Args.ProcessConfigsSync = !async
+		i, _ := integration.New(load.IntegrationName, load.IntegrationVersion)
+		load.Entity, _ = i.Entity("TestRunFilesRecoversFromPanic", "nri-flex")
+
+		configs := []load.Config{
+			{
+				Name: "broken",
+				APIs: []load.API{{
+					Name:       "unwritable",
+					Commands:   []load.Command{{Run: "echo value:1"}},
+					SaveOutput: "/nonexistent/dir/output.json", // StoreJSON panics when it cannot write
+				}},
+			},
+			{
+				Name: "healthy",
+				APIs: []load.API{{
+					Name:     "status",
+					Commands: []load.Command{{Run: "echo value:2"}},
+				}},
+			},
+		}
+		errs := RunFiles(nil, &configs)
+		assert.Empty(t, errs)
+
+		assert.Len(t, samplesByEventType("statusSample"), 1, "other configs must still produce samples")
+		flexErrors := samplesByEventType("flexError")
+		require.Len(t, flexErrors, 1)
+		assert.Equal(t, "broken", flexErrors[0].Metrics["config"])
+		assert.Equal(t, "unwritable", flexErrors[0].Metrics["api"])
+		assert.Equal(t, runErrorPanic, flexErrors[0].Metrics["errorType"])
+		assert.Contains(t, flexErrors[0].Metrics["error"], "failed to write file")
+	}
+	load.Args.ProcessConfigsSync = false
+}
+
+func TestRunFilesTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses unix commands")
+	}
+	load.Refresh()
+	i, _ := integration.New(load.IntegrationName, load.IntegrationVersion)
+	load.Entity, _ = i.Entity("TestRunFilesTimeout", "nri-flex")
+
+	configs := []load.Config{{
+		Name:    "slow",
+		Timeout: "200ms",
+		APIs: []load.API{
+			{Name: "sleepy", Commands: []load.Command{{Run: "sleep 5", Timeout: 10000}}},
+			{Name: "skipped", Commands: []load.Command{{Run: "echo value:1"}}},
+		},
+	}}
+
+	start := time.Now()
+	RunFiles(nil, &configs)
+	assert.Less(t, int64(time.Since(start)), int64(3*time.Second), "the command must be cancelled with the run")
+
+	assert.Empty(t, samplesByEventType("skippedSample"))
+	flexErrors := samplesByEventType("flexError")
+	require.Len(t, flexErrors, 1)
+	assert.Equal(t, "slow", flexErrors[0].Metrics["config"])
+	assert.Equal(t, "sleepy", flexErrors[0].Metrics["api"])
+	assert.Equal(t, runErrorTimeout, flexErrors[0].Metrics["errorType"])
+}
+
+func TestConfigTimeout(t *testing.T) {
+	defer func() { load.Args.ConfigTimeout = "" }()
+
+	tests := map[string]struct {
+		timeout        string
+		defaultTimeout string
+		expected       time.Duration
+	}{
+		"unset":            {"", "", 0},
+		"config":           {"30s", "", 30 * time.Second},
+		"default":          {"", "2m", 2 * time.Minute},
+		"config over args": {"10s", "2m", 10 * time.Second},
+		"invalid":          {"abc", "", 0},
+		"negative":         {"-1s", "", 0},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			load.Args.ConfigTimeout = tc.defaultTimeout
+			assert.Equal(t, tc.expected, configTimeout(load.Config{Name: name, Timeout: tc.timeout}))
+		})
+	}
+}
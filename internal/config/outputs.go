@@ -0,0 +1,35 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/newrelic/nri-flex/internal/load"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// LoadOutputs reads the outputs section of an outputs file
+// environment variables are substituted as in config files, so keys can be kept out of the file with $$MY_ENV_VAR
+func LoadOutputs(filePath string) ([]load.OutputConfig, error) {
+	b, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("config: failed to read outputs file, %v", err)
+	}
+
+	ymlStr := string(b)
+	SubEnvVariables(&ymlStr)
+
+	var outputs load.Outputs
+	if err := yaml.UnmarshalStrict([]byte(ymlStr), &outputs); err != nil {
+		return nil, fmt.Errorf("config: failed to parse outputs file %s, %v", filePath, err)
+	}
+	if len(outputs.Outputs) == 0 {
+		return nil, fmt.Errorf("config: outputs file %s lists no outputs", filePath)
+	}
+	return outputs.Outputs, nil
+}
@@ -0,0 +1,43 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/newrelic/nri-flex/internal/load"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadOutputs(t *testing.T) {
+	os.Setenv("TEST_OUTPUTS_KEY", "secret")
+	defer os.Unsetenv("TEST_OUTPUTS_KEY")
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "outputs.yml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`
+outputs:
+  - type: stdout
+  - type: metric_api
+    api_key: $$TEST_OUTPUTS_KEY
+`), 0644))
+
+	outputs, err := LoadOutputs(path)
+	require.NoError(t, err)
+	assert.Equal(t, []load.OutputConfig{{Type: "stdout"}, {Type: "metric_api", APIKey: "secret"}}, outputs)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("outputs:\n  - type: stdout\n    api_kye: secret\n"), 0644))
+	_, err = LoadOutputs(path)
+	assert.Error(t, err)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("outputs: []\n"), 0644))
+	_, err = LoadOutputs(path)
+	assert.Error(t, err)
+}
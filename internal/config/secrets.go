@@ -123,7 +123,10 @@ func loadSecrets(config *load.Config) error {
 	// if ymlStr has a value it means a secret was successfully retrieved, decrypted, and substitutions were attempted
 	// we can then attempt to read and overwrite the config
 	if ymlStr != "" {
-		*config, err = ReadYML(ymlStr)
+		var replacement load.Config
+		replacement, err = ReadYML(ymlStr)
+		keepRun(&replacement, config)
+		*config = replacement
 		if err != nil {
 			load.Logrus.WithFields(logrus.Fields{
 				"config": config.Name,
@@ -0,0 +1,288 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Knetic/govaluate"
+	"github.com/newrelic/nri-flex/internal/inputs"
+	"github.com/newrelic/nri-flex/internal/load"
+	"github.com/newrelic/nri-flex/internal/processor"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var (
+	yamlLineRegex     = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+	mathVariableRegex = regexp.MustCompile(`\${.*?}`)
+)
+
+// ValidationError describes a single problem found in a config file
+type ValidationError struct {
+	File    string
+	Line    int // 0 when the line is unknown
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.File, e.Message)
+}
+
+// strictV4Config is used to strictly decode V4 agent config files
+// only the config of nri-flex integrations is checked, other agent keys are left to the agent
+type strictV4Config struct {
+	Integrations []strictV4Entry `yaml:"integrations"`
+}
+
+type strictV4Entry struct {
+	Name   string
+	Config *load.Config
+}
+
+// UnmarshalYAML decodes the entry name first, and only strictly decodes the config for nri-flex
+func (e *strictV4Entry) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var head struct {
+		Name  string                 `yaml:"name"`
+		Other map[string]interface{} `yaml:",inline"`
+	}
+	if err := unmarshal(&head); err != nil {
+		return err
+	}
+	e.Name = head.Name
+	if head.Name != "nri-flex" {
+		return nil
+	}
+
+	var flex struct {
+		Config load.Config            `yaml:"config"`
+		Other  map[string]interface{} `yaml:",inline"`
+	}
+	if err := unmarshal(&flex); err != nil {
+		return err
+	}
+	e.Config = &flex.Config
+	return nil
+}
+
+// ValidatePath validates a single config file, or every yml/yaml file nested under a directory
+func ValidatePath(configPath string) []ValidationError {
+	info, err := os.Stat(configPath)
+	if err != nil {
+		return []ValidationError{{File: configPath, Message: err.Error()}}
+	}
+	if !info.IsDir() {
+		return ValidateFile(configPath)
+	}
+
+	var errors []ValidationError
+	err = filepath.Walk(configPath, func(filePath string, f os.FileInfo, err error) error {
+		if err != nil {
+			errors = append(errors, ValidationError{File: filePath, Message: err.Error()})
+			return nil
+		}
+		// same exclusions as recurseDirectory
+		if f.IsDir() && (strings.Contains(f.Name(), ".git") || strings.Contains(f.Name(), "nr-integrations")) {
+			return filepath.SkipDir
+		}
+		if f.IsDir() || (!strings.HasSuffix(f.Name(), "yml") && !strings.HasSuffix(f.Name(), "yaml")) {
+			return nil
+		}
+		errors = append(errors, ValidateFile(filePath)...)
+		return nil
+	})
+	if err != nil {
+		errors = append(errors, ValidationError{File: configPath, Message: err.Error()})
+	}
+	return errors
+}
+
+// ValidateFile strictly decodes a config file, rejecting unknown keys and type mismatches
+// it then checks the regexes and math expressions used by each api
+func ValidateFile(filePath string) []ValidationError {
+	b, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return []ValidationError{{File: filePath, Message: err.Error()}}
+	}
+
+	ymlStr := string(b)
+	SubEnvVariables(&ymlStr)
+	SubTimestamps(&ymlStr, time.Now())
+
+	configs, errors := strictDecode(filePath, ymlStr)
+	for _, cfg := range configs {
+		errors = append(errors, validateConfig(filePath, ymlStr, cfg)...)
+	}
+	sort.SliceStable(errors, func(i, j int) bool { return errors[i].Line < errors[j].Line })
+	return errors
+}
+
+// strictDecode decodes either a V4 integrations file or a plain Flex config
+func strictDecode(filePath string, ymlStr string) ([]load.Config, []ValidationError) {
+	var top map[string]interface{}
+	if err := yaml.Unmarshal([]byte(ymlStr), &top); err != nil {
+		return nil, yamlErrors(filePath, err)
+	}
+
+	if _, ok := top["integrations"]; ok {
+		v4 := strictV4Config{}
+		if err := yaml.UnmarshalStrict([]byte(ymlStr), &v4); err != nil {
+			return nil, yamlErrors(filePath, err)
+		}
+		var configs []load.Config
+		for _, integration := range v4.Integrations {
+			if integration.Config != nil {
+				configs = append(configs, *integration.Config)
+			}
+		}
+		if len(configs) == 0 {
+			return nil, []ValidationError{{File: filePath, Message: errNoV4IntegrationsFound.Error()}}
+		}
+		return configs, nil
+	}
+
+	cfg := load.Config{}
+	if err := yaml.UnmarshalStrict([]byte(ymlStr), &cfg); err != nil {
+		return nil, yamlErrors(filePath, err)
+	}
+	return []load.Config{cfg}, nil
+}
+
+// yamlErrors splits a yaml error into one validation error per reported line
+func yamlErrors(filePath string, err error) []ValidationError {
+	messages := []string{err.Error()}
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		messages = typeErr.Errors
+	}
+
+	var errors []ValidationError
+	for _, message := range messages {
+		validationErr := ValidationError{File: filePath, Message: message}
+		if matches := yamlLineRegex.FindStringSubmatch(message); len(matches) == 3 {
+			validationErr.Line, _ = strconv.Atoi(matches[1])
+			validationErr.Message = matches[2]
+		}
+		errors = append(errors, validationErr)
+	}
+	return errors
+}
+
+// validateConfig checks the parts of a config that decode fine but would fail at run time
+func validateConfig(filePath string, ymlStr string, cfg load.Config) []ValidationError {
+	var errors []ValidationError
+	add := func(needle string, format string, args ...interface{}) {
+		errors = append(errors, ValidationError{
+			File:    filePath,
+			Line:    lineOf(ymlStr, needle),
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+	checkRegex := func(field string, expr string) {
+		if _, err := regexp.Compile(expr); err != nil {
+			add(expr, "%s: invalid regex %q: %v", field, expr, err)
+		}
+	}
+
+	if cfg.Name == "" {
+		add("", "config requires a name")
+	}
+
+	for i, api := range cfg.APIs {
+		prefix := fmt.Sprintf("apis[%d]", i)
+		if api.Name != "" {
+			prefix = fmt.Sprintf("apis[%d] (%s)", i, api.Name)
+		}
+		if err := inputs.Validate(api); err != nil {
+			add(api.Name, "%s: %v", prefix, err)
+		}
+		if err := inputs.VerifyOAuth2(cfg, api); err != nil {
+			add("oauth2", "%s: %v", prefix, err)
+		}
+		for _, err := range processor.ValidatePipeline(api) {
+			add("pipeline", "%s %v", prefix, err)
+		}
+
+		for _, filters := range [][]map[string]string{api.SampleFilter, api.SampleIncludeFilter, api.SampleExcludeFilter, api.SampleIncludeMatchAllFilter} {
+			for _, filter := range filters {
+				for k, v := range filter {
+					checkRegex(prefix+" sample filter key", k)
+					checkRegex(prefix+" sample filter value", v)
+				}
+			}
+		}
+		for _, filter := range append(append([]load.Filter{}, api.EventFilter...), api.KeyFilter...) {
+			switch filter.Mode {
+			case "", "regex":
+				checkRegex(prefix+" filter key", filter.Key)
+				checkRegex(prefix+" filter value", filter.Value)
+			case "prefix", "suffix", "contains":
+			default:
+				add(filter.Mode, "%s: unknown filter mode %q", prefix, filter.Mode)
+			}
+		}
+		for k := range api.RenameKeys {
+			checkRegex(prefix+" rename_keys", k)
+		}
+		for k := range api.ReplaceKeys {
+			checkRegex(prefix+" replace_keys", k)
+		}
+		for k, v := range api.ValueParser {
+			checkRegex(prefix+" value_parser key", k)
+			checkRegex(prefix+" value_parser value", v)
+		}
+		for k := range api.ValueTransformer {
+			checkRegex(prefix+" value_transformer", k)
+		}
+		for k := range api.TimestampConversion {
+			checkRegex(prefix+" timestamp_conversion", k)
+		}
+		for k := range api.RenameSamples {
+			checkRegex(prefix+" rename_samples", k)
+		}
+		for _, k := range api.KeepKeys {
+			checkRegex(prefix+" keep_keys", k)
+		}
+		for _, k := range api.RemoveKeys {
+			checkRegex(prefix+" remove_keys", "(?i)"+k)
+		}
+		for _, mappings := range api.ValueMapper {
+			for _, mapping := range mappings {
+				checkRegex(prefix+" value_mapper", strings.Split(mapping, "=>")[0])
+			}
+		}
+		for metric, formula := range api.Math {
+			// keys are substituted at run time, so any placeholder value is enough to parse the expression
+			expr := mathVariableRegex.ReplaceAllString(formula, "1")
+			if _, err := govaluate.NewEvaluableExpression(expr); err != nil {
+				add(formula, "%s math %s: invalid expression %q: %v", prefix, metric, formula, err)
+			}
+		}
+	}
+	return errors
+}
+
+// lineOf returns the first line containing needle, or 0 if not found
+func lineOf(content string, needle string) int {
+	if needle == "" {
+		return 0
+	}
+	for i, line := range strings.Split(content, "\n") {
+		if strings.Contains(line, needle) {
+			return i + 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,140 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateFile(t *testing.T) {
+	tests := map[string]struct {
+		yml      string
+		expected []string
+	}{
+		"valid": {
+			yml: `
+name: valid
+apis:
+  - name: cmd
+    commands:
+      - run: echo "a:1"
+        split_by: ":"
+    rename_keys:
+      a: b
+    math:
+      c: ${b} * 2
+`,
+		},
+		"unknown key": {
+			yml: `
+name: typo
+apis:
+  - name: cmd
+    sample_exlude_filter:
+      - a: b
+`,
+			expected: []string{"test.yml:5: field sample_exlude_filter not found in type load.API"},
+		},
+		"type mismatch": {
+			yml: `
+name: types
+apis:
+  - name: cmd
+    timeout: abc
+`,
+			expected: []string{"test.yml:5: cannot unmarshal !!str `abc` into int"},
+		},
+		"invalid regex and math": {
+			yml: `
+name: semantic
+apis:
+  - name: cmd
+    rename_keys:
+      "a(": b
+    math:
+      c: ${b} * (2
+`,
+			expected: []string{
+				"test.yml:6: apis[0] (cmd) rename_keys: invalid regex \"a(\": error parsing regexp: missing closing ): `a(`",
+				"test.yml:8: apis[0] (cmd) math c: invalid expression \"${b} * (2\": Unbalanced parenthesis",
+			},
+		},
+		"invalid input": {
+			yml: `
+name: inputs
+apis:
+  - name: cmd
+    commands:
+      - split_by: ":"
+  - name: queue
+    input:
+      kafka:
+        brokers: localhost:9092
+`,
+			expected: []string{
+				"test.yml:4: apis[0] (cmd): commands input: commands[0] requires run, dial, cache or container_exec",
+				"test.yml:7: apis[1] (queue): unknown input kafka, registered inputs are file, cache, ingest, commands, http, database, scp",
+			},
+		},
+		"oauth2 with signer": {
+			yml: `
+name: signed
+apis:
+  - name: orders
+    url: https://api.example.com/orders
+    oauth2:
+      token_url: https://idp.example.com/oauth2/token
+    signer:
+      type: aws_sigv4
+      service: execute-api
+      region: us-east-1
+`,
+			expected: []string{"test.yml:6: apis[0] (orders): oauth2: cannot be used with the aws_sigv4 signer, both set the Authorization header"},
+		},
+		"v4 integrations": {
+			yml: `
+integrations:
+  - name: nri-flex
+    interval: 30s
+    config:
+      name: v4
+      apis:
+        - name: cmd
+          rename_key:
+            a: b
+  - name: nri-other
+    config:
+      anything: goes
+`,
+			expected: []string{"test.yml:9: field rename_key not found in type load.API"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "validate")
+			require.NoError(t, err)
+			defer func() { _ = os.RemoveAll(dir) }()
+
+			file := filepath.Join(dir, "test.yml")
+			require.NoError(t, ioutil.WriteFile(file, []byte(tc.yml), 0644))
+
+			var actual []string
+			for _, err := range ValidateFile(file) {
+				rel, _ := filepath.Rel(dir, err.File)
+				err.File = rel
+				actual = append(actual, err.Error())
+			}
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
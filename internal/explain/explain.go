@@ -0,0 +1,239 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package explain records how each data set and sample moves through the processor
+// so a wrong sample can be traced back to the step that produced it
+package explain
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// change operations
+const (
+	Added   = "added"
+	Removed = "removed"
+	Changed = "changed"
+)
+
+// Trace holds every data set recorded during an execution
+type Trace struct {
+	DataSets []*DataSet `json:"dataSets"`
+}
+
+// DataSet records a single data set processed by an api, from the fetched data to the samples created
+type DataSet struct {
+	Config          string      `json:"config"`
+	API             string      `json:"api"`
+	Raw             interface{} `json:"raw"`
+	AfterStartKey   interface{} `json:"afterStartKey,omitempty"`
+	AfterStripKeys  interface{} `json:"afterStripKeys,omitempty"`
+	AfterFlattening interface{} `json:"afterFlattening,omitempty"`
+	Samples         []*Sample   `json:"samples"`
+}
+
+// Sample records the steps applied to a single sample
+type Sample struct {
+	Input     map[string]interface{} `json:"input"`
+	Steps     []Step                 `json:"steps,omitempty"`
+	DroppedBy string                 `json:"droppedBy,omitempty"` // set when a filter or limit dropped the sample
+	EventType string                 `json:"eventType,omitempty"`
+	Output    map[string]interface{} `json:"output,omitempty"`
+	last      map[string]interface{}
+}
+
+// Step is a processor step that changed the sample
+type Step struct {
+	Name    string   `json:"name"`
+	Key     string   `json:"key,omitempty"` // the original key being processed, empty for steps applied to the whole sample
+	Changes []Change `json:"changes"`
+}
+
+// Change is a single attribute difference between two states of a sample
+type Change struct {
+	Op   string      `json:"op"`
+	Key  string      `json:"key"`
+	From interface{} `json:"from,omitempty"`
+	To   interface{} `json:"to,omitempty"`
+}
+
+var (
+	traceLock sync.Mutex
+	current   *Trace
+)
+
+// Start enables recording, data sets processed afterwards are added to the trace
+func Start() {
+	traceLock.Lock()
+	defer traceLock.Unlock()
+	current = &Trace{}
+}
+
+// Stop disables recording and returns the trace, nil if recording was not started
+func Stop() *Trace {
+	traceLock.Lock()
+	defer traceLock.Unlock()
+	trace := current
+	current = nil
+	return trace
+}
+
+// NewDataSet starts recording a data set, it returns nil when recording is not enabled
+// all methods are safe to call on a nil DataSet or Sample
+func NewDataSet(config string, api string, raw interface{}) *DataSet {
+	traceLock.Lock()
+	defer traceLock.Unlock()
+	if current == nil {
+		return nil
+	}
+	ds := &DataSet{Config: config, API: api, Raw: copyValue(raw)}
+	current.DataSets = append(current.DataSets, ds)
+	return ds
+}
+
+// StartKey records the data set after start_key has been applied
+func (d *DataSet) StartKey(data interface{}) {
+	if d != nil {
+		d.AfterStartKey = copyValue(data)
+	}
+}
+
+// StripKeys records the data set after strip_keys has been applied
+func (d *DataSet) StripKeys(data interface{}) {
+	if d != nil {
+		d.AfterStripKeys = copyValue(data)
+	}
+}
+
+// Flattened records the samples produced by flattening
+func (d *DataSet) Flattened(data interface{}) {
+	if d != nil {
+		d.AfterFlattening = copyValue(data)
+	}
+}
+
+// NewSample starts recording a sample of the data set
+func (d *DataSet) NewSample(sample map[string]interface{}) *Sample {
+	if d == nil {
+		return nil
+	}
+	input := copyMap(sample)
+	s := &Sample{Input: input, last: input}
+	d.Samples = append(d.Samples, s)
+	return s
+}
+
+// Step records the changes made to the sample by a step applied to the whole sample
+func (s *Sample) Step(name string, sample map[string]interface{}) {
+	if s == nil {
+		return
+	}
+	s.record(name, "", copyMap(sample))
+}
+
+// KeyStep records the changes made by a step applied to a single key
+// the step is working on key and value, which have not yet been written back to the sample under originalKey
+func (s *Sample) KeyStep(name string, originalKey string, sample map[string]interface{}, key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	state := copyMap(sample)
+	delete(state, originalKey)
+	state[key] = copyValue(value)
+	s.record(name, originalKey, state)
+}
+
+// Drop records the filter or limit that dropped the sample
+func (s *Sample) Drop(reason string) {
+	if s != nil {
+		s.DroppedBy = reason
+	}
+}
+
+// Done records the final sample and its event type
+func (s *Sample) Done(eventType string, sample map[string]interface{}) {
+	if s == nil {
+		return
+	}
+	s.EventType = eventType
+	if s.DroppedBy == "" {
+		s.Output = copyMap(sample)
+	}
+}
+
+func (s *Sample) record(name string, key string, state map[string]interface{}) {
+	changes := Diff(s.last, state)
+	s.last = state
+	if len(changes) > 0 {
+		s.Steps = append(s.Steps, Step{Name: name, Key: key, Changes: changes})
+	}
+}
+
+// Diff returns the changes between two states of a sample sorted by key
+func Diff(before map[string]interface{}, after map[string]interface{}) []Change {
+	var changes []Change
+	for k, v := range after {
+		old, ok := before[k]
+		if !ok {
+			changes = append(changes, Change{Op: Added, Key: k, To: v})
+		} else if !reflect.DeepEqual(old, v) {
+			changes = append(changes, Change{Op: Changed, Key: k, From: old, To: v})
+		}
+	}
+	for k, v := range before {
+		if _, ok := after[k]; !ok {
+			changes = append(changes, Change{Op: Removed, Key: k, From: v})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Key == changes[j].Key {
+			return changes[i].Op < changes[j].Op
+		}
+		return changes[i].Key < changes[j].Key
+	})
+	return changes
+}
+
+// WriteFile writes the trace as indented json
+func (t *Trace) WriteFile(path string) error {
+	b, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return fmt.Errorf("explain: failed to marshal trace, %v", err)
+	}
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		return fmt.Errorf("explain: failed to write trace, %v", err)
+	}
+	return nil
+}
+
+// copyValue deep copies the maps and slices that make up fetched data
+// the processor modifies data in place, so every recorded state needs its own copy
+func copyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return copyMap(v)
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, e := range v {
+			c[i] = copyValue(e)
+		}
+		return c
+	default:
+		return v
+	}
+}
+
+func copyMap(m map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		c[k] = copyValue(v)
+	}
+	return c
+}
@@ -0,0 +1,59 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package explain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	before := map[string]interface{}{"a": 1, "b": "x", "c": true}
+	after := map[string]interface{}{"a": 2, "c": true, "d": "y"}
+
+	expected := []Change{
+		{Op: Changed, Key: "a", From: 1, To: 2},
+		{Op: Removed, Key: "b", From: "x"},
+		{Op: Added, Key: "d", To: "y"},
+	}
+	assert.Equal(t, expected, Diff(before, after))
+	assert.Empty(t, Diff(before, before))
+}
+
+func TestSampleSteps(t *testing.T) {
+	Start()
+	ds := NewDataSet("cfg", "api", map[string]interface{}{"a": map[string]interface{}{"b": "5ms"}})
+	sample := map[string]interface{}{"a.b": "5ms"}
+	st := ds.NewSample(sample)
+
+	// a key step that renames and changes the value, before it is written back to the sample
+	st.KeyStep("rename", "a.b", sample, "bee", "5ms")
+	st.KeyStep("parse", "a.b", sample, "bee", "5")
+	// no change, not recorded
+	st.KeyStep("noop", "a.b", sample, "bee", "5")
+	sample["bee"] = "5"
+	delete(sample, "a.b")
+	st.Step("whole", sample)
+	st.Drop("sample_filter")
+	st.Done("apiSample", sample)
+
+	trace := Stop()
+	assert.Len(t, trace.DataSets, 1)
+	assert.Equal(t, "cfg", trace.DataSets[0].Config)
+	assert.Len(t, st.Steps, 2)
+	assert.Equal(t, "rename", st.Steps[0].Name)
+	assert.Equal(t, "a.b", st.Steps[0].Key)
+	assert.Equal(t, []Change{{Op: Changed, Key: "bee", From: "5ms", To: "5"}}, st.Steps[1].Changes)
+	assert.Equal(t, "sample_filter", st.DroppedBy)
+	assert.Nil(t, st.Output)
+
+	// recording stopped, nothing is recorded and nil receivers are safe
+	ds = NewDataSet("cfg", "api", nil)
+	assert.Nil(t, ds)
+	ds.StartKey(nil)
+	ds.NewSample(sample).KeyStep("rename", "a", sample, "b", 1)
+}
@@ -0,0 +1,95 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package fixture
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Difference describes an expected sample that was not produced, or a produced sample that was not expected
+type Difference struct {
+	Expected   map[string]interface{} `json:"expected,omitempty"`
+	Actual     map[string]interface{} `json:"actual,omitempty"` // the closest produced sample, if any
+	Attributes []AttributeDiff        `json:"attributes,omitempty"`
+}
+
+// AttributeDiff is an expected attribute that is missing or has a different value
+type AttributeDiff struct {
+	Key      string      `json:"key"`
+	Expected interface{} `json:"expected"`
+	Actual   interface{} `json:"actual,omitempty"`
+	Missing  bool        `json:"missing,omitempty"`
+}
+
+// Compare matches each expected sample against the produced samples
+// an expected sample only needs to list the attributes to check, so attributes that change between runs can be left out
+// every produced sample must be matched by an expected sample
+func Compare(expected []map[string]interface{}, actual []map[string]interface{}) []Difference {
+	var differences []Difference
+	matched := make([]bool, len(actual))
+
+	for _, expectedSample := range expected {
+		best, bestDiffs := -1, []AttributeDiff(nil)
+		for i, actualSample := range actual {
+			if matched[i] {
+				continue
+			}
+			diffs := attributeDiffs(expectedSample, actualSample)
+			if best == -1 || len(diffs) < len(bestDiffs) {
+				best, bestDiffs = i, diffs
+			}
+			if len(diffs) == 0 {
+				break
+			}
+		}
+
+		switch {
+		case best == -1:
+			differences = append(differences, Difference{Expected: expectedSample})
+		case len(bestDiffs) > 0 && !sameEventType(expectedSample, actual[best]):
+			// do not pair with an unrelated sample, it would only make the difference harder to read
+			differences = append(differences, Difference{Expected: expectedSample})
+		case len(bestDiffs) > 0:
+			matched[best] = true
+			differences = append(differences, Difference{Expected: expectedSample, Actual: actual[best], Attributes: bestDiffs})
+		default:
+			matched[best] = true
+		}
+	}
+
+	for i, actualSample := range actual {
+		if !matched[i] {
+			differences = append(differences, Difference{Actual: actualSample})
+		}
+	}
+	return differences
+}
+
+func attributeDiffs(expected map[string]interface{}, actual map[string]interface{}) []AttributeDiff {
+	var diffs []AttributeDiff
+	for k, v := range expected {
+		actualValue, ok := actual[k]
+		if !ok {
+			diffs = append(diffs, AttributeDiff{Key: k, Expected: v, Missing: true})
+		} else if !equalValues(v, actualValue) {
+			diffs = append(diffs, AttributeDiff{Key: k, Expected: v, Actual: actualValue})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Key < diffs[j].Key })
+	return diffs
+}
+
+func sameEventType(expected map[string]interface{}, actual map[string]interface{}) bool {
+	eventType, ok := expected["event_type"]
+	return !ok || equalValues(eventType, actual["event_type"])
+}
+
+// equalValues compares values by their string form, expected samples are decoded from json
+// so numbers are always float64 while produced samples hold whatever type the processor set
+func equalValues(a interface{}, b interface{}) bool {
+	return fmt.Sprintf("%v", a) == fmt.Sprintf("%v", b)
+}
@@ -0,0 +1,171 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package fixture records the inputs fetched by each api, serves them back in place of live services,
+// and compares the samples produced with expected samples
+//
+// A fixture directory holds a folder per config, named after the config:
+//
+//	<dir>/<config name>/inputs/<api name>.json    recorded inputs of the api, in the order they were fetched
+//	<dir>/<config name>/expected.json             samples the config is expected to produce
+package fixture
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// input kinds
+const (
+	KindHTTP    = "http"
+	KindCommand = "command"
+	KindFile    = "file"
+	KindQuery   = "query"
+	KindSCP     = "scp"
+	KindDial    = "dial"
+)
+
+// ExpectedFile is the name of the file holding the expected samples of a config
+const ExpectedFile = "expected.json"
+
+var unsafeNameRegex = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+// Input is a single recorded input
+type Input struct {
+	Kind    string              `json:"kind"`
+	Key     string              `json:"key"`               // url, command, file, query, remote file or address the input was read from
+	Status  int                 `json:"status,omitempty"`  // http status code, 200 if not set
+	Headers map[string][]string `json:"headers,omitempty"` // http response headers
+	Body    string              `json:"body"`
+	Rows    []map[string]string `json:"rows,omitempty"`  // database query result rows
+	Error   string              `json:"error,omitempty"` // set if the input failed, eg. a command exiting non-zero
+}
+
+// Store serves the inputs of a fixture directory
+type Store struct {
+	dir    string
+	lock   sync.Mutex
+	inputs map[string][]Input // by config and api, loaded on first use
+	served map[string][]bool
+}
+
+// NewStore creates a store reading from a fixture directory
+func NewStore(dir string) (*Store, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("fixture: failed to read fixture dir, %v", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("fixture: %s is not a directory", dir)
+	}
+	return &Store{dir: dir, inputs: map[string][]Input{}, served: map[string][]bool{}}, nil
+}
+
+// Next returns the next recorded input of the api matching kind and key
+// inputs are served once each in the order they were recorded, if none match the key the next input of the same kind is used
+// so keys that change between runs such as urls with timestamps can still be replayed
+func (s *Store) Next(config string, api string, kind string, key string) (Input, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	path := InputsFile(s.dir, config, api)
+	inputs, ok := s.inputs[path]
+	if !ok {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return Input{}, fmt.Errorf("fixture: no recorded inputs for config %s api %s, %v", config, api, err)
+		}
+		if err := json.Unmarshal(b, &inputs); err != nil {
+			return Input{}, fmt.Errorf("fixture: failed to parse %s, %v", path, err)
+		}
+		s.inputs[path] = inputs
+		s.served[path] = make([]bool, len(inputs))
+	}
+
+	match := -1
+	for i, input := range inputs {
+		if s.served[path][i] || input.Kind != kind {
+			continue
+		}
+		if input.Key == key {
+			match = i
+			break
+		}
+		if match == -1 {
+			match = i
+		}
+	}
+	if match == -1 {
+		return Input{}, fmt.Errorf("fixture: no %s input left for config %s api %s key %s", kind, config, api, key)
+	}
+	s.served[path][match] = true
+	return inputs[match], nil
+}
+
+var (
+	replayLock sync.RWMutex
+	replay     *Store
+)
+
+// Replay serves inputs from the store instead of fetching them, nil stops replaying
+func Replay(s *Store) {
+	replayLock.Lock()
+	defer replayLock.Unlock()
+	replay = s
+}
+
+// Replaying returns true if inputs are served from a store
+func Replaying() bool {
+	replayLock.RLock()
+	defer replayLock.RUnlock()
+	return replay != nil
+}
+
+// Next returns the next recorded input from the store being replayed
+func Next(config string, api string, kind string, key string) (Input, error) {
+	replayLock.RLock()
+	s := replay
+	replayLock.RUnlock()
+	if s == nil {
+		return Input{}, fmt.Errorf("fixture: not replaying")
+	}
+	return s.Next(config, api, kind, key)
+}
+
+// InputsFile returns the path of the recorded inputs of an api
+func InputsFile(dir string, config string, api string) string {
+	return filepath.Join(dir, safeName(config), "inputs", safeName(api)+".json")
+}
+
+// ExpectedSamplesFile returns the path of the expected samples of a config
+func ExpectedSamplesFile(dir string, config string) string {
+	return filepath.Join(dir, safeName(config), ExpectedFile)
+}
+
+// LoadExpected reads the expected samples of a config
+func LoadExpected(dir string, config string) ([]map[string]interface{}, error) {
+	path := ExpectedSamplesFile(dir, config)
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("fixture: failed to read expected samples, %v", err)
+	}
+	var samples []map[string]interface{}
+	if err := json.Unmarshal(b, &samples); err != nil {
+		return nil, fmt.Errorf("fixture: failed to parse %s, %v", path, err)
+	}
+	return samples, nil
+}
+
+func safeName(name string) string {
+	if name == "" {
+		return "_"
+	}
+	return unsafeNameRegex.ReplaceAllString(name, "_")
+}
@@ -0,0 +1,140 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package fixture
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreNext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fixtures")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := InputsFile(dir, "my config", "api/1")
+	require.Equal(t, filepath.Join(dir, "my_config", "inputs", "api_1.json"), path)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, ioutil.WriteFile(path, []byte(`[
+		{"kind": "http", "key": "http://a/?page=1", "body": "page1"},
+		{"kind": "command", "key": "echo", "body": "echoed"},
+		{"kind": "http", "key": "http://a/?page=2", "body": "page2"}
+	]`), 0644))
+
+	s, err := NewStore(dir)
+	require.NoError(t, err)
+
+	// matched by key first
+	input, err := s.Next("my config", "api/1", KindHTTP, "http://a/?page=2")
+	require.NoError(t, err)
+	assert.Equal(t, "page2", input.Body)
+
+	// otherwise the next input of the same kind
+	input, err = s.Next("my config", "api/1", KindHTTP, "http://a/?page=9")
+	require.NoError(t, err)
+	assert.Equal(t, "page1", input.Body)
+
+	// each input is only served once
+	_, err = s.Next("my config", "api/1", KindHTTP, "http://a/?page=1")
+	assert.Error(t, err)
+
+	input, err = s.Next("my config", "api/1", KindCommand, "echo")
+	require.NoError(t, err)
+	assert.Equal(t, "echoed", input.Body)
+
+	_, err = s.Next("my config", "other", KindCommand, "echo")
+	assert.Error(t, err)
+
+	_, err = NewStore(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
+
+func TestCompare(t *testing.T) {
+	actual := []map[string]interface{}{
+		{"event_type": "redisSample", "clients": 3, "version": "6.2", "flex.commandTimeMs": 12},
+		{"event_type": "hostSample", "host": "a", "load": 0.5},
+	}
+
+	tests := map[string]struct {
+		expected    []map[string]interface{}
+		differences []Difference
+	}{
+		"match ignoring unlisted attributes": {
+			expected: []map[string]interface{}{
+				{"event_type": "hostSample", "host": "a", "load": 0.5},
+				{"event_type": "redisSample", "clients": float64(3)},
+			},
+		},
+		"different value": {
+			expected: []map[string]interface{}{
+				{"event_type": "redisSample", "clients": float64(4), "role": "master"},
+				{"event_type": "hostSample", "host": "a"},
+			},
+			differences: []Difference{{
+				Expected: map[string]interface{}{"event_type": "redisSample", "clients": float64(4), "role": "master"},
+				Actual:   actual[0],
+				Attributes: []AttributeDiff{
+					{Key: "clients", Expected: float64(4), Actual: 3},
+					{Key: "role", Expected: "master", Missing: true},
+				},
+			}},
+		},
+		"missing and unexpected samples": {
+			expected: []map[string]interface{}{
+				{"event_type": "redisSample", "clients": 3},
+				{"event_type": "diskSample", "used": 1},
+			},
+			differences: []Difference{
+				{Expected: map[string]interface{}{"event_type": "diskSample", "used": 1}},
+				{Actual: actual[1]},
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.differences, Compare(tc.expected, actual))
+		})
+	}
+}
+
+func TestRecorderWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fixtures")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	r := NewRecorder(dir)
+	r.Add("cfg", "api", Input{Kind: KindCommand, Key: "echo hi", Body: "hi\n"})
+	r.Add("cfg", "api", Input{Kind: KindQuery, Key: "select 1", Rows: []map[string]string{{"one": "1"}}})
+	r.AddSample("cfg", map[string]interface{}{
+		"event_type":          "apiSample",
+		"value":               1,
+		"integration_version": "1.0.0",
+		"flex.commandTimeMs":  5,
+		"flex.time.startMs":   100,
+	})
+	require.NoError(t, r.Write())
+
+	// the recorded inputs are served back in order
+	s, err := NewStore(dir)
+	require.NoError(t, err)
+	input, err := s.Next("cfg", "api", KindCommand, "echo hi")
+	require.NoError(t, err)
+	assert.Equal(t, "hi\n", input.Body)
+	input, err = s.Next("cfg", "api", KindQuery, "select 1")
+	require.NoError(t, err)
+	assert.Equal(t, []map[string]string{{"one": "1"}}, input.Rows)
+
+	// volatile attributes are left out of the expected samples
+	expected, err := LoadExpected(dir, "cfg")
+	require.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{{"event_type": "apiSample", "value": float64(1)}}, expected)
+}
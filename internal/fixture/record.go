@@ -0,0 +1,148 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package fixture
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// volatileAttributes change on every run, so they are left out of recorded expected samples
+var volatileAttributes = []string{"integration_version", "integration_name", "flex.commandTimeMs", "flex.QueryStartMs", "flex.QueryTimeMs", "flex.time."}
+
+// Recorder captures every input fetched and every sample produced, to be written as a fixture directory
+type Recorder struct {
+	dir     string
+	lock    sync.Mutex
+	inputs  map[string][]Input                  // by inputs file
+	samples map[string][]map[string]interface{} // by config
+}
+
+// NewRecorder creates a recorder writing to a fixture directory
+func NewRecorder(dir string) *Recorder {
+	return &Recorder{dir: dir, inputs: map[string][]Input{}, samples: map[string][]map[string]interface{}{}}
+}
+
+// Add records an input fetched by an api
+func (r *Recorder) Add(config string, api string, input Input) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	path := InputsFile(r.dir, config, api)
+	r.inputs[path] = append(r.inputs[path], input)
+}
+
+// AddSample records a sample produced by a config
+func (r *Recorder) AddSample(config string, sample map[string]interface{}) {
+	stable := map[string]interface{}{}
+	for k, v := range sample {
+		if !isVolatile(k) {
+			stable[k] = v
+		}
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.samples[config] = append(r.samples[config], stable)
+}
+
+// Write writes the recorded inputs, and the samples of each config as its expected samples
+// files of configs and apis that were not run are left untouched
+func (r *Recorder) Write() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for path, inputs := range r.inputs {
+		if err := writeJSON(path, inputs); err != nil {
+			return err
+		}
+	}
+	for config, samples := range r.samples {
+		// keep the expected samples in a stable order so re-recording gives a readable diff
+		sort.SliceStable(samples, func(i, j int) bool {
+			return fmt.Sprintf("%v", samples[i]) < fmt.Sprintf("%v", samples[j])
+		})
+		if err := writeJSON(ExpectedSamplesFile(r.dir, config), samples); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeJSON(path string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("fixture: failed to marshal %s, %v", path, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("fixture: failed to create %s, %v", filepath.Dir(path), err)
+	}
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		return fmt.Errorf("fixture: failed to write %s, %v", path, err)
+	}
+	return nil
+}
+
+func isVolatile(key string) bool {
+	for _, attribute := range volatileAttributes {
+		if key == attribute || (strings.HasSuffix(attribute, ".") && strings.HasPrefix(key, attribute)) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	recordLock sync.RWMutex
+	recorder   *Recorder
+)
+
+// Record captures inputs and samples with the recorder, nil stops recording
+func Record(r *Recorder) {
+	recordLock.Lock()
+	defer recordLock.Unlock()
+	recorder = r
+}
+
+// StopRecording stops recording and returns the recorder, nil if not recording
+func StopRecording() *Recorder {
+	recordLock.Lock()
+	defer recordLock.Unlock()
+	r := recorder
+	recorder = nil
+	return r
+}
+
+// Recording returns true if inputs are being recorded
+func Recording() bool {
+	recordLock.RLock()
+	defer recordLock.RUnlock()
+	return recorder != nil
+}
+
+// Add records an input with the active recorder, if any
+func Add(config string, api string, input Input) {
+	recordLock.RLock()
+	r := recorder
+	recordLock.RUnlock()
+	if r != nil {
+		r.Add(config, api, input)
+	}
+}
+
+// AddSample records a sample with the active recorder, if any
+func AddSample(config string, sample map[string]interface{}) {
+	recordLock.RLock()
+	r := recorder
+	recordLock.RUnlock()
+	if r != nil {
+		r.AddSample(config, sample)
+	}
+}
@@ -0,0 +1,402 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package inputs
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/newrelic/nri-flex/internal/load"
+	"github.com/newrelic/nri-flex/internal/signer"
+)
+
+// the built in inputs, configured by their block under input like any other input
+// apis written before inputs had blocks set the fields of load.API instead, legacyInput maps them to the block of their input
+
+// builtinInput is an input whose block sets fields of load.API, the fields its fetch reads
+type builtinInput interface {
+	Input
+	config() builtinConfig
+}
+
+// builtinConfig is the block of a built in input
+type builtinConfig interface {
+	apply(api *load.API)
+}
+
+// legacyInput returns the built in input the fields of api select, in the order the if/else chain of FetchData used to check them
+func legacyInput(api load.API) string {
+	switch {
+	case api.File != "":
+		return "file"
+	case api.Cache != "":
+		return "cache"
+	case api.Ingest:
+		return "ingest"
+	case len(api.Commands) > 0 && api.Database == "" && api.DBConn == "":
+		return "commands"
+	case api.URL != "" || len(api.Steps) > 0:
+		return "http"
+	case api.Database != "" && api.DBConn != "":
+		return "database"
+	case api.Scp.Host != "":
+		return "scp"
+	}
+	return ""
+}
+
+// withLegacyBlock returns api with an empty block for the built in input its fields select, unless it has the block of a registered input
+func withLegacyBlock(api load.API) load.API {
+	for name := range api.Input {
+		if isRegistered(name) {
+			return api
+		}
+	}
+	name := legacyInput(api)
+	if name == "" {
+		return api
+	}
+	input := make(map[string]interface{}, len(api.Input)+1)
+	for k, v := range api.Input {
+		input[k] = v
+	}
+	input[name] = nil
+	api.Input = input
+	return api
+}
+
+// configured returns api with the block of input applied to its fields
+func configured(api load.API, input builtinInput) (load.API, error) {
+	cfg := input.config()
+	if err := DecodeConfig(api, input.Name(), cfg); err != nil {
+		return api, err
+	}
+	cfg.apply(&api)
+	return api, nil
+}
+
+// Configure sets the fields of api from the block of the built in input it uses, the block taking precedence
+// apis without the block of a registered input get the block of the built in input their fields select
+func Configure(api *load.API) error {
+	*api = withLegacyBlock(*api)
+	input, ok := Lookup(*api).(builtinInput)
+	if !ok {
+		return nil
+	}
+	configuredAPI, err := configured(*api, input)
+	if err != nil {
+		return err
+	}
+	*api = configuredAPI
+	return nil
+}
+
+// hasBlock reports whether api has the block of the input name under input
+func hasBlock(api load.API, name string) bool {
+	_, ok := api.Input[name]
+	return ok
+}
+
+type fileInput struct{}
+
+type fileConfig struct {
+	Path string `yaml:"path"` // json or csv file to read
+}
+
+func (c *fileConfig) apply(api *load.API) {
+	if c.Path != "" {
+		api.File = c.Path
+	}
+}
+
+func (fileInput) Name() string              { return "file" }
+func (fileInput) Matches(api load.API) bool { return hasBlock(api, "file") }
+func (fileInput) config() builtinConfig     { return &fileConfig{} }
+
+func (in fileInput) Validate(api load.API) error {
+	api, err := configured(api, in)
+	if err != nil {
+		return err
+	}
+	if api.File == "" {
+		return fmt.Errorf("file requires path")
+	}
+	return nil
+}
+
+func (fileInput) Fetch(dataStore *[]interface{}, yml *load.Config, apiNo int) error {
+	return ProcessFile(dataStore, yml, apiNo)
+}
+
+type cacheInput struct{}
+
+type cacheConfig struct {
+	Name string `yaml:"name"` // url or name of the api whose output is read
+}
+
+func (c *cacheConfig) apply(api *load.API) {
+	if c.Name != "" {
+		api.Cache = c.Name
+	}
+}
+
+func (cacheInput) Name() string              { return "cache" }
+func (cacheInput) Matches(api load.API) bool { return hasBlock(api, "cache") }
+func (cacheInput) config() builtinConfig     { return &cacheConfig{} }
+
+func (in cacheInput) Validate(api load.API) error {
+	api, err := configured(api, in)
+	if err != nil {
+		return err
+	}
+	if api.Cache == "" {
+		return fmt.Errorf("cache requires name")
+	}
+	return nil
+}
+
+func (cacheInput) Fetch(dataStore *[]interface{}, yml *load.Config, apiNo int) error {
+	if cached := yml.Datastore[yml.APIs[apiNo].Cache]; cached != nil {
+		*dataStore = cached
+	}
+	return nil
+}
+
+type ingestInput struct{}
+
+type ingestConfig struct{}
+
+func (c *ingestConfig) apply(api *load.API) {
+	api.Ingest = true
+}
+
+func (ingestInput) Name() string              { return "ingest" }
+func (ingestInput) Matches(api load.API) bool { return hasBlock(api, "ingest") }
+func (ingestInput) config() builtinConfig     { return &ingestConfig{} }
+
+func (in ingestInput) Validate(api load.API) error {
+	_, err := configured(api, in)
+	return err
+}
+
+func (ingestInput) Fetch(dataStore *[]interface{}, yml *load.Config, apiNo int) error {
+	if ingested := yml.Datastore["IngestData"]; ingested != nil {
+		*dataStore = ingested
+	}
+	return nil
+}
+
+type commandsInput struct{}
+
+type commandsConfig struct {
+	Commands []load.Command `yaml:"commands"` // commands to run, their output is merged into one sample
+	Shell    string         `yaml:"shell"`    // shell used to run commands
+	Async    bool           `yaml:"async"`    // run commands async
+}
+
+func (c *commandsConfig) apply(api *load.API) {
+	if len(c.Commands) > 0 {
+		api.Commands = c.Commands
+	}
+	if c.Shell != "" {
+		api.Shell = c.Shell
+	}
+	if c.Async {
+		api.CommandsAsync = true
+	}
+}
+
+func (commandsInput) Name() string              { return "commands" }
+func (commandsInput) Matches(api load.API) bool { return hasBlock(api, "commands") }
+func (commandsInput) config() builtinConfig     { return &commandsConfig{} }
+
+func (in commandsInput) Validate(api load.API) error {
+	api, err := configured(api, in)
+	if err != nil {
+		return err
+	}
+	if len(api.Commands) == 0 {
+		return fmt.Errorf("commands requires at least one command")
+	}
+	for i, command := range api.Commands {
+		if command.Run == "" && command.Dial == "" && command.Cache == "" && command.ContainerExec == "" {
+			return fmt.Errorf("commands[%d] requires run, dial, cache or container_exec", i)
+		}
+	}
+	return nil
+}
+
+func (commandsInput) Fetch(dataStore *[]interface{}, yml *load.Config, apiNo int) error {
+	RunCommands(dataStore, yml, apiNo)
+	return nil
+}
+
+type httpInput struct{}
+
+type httpConfig struct {
+	URL     string            `yaml:"url"`     // http(s) endpoint to request, prefixed by global base_url
+	Method  string            `yaml:"method"`  // http method, GET by default
+	Payload string            `yaml:"payload"` // http body sent with POST or PUT
+	Headers map[string]string `yaml:"headers"` // http headers, take precedence over the headers of the api
+	Steps   []load.HTTPStep   `yaml:"steps"`   // requests sent in order, see load.HTTPStep
+}
+
+func (c *httpConfig) apply(api *load.API) {
+	if c.URL != "" {
+		api.URL = c.URL
+	}
+	if c.Method != "" {
+		api.Method = c.Method
+	}
+	if c.Payload != "" {
+		api.Payload = c.Payload
+	}
+	if len(c.Headers) > 0 {
+		headers := make(map[string]string, len(api.Headers)+len(c.Headers))
+		for k, v := range api.Headers {
+			headers[k] = v
+		}
+		for k, v := range c.Headers {
+			headers[k] = v
+		}
+		api.Headers = headers
+	}
+	if len(c.Steps) > 0 {
+		api.Steps = c.Steps
+	}
+}
+
+func (httpInput) Name() string              { return "http" }
+func (httpInput) Matches(api load.API) bool { return hasBlock(api, "http") }
+func (httpInput) config() builtinConfig     { return &httpConfig{} }
+
+func (in httpInput) Validate(api load.API) error {
+	api, err := configured(api, in)
+	if err != nil {
+		return err
+	}
+	if api.URL == "" && len(api.Steps) == 0 {
+		return fmt.Errorf("http requires url or steps")
+	}
+	if _, err := url.Parse(api.URL); err != nil {
+		return err
+	}
+	if api.Format != "" {
+		if format, _ := lookupDecoder(api, ""); format == "" {
+			return fmt.Errorf("unknown format %s, registered formats are %s", api.Format, strings.Join(RegisteredFormats(), ", "))
+		}
+	}
+	if _, err := newHTTPRetry(api.Retry); err != nil {
+		return err
+	}
+	if err := validateSteps(api); err != nil {
+		return err
+	}
+	if cfg := signer.Config(api); cfg.Type != "" {
+		if _, err := signer.New(cfg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (httpInput) Fetch(dataStore *[]interface{}, yml *load.Config, apiNo int) error {
+	api := yml.APIs[apiNo]
+	if len(api.Steps) > 0 {
+		return RunHTTPSteps(dataStore, yml, api)
+	}
+	reqURL := api.URL
+	doLoop := true
+	RunHTTP(dataStore, &doLoop, yml, api, &reqURL)
+	return nil
+}
+
+type databaseInput struct{}
+
+type databaseConfig struct {
+	Type    string         `yaml:"type"`    // database type eg. postgres, mysql, mssql
+	Driver  string         `yaml:"driver"`  // override the database driver
+	Conn    string         `yaml:"conn"`    // database connection string
+	Queries []load.Command `yaml:"queries"` // queries to run against the database
+	Async   bool           `yaml:"async"`   // perform queries async
+}
+
+func (c *databaseConfig) apply(api *load.API) {
+	if c.Type != "" {
+		api.Database = c.Type
+	}
+	if c.Driver != "" {
+		api.DBDriver = c.Driver
+	}
+	if c.Conn != "" {
+		api.DBConn = c.Conn
+	}
+	if len(c.Queries) > 0 {
+		api.DBQueries = c.Queries
+	}
+	if c.Async {
+		api.DBAsync = true
+	}
+}
+
+func (databaseInput) Name() string              { return "database" }
+func (databaseInput) Matches(api load.API) bool { return hasBlock(api, "database") }
+func (databaseInput) config() builtinConfig     { return &databaseConfig{} }
+
+func (in databaseInput) Validate(api load.API) error {
+	api, err := configured(api, in)
+	if err != nil {
+		return err
+	}
+	if api.Database == "" || api.DBConn == "" {
+		return fmt.Errorf("database requires type and conn")
+	}
+	for i, query := range api.DBQueries {
+		if query.Run == "" {
+			return fmt.Errorf("db_queries[%d] requires run", i)
+		}
+	}
+	return nil
+}
+
+func (databaseInput) Fetch(dataStore *[]interface{}, yml *load.Config, apiNo int) error {
+	ProcessQueries(dataStore, yml, apiNo)
+	return nil
+}
+
+type scpInput struct{}
+
+// scpConfig has the keys of load.SCP
+type scpConfig load.SCP
+
+func (c *scpConfig) apply(api *load.API) {
+	if *c != (scpConfig{}) {
+		api.Scp = load.SCP(*c)
+	}
+}
+
+func (scpInput) Name() string              { return "scp" }
+func (scpInput) Matches(api load.API) bool { return hasBlock(api, "scp") }
+func (scpInput) config() builtinConfig     { return &scpConfig{} }
+
+func (in scpInput) Validate(api load.API) error {
+	api, err := configured(api, in)
+	if err != nil {
+		return err
+	}
+	if api.Scp.Host == "" {
+		return fmt.Errorf("scp requires host")
+	}
+	if api.Scp.RemoteFile == "" {
+		return fmt.Errorf("scp requires remote_file")
+	}
+	return nil
+}
+
+func (scpInput) Fetch(dataStore *[]interface{}, yml *load.Config, apiNo int) error {
+	return RunScpWithTimeout(dataStore, yml, yml.APIs[apiNo])
+}
@@ -16,6 +16,7 @@ import (
 	"time"
 
 	xj "github.com/basgys/goxml2json"
+	"github.com/newrelic/nri-flex/internal/fixture"
 	"github.com/newrelic/nri-flex/internal/formatter"
 	"github.com/newrelic/nri-flex/internal/load"
 	"github.com/sirupsen/logrus"
@@ -67,7 +68,7 @@ func RunCommands(dataStore *[]interface{}, yml *load.Config, apiNo int) {
 				}
 			}
 		} else if command.Dial != "" {
-			NetDialWithTimeout(dataStore, command, &dataSample, api, &processType)
+			NetDialWithTimeout(dataStore, yml, command, &dataSample, api, &processType)
 		} else if command.ContainerExec != "" {
 			// handle commands against containers
 			if yml.CustomAttributes != nil {
@@ -86,7 +87,7 @@ func RunCommands(dataStore *[]interface{}, yml *load.Config, apiNo int) {
 }
 
 func commandRun(dataStore *[]interface{}, yml *load.Config, command load.Command, api load.API, startTime int64, dataSample map[string]interface{}, processType string) {
-	command.Run = envCommandCheck(command.Run)
+	command.Run = envCommandCheck(yml.State().Args(), command.Run)
 	runCommand := command.Run
 	if command.Output == load.Jmx {
 		SetJMXCommand(&runCommand, command, api, yml)
@@ -101,14 +102,14 @@ func commandRun(dataStore *[]interface{}, yml *load.Config, command load.Command
 	}
 
 	// Create a new context and add a timeout to it
-	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	ctx, cancel := context.WithTimeout(yml.Context(), commandTimeout)
 	defer cancel() // The cancel should be deferred so resources are cleaned up
 
 	// Create the command with our context
 	cmd := buildCommand(ctx, api, command)
 
 	// https://golang.org/pkg/os/exec/#Cmd.StdinPipe
-	if load.Args.StdinPipe {
+	if yml.State().Args().StdinPipe {
 		_, err := cmd.StdinPipe()
 		if err != nil {
 			load.Logrus.WithFields(logrus.Fields{
@@ -119,7 +120,18 @@ func commandRun(dataStore *[]interface{}, yml *load.Config, command load.Command
 		}
 	}
 
-	output, err := cmd.CombinedOutput()
+	var output []byte
+	var err error
+	if fixture.Replaying() {
+		output, err = replayCommand(yml, api, command.Run)
+	} else {
+		output, err = cmd.CombinedOutput()
+		if fixture.Recording() {
+			recordCommand(yml, api, command.Run, output, err)
+		}
+	}
+
+	addBytesRead(yml, api, len(output))
 
 	// check if a assertion is defined and successfully passes before continuing, see function for detailed comments
 	if !checkAssertion(command.Assert, output) {
@@ -133,6 +145,11 @@ func commandRun(dataStore *[]interface{}, yml *load.Config, command load.Command
 	contextError := ctx.Err()
 
 	if err != nil || contextError != nil {
+		runError := err
+		if runError == nil {
+			runError = contextError
+		}
+		yml.State().ConfigError(yml.Name, api.Name, runError)
 		contextErrorStr := ""
 		if contextError != nil {
 			contextErrorStr = contextError.Error()
@@ -176,8 +193,8 @@ func commandRun(dataStore *[]interface{}, yml *load.Config, command load.Command
 }
 
 // checks if explicitedly enabled log
-func envCommandCheck(commandStr string) string {
-	if load.Args.AllowEnvCommands {
+func envCommandCheck(args *load.ArgumentList, commandStr string) string {
+	if args.AllowEnvCommands {
 		load.Logrus.WithFields(logrus.Fields{
 			"command": commandStr,
 			"prepend": os.Getenv("FLEX_CMD_PREPEND"),
@@ -483,6 +500,9 @@ func detectCommandOutput(dataOutput string, commandOutput string) (string, inter
 // in windows it will be run under "cmd". some windows set powershell as the default
 // to override the defaults, set the shell to run either at the API level or command level.
 // for *unix append the "-c", for windows "/c" unless we override the shell. in that case flags should be provided
+// commandWaitDelay is how long output is still read from a command after it is cancelled
+const commandWaitDelay = 500 * time.Millisecond
+
 func buildCommand(ctx context.Context, api load.API, command load.Command) *exec.Cmd {
 	commandShell := load.DefaultShell
 	// not sure we should keep this for other shells
@@ -500,7 +520,10 @@ func buildCommand(ctx context.Context, api load.API, command load.Command) *exec
 		commandShell = command.Shell
 	}
 
-	return exec.CommandContext(ctx, commandShell, secondParameter, command.Run)
+	cmd := exec.CommandContext(ctx, commandShell, secondParameter, command.Run)
+	// once cancelled only the shell is killed, do not wait on children of the shell still holding its output
+	cmd.WaitDelay = commandWaitDelay
+	return cmd
 }
 
 // checkAssertion perform output based assertions
@@ -230,20 +230,20 @@ func TestEnvCommandCheck(t *testing.T) {
 	os.Setenv("FLEX_CMD_PREPEND", "echo hi && ")
 
 	command := "echo hello"
-	command = envCommandCheck(command)
+	command = envCommandCheck(&load.Args, command)
 
 	// should not be modified
 	assert.Equal(t, "echo hello", command)
 
 	// should now be modified
 	load.Args.AllowEnvCommands = true
-	command = envCommandCheck(command)
+	command = envCommandCheck(&load.Args, command)
 	assert.Equal(t, "echo hi && echo hello", command)
 
 	// check if wrap applies
 	os.Setenv("FLEX_CMD_PREPEND", "")
 	os.Setenv("FLEX_CMD_WRAP", "true")
-	commandWrap := envCommandCheck("echo hello")
+	commandWrap := envCommandCheck(&load.Args, "echo hello")
 	assert.Equal(t, "\"echo hello\"", commandWrap)
 
 	// disable modifications to not effect other tests
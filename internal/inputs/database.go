@@ -18,6 +18,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/newrelic/nri-flex/internal/fixture"
 	"github.com/newrelic/nri-flex/internal/load"
 	"github.com/sirupsen/logrus"
 
@@ -44,6 +45,13 @@ func ProcessQueries(dataStore *[]interface{}, yml *load.Config, apiNo int) {
 		"database": api.Database,
 	}).Debug("database: process queries")
 
+	if fixture.Replaying() {
+		for _, query := range api.DBQueries {
+			checkAndRunQuery(nil, query, api, yml, dataStore)
+		}
+		return
+	}
+
 	// sql.Open doesn't open the connection, use a generic Ping() to test the connection
 	db, err := sql.Open(setDatabaseDriver(api.Database, api.DBDriver, yml, api), api.DBConn)
 	if err != nil {
@@ -54,8 +62,9 @@ func ProcessQueries(dataStore *[]interface{}, yml *load.Config, apiNo int) {
 			"database": api.Database,
 		}).Debug("database: unable to connect")
 
+		yml.State().ConfigError(yml.Name, api.Name, err)
 		if api.Logging.Open {
-			errorLogToInsights(err, api.Database, api.Name, "")
+			errorLogToInsights(yml.State(), err, api.Database, api.Name, "")
 		}
 		return
 	}
@@ -78,7 +87,7 @@ func ProcessQueries(dataStore *[]interface{}, yml *load.Config, apiNo int) {
 	// https://stackoverflow.com/questions/41618428/golang-ping-succeed-the-second-time-even-if-database-is-down/41619206#41619206
 	var pingError error
 	if db != nil {
-		dbPingWithTimeout(db, &pingError)
+		dbPingWithTimeout(yml.Context(), db, &pingError)
 	}
 
 	if pingError != nil {
@@ -88,8 +97,9 @@ func ProcessQueries(dataStore *[]interface{}, yml *load.Config, apiNo int) {
 			"database": api.Database,
 		}).Debug("database: ping error")
 
+		yml.State().ConfigError(yml.Name, api.Name, pingError)
 		if api.Logging.Open {
-			errorLogToInsights(pingError, api.Database, api.Name, "")
+			errorLogToInsights(yml.State(), pingError, api.Database, api.Name, "")
 		}
 		return
 	}
@@ -98,10 +108,12 @@ func ProcessQueries(dataStore *[]interface{}, yml *load.Config, apiNo int) {
 	if api.DBAsync {
 		var wg sync.WaitGroup
 		var mu sync.Mutex
+		var panics load.Panics
 		wg.Add(len(api.DBQueries))
 		for _, query := range api.DBQueries {
 			go func(query load.Command) {
 				defer wg.Done()
+				defer panics.Recover(api.Name)
 				localStore := []interface{}{}
 				checkAndRunQuery(db, query, api, yml, &localStore)
 				mu.Lock()
@@ -110,6 +122,7 @@ func ProcessQueries(dataStore *[]interface{}, yml *load.Config, apiNo int) {
 			}(query)
 		}
 		wg.Wait()
+		panics.Raise()
 	} else {
 		for _, query := range api.DBQueries {
 			checkAndRunQuery(db, query, api, yml, dataStore)
@@ -126,20 +139,28 @@ func checkAndRunQuery(db *sql.DB, query load.Command, api load.API, yml *load.Co
 		load.Logrus.WithFields(logrus.Fields{"name": yml.Name, "database": api.Database}).Error("database: run parameter not defined")
 		return
 	}
+	if fixture.Replaying() {
+		replayQueryRows(query, api, yml, dataStore)
+		return
+	}
 	runQuery(db, query, api, yml, dataStore)
 }
 
 func runQuery(db *sql.DB, query load.Command, api load.API, yml *load.Config, dataStore *[]interface{}) {
 	queryStartTime := load.TimestampMs()
-	rows, err := db.Query(query.Run)
+	rows, err := db.QueryContext(yml.Context(), query.Run)
 	if err != nil {
+		if fixture.Recording() {
+			fixture.Add(yml.Name, api.Name, fixture.Input{Kind: fixture.KindQuery, Key: query.Run, Error: err.Error()})
+		}
 		load.Logrus.WithFields(logrus.Fields{
 			"query":    query.Run,
 			"name":     yml.Name,
 			"database": api.Database,
 		}).Error("database: query failed")
 
-		errorLogToInsights(err, api.Database, api.Name, query.Name)
+		yml.State().ConfigError(yml.Name, api.Name, err)
+		errorLogToInsights(yml.State(), err, api.Database, api.Name, query.Name)
 		return
 	}
 
@@ -158,7 +179,8 @@ func runQuery(db *sql.DB, query load.Command, api load.API, yml *load.Config, da
 			"query":      query.Run,
 		}).Debug("database: column return failed")
 
-		errorLogToInsights(err, api.Database, api.Name, query.Name)
+		yml.State().ConfigError(yml.Name, api.Name, err)
+		errorLogToInsights(yml.State(), err, api.Database, api.Name, query.Name)
 
 		return
 	}
@@ -172,18 +194,9 @@ func runQuery(db *sql.DB, query load.Command, api load.API, yml *load.Config, da
 	}
 
 	// Fetch rows
+	var recorded []map[string]string
 	rowNo := 1
 	for rows.Next() {
-		rowSet := map[string]interface{}{
-			"rowIdentifier": query.Name + "_" + strconv.Itoa(rowNo),
-			"queryLabel":    query.Name,
-			"event_type":    query.Name,
-		}
-		// apply event type override if set (this is useful to set if needing to group multiples under one event type)
-		if query.EventType != "" {
-			rowSet["event_type"] = query.EventType
-		}
-
 		// get RawBytes
 		err = rows.Scan(scanArgs...)
 		if err != nil {
@@ -193,21 +206,23 @@ func runQuery(db *sql.DB, query load.Command, api load.API, yml *load.Config, da
 			return
 		}
 		// Loop through each column
+		row := make(map[string]string, len(cols))
 		for i, col := range values {
 			// If value nil == null
 			if col == nil {
-				rowSet[cols[i]] = ""
+				row[cols[i]] = ""
 			} else {
-				rowSet[cols[i]] = asString(col)
+				row[cols[i]] = asString(col)
 			}
 		}
-		queryEndTime := load.TimestampMs()
-		rowSet["flex.QueryStartMs"] = queryStartTime
-		rowSet["flex.QueryTimeMs"] = queryEndTime - queryStartTime
-		*dataStore = append(*dataStore, rowSet)
+		*dataStore = append(*dataStore, newRowSet(query, rowNo, row, queryStartTime))
 		// load.StoreAppend(rowSet)
+		recorded = append(recorded, row)
 		rowNo++
 	}
+	if fixture.Recording() {
+		fixture.Add(yml.Name, api.Name, fixture.Input{Kind: fixture.KindQuery, Key: query.Run, Rows: recorded})
+	}
 	err = rows.Err()
 	if err != nil {
 		load.Logrus.WithFields(logrus.Fields{
@@ -219,6 +234,43 @@ func runQuery(db *sql.DB, query load.Command, api load.API, yml *load.Config, da
 	}
 }
 
+// replayQueryRows creates the same row samples as a query, from the recorded rows
+func replayQueryRows(query load.Command, api load.API, yml *load.Config, dataStore *[]interface{}) {
+	queryStartTime := load.TimestampMs()
+	rows, err := replayQuery(yml, api, query.Run)
+	if err != nil {
+		load.Logrus.WithFields(logrus.Fields{
+			"query":    query.Run,
+			"name":     yml.Name,
+			"database": api.Database,
+		}).WithError(err).Error("database: query failed")
+		return
+	}
+	for i, row := range rows {
+		*dataStore = append(*dataStore, newRowSet(query, i+1, row, queryStartTime))
+	}
+}
+
+// newRowSet creates the sample of a single result row
+func newRowSet(query load.Command, rowNo int, row map[string]string, queryStartTime int64) map[string]interface{} {
+	rowSet := map[string]interface{}{
+		"rowIdentifier": query.Name + "_" + strconv.Itoa(rowNo),
+		"queryLabel":    query.Name,
+		"event_type":    query.Name,
+	}
+	// apply event type override if set (this is useful to set if needing to group multiples under one event type)
+	if query.EventType != "" {
+		rowSet["event_type"] = query.EventType
+	}
+	for col, value := range row {
+		rowSet[col] = value
+	}
+	queryEndTime := load.TimestampMs()
+	rowSet["flex.QueryStartMs"] = queryStartTime
+	rowSet["flex.QueryTimeMs"] = queryEndTime - queryStartTime
+	return rowSet
+}
+
 // setDatabaseDriver returns driver if set, otherwise sets a default driver based on database
 func setDatabaseDriver(database, driver string, yml *load.Config, api load.API) string {
 	if driver != "" {
@@ -253,11 +305,11 @@ func setDatabaseDriver(database, driver string, yml *load.Config, api load.API)
 }
 
 // errorLogToInsights log errors to insights, useful to debug
-func errorLogToInsights(err error, database, name, queryLabel string) {
-	errorMetricSet := load.Entity.NewMetricSet(database + "Error")
+func errorLogToInsights(state *load.State, err error, database, name, queryLabel string) {
+	errorMetricSet := state.Entity().NewMetricSet(database + "Error")
 
-	load.StatusCounterIncrement("EventCount")
-	load.StatusCounterIncrement(database + "Error")
+	state.StatusCounterIncrement("EventCount")
+	state.StatusCounterIncrement(database + "Error")
 
 	checkError(errorMetricSet.SetMetric("errorMsg", err.Error(), metric.ATTRIBUTE))
 	if name != "" {
@@ -269,9 +321,7 @@ func errorLogToInsights(err error, database, name, queryLabel string) {
 }
 
 // dbPingWithTimeout Database Ping() with Timeout
-func dbPingWithTimeout(db *sql.DB, pingError *error) {
-	ctx := context.Background()
-
+func dbPingWithTimeout(ctx context.Context, db *sql.DB, pingError *error) {
 	// Create a channel for signal handling
 	c := make(chan struct{})
 	// Define a cancellation after 1s in the context
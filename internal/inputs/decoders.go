@@ -0,0 +1,223 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package inputs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"sort"
+	"strings"
+	"sync"
+
+	xj "github.com/basgys/goxml2json"
+	"github.com/newrelic/nri-flex/internal/load"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Decoder decodes the body of an http response
+// it returns the body as encoding/json would unmarshal it, samples are then created as for a json response
+// a decoder that appends samples to dataStore itself returns nil
+type Decoder func(dataStore *[]interface{}, body []byte, yml *load.Config, api *load.API) (interface{}, error)
+
+type decoder struct {
+	format     string
+	mediaTypes []string // a type like application/*+json matches any type with the prefix and suffix, parameters must match when set
+	decode     Decoder
+}
+
+// decoders holds the decoders in the order their media types are matched against the content type of a response
+var decoders = struct {
+	sync.RWMutex
+	list []decoder
+}{list: []decoder{
+	{"json", []string{"application/json", "text/json", "application/*+json"}, decodeJSON},
+	{"ndjson", []string{"application/x-ndjson", "application/ndjson", "application/jsonl", "application/x-jsonlines"}, decodeNDJSON},
+	{"xml", []string{"application/xml", "text/xml", "application/*+xml"}, decodeXML},
+	{"csv", []string{"text/csv", "application/csv"}, decodeCSV},
+	{"html", []string{"text/html"}, decodeHTML},
+	{"yaml", []string{"application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml"}, decodeYAML},
+	{"prometheus", []string{"text/plain; version=0.0.4"}, decodePrometheus},
+	{"openmetrics", []string{"application/openmetrics-text"}, decodeOpenMetrics},
+}}
+
+// RegisterDecoder adds the decoder of a format, for responses of the media types or apis that set it as their format
+// it is matched after the decoders registered before it, and panics if a decoder of the same format is registered
+func RegisterDecoder(format string, decode Decoder, mediaTypes ...string) {
+	decoders.Lock()
+	defer decoders.Unlock()
+	for _, registered := range decoders.list {
+		if registered.format == format {
+			panic(fmt.Sprintf("inputs: RegisterDecoder called twice for format %s", format))
+		}
+	}
+	decoders.list = append(decoders.list, decoder{format: format, mediaTypes: mediaTypes, decode: decode})
+}
+
+// RegisteredFormats returns the format of every registered decoder, sorted
+func RegisteredFormats() []string {
+	decoders.RLock()
+	defer decoders.RUnlock()
+	formats := make([]string, 0, len(decoders.list))
+	for _, d := range decoders.list {
+		formats = append(formats, d.format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// lookupDecoder returns the decoder of the format of the api, else of the content type of the response
+// nil is returned when none matches, the format of the body is then detected
+func lookupDecoder(api load.API, contentType string) (string, Decoder) {
+	decoders.RLock()
+	defer decoders.RUnlock()
+
+	format := api.Format
+	if format == "" && api.Prometheus.Enable {
+		format = "prometheus"
+	}
+	if format != "" {
+		for _, d := range decoders.list {
+			if d.format == format {
+				return d.format, d.decode
+			}
+		}
+		return "", nil
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", nil
+	}
+	for _, d := range decoders.list {
+		for _, pattern := range d.mediaTypes {
+			if !matchMediaType(pattern, mediaType, params) {
+				continue
+			}
+			// html pages are only searched for tables when parse_html is set
+			if d.format == "html" && !api.ParseHTML {
+				return "", nil
+			}
+			return d.format, d.decode
+		}
+	}
+	return "", nil
+}
+
+func matchMediaType(pattern string, mediaType string, params map[string]string) bool {
+	patternType, patternParams, err := mime.ParseMediaType(pattern)
+	if err != nil {
+		return false
+	}
+	if i := strings.Index(patternType, "*"); i >= 0 {
+		prefix, suffix := patternType[:i], patternType[i+1:]
+		if len(mediaType) <= len(prefix)+len(suffix) || !strings.HasPrefix(mediaType, prefix) || !strings.HasSuffix(mediaType, suffix) {
+			return false
+		}
+	} else if patternType != mediaType {
+		return false
+	}
+	for k, v := range patternParams {
+		if params[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func decodeJSON(dataStore *[]interface{}, body []byte, yml *load.Config, api *load.API) (interface{}, error) {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// decodeNDJSON decodes a json value per line into an array
+func decodeNDJSON(dataStore *[]interface{}, body []byte, yml *load.Config, api *load.API) (interface{}, error) {
+	values := []interface{}{}
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 64*1024), len(body)+1)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		var v interface{}
+		if err := json.Unmarshal(text, &v); err != nil {
+			return nil, fmt.Errorf("line %d, %v", line, err)
+		}
+		values = append(values, v)
+	}
+	return values, scanner.Err()
+}
+
+func decodeXML(dataStore *[]interface{}, body []byte, yml *load.Config, api *load.API) (interface{}, error) {
+	jsonBody, err := xj.Convert(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON(dataStore, jsonBody.Bytes(), yml, api)
+}
+
+func decodeCSV(dataStore *[]interface{}, body []byte, yml *load.Config, api *load.API) (interface{}, error) {
+	stringBody := string(body)
+	return nil, processCsv(dataStore, yml.Name, "", &stringBody, api.SetHeader)
+}
+
+func decodeHTML(dataStore *[]interface{}, body []byte, yml *load.Config, api *load.API) (interface{}, error) {
+	jsonBody, err := ParseToJSON(body)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON(dataStore, []byte(jsonBody), yml, api)
+}
+
+func decodeYAML(dataStore *[]interface{}, body []byte, yml *load.Config, api *load.API) (interface{}, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(body, &v); err != nil {
+		return nil, err
+	}
+	return jsonValue(v), nil
+}
+
+// jsonValue converts the maps yaml decodes, keyed by any type, to maps keyed by string as json decodes
+func jsonValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			m[fmt.Sprintf("%v", k)] = jsonValue(v)
+		}
+		return m
+	case []interface{}:
+		for i := range t {
+			t[i] = jsonValue(t[i])
+		}
+		return t
+	case int:
+		return float64(t)
+	case int64:
+		return float64(t)
+	case uint64:
+		return float64(t)
+	}
+	return v
+}
+
+func decodePrometheus(dataStore *[]interface{}, body []byte, yml *load.Config, api *load.API) (interface{}, error) {
+	Prometheus(dataStore, bytes.NewReader(body), yml, api)
+	return nil, nil
+}
+
+func decodeOpenMetrics(dataStore *[]interface{}, body []byte, yml *load.Config, api *load.API) (interface{}, error) {
+	Prometheus(dataStore, strings.NewReader(openMetricsToText(body)), yml, api)
+	return nil, nil
+}
@@ -0,0 +1,204 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package inputs
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/newrelic/nri-flex/internal/load"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupDecoder(t *testing.T) {
+	tests := map[string]struct {
+		contentType string
+		api         load.API
+		want        string
+	}{
+		"json":                 {contentType: "application/json", want: "json"},
+		"json charset":         {contentType: "application/json; charset=utf-8", want: "json"},
+		"json upper case":      {contentType: "Application/JSON", want: "json"},
+		"json api":             {contentType: "application/vnd.api+json", want: "json"},
+		"problem json":         {contentType: "application/problem+json", want: "json"},
+		"ndjson":               {contentType: "application/x-ndjson", want: "ndjson"},
+		"xml suffix":           {contentType: "application/atom+xml", want: "xml"},
+		"csv":                  {contentType: "text/csv; header=present", want: "csv"},
+		"yaml":                 {contentType: "application/yaml", want: "yaml"},
+		"prometheus":           {contentType: "text/plain; version=0.0.4; charset=utf-8", want: "prometheus"},
+		"openmetrics":          {contentType: "application/openmetrics-text; version=1.0.0; charset=utf-8", want: "openmetrics"},
+		"plain text":           {contentType: "text/plain", want: ""},
+		"html without parsing": {contentType: "text/html; charset=utf-8", want: ""},
+		"html":                 {contentType: "text/html; charset=utf-8", api: load.API{ParseHTML: true}, want: "html"},
+		"no content type":      {contentType: "", want: ""},
+		"format override":      {contentType: "text/plain", api: load.API{Format: "ndjson"}, want: "ndjson"},
+		"prometheus enabled":   {contentType: "application/json", api: load.API{Prometheus: load.Prometheus{Enable: true}}, want: "prometheus"},
+		"unknown format":       {contentType: "application/json", api: load.API{Format: "toml"}, want: ""},
+	}
+	for name, tc := range tests {
+		format, _ := lookupDecoder(tc.api, tc.contentType)
+		assert.Equal(t, tc.want, format, name)
+	}
+}
+
+func TestRunHTTPDecoders(t *testing.T) {
+	tests := map[string]struct {
+		contentType string
+		body        string
+		api         load.API
+		want        []map[string]interface{}
+	}{
+		"json charset": {
+			contentType: "application/json; charset=utf-8",
+			body:        `{"status":"ok","connections":3}`,
+			want:        []map[string]interface{}{{"status": "ok", "connections": float64(3), "api.StatusCode": 200}},
+		},
+		"problem json": {
+			contentType: "application/problem+json",
+			body:        `{"title":"Not Found","status":404}`,
+			want:        []map[string]interface{}{{"title": "Not Found", "status": float64(404), "api.StatusCode": 200}},
+		},
+		"ndjson": {
+			contentType: "application/x-ndjson",
+			body:        "{\"name\":\"a\",\"size\":1}\n\n{\"name\":\"b\",\"size\":2}\n",
+			want:        []map[string]interface{}{{"name": "a", "size": float64(1), "api.StatusCode": 200}, {"name": "b", "size": float64(2), "api.StatusCode": 200}},
+		},
+		"yaml": {
+			contentType: "application/yaml",
+			body:        "status: ok\nqueues:\n  depth: 7\n",
+			want:        []map[string]interface{}{{"status": "ok", "queues": map[string]interface{}{"depth": float64(7)}, "api.StatusCode": 200}},
+		},
+		"csv charset": {
+			contentType: "text/csv; charset=utf-8",
+			body:        "name,size\na,1\n",
+			want:        []map[string]interface{}{{"name": "a", "size": "1"}},
+		},
+		"format overrides a wrong header": {
+			contentType: "text/plain",
+			body:        `{"status":"ok"}`,
+			api:         load.API{Format: "json"},
+			want:        []map[string]interface{}{{"status": "ok", "api.StatusCode": 200}},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			load.Refresh()
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tc.contentType)
+				_, _ = fmt.Fprint(w, tc.body)
+			}))
+			defer ts.Close()
+
+			api := tc.api
+			api.Name = "decoder"
+			api.URL = ts.URL
+			config := load.Config{Name: "decoder", APIs: []load.API{api}}
+			loop := true
+			var dataStore []interface{}
+			url := api.URL
+			RunHTTP(&dataStore, &loop, &config, api, &url)
+
+			var got []map[string]interface{}
+			for _, sample := range dataStore {
+				got = append(got, sample.(map[string]interface{}))
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestRunHTTPOpenMetrics(t *testing.T) {
+	load.Refresh()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+		_, _ = fmt.Fprint(w, `# HELP requests Requests served.
+# TYPE requests counter
+requests_total{code="200"} 1027 # {trace_id="KOO5S4vxi0o"} 1 1520879607.789
+requests_created{code="200"} 1520879607.789
+# TYPE build info
+build_info{version="1.2.3"} 1
+# EOF
+`)
+	}))
+	defer ts.Close()
+
+	api := load.API{Name: "openmetrics", URL: ts.URL}
+	config := load.Config{Name: "openmetrics", APIs: []load.API{api}}
+	loop := true
+	var dataStore []interface{}
+	url := api.URL
+	RunHTTP(&dataStore, &loop, &config, api, &url)
+
+	values := map[string]interface{}{}
+	for _, sample := range dataStore {
+		for k, v := range sample.(map[string]interface{}) {
+			values[k] = v
+		}
+	}
+	assert.Equal(t, "1027", values["requests_total.counter"])
+	assert.Equal(t, "1", values["build_info.gauge"])
+	assert.NotContains(t, values, "requests_created.untyped")
+}
+
+func TestOpenMetricsToText(t *testing.T) {
+	in := `# TYPE latency histogram
+# UNIT latency seconds
+latency_bucket{le="+Inf"} 3 1520879607.789
+latency_count 3
+latency_sum 0.5
+latency_created 1520879607
+# HELP queue Queue depth.
+# TYPE queue gauge
+queue{name="a b # c"} 4 # {trace_id="x"} 1
+# TYPE state stateset
+state{state="up"} 1
+# TYPE memory gaugehistogram
+memory_gcount 2
+# TYPE other unknown
+other 5
+# EOF
+`
+	want := `# TYPE latency histogram
+latency_bucket{le="+Inf"} 3 1520879607789
+latency_count 3
+latency_sum 0.5
+# HELP queue Queue depth.
+# TYPE queue gauge
+queue{name="a b # c"} 4
+# TYPE state gauge
+state{state="up"} 1
+memory_gcount 2
+# TYPE other untyped
+other 5
+`
+	assert.Equal(t, want, openMetricsToText([]byte(in)))
+}
+
+func TestRegisterDecoder(t *testing.T) {
+	RegisterDecoder("lines", func(dataStore *[]interface{}, body []byte, yml *load.Config, api *load.API) (interface{}, error) {
+		return map[string]interface{}{"lines": string(body)}, nil
+	}, "text/x-lines")
+	defer func() {
+		decoders.Lock()
+		decoders.list = decoders.list[:len(decoders.list)-1]
+		decoders.Unlock()
+	}()
+
+	format, decode := lookupDecoder(load.API{}, "text/x-lines")
+	require.NotNil(t, decode)
+	assert.Equal(t, "lines", format)
+	formats := RegisteredFormats()
+	assert.True(t, sort.StringsAreSorted(formats))
+	assert.Contains(t, formats, "lines")
+
+	assert.Panics(t, func() { RegisterDecoder("json", decodeJSON) })
+	assert.EqualError(t, Validate(load.API{URL: "http://localhost", Format: "toml"}),
+		"http input: unknown format toml, registered formats are csv, html, json, lines, ndjson, openmetrics, prometheus, xml, yaml")
+}
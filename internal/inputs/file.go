@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"strings"
 
 	"github.com/newrelic/nri-flex/internal/load"
@@ -16,7 +15,7 @@ import (
 func ProcessFile(dataStore *[]interface{}, cfg *load.Config, apiNo int) error {
 	file := cfg.APIs[apiNo].File
 
-	b, err := ioutil.ReadFile(file)
+	b, err := readFile(cfg, apiNo)
 	if err != nil {
 		return fmt.Errorf("file input: failed to read file: %v", err)
 	}
@@ -0,0 +1,139 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package inputs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/newrelic/nri-flex/internal/fixture"
+	"github.com/newrelic/nri-flex/internal/load"
+	"github.com/parnurzeal/gorequest"
+)
+
+// replayHTTP builds a response from the recorded input instead of sending the request
+func replayHTTP(yml *load.Config, api load.API, reqURL string) (gorequest.Response, []error) {
+	input, err := fixture.Next(yml.Name, api.Name, fixture.KindHTTP, reqURL)
+	if err != nil {
+		return nil, []error{err}
+	}
+	if input.Error != "" {
+		return nil, []error{errors.New(input.Error)}
+	}
+
+	status := input.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	request, _ := http.NewRequest(http.MethodGet, reqURL, nil)
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode: status,
+		Header:     http.Header(input.Headers),
+		Body:       ioutil.NopCloser(strings.NewReader(input.Body)),
+		Request:    request,
+	}, nil
+}
+
+// recordHTTP records the response, the body is read and replaced so it can still be processed
+func recordHTTP(yml *load.Config, api load.API, reqURL string, resp gorequest.Response, errs []error) {
+	input := fixture.Input{Kind: fixture.KindHTTP, Key: reqURL}
+	if resp == nil {
+		input.Error = joinErrors(errs)
+		fixture.Add(yml.Name, api.Name, input)
+		return
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		input.Error = err.Error()
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	input.Status = resp.StatusCode
+	input.Headers = resp.Header
+	input.Body = string(body)
+	fixture.Add(yml.Name, api.Name, input)
+}
+
+// replayCommand returns the recorded output of a command instead of running it
+func replayCommand(yml *load.Config, api load.API, run string) ([]byte, error) {
+	input, err := fixture.Next(yml.Name, api.Name, fixture.KindCommand, run)
+	if err != nil {
+		return nil, err
+	}
+	if input.Error != "" {
+		return []byte(input.Body), errors.New(input.Error)
+	}
+	return []byte(input.Body), nil
+}
+
+// recordCommand records the output of a command
+func recordCommand(yml *load.Config, api load.API, run string, output []byte, err error) {
+	input := fixture.Input{Kind: fixture.KindCommand, Key: run, Body: string(output)}
+	if err != nil {
+		input.Error = err.Error()
+	}
+	fixture.Add(yml.Name, api.Name, input)
+}
+
+// readFile reads the file of an api, or its recorded contents when replaying
+func readFile(cfg *load.Config, apiNo int) ([]byte, error) {
+	file := cfg.APIs[apiNo].File
+	if fixture.Replaying() {
+		return replayContent(cfg.Name, cfg.APIs[apiNo].Name, fixture.KindFile, file)
+	}
+	b, err := ioutil.ReadFile(file)
+	recordContent(cfg.Name, cfg.APIs[apiNo].Name, fixture.KindFile, file, b, err)
+	addBytesRead(cfg, cfg.APIs[apiNo], len(b))
+	return b, err
+}
+
+// replayContent returns recorded contents that are processed as a whole, eg. local and remote files
+func replayContent(config string, api string, kind string, key string) ([]byte, error) {
+	input, err := fixture.Next(config, api, kind, key)
+	if err != nil {
+		return nil, err
+	}
+	if input.Error != "" {
+		return nil, errors.New(input.Error)
+	}
+	return []byte(input.Body), nil
+}
+
+func recordContent(config string, api string, kind string, key string, content []byte, err error) {
+	if !fixture.Recording() {
+		return
+	}
+	input := fixture.Input{Kind: kind, Key: key, Body: string(content)}
+	if err != nil {
+		input.Error = err.Error()
+	}
+	fixture.Add(config, api, input)
+}
+
+// replayQuery returns the recorded rows of a database query
+func replayQuery(yml *load.Config, api load.API, query string) ([]map[string]string, error) {
+	input, err := fixture.Next(yml.Name, api.Name, fixture.KindQuery, query)
+	if err != nil {
+		return nil, err
+	}
+	if input.Error != "" {
+		return nil, errors.New(input.Error)
+	}
+	return input.Rows, nil
+}
+
+func joinErrors(errs []error) string {
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
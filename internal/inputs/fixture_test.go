@@ -0,0 +1,94 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package inputs
+
+import (
+	"io/ioutil"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/newrelic/nri-flex/internal/fixture"
+	"github.com/newrelic/nri-flex/internal/load"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordAndReplayCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip()
+	}
+	load.Refresh()
+	dir, err := ioutil.TempDir("", "fixtures")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := load.Config{
+		Name: "recordExample",
+		APIs: []load.API{{
+			Name: "echo",
+			Commands: []load.Command{{
+				Run:     `echo "zHello:world"`,
+				SplitBy: ":",
+			}},
+		}},
+	}
+
+	fixture.Record(fixture.NewRecorder(dir))
+	var recorded []interface{}
+	RunCommands(&recorded, &config, 0)
+	require.NoError(t, fixture.StopRecording().Write())
+	require.Len(t, recorded, 1)
+	assert.Equal(t, "world", recorded[0].(map[string]interface{})["zHello"])
+
+	// change the command so replaying can only succeed from the fixture
+	config.APIs[0].Commands[0].Run = "exit 1"
+	store, err := fixture.NewStore(dir)
+	require.NoError(t, err)
+	fixture.Replay(store)
+	defer fixture.Replay(nil)
+
+	var replayed []interface{}
+	RunCommands(&replayed, &config, 0)
+	require.Len(t, replayed, 1)
+	assert.Equal(t, "world", replayed[0].(map[string]interface{})["zHello"])
+}
+
+func TestReplayQuery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fixtures")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	r := fixture.NewRecorder(dir)
+	r.Add("dbExample", "db", fixture.Input{Kind: fixture.KindQuery, Key: "select * from users", Rows: []map[string]string{
+		{"name": "a", "age": "30"},
+		{"name": "b", "age": ""},
+	}})
+	require.NoError(t, r.Write())
+
+	store, err := fixture.NewStore(dir)
+	require.NoError(t, err)
+	fixture.Replay(store)
+	defer fixture.Replay(nil)
+
+	config := load.Config{
+		Name: "dbExample",
+		APIs: []load.API{{
+			Name:      "db",
+			Database:  "postgres",
+			DBConn:    "user=postgres host=unreachable.invalid",
+			DBQueries: []load.Command{{Name: "users", Run: "select * from users"}},
+		}},
+	}
+	var dataStore []interface{}
+	ProcessQueries(&dataStore, &config, 0)
+	require.Len(t, dataStore, 2)
+	first := dataStore[0].(map[string]interface{})
+	assert.Equal(t, "users_1", first["rowIdentifier"])
+	assert.Equal(t, "users", first["event_type"])
+	assert.Equal(t, "a", first["name"])
+	assert.Equal(t, "", dataStore[1].(map[string]interface{})["age"])
+}
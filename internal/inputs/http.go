@@ -20,9 +20,9 @@ import (
 	"time"
 
 	xj "github.com/basgys/goxml2json"
-	"github.com/newrelic/nri-flex/internal/aliyun"
-	"github.com/newrelic/nri-flex/internal/huaweihws"
+	"github.com/newrelic/nri-flex/internal/fixture"
 	"github.com/newrelic/nri-flex/internal/load"
+	"github.com/newrelic/nri-flex/internal/signer"
 	"github.com/parnurzeal/gorequest"
 	"github.com/sirupsen/logrus"
 )
@@ -33,6 +33,10 @@ import (
 func RunHTTP(dataStore *[]interface{}, doLoop *bool, yml *load.Config, api load.API, reqURL *string) {
 	load.Logrus.Debugf("%v - running http requests", yml.Name)
 	for *doLoop {
+		if err := yml.Context().Err(); err != nil {
+			load.Logrus.WithFields(logrus.Fields{"name": yml.Name}).WithError(err).Debug("http: run cancelled, not requesting next page")
+			return
+		}
 		request := gorequest.New()
 
 		if api.EscapeURL {
@@ -64,9 +68,20 @@ func RunHTTP(dataStore *[]interface{}, doLoop *bool, yml *load.Config, api load.
 
 		request = setRequestOptions(request, *yml, api)
 		load.Logrus.Debugf("sending %v request to %v", request.Method, *reqURL)
-		resp, _, errors := request.End()
-		load.StatusCounterIncrement("HttpRequests")
+		var resp gorequest.Response
+		var errors []error
+		if fixture.Replaying() {
+			resp, errors = replayHTTP(yml, api, *reqURL)
+		} else {
+			resp, errors = endWithOAuth2(request, yml, api, *reqURL)
+			if fixture.Recording() {
+				recordHTTP(yml, api, *reqURL, resp, errors)
+			}
+		}
+		yml.State().StatusCounterIncrement("HttpRequests")
 		if resp != nil {
+			body := &countingReader{ReadCloser: resp.Body}
+			resp.Body = body
 			nextLink := ""
 			if resp.Header["Link"] != nil {
 				headerLinks := strings.Split(resp.Header["Link"][0], ",")
@@ -83,46 +98,30 @@ func RunHTTP(dataStore *[]interface{}, doLoop *bool, yml *load.Config, api load.
 
 			load.Logrus.Debugf("URL: %v Status: %v Code: %d", *reqURL, resp.Status, resp.StatusCode)
 
+			format, decode := lookupDecoder(api, contentType)
 			switch {
-			case api.Prometheus.Enable:
-				Prometheus(dataStore, resp.Body, yml, &api)
-			case contentType == "application/json":
-				body, _ := ioutil.ReadAll(resp.Body)
-				addPage := handlePagination(nil, &api.Pagination, &nextLink, body, resp.StatusCode)
-				if api.Debug {
-					load.Logrus.Debugf("HTTP Debug:\nURL: %v\nBody:\n%v\n", *reqURL, string(body))
+			case decode != nil:
+				body, err := ioutil.ReadAll(resp.Body)
+				if err != nil {
+					load.Logrus.WithError(err).Errorf("http: URL %v failed to read resp.Body", *reqURL)
+					break
 				}
-				// if not using pagination handle json for any response, if using pagination check the status code before storing
-				if api.Pagination.OriginalURL == "" || (api.Pagination.OriginalURL != "" && resp.StatusCode >= 200 && resp.StatusCode <= 299) && addPage {
-					handleJSON(dataStore, body, &resp, doLoop, reqURL, nextLink, api.ReturnHeaders)
+				addPage := true
+				if format == "json" {
+					addPage = handlePagination(nil, &api.Pagination, &nextLink, body, resp.StatusCode)
 				}
-			case contentType == "text/xml" || contentType == "application/xml":
-				jsonBody, err := xj.Convert(resp.Body)
-				if err != nil {
-					load.Logrus.WithError(err).Errorf("http: URL %v failed to convert XML to Json resp.Body", *reqURL)
-				} else {
-					if api.Pagination.OriginalURL == "" || (api.Pagination.OriginalURL != "" && resp.StatusCode >= 200 && resp.StatusCode <= 299) {
-						handleJSON(dataStore, jsonBody.Bytes(), &resp, doLoop, reqURL, nextLink, api.ReturnHeaders)
-					}
+				if api.Debug {
+					load.Logrus.Debugf("HTTP Debug:\nURL: %v\nBody:\n%v\n", *reqURL, string(body))
 				}
-			case (contentType == "text/html" || contentType == "text/html; charset=utf-8") && api.ParseHTML:
-				body, _ := ioutil.ReadAll(resp.Body)
-				jsonBody, err := ParseToJSON(body)
+				value, err := decode(dataStore, body, yml, &api)
 				if err != nil {
-					load.Logrus.WithError(err).Errorf("http: URL %v failed to convert XML to Json resp.Body", *reqURL)
-				} else {
-					if api.Pagination.OriginalURL == "" || (api.Pagination.OriginalURL != "" && resp.StatusCode >= 200 && resp.StatusCode <= 299) {
-						handleJSON(dataStore, []byte(jsonBody), &resp, doLoop, reqURL, nextLink, api.ReturnHeaders)
-					}
+					load.Logrus.WithError(err).Errorf("http: URL %v failed to decode %s resp.Body", *reqURL, format)
+					break
 				}
-			case contentType == "text/csv":
-				body, _ := ioutil.ReadAll(resp.Body)
-				stringBody := string(body)
-				err := processCsv(dataStore, "", "", &stringBody, api.SetHeader)
-				if err != nil {
-					load.Logrus.WithError(err).Errorf("http: URL %v failed to process text/csv body resp.Body", *reqURL)
+				// if not using pagination handle the body for any response, if using pagination check the status code before storing
+				if value != nil && (api.Pagination.OriginalURL == "" || (resp.StatusCode >= 200 && resp.StatusCode <= 299) && addPage) {
+					handleBody(dataStore, value, &resp, doLoop, reqURL, nextLink, api.ReturnHeaders)
 				}
-
 			default:
 				// some apis do not specify a content-type header, if not set attempt to detect if the payload is json
 				body, err := ioutil.ReadAll(resp.Body)
@@ -174,6 +173,11 @@ func RunHTTP(dataStore *[]interface{}, doLoop *bool, yml *load.Config, api load.
 				}
 			}
 
+			yml.State().APIStatusUpdate(yml.Name, api.Name, func(status *load.APIStatus) {
+				status.BytesRead += body.n
+				status.HTTPStatus = resp.StatusCode
+			})
+
 			if nextLink != "" {
 				*reqURL = nextLink
 			} else {
@@ -182,6 +186,7 @@ func RunHTTP(dataStore *[]interface{}, doLoop *bool, yml *load.Config, api load.
 
 		} else {
 			httpErrorSample := map[string]interface{}{}
+			yml.State().ConfigError(yml.Name, api.Name, fmt.Errorf("http: request to %s failed, %s", *reqURL, joinErrors(errors)))
 
 			for i, err := range errors {
 				load.Logrus.WithFields(logrus.Fields{
@@ -205,24 +210,17 @@ func RunHTTP(dataStore *[]interface{}, doLoop *bool, yml *load.Config, api load.
 // Sets global config for all APIs/Endpoints
 // However, nested configs that are defined will take precedence over global config
 func setRequestOptions(request *gorequest.SuperAgent, yml load.Config, api load.API) *gorequest.SuperAgent {
-	rootCAs := x509.NewCertPool()
-	if yml.Global.Timeout > 0 {
-		request = request.Timeout(time.Duration(yml.Global.Timeout) * time.Millisecond)
-	}
-	if yml.Global.Proxy != "" {
-		request = request.Proxy(yml.Global.Proxy)
-	}
 	if yml.Global.User != "" {
 		request = request.SetBasicAuth(yml.Global.User, yml.Global.Pass)
 	}
 	for h, v := range yml.Global.Headers {
 		request = request.Set(h, v)
 	}
-	if api.Timeout > 0 {
-		request = request.Timeout(time.Duration(api.Timeout) * time.Millisecond)
+	if timeout := httpTimeout(yml, api); timeout > 0 {
+		request = request.Timeout(timeout)
 	}
-	if api.Proxy != "" {
-		request = request.Proxy(api.Proxy)
+	if proxy := requestProxy(yml, api); proxy != "" {
+		request = request.Proxy(proxy)
 	}
 	if api.User != "" {
 		request = request.SetBasicAuth(api.User, api.Pass)
@@ -230,100 +228,117 @@ func setRequestOptions(request *gorequest.SuperAgent, yml load.Config, api load.
 	for h, v := range api.Headers {
 		request = request.Set(h, v)
 	}
-
-	tmpGlobalTLSConfig := tls.Config{
-		InsecureSkipVerify: yml.Global.TLSConfig.InsecureSkipVerify,
-		MinVersion:         yml.Global.TLSConfig.MinVersion,
-		MaxVersion:         yml.Global.TLSConfig.MaxVersion,
-	}
-
-	if yml.Global.TLSConfig.Ca != "" {
-		ca, err := ioutil.ReadFile(yml.Global.TLSConfig.Ca)
+	// replayed requests are never sent, they need no token
+	if oauth2Config(yml, api).TokenURL != "" && !fixture.Replaying() {
+		authorization, err := oauth2Authorization(yml, api, false)
 		if err != nil {
-			load.Logrus.WithError(err).Error("http: failed to read ca")
+			load.Logrus.WithFields(logrus.Fields{"name": yml.Name}).WithError(err).Error("http: failed to get oauth2 token")
 		} else {
-			rootCAs.AppendCertsFromPEM(ca)
-			tmpGlobalTLSConfig.RootCAs = rootCAs
+			request = request.Set("Authorization", authorization)
 		}
 	}
 
-	if yml.Global.TLSConfig.Key != "" && yml.Global.TLSConfig.Cert != "" {
-		cert, err := tls.LoadX509KeyPair(yml.Global.TLSConfig.Cert, yml.Global.TLSConfig.Key)
-		if err != nil {
-			load.Logrus.WithError(err).Error("http: failed to load x509 keypair")
-		} else {
-			tmpGlobalTLSConfig.Certificates = []tls.Certificate{cert}
-		}
-	}
+	request = request.TLSClientConfig(requestTLSConfig(yml, api))
 
-	request = request.TLSClientConfig(&tmpGlobalTLSConfig)
+	// replayed requests are not signed either, the credentials of the signer may not be there
+	if cfg := signer.Config(api); cfg.Type != "" && !fixture.Replaying() {
+		request = signRequest(request, cfg)
+	}
+	return request
+}
 
-	if api.TLSConfig.Enable {
-		tmpAPITLSConfig := tls.Config{
-			InsecureSkipVerify: api.TLSConfig.InsecureSkipVerify,
-			MinVersion:         api.TLSConfig.MinVersion,
-			MaxVersion:         api.TLSConfig.MaxVersion,
+// httpTimeout is the timeout of the api, or the global one, 0 when neither is set
+// a request never outlives the time budget of the config run, so it is shortened to the time left
+func httpTimeout(yml load.Config, api load.API) time.Duration {
+	timeout := time.Duration(api.Timeout) * time.Millisecond
+	if timeout <= 0 {
+		timeout = time.Duration(yml.Global.Timeout) * time.Millisecond
+	}
+	if deadline, ok := yml.Context().Deadline(); ok {
+		if remaining := time.Until(deadline); timeout <= 0 || remaining < timeout {
+			timeout = remaining
 		}
-
-		if api.TLSConfig.Ca != "" {
-			ca, err := ioutil.ReadFile(api.TLSConfig.Ca)
-			if err != nil {
-				load.Logrus.WithError(err).Error("http: failed to read ca")
-			} else {
-				rootCAs.AppendCertsFromPEM(ca)
-				tmpAPITLSConfig.RootCAs = rootCAs
-			}
+		// a run out of time fails its requests right away
+		if timeout <= 0 {
+			timeout = time.Nanosecond
 		}
+	}
+	return timeout
+}
 
-		if api.TLSConfig.Key != "" && api.TLSConfig.Cert != "" {
-			cert, err := tls.LoadX509KeyPair(api.TLSConfig.Cert, api.TLSConfig.Key)
-			if err != nil {
-				load.Logrus.WithError(err).Error("http: failed to load x509 keypair")
-			} else {
-				tmpAPITLSConfig.Certificates = []tls.Certificate{cert}
-			}
-		}
+// requestProxy is the proxy of the api, or the global one
+func requestProxy(yml load.Config, api load.API) string {
+	if api.Proxy != "" {
+		return api.Proxy
+	}
+	return yml.Global.Proxy
+}
 
-		request = request.TLSClientConfig(&tmpAPITLSConfig)
+// requestTLSConfig is the tls config of the api when enabled, or the global one
+// the ca of the api is trusted along with the global one
+func requestTLSConfig(yml load.Config, api load.API) *tls.Config {
+	rootCAs := x509.NewCertPool()
+	tlsConfig := newTLSConfig(yml.Global.TLSConfig, rootCAs)
+	if api.TLSConfig.Enable {
+		tlsConfig = newTLSConfig(api.TLSConfig, rootCAs)
 	}
+	return tlsConfig
+}
 
-	if api.AliyunSigner.Key != "" && api.AliyunSigner.Secret != "" {
-		signer := aliyun.Signer{
-			Key:    api.AliyunSigner.Key,
-			Secret: api.AliyunSigner.Secret,
-		}
-		r, err := request.MakeRequest()
+// newTLSConfig reads the ca and keypair of a tls_config block, the ca is added to rootCAs
+func newTLSConfig(cfg load.TLSConfig, rootCAs *x509.CertPool) *tls.Config {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+		MinVersion:         cfg.MinVersion,
+		MaxVersion:         cfg.MaxVersion,
+	}
+
+	if cfg.Ca != "" {
+		ca, err := ioutil.ReadFile(cfg.Ca)
 		if err != nil {
-			load.Logrus.WithError(err).Error("http: signer failed to convert request for AliyunSigner.")
+			load.Logrus.WithError(err).Error("http: failed to read ca")
 		} else {
-			signerdURL, err := signer.Sign(r)
-			if err != nil {
-				load.Logrus.WithError(err).Error("http: signer failed to sign the request.")
-			} else {
-				request.Url = signerdURL
-			}
+			rootCAs.AppendCertsFromPEM(ca)
+			tlsConfig.RootCAs = rootCAs
 		}
 	}
 
-	if api.HWSigner.Key != "" && api.HWSigner.Secret != "" {
-		signer := huaweihws.Signer{
-			Key:    api.HWSigner.Key,
-			Secret: api.HWSigner.Secret,
-		}
-		r, err := request.MakeRequest()
+	if cfg.Key != "" && cfg.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.Cert, cfg.Key)
 		if err != nil {
-			load.Logrus.WithError(err).Error("http: signer failed to convert request for HWSigner.")
+			load.Logrus.WithError(err).Error("http: failed to load x509 keypair")
 		} else {
-			err := signer.Sign(r)
-			if err != nil {
-				load.Logrus.WithError(err).Error("http: signer failed to sign the request.")
-			} else {
-				request = request.Set(huaweihws.HeaderAuthorization, r.Header.Get(huaweihws.HeaderAuthorization))
-				request = request.Set(huaweihws.HeaderXDate, r.Header.Get(huaweihws.HeaderXDate))
-			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+	}
+	return tlsConfig
+}
 
+// signRequest signs the request built so far, then sets the headers and url the signer changed on it
+func signRequest(request *gorequest.SuperAgent, cfg load.Signer) *gorequest.SuperAgent {
+	s, err := signer.New(cfg)
+	if err != nil {
+		load.Logrus.WithError(err).Error("http: failed to create signer")
+		return request
+	}
+	r, err := request.MakeRequest()
+	if err != nil {
+		load.Logrus.WithError(err).Errorf("http: signer failed to convert request for %s", cfg.Type)
+		return request
+	}
+	before := r.Header.Clone()
+	if err := s.Sign(r); err != nil {
+		load.Logrus.WithError(err).Errorf("http: %s signer failed to sign the request", cfg.Type)
+		return request
+	}
+	for h := range r.Header {
+		if v := r.Header.Get(h); v != before.Get(h) {
+			request = request.Set(h, v)
 		}
 	}
+	if signedURL := r.URL.String(); signedURL != request.Url {
+		request.Url = signedURL
+	}
 	return request
 }
 
@@ -336,7 +351,11 @@ func handleJSON(sample *[]interface{}, body []byte, resp *gorequest.Response, do
 		load.Logrus.WithError(err).Error("http: failed to unmarshal json")
 		return
 	}
+	handleBody(sample, b, resp, doLoop, url, nextLink, includeHeaders)
+}
 
+// handleBody creates the samples of a body decoded as json would be
+func handleBody(sample *[]interface{}, b interface{}, resp *gorequest.Response, doLoop *bool, url *string, nextLink string, includeHeaders bool) {
 	var cb responseBody
 
 	switch t := b.(type) {
@@ -6,6 +6,9 @@
 package inputs
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -21,7 +24,9 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/newrelic/nri-flex/internal/fixture"
 	"github.com/newrelic/nri-flex/internal/load"
+	"github.com/newrelic/nri-flex/internal/signer"
 )
 
 func TestRunHttp(t *testing.T) {
@@ -318,6 +323,92 @@ func assertElementsMatch(t *testing.T, actual []interface{}, expected []interfac
 	assert.ElementsMatch(t, actual, expected)
 }
 
+func TestRunHTTPSigner(t *testing.T) {
+	load.Refresh()
+	// the server checks the signature of the method, path and body against the shared secret
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		sum := sha256.Sum256(body)
+		mac := hmac.New(sha256.New, []byte("s3cr3t"))
+		_, _ = mac.Write([]byte(r.Method + " " + r.URL.Path + " " + hex.EncodeToString(sum[:])))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"verified":%t,"date":%q}`, r.Header.Get("X-Signature") == "flex:"+hex.EncodeToString(mac.Sum(nil)), r.Header.Get("X-Date"))
+	}))
+	defer ts.Close()
+
+	config := load.Config{
+		Name: "signer",
+		APIs: []load.API{{
+			EventType: "signerSample",
+			URL:       ts.URL + "/query",
+			Method:    http.MethodPost,
+			Payload:   `{"query":"up"}`,
+			Signer: load.Signer{
+				Type:       "hmac",
+				Key:        "flex",
+				Secret:     "s3cr3t",
+				Template:   "{{.Method}} {{.Path}} {{.BodySHA256}}",
+				Header:     "X-Signature",
+				Value:      "{{.Key}}:{{.Signature}}",
+				DateHeader: "X-Date",
+			},
+		}},
+	}
+	loop := true
+	var dataStore []interface{}
+	url := config.APIs[0].URL
+	RunHTTP(&dataStore, &loop, &config, config.APIs[0], &url)
+
+	require.Len(t, dataStore, 1)
+	sample := dataStore[0].(map[string]interface{})
+	assert.Equal(t, true, sample["verified"])
+	assert.NotEmpty(t, sample["date"])
+}
+
+// countingSigner counts the requests it signs
+type countingSigner struct{ signed *int }
+
+func (s countingSigner) Sign(r *http.Request) error {
+	*s.signed++
+	r.Header.Set("X-Signature", "signed")
+	return nil
+}
+
+func TestReplayHTTPSigner(t *testing.T) {
+	load.Refresh()
+	signed := 0
+	signer.Register("replayCounting", func(cfg load.Signer) (signer.Signer, error) {
+		return countingSigner{signed: &signed}, nil
+	})
+
+	dir := t.TempDir()
+	r := fixture.NewRecorder(dir)
+	r.Add("signer", "replayed", fixture.Input{Kind: fixture.KindHTTP, Key: "http://api.example.com/query", Body: `{"up":1}`})
+	require.NoError(t, r.Write())
+	store, err := fixture.NewStore(dir)
+	require.NoError(t, err)
+	fixture.Replay(store)
+	defer fixture.Replay(nil)
+
+	config := load.Config{
+		Name: "signer",
+		APIs: []load.API{{
+			Name:      "replayed",
+			EventType: "signerSample",
+			URL:       "http://api.example.com/query",
+			Signer:    load.Signer{Type: "replayCounting"},
+		}},
+	}
+	loop := true
+	var dataStore []interface{}
+	url := config.APIs[0].URL
+	RunHTTP(&dataStore, &loop, &config, config.APIs[0], &url)
+
+	require.Len(t, dataStore, 1)
+	assert.Equal(t, float64(1), dataStore[0].(map[string]interface{})["up"])
+	assert.Zero(t, signed)
+}
+
 func newMockHttpServer(filePath string, statusCode int) *httptest.Server {
 	mockHttpHandler := mockHttpHandler{
 		filePath:   filePath,
@@ -15,7 +15,7 @@ import (
 
 // SetJMXCommand Add parameters to JMX call
 func SetJMXCommand(runCommand *string, command load.Command, api load.API, config *load.Config) {
-	*runCommand = fmt.Sprintf("echo '%v' | java -jar %vnrjmx.jar", *runCommand, load.Args.NRJMXToolPath)
+	*runCommand = fmt.Sprintf("echo '%v' | java -jar %vnrjmx.jar", *runCommand, config.State().Args().NRJMXToolPath)
 
 	// order command > api > global
 	if command.Jmx.Host != "" {
@@ -13,13 +13,18 @@ import (
 	"net/textproto"
 	"time"
 
+	"github.com/newrelic/nri-flex/internal/fixture"
 	"github.com/newrelic/nri-flex/internal/load"
 )
 
 // NetDialWithTimeout performs network dial without timeout
-func NetDialWithTimeout(dataStore *[]interface{}, command load.Command, dataSample *map[string]interface{}, api load.API, processType *string) {
+func NetDialWithTimeout(dataStore *[]interface{}, yml *load.Config, command load.Command, dataSample *map[string]interface{}, api load.API, processType *string) {
+	if fixture.Replaying() {
+		replayDial(dataStore, yml, command, dataSample, api, processType)
+		return
+	}
 
-	ctx := context.Background()
+	ctx := yml.Context()
 	// Create a channel for signal handling
 	c := make(chan struct{})
 	// Define a cancellation after default dial timeout in the context
@@ -35,16 +40,13 @@ func NetDialWithTimeout(dataStore *[]interface{}, command load.Command, dataSamp
 	defer cancel()
 
 	addr := command.Dial
-	netw := "tcp"
-	if command.Network != "" {
-		netw = command.Network
-	}
+	netw := dialNetwork(command)
 
 	var dialError error
 	var data string
 	// Run dial via a goroutine
 	load.Logrus.Debugf("commands: dialling %v : %v", addr, netw)
-	dialConn, err := net.DialTimeout(netw, addr, time.Duration(timeout)*time.Millisecond)
+	dialConn, err := (&net.Dialer{Timeout: time.Duration(timeout) * time.Millisecond}).DialContext(ctx, netw, addr)
 	if err == nil {
 		defer dialConn.Close()
 	}
@@ -71,8 +73,10 @@ func NetDialWithTimeout(dataStore *[]interface{}, command load.Command, dataSamp
 	}(dialConn, err)
 
 	// Listen for signals
+	var recordedErr error
 	select {
 	case <-ctx.Done():
+		recordedErr = ctx.Err()
 		if command.Run == "" {
 			*dataStore = append(*dataStore, map[string]interface{}{"portStatus": "closed", "addr": command.Dial, "netw": netw, "err": ctx.Err().Error()})
 			// load.StoreAppend(map[string]interface{}{"portStatus": "closed", "addr": command.Dial, "netw": netw, "err": ctx.Err().Error()})
@@ -85,6 +89,7 @@ func NetDialWithTimeout(dataStore *[]interface{}, command load.Command, dataSamp
 			load.Logrus.Debug("commands: dial " + ctx.Err().Error())
 		}
 	case <-c:
+		recordedErr = dialError
 		if command.Run == "" && dialError == nil {
 			*dataStore = append(*dataStore, map[string]interface{}{"portStatus": "open", "addr": command.Dial, "netw": netw})
 			// load.StoreAppend(map[string]interface{}{"portStatus": "open", "addr": command.Dial, "netw": netw})
@@ -96,4 +101,39 @@ func NetDialWithTimeout(dataStore *[]interface{}, command load.Command, dataSamp
 		}
 		load.Logrus.Debugf("commands: finished dial %v : %v", command.Dial, netw)
 	}
+
+	if fixture.Recording() {
+		input := fixture.Input{Kind: fixture.KindDial, Key: command.Dial, Body: data}
+		if recordedErr != nil {
+			input.Error = recordedErr.Error()
+		}
+		fixture.Add(yml.Name, api.Name, input)
+	}
+}
+
+// replayDial creates the same samples as a dial, from the recorded response
+func replayDial(dataStore *[]interface{}, yml *load.Config, command load.Command, dataSample *map[string]interface{}, api load.API, processType *string) {
+	netw := dialNetwork(command)
+	input, err := fixture.Next(yml.Name, api.Name, fixture.KindDial, command.Dial)
+	if err != nil {
+		input.Error = err.Error()
+	}
+
+	switch {
+	case command.Run == "" && input.Error == "":
+		*dataStore = append(*dataStore, map[string]interface{}{"portStatus": "open", "addr": command.Dial, "netw": netw})
+	case command.Run == "":
+		*dataStore = append(*dataStore, map[string]interface{}{"portStatus": "closed", "addr": command.Dial, "netw": netw, "err": input.Error})
+	case input.Body != "":
+		processOutput(dataStore, input.Body, dataSample, command, api, processType)
+	default:
+		load.Logrus.Error("commands: dial " + input.Error)
+	}
+}
+
+func dialNetwork(command load.Command) string {
+	if command.Network != "" {
+		return command.Network
+	}
+	return "tcp"
 }
@@ -47,7 +47,7 @@ func TestNetDial(t *testing.T) {
 	dataStore := []interface{}{}
 	dataSample := map[string]interface{}{}
 	processType := ""
-	NetDialWithTimeout(&dataStore, config.APIs[0].Commands[0], &dataSample, config.APIs[0], &processType)
+	NetDialWithTimeout(&dataStore, &config, config.APIs[0].Commands[0], &dataSample, config.APIs[0], &processType)
 
 	if len(expectedDatastore) != len(dataStore) {
 		t.Errorf("Incorrect number of samples generated expected: %d, got: %d", len(expectedDatastore), len(dataStore))
@@ -0,0 +1,262 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package inputs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/persist"
+	"github.com/newrelic/nri-flex/internal/load"
+	"github.com/newrelic/nri-flex/internal/signer"
+	"github.com/parnurzeal/gorequest"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	oauth2ClientCredentials  = "client_credentials"
+	oauth2TokenExchange      = "token_exchange"
+	oauth2TokenExchangeGrant = "urn:ietf:params:oauth:grant-type:token-exchange"
+	oauth2AccessTokenType    = "urn:ietf:params:oauth:token-type:access_token"
+	// oauth2ExpiryDelta renews tokens this long before they expire, so a request never carries an expired token
+	oauth2ExpiryDelta = 30 * time.Second
+)
+
+// oauth2Lock serializes token requests, so apis running async share a token rather than each fetching their own
+var oauth2Lock sync.Mutex
+
+// oauth2Memory caches tokens when the integration has no persistent store, eg. in tests
+var oauth2Memory = persist.NewInMemoryStore()
+
+// oauth2Token is a token cached until it expires
+type oauth2Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresAtMs int64  `json:"expires_at_ms"` // 0 when the token endpoint did not tell
+}
+
+// header is the value of the Authorization header
+func (t oauth2Token) header() string {
+	if t.TokenType == "" || strings.EqualFold(t.TokenType, "bearer") {
+		return "Bearer " + t.AccessToken
+	}
+	return t.TokenType + " " + t.AccessToken
+}
+
+func (t oauth2Token) valid() bool {
+	return t.AccessToken != "" && (t.ExpiresAtMs == 0 || time.Now().Add(oauth2ExpiryDelta).UnixNano()/1e6 < t.ExpiresAtMs)
+}
+
+// oauth2Config returns the oauth2 block of the api, or the global one when the api does not set one
+func oauth2Config(yml load.Config, api load.API) load.OAuth2 {
+	if api.OAuth2.TokenURL != "" {
+		return api.OAuth2
+	}
+	return yml.Global.OAuth2
+}
+
+func oauth2Store(storer persist.Storer) persist.Storer {
+	if storer != nil {
+		return storer
+	}
+	return oauth2Memory
+}
+
+// oauth2Key identifies the token of a client in the store, hashed to keep the config out of the store keys
+func oauth2Key(cfg load.OAuth2) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		cfg.TokenURL, cfg.GrantType, cfg.ClientID, cfg.Audience, strings.Join(cfg.Scopes, " "), cfg.SubjectToken, cfg.SubjectTokenFile,
+	}, "\x00")))
+	return "oauth2:" + hex.EncodeToString(sum[:])
+}
+
+// oauth2Authorization returns the Authorization header of the api, from the cached token while it is valid
+// renew drops the cached token first, eg. when a request was rejected with a 401
+func oauth2Authorization(yml load.Config, api load.API, renew bool) (string, error) {
+	cfg := oauth2Config(yml, api)
+	oauth2Lock.Lock()
+	defer oauth2Lock.Unlock()
+
+	store := oauth2Store(yml.State().Storer())
+	key := oauth2Key(cfg)
+	var token oauth2Token
+	if !renew {
+		if _, err := store.Get(key, &token); err == nil && token.valid() {
+			return token.header(), nil
+		}
+	}
+
+	token, err := fetchOAuth2Token(cfg, oauth2Client(yml, api))
+	if err != nil {
+		_ = store.Delete(key)
+		return "", err
+	}
+	store.Set(key, token)
+	return token.header(), nil
+}
+
+// requestTimeout is the timeout of the requests of the api, 30s when none is set
+func requestTimeout(yml load.Config, api load.API) time.Duration {
+	if timeout := httpTimeout(yml, api); timeout > 0 {
+		return timeout
+	}
+	return 30 * time.Second
+}
+
+// oauth2Client sends token requests through the proxy and with the tls config the requests of the api use
+func oauth2Client(yml load.Config, api load.API) *http.Client {
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: requestTLSConfig(yml, api)}
+	if proxy := requestProxy(yml, api); proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			load.Logrus.WithError(err).Error("oauth2: failed to parse proxy")
+		} else {
+			transport.Proxy = http.ProxyURL(proxyURL)
+		}
+	}
+	return &http.Client{Timeout: requestTimeout(yml, api), Transport: transport}
+}
+
+// fetchOAuth2Token requests a token with the client credentials or token exchange grant
+func fetchOAuth2Token(cfg load.OAuth2, client *http.Client) (oauth2Token, error) {
+	form := url.Values{}
+	switch cfg.GrantType {
+	case "", oauth2ClientCredentials:
+		form.Set("grant_type", oauth2ClientCredentials)
+	case oauth2TokenExchange, oauth2TokenExchangeGrant:
+		subjectToken := cfg.SubjectToken
+		if cfg.SubjectTokenFile != "" {
+			b, err := ioutil.ReadFile(cfg.SubjectTokenFile)
+			if err != nil {
+				return oauth2Token{}, fmt.Errorf("oauth2: failed to read subject_token_file, %v", err)
+			}
+			subjectToken = strings.TrimSpace(string(b))
+		}
+		if subjectToken == "" {
+			return oauth2Token{}, fmt.Errorf("oauth2: token_exchange requires subject_token or subject_token_file")
+		}
+		subjectTokenType := cfg.SubjectTokenType
+		if subjectTokenType == "" {
+			subjectTokenType = oauth2AccessTokenType
+		}
+		form.Set("grant_type", oauth2TokenExchangeGrant)
+		form.Set("subject_token", subjectToken)
+		form.Set("subject_token_type", subjectTokenType)
+	default:
+		return oauth2Token{}, fmt.Errorf("oauth2: unsupported grant_type %s, use client_credentials or token_exchange", cfg.GrantType)
+	}
+	if len(cfg.Scopes) > 0 {
+		form.Set("scope", strings.Join(cfg.Scopes, " "))
+	}
+	if cfg.Audience != "" {
+		form.Set("audience", cfg.Audience)
+	}
+	for k, v := range cfg.Params {
+		form.Set(k, v)
+	}
+
+	useBasicAuth := false
+	switch cfg.ClientAuth {
+	case "", "basic":
+		useBasicAuth = cfg.ClientID != ""
+	case "post":
+		if cfg.ClientID != "" {
+			form.Set("client_id", cfg.ClientID)
+			form.Set("client_secret", cfg.ClientSecret)
+		}
+	default:
+		return oauth2Token{}, fmt.Errorf("oauth2: unsupported client_auth %s, use basic or post", cfg.ClientAuth)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return oauth2Token{}, fmt.Errorf("oauth2: unable to create token request, %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if useBasicAuth {
+		req.SetBasicAuth(url.QueryEscape(cfg.ClientID), url.QueryEscape(cfg.ClientSecret))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return oauth2Token{}, fmt.Errorf("oauth2: token request failed, %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return oauth2Token{}, fmt.Errorf("oauth2: failed to read token response, %v", err)
+	}
+
+	// the body is never logged, it holds the token
+	var result struct {
+		AccessToken      string      `json:"access_token"`
+		TokenType        string      `json:"token_type"`
+		ExpiresIn        json.Number `json:"expires_in"`
+		Error            string      `json:"error"`
+		ErrorDescription string      `json:"error_description"`
+	}
+	jsonErr := json.Unmarshal(body, &result)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		if result.Error != "" {
+			return oauth2Token{}, fmt.Errorf("oauth2: token request failed, status code: %d, %s %s", resp.StatusCode, result.Error, result.ErrorDescription)
+		}
+		return oauth2Token{}, fmt.Errorf("oauth2: token request failed, status code: %d", resp.StatusCode)
+	}
+	if jsonErr != nil {
+		return oauth2Token{}, fmt.Errorf("oauth2: failed to unmarshal token response, %v", jsonErr)
+	}
+	if result.AccessToken == "" {
+		return oauth2Token{}, fmt.Errorf("oauth2: token response has no access_token")
+	}
+
+	token := oauth2Token{AccessToken: result.AccessToken, TokenType: result.TokenType}
+	if expiresIn, err := result.ExpiresIn.Int64(); err == nil && expiresIn > 0 {
+		token.ExpiresAtMs = time.Now().Add(time.Duration(expiresIn)*time.Second).UnixNano() / 1e6
+	}
+	load.Logrus.WithFields(logrus.Fields{
+		"token_url":  cfg.TokenURL,
+		"client_id":  cfg.ClientID,
+		"expires_in": result.ExpiresIn.String(),
+	}).Debug("oauth2: fetched token")
+	return token, nil
+}
+
+// endWithOAuth2 sends the request with retries, when the api token is rejected with a 401 a new one is fetched
+// and the request sent again once, the token may have been revoked before it expired
+func endWithOAuth2(request *gorequest.SuperAgent, yml *load.Config, api load.API, reqURL string) (gorequest.Response, []error) {
+	resp, errs := endWithRetries(request, yml, api, reqURL)
+	if resp == nil || resp.StatusCode != http.StatusUnauthorized || oauth2Config(*yml, api).TokenURL == "" {
+		return resp, errs
+	}
+	authorization, err := oauth2Authorization(*yml, api, true)
+	if err != nil {
+		load.Logrus.WithFields(logrus.Fields{"name": yml.Name}).WithError(err).Error("http: failed to renew oauth2 token")
+		return resp, errs
+	}
+	load.Logrus.Debugf("http: URL %v unauthorized, retrying with a new oauth2 token", reqURL)
+	request.Set("Authorization", authorization)
+	return endWithRetries(request, yml, api, reqURL)
+}
+
+// VerifyOAuth2 rejects an api getting oauth2 tokens with a signer that sets the Authorization header too
+func VerifyOAuth2(yml load.Config, api load.API) error {
+	if oauth2Config(yml, api).TokenURL == "" {
+		return nil
+	}
+	if cfg := signer.Config(api); signer.WritesAuthorization(cfg) {
+		return fmt.Errorf("oauth2: cannot be used with the %s signer, both set the Authorization header", cfg.Type)
+	}
+	return nil
+}
@@ -0,0 +1,424 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package inputs
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/persist"
+	"github.com/newrelic/nri-flex/internal/fixture"
+	"github.com/newrelic/nri-flex/internal/load"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tokenServer issues tokens t1, t2... and records the form of every token request
+type tokenServer struct {
+	*httptest.Server
+	lock      sync.Mutex
+	requests  []http.Request
+	expiresIn int
+}
+
+func newTokenServer(t *testing.T, expiresIn int) *tokenServer {
+	s := &tokenServer{expiresIn: expiresIn}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.requests = append(s.requests, *r)
+		if r.PostForm.Get("client_secret") == "wrong" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = fmt.Fprint(w, `{"error":"invalid_client","error_description":"bad secret"}`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"access_token":"t%d","token_type":"bearer","expires_in":%d}`, len(s.requests), s.expiresIn)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// apiServer replies with the Authorization header of a request, rejecting the tokens listed as revoked
+func newAPIServer(t *testing.T, revoked ...string) *httptest.Server {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		for _, token := range revoked {
+			if authorization == "Bearer "+token {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"authorization":%q}`, authorization)
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func runOAuth2(t *testing.T, config *load.Config) []interface{} {
+	loop := true
+	var dataStore []interface{}
+	api := config.APIs[0]
+	url := api.URL
+	RunHTTP(&dataStore, &loop, config, api, &url)
+	return dataStore
+}
+
+func TestOAuth2ClientCredentials(t *testing.T) {
+	load.Refresh()
+	load.Storer = persist.NewInMemoryStore()
+	defer func() { load.Storer = nil }()
+	tokens := newTokenServer(t, 3600)
+	ts := newAPIServer(t)
+
+	config := load.Config{
+		Name: "oauth2",
+		Global: load.Global{OAuth2: load.OAuth2{
+			TokenURL:     tokens.URL,
+			ClientID:     "flex",
+			ClientSecret: "s3cr3t",
+			Scopes:       []string{"metrics.read", "status.read"},
+			Audience:     "https://api.example.com",
+		}},
+		APIs: []load.API{{EventType: "oauth2Sample", URL: ts.URL}},
+	}
+
+	// the token is fetched once, then reused from the store until it expires
+	for i := 0; i < 2; i++ {
+		samples := runOAuth2(t, &config)
+		require.Len(t, samples, 1)
+		assert.Equal(t, "Bearer t1", samples[0].(map[string]interface{})["authorization"])
+	}
+	require.Len(t, tokens.requests, 1)
+
+	request := tokens.requests[0]
+	assert.Equal(t, "client_credentials", request.PostForm.Get("grant_type"))
+	assert.Equal(t, "metrics.read status.read", request.PostForm.Get("scope"))
+	assert.Equal(t, "https://api.example.com", request.PostForm.Get("audience"))
+	user, pass, ok := request.BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "flex", user)
+	assert.Equal(t, "s3cr3t", pass)
+}
+
+func TestOAuth2StateStore(t *testing.T) {
+	load.Refresh()
+	tokens := newTokenServer(t, 3600)
+	ts := newAPIServer(t)
+
+	// tokens are kept in the store of the state the config runs with
+	store := persist.NewInMemoryStore()
+	config := load.Config{
+		Name:   "oauth2",
+		Global: load.Global{OAuth2: load.OAuth2{TokenURL: tokens.URL, ClientID: "state", ClientSecret: "s3cr3t"}},
+		APIs:   []load.API{{EventType: "oauth2Sample", URL: ts.URL}},
+	}
+	config.SetState(load.NewState(load.Args, store))
+
+	samples := runOAuth2(t, &config)
+	require.Len(t, samples, 1)
+	assert.Equal(t, "Bearer t1", samples[0].(map[string]interface{})["authorization"])
+
+	key := oauth2Key(config.Global.OAuth2)
+	var token oauth2Token
+	_, err := store.Get(key, &token)
+	require.NoError(t, err)
+	assert.Equal(t, "t1", token.AccessToken)
+	_, err = oauth2Memory.Get(key, &token)
+	assert.Equal(t, persist.ErrNotFound, err)
+}
+
+func TestOAuth2Renew(t *testing.T) {
+	tests := map[string]struct {
+		expiresIn int
+		revoked   []string
+		tokens    int
+		want      []string
+	}{
+		"tokens about to expire are renewed": {expiresIn: 10, tokens: 2, want: []string{"Bearer t1", "Bearer t2"}},
+		"revoked token is renewed on 401":    {expiresIn: 3600, revoked: []string{"t1"}, tokens: 2, want: []string{"Bearer t2", "Bearer t2"}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			load.Refresh()
+			load.Storer = persist.NewInMemoryStore()
+			defer func() { load.Storer = nil }()
+			tokens := newTokenServer(t, tc.expiresIn)
+			ts := newAPIServer(t, tc.revoked...)
+
+			config := load.Config{
+				Name: "oauth2",
+				APIs: []load.API{{
+					EventType: "oauth2Sample",
+					URL:       ts.URL,
+					OAuth2:    load.OAuth2{TokenURL: tokens.URL, ClientID: "flex", ClientSecret: "s3cr3t", ClientAuth: "post"},
+				}},
+			}
+
+			var got []string
+			for range tc.want {
+				samples := runOAuth2(t, &config)
+				require.Len(t, samples, 1)
+				got = append(got, samples[0].(map[string]interface{})["authorization"].(string))
+			}
+			assert.Equal(t, tc.want, got)
+			require.Len(t, tokens.requests, tc.tokens)
+			assert.Equal(t, "s3cr3t", tokens.requests[0].PostForm.Get("client_secret"))
+		})
+	}
+}
+
+func TestOAuth2TokenExchange(t *testing.T) {
+	load.Refresh()
+	tokens := newTokenServer(t, 3600)
+	subjectTokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, ioutil.WriteFile(subjectTokenFile, []byte("service-account-token\n"), 0600))
+
+	cfg := load.OAuth2{
+		TokenURL:         tokens.URL,
+		GrantType:        "token_exchange",
+		SubjectTokenFile: subjectTokenFile,
+		Audience:         "metrics",
+		Params:           map[string]string{"requested_token_type": "urn:ietf:params:oauth:token-type:access_token"},
+	}
+	token, err := fetchOAuth2Token(cfg, oauth2Client(load.Config{}, load.API{}))
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer t1", token.header())
+
+	form := tokens.requests[0].PostForm
+	assert.Equal(t, "urn:ietf:params:oauth:grant-type:token-exchange", form.Get("grant_type"))
+	assert.Equal(t, "service-account-token", form.Get("subject_token"))
+	assert.Equal(t, "urn:ietf:params:oauth:token-type:access_token", form.Get("subject_token_type"))
+	assert.Equal(t, "metrics", form.Get("audience"))
+	assert.Equal(t, "urn:ietf:params:oauth:token-type:access_token", form.Get("requested_token_type"))
+	_, _, ok := tokens.requests[0].BasicAuth()
+	assert.False(t, ok)
+}
+
+func TestOAuth2Errors(t *testing.T) {
+	tokens := newTokenServer(t, 3600)
+	tests := map[string]struct {
+		cfg load.OAuth2
+		err string
+	}{
+		"error response": {
+			cfg: load.OAuth2{TokenURL: tokens.URL, ClientID: "flex", ClientSecret: "wrong", ClientAuth: "post"},
+			err: "oauth2: token request failed, status code: 401, invalid_client bad secret",
+		},
+		"unsupported grant": {
+			cfg: load.OAuth2{TokenURL: tokens.URL, GrantType: "password"},
+			err: "oauth2: unsupported grant_type password, use client_credentials or token_exchange",
+		},
+		"missing subject token": {
+			cfg: load.OAuth2{TokenURL: tokens.URL, GrantType: "token_exchange"},
+			err: "oauth2: token_exchange requires subject_token or subject_token_file",
+		},
+		"unsupported client auth": {
+			cfg: load.OAuth2{TokenURL: tokens.URL, ClientAuth: "jwt"},
+			err: "oauth2: unsupported client_auth jwt, use basic or post",
+		},
+	}
+	for name, tc := range tests {
+		_, err := fetchOAuth2Token(tc.cfg, oauth2Client(load.Config{}, load.API{}))
+		assert.EqualError(t, err, tc.err, name)
+	}
+}
+
+func TestOAuth2Replay(t *testing.T) {
+	load.Refresh()
+	dir := t.TempDir()
+	r := fixture.NewRecorder(dir)
+	r.Add("oauth2", "replayed", fixture.Input{Kind: fixture.KindHTTP, Key: "http://api.example.com/stats", Body: `{"queued":3}`})
+	require.NoError(t, r.Write())
+	store, err := fixture.NewStore(dir)
+	require.NoError(t, err)
+	fixture.Replay(store)
+	defer fixture.Replay(nil)
+	hook := new(test.Hook)
+	load.Logrus.AddHook(hook)
+	defer load.Logrus.ReplaceHooks(logrus.LevelHooks{})
+
+	// the token endpoint is unreachable, replaying must not need it
+	config := load.Config{
+		Name: "oauth2",
+		APIs: []load.API{{
+			Name:      "replayed",
+			EventType: "oauth2Sample",
+			URL:       "http://api.example.com/stats",
+			OAuth2:    load.OAuth2{TokenURL: "http://127.0.0.1:1/token", ClientID: "flex", ClientSecret: "s3cr3t"},
+		}},
+	}
+	samples := runOAuth2(t, &config)
+	require.Len(t, samples, 1)
+	assert.Equal(t, float64(3), samples[0].(map[string]interface{})["queued"])
+	for _, entry := range hook.AllEntries() {
+		assert.Greater(t, entry.Level, logrus.ErrorLevel, entry.Message)
+	}
+}
+
+func TestOAuth2TokenTLS(t *testing.T) {
+	load.Refresh()
+	tokens := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprint(w, `{"access_token":"tls","expires_in":3600}`)
+	}))
+	defer tokens.Close()
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, ioutil.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tokens.Certificate().Raw}), 0600))
+
+	tests := map[string]struct {
+		global load.TLSConfig
+		api    load.TLSConfig
+		err    bool
+	}{
+		"global ca":           {global: load.TLSConfig{Ca: ca}},
+		"api ca":              {api: load.TLSConfig{Enable: true, Ca: ca}},
+		"insecure skip":       {global: load.TLSConfig{InsecureSkipVerify: true}},
+		"unknown ca rejected": {err: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := load.OAuth2{TokenURL: tokens.URL, ClientID: name}
+			yml := load.Config{Name: "oauth2", Global: load.Global{TLSConfig: tc.global}}
+			api := load.API{OAuth2: cfg, TLSConfig: tc.api}
+			token, err := fetchOAuth2Token(cfg, oauth2Client(yml, api))
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "Bearer tls", token.header())
+		})
+	}
+}
+
+func TestOAuth2TokenProxy(t *testing.T) {
+	load.Refresh()
+	// the proxy answers for the token endpoint, which does not exist
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprint(w, `{"access_token":"proxied"}`)
+	}))
+	defer proxy.Close()
+
+	cfg := load.OAuth2{TokenURL: "http://tokens.invalid/token"}
+	api := load.API{OAuth2: cfg, Proxy: proxy.URL}
+	token, err := fetchOAuth2Token(cfg, oauth2Client(load.Config{Global: load.Global{Proxy: "http://127.0.0.1:1"}}, api))
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer proxied", token.header())
+	assert.Equal(t, []string{"http://tokens.invalid/token"}, proxied)
+}
+
+func TestOAuth2RenewRetries(t *testing.T) {
+	load.Refresh()
+	tokens := newTokenServer(t, 3600)
+	// t1 is revoked, the first request with t2 fails with a retryable status code
+	var lock sync.Mutex
+	var authorizations []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		authorization := r.Header.Get("Authorization")
+		authorizations = append(authorizations, authorization)
+		switch {
+		case authorization == "Bearer t1":
+			w.WriteHeader(http.StatusUnauthorized)
+		case len(authorizations) == 2:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = fmt.Fprintf(w, `{"authorization":%q}`, authorization)
+		}
+	}))
+	defer ts.Close()
+
+	config := load.Config{
+		Name: "oauth2",
+		APIs: []load.API{{
+			EventType: "oauth2Sample",
+			URL:       ts.URL,
+			OAuth2:    load.OAuth2{TokenURL: tokens.URL, ClientID: "retries", ClientSecret: "s3cr3t"},
+			Retry:     load.Retry{Attempts: 2, Backoff: "1ms"},
+		}},
+	}
+	samples := runOAuth2(t, &config)
+	require.Len(t, samples, 1)
+	assert.Equal(t, "Bearer t2", samples[0].(map[string]interface{})["authorization"])
+	assert.Equal(t, []string{"Bearer t1", "Bearer t2", "Bearer t2"}, authorizations)
+}
+
+func TestOAuth2Steps(t *testing.T) {
+	load.Refresh()
+	tokens := newTokenServer(t, 3600)
+	ts := newAPIServer(t, "t1")
+
+	api := load.API{
+		Name:      "steps",
+		EventType: "oauth2Sample",
+		URL:       ts.URL,
+		OAuth2:    load.OAuth2{TokenURL: tokens.URL, ClientID: "steps", ClientSecret: "s3cr3t"},
+		Steps:     []load.HTTPStep{{Name: "login", URL: "/login"}, {Name: "stats", URL: "/stats"}},
+	}
+	config := load.Config{Name: "oauth2", APIs: []load.API{api}}
+
+	// the revoked token is renewed by the first step, the second one reuses the new token
+	var dataStore []interface{}
+	require.NoError(t, RunHTTPSteps(&dataStore, &config, api))
+	require.Len(t, dataStore, 1)
+	assert.Equal(t, "Bearer t2", dataStore[0].(map[string]interface{})["authorization"])
+	assert.Len(t, tokens.requests, 2)
+}
+
+func TestVerifyOAuth2(t *testing.T) {
+	oauth2 := load.OAuth2{TokenURL: "http://localhost/token"}
+	tests := map[string]struct {
+		yml load.Config
+		api load.API
+		err string
+	}{
+		"no signer":         {api: load.API{OAuth2: oauth2}},
+		"no oauth2":         {api: load.API{Signer: load.Signer{Type: "aws_sigv4"}}},
+		"aliyun signer":     {api: load.API{OAuth2: oauth2, Signer: load.Signer{Type: "aliyun"}}},
+		"hmac other header": {api: load.API{OAuth2: oauth2, Signer: load.Signer{Type: "hmac", Header: "X-Signature"}}},
+		"aws signer": {
+			api: load.API{OAuth2: oauth2, Signer: load.Signer{Type: "aws_sigv4"}},
+			err: "oauth2: cannot be used with the aws_sigv4 signer, both set the Authorization header",
+		},
+		"global oauth2 with hw_signer": {
+			yml: load.Config{Global: load.Global{OAuth2: oauth2}},
+			api: load.API{HWSigner: load.HWSigner{Key: "k", Secret: "s"}},
+			err: "oauth2: cannot be used with the huawei signer, both set the Authorization header",
+		},
+		"hmac authorization header": {
+			api: load.API{OAuth2: oauth2, Signer: load.Signer{Type: "hmac", Header: "authorization"}},
+			err: "oauth2: cannot be used with the hmac signer, both set the Authorization header",
+		},
+		"hmac default header": {
+			api: load.API{OAuth2: oauth2, Signer: load.Signer{Type: "hmac"}},
+			err: "oauth2: cannot be used with the hmac signer, both set the Authorization header",
+		},
+	}
+	for name, tc := range tests {
+		err := VerifyOAuth2(tc.yml, tc.api)
+		if tc.err == "" {
+			assert.NoError(t, err, name)
+		} else {
+			assert.EqualError(t, err, tc.err, name)
+		}
+	}
+}
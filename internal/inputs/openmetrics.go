@@ -0,0 +1,125 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package inputs
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// openMetricsToText converts the openmetrics text format to the prometheus text format the parser reads
+// - # EOF and # UNIT lines are dropped
+// - counter families are named after their _total samples, info families after their _info samples
+// - info and stateset families are gauges, unknown families untyped, gaugehistogram families have no type
+// - _created samples, exemplars are dropped, timestamps converted from seconds to milliseconds
+func openMetricsToText(body []byte) string {
+	lines := strings.Split(string(body), "\n")
+
+	// metadata may come in any order, so every type is known before a HELP is renamed
+	types := map[string]string{}
+	for _, line := range lines {
+		if parts := strings.SplitN(strings.TrimRight(line, "\r"), " ", 4); len(parts) == 4 && parts[0] == "#" && parts[1] == "TYPE" {
+			types[parts[2]] = parts[3]
+		}
+	}
+
+	var out strings.Builder
+	for _, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			parts := strings.SplitN(line, " ", 4)
+			if len(parts) < 3 || (parts[1] != "TYPE" && parts[1] != "HELP") {
+				continue
+			}
+			name, metricType := openMetricsFamily(parts[2], types[parts[2]])
+			if metricType == "" {
+				continue
+			}
+			if parts[1] == "TYPE" {
+				parts[3] = metricType
+			}
+			parts[2] = name
+			out.WriteString(strings.Join(parts, " "))
+			out.WriteString("\n")
+			continue
+		}
+		if openMetricsCreated(line, types) {
+			continue
+		}
+		out.WriteString(openMetricsSample(line))
+		out.WriteString("\n")
+	}
+	return out.String()
+}
+
+// openMetricsFamily returns the prometheus name and type of a family, no type when its samples are left untyped
+func openMetricsFamily(name string, metricType string) (string, string) {
+	switch metricType {
+	case "counter":
+		return name + "_total", metricType
+	case "info":
+		return name + "_info", "gauge"
+	case "stateset":
+		return name, "gauge"
+	case "unknown":
+		return name, "untyped"
+	case "gaugehistogram":
+		return name, ""
+	}
+	return name, metricType
+}
+
+// openMetricsCreated reports whether a sample is the creation time of a counter, histogram or summary
+func openMetricsCreated(line string, types map[string]string) bool {
+	end := strings.IndexAny(line, "{ ")
+	if end < 0 || !strings.HasSuffix(line[:end], "_created") {
+		return false
+	}
+	switch types[strings.TrimSuffix(line[:end], "_created")] {
+	case "counter", "histogram", "summary":
+		return true
+	}
+	return false
+}
+
+// openMetricsSample drops the exemplar of a sample and converts its timestamp to milliseconds
+func openMetricsSample(line string) string {
+	end := strings.IndexAny(line, "{ ")
+	if end < 0 {
+		return line
+	}
+	if line[end] == '{' {
+		// label values may hold spaces and #
+		quoted := false
+		for i := end + 1; i < len(line); i++ {
+			switch {
+			case line[i] == '\\' && quoted:
+				i++
+			case line[i] == '"':
+				quoted = !quoted
+			case line[i] == '}' && !quoted:
+				end = i + 1
+				i = len(line)
+			}
+		}
+	}
+
+	rest := line[end:]
+	if i := strings.Index(rest, " # "); i >= 0 {
+		rest = rest[:i]
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 2 {
+		if seconds, err := strconv.ParseFloat(fields[1], 64); err == nil {
+			fields[1] = strconv.FormatInt(int64(math.Round(seconds*1000)), 10)
+		}
+	}
+	return line[:end] + " " + strings.Join(fields, " ")
+}
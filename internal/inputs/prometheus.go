@@ -42,7 +42,7 @@ func Prometheus(dataStore *[]interface{}, input io.Reader, cfg *load.Config, api
 	}()
 
 	if (*cfg).MetricAPI {
-		prometheusMetricAPI(api, &mfChan, cfg.Name)
+		prometheusMetricAPI(api, &mfChan, cfg.Name, cfg.State())
 	} else {
 		prometheusStandard(api, &mfChan, dataStore, cfg.Name)
 	}
@@ -185,7 +185,7 @@ func prometheusNewFamily(dtoMF *dto.MetricFamily, dataStore *[]interface{}, api
 	}
 }
 
-func prometheusMetricAPI(api *load.API, mfChan *chan *dto.MetricFamily, cfgName string) {
+func prometheusMetricAPI(api *load.API, mfChan *chan *dto.MetricFamily, cfgName string, state *load.State) {
 	load.Logrus.WithFields(logrus.Fields{
 		"name": cfgName,
 	}).Debug("prometheus: parser generating standard event output")
@@ -228,7 +228,7 @@ func prometheusMetricAPI(api *load.API, mfChan *chan *dto.MetricFamily, cfgName
 					CommonAttributes: attributes,
 					Metrics:          summaryMetrics,
 				}
-				load.MetricsStoreAppend(Metrics)
+				state.MetricsStoreAppend(Metrics)
 			} else if mf.GetType() == dto.MetricType_HISTOGRAM {
 				attributes["prometheusType"] = "histogram"
 				histogramMetrics := []map[string]interface{}{}
@@ -264,7 +264,7 @@ func prometheusMetricAPI(api *load.API, mfChan *chan *dto.MetricFamily, cfgName
 					CommonAttributes: attributes,
 					Metrics:          histogramMetrics,
 				}
-				load.MetricsStoreAppend(Metrics)
+				state.MetricsStoreAppend(Metrics)
 			} else if mf.GetType() == dto.MetricType_GAUGE {
 				attributes["prometheusType"] = "gauge"
 				Metrics := load.Metrics{
@@ -277,7 +277,7 @@ func prometheusMetricAPI(api *load.API, mfChan *chan *dto.MetricFamily, cfgName
 							"value": getValue(m),
 						}},
 				}
-				load.MetricsStoreAppend(Metrics)
+				state.MetricsStoreAppend(Metrics)
 			} else if mf.GetType() == dto.MetricType_COUNTER {
 				attributes["prometheusType"] = "counter"
 				Metrics := load.Metrics{
@@ -290,7 +290,7 @@ func prometheusMetricAPI(api *load.API, mfChan *chan *dto.MetricFamily, cfgName
 							"value": getValue(m),
 						}},
 				}
-				load.MetricsStoreAppend(Metrics)
+				state.MetricsStoreAppend(Metrics)
 			}
 		}
 	}
@@ -0,0 +1,132 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package inputs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/newrelic/nri-flex/internal/load"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Input is a source of data for an api
+type Input interface {
+	// Name identifies the input, it is reported as the inputType of the api and keys its block under input
+	Name() string
+	// Matches reports whether the input fetches the data of api
+	Matches(api load.API) bool
+	// Validate checks the config of an api the input matches, before anything runs
+	Validate(api load.API) error
+	// Fetch appends the data of the api to dataStore
+	Fetch(dataStore *[]interface{}, yml *load.Config, apiNo int) error
+}
+
+// registry holds the inputs in the order they are matched against an api
+// the built in inputs come first, an api with blocks of several inputs is fetched by the first
+var registry = struct {
+	sync.RWMutex
+	inputs []Input
+}{inputs: []Input{
+	fileInput{},
+	cacheInput{},
+	ingestInput{},
+	commandsInput{},
+	httpInput{},
+	databaseInput{},
+	scpInput{},
+}}
+
+// Register adds an input, it is matched after the inputs registered before it
+// it panics if an input of the same name is registered, like database/sql does for drivers
+func Register(input Input) {
+	registry.Lock()
+	defer registry.Unlock()
+	for _, registered := range registry.inputs {
+		if registered.Name() == input.Name() {
+			panic(fmt.Sprintf("inputs: Register called twice for input %s", input.Name()))
+		}
+	}
+	registry.inputs = append(registry.inputs, input)
+}
+
+// Lookup returns the input that fetches the data of api, or nil if none does
+// apis without the block of a registered input are matched by the block of the built in input their fields select
+func Lookup(api load.API) Input {
+	api = withLegacyBlock(api)
+	registry.RLock()
+	defer registry.RUnlock()
+	for _, input := range registry.inputs {
+		if input.Matches(api) {
+			return input
+		}
+	}
+	return nil
+}
+
+// Registered returns the name of every registered input
+func Registered() []string {
+	registry.RLock()
+	defer registry.RUnlock()
+	names := make([]string, 0, len(registry.inputs))
+	for _, input := range registry.inputs {
+		names = append(names, input.Name())
+	}
+	return names
+}
+
+// Validate checks that every block under input belongs to a registered input, and the config of the input of api
+func Validate(api load.API) error {
+	var unknown []string
+	for name := range api.Input {
+		if !isRegistered(name) {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown input %s, registered inputs are %s", strings.Join(unknown, ", "), strings.Join(Registered(), ", "))
+	}
+
+	api = withLegacyBlock(api)
+	input := Lookup(api)
+	if input == nil {
+		return nil
+	}
+	if err := input.Validate(api); err != nil {
+		return fmt.Errorf("%s input: %v", input.Name(), err)
+	}
+	return nil
+}
+
+// DecodeConfig decodes the block of an input under input into out, so inputs can declare their own config
+func DecodeConfig(api load.API, name string, out interface{}) error {
+	block, ok := api.Input[name]
+	if !ok {
+		return fmt.Errorf("inputs: no config for input %s", name)
+	}
+	b, err := yaml.Marshal(block)
+	if err != nil {
+		return fmt.Errorf("inputs: failed to read config of input %s, %v", name, err)
+	}
+	if err := yaml.UnmarshalStrict(b, out); err != nil {
+		return fmt.Errorf("inputs: invalid config of input %s, %v", name, err)
+	}
+	return nil
+}
+
+func isRegistered(name string) bool {
+	registry.RLock()
+	defer registry.RUnlock()
+	for _, input := range registry.inputs {
+		if input.Name() == name {
+			return true
+		}
+	}
+	return false
+}
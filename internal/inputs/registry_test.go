@@ -0,0 +1,158 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package inputs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-flex/internal/load"
+)
+
+func TestLookup(t *testing.T) {
+	commands := []load.Command{{Run: "echo a:1"}}
+
+	tests := map[string]struct {
+		api      load.API
+		expected string
+	}{
+		"none":                 {load.API{Name: "empty"}, ""},
+		"file over url":        {load.API{File: "a.json", URL: "http://localhost"}, "file"},
+		"cache":                {load.API{Cache: "http://localhost"}, "cache"},
+		"ingest":               {load.API{Ingest: true}, "ingest"},
+		"commands over url":    {load.API{Commands: commands, URL: "http://localhost"}, "commands"},
+		"http":                 {load.API{URL: "http://localhost"}, "http"},
+		"database queries":     {load.API{Commands: commands, Database: "postgres", DBConn: "host=localhost"}, "database"},
+		"database without url": {load.API{Database: "postgres", DBConn: "host=localhost"}, "database"},
+		"scp":                  {load.API{Scp: load.SCP{Host: "localhost"}}, "scp"},
+		"file block":           {load.API{Input: map[string]interface{}{"file": map[interface{}]interface{}{"path": "a.json"}}}, "file"},
+		"block over fields":    {load.API{URL: "http://localhost", Input: map[string]interface{}{"ingest": nil}}, "ingest"},
+		"unregistered block":   {load.API{URL: "http://localhost", Input: map[string]interface{}{"kafka": nil}}, "http"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			input := Lookup(tc.api)
+			if tc.expected == "" {
+				assert.Nil(t, input)
+				return
+			}
+			require.NotNil(t, input)
+			assert.Equal(t, tc.expected, input.Name())
+		})
+	}
+}
+
+func TestDecodeConfig(t *testing.T) {
+	var cfg struct {
+		Brokers []string `yaml:"brokers"`
+	}
+	api := load.API{Input: map[string]interface{}{
+		"kafka": map[interface{}]interface{}{"brokers": []interface{}{"localhost:9092"}},
+	}}
+	require.NoError(t, DecodeConfig(api, "kafka", &cfg))
+	assert.Equal(t, []string{"localhost:9092"}, cfg.Brokers)
+
+	assert.Error(t, DecodeConfig(api, "redis", &cfg))
+	api.Input["kafka"] = map[interface{}]interface{}{"broker": "localhost:9092"}
+	assert.Error(t, DecodeConfig(api, "kafka", &cfg), "unknown keys must be rejected")
+}
+
+func TestConfigure(t *testing.T) {
+	tests := map[string]struct {
+		api      load.API
+		expected load.API
+		err      string
+	}{
+		"legacy fields get a block": {
+			api:      load.API{URL: "http://localhost"},
+			expected: load.API{URL: "http://localhost", Input: map[string]interface{}{"http": nil}},
+		},
+		"block sets fields": {
+			api: load.API{
+				Headers: map[string]string{"accept": "application/json", "user": "flex"},
+				Input: map[string]interface{}{"http": map[interface{}]interface{}{
+					"url":     "http://localhost/status",
+					"method":  "POST",
+					"headers": map[interface{}]interface{}{"accept": "text/plain"},
+				}},
+			},
+			expected: load.API{
+				URL:     "http://localhost/status",
+				Method:  "POST",
+				Headers: map[string]string{"accept": "text/plain", "user": "flex"},
+			},
+		},
+		"commands block": {
+			api: load.API{Input: map[string]interface{}{"commands": map[interface{}]interface{}{
+				"shell":    "/bin/bash",
+				"async":    true,
+				"commands": []interface{}{map[interface{}]interface{}{"run": "echo a:1"}},
+			}}},
+			expected: load.API{Shell: "/bin/bash", CommandsAsync: true, Commands: []load.Command{{Run: "echo a:1"}}},
+		},
+		"database block": {
+			api: load.API{Input: map[string]interface{}{"database": map[interface{}]interface{}{
+				"type":    "postgres",
+				"conn":    "host=localhost",
+				"queries": []interface{}{map[interface{}]interface{}{"run": "select 1"}},
+			}}},
+			expected: load.API{Database: "postgres", DBConn: "host=localhost", DBQueries: []load.Command{{Run: "select 1"}}},
+		},
+		"scp block": {
+			api:      load.API{Input: map[string]interface{}{"scp": map[interface{}]interface{}{"host": "localhost", "remote_file": "/tmp/a.json"}}},
+			expected: load.API{Scp: load.SCP{Host: "localhost", RemoteFile: "/tmp/a.json"}},
+		},
+		"unknown key": {
+			api: load.API{Input: map[string]interface{}{"file": map[interface{}]interface{}{"file": "a.json"}}},
+			err: "invalid config of input file",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			api := tc.api
+			err := Configure(&api)
+			if tc.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.NoError(t, err)
+			if tc.expected.Input == nil {
+				tc.expected.Input = tc.api.Input
+			}
+			assert.Equal(t, tc.expected, api)
+		})
+	}
+}
+
+func TestValidateBuiltin(t *testing.T) {
+	tests := map[string]struct {
+		api load.API
+		err string
+	}{
+		"legacy":            {load.API{File: "a.json"}, ""},
+		"block":             {load.API{Input: map[string]interface{}{"cache": map[interface{}]interface{}{"name": "status"}}}, ""},
+		"empty block":       {load.API{Input: map[string]interface{}{"file": nil}}, "file input: file requires path"},
+		"invalid block":     {load.API{Input: map[string]interface{}{"http": map[interface{}]interface{}{"uri": "http://localhost"}}}, "http input: inputs: invalid config of input http"},
+		"invalid commands":  {load.API{Input: map[string]interface{}{"commands": map[interface{}]interface{}{"commands": []interface{}{map[interface{}]interface{}{"shell": "sh"}}}}}, "commands input: commands[0] requires run"},
+		"database requires": {load.API{Input: map[string]interface{}{"database": map[interface{}]interface{}{"type": "postgres"}}}, "database input: database requires type and conn"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := Validate(tc.api)
+			if tc.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.err)
+		})
+	}
+}
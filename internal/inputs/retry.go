@@ -0,0 +1,199 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package inputs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/newrelic/nri-flex/internal/load"
+	"github.com/parnurzeal/gorequest"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	retryTimeout    = "timeout"
+	retryConnection = "connection"
+	retryDNS        = "dns"
+	retryNone       = "none"
+)
+
+// httpRetry is the retry block of an api, with its defaults set
+type httpRetry struct {
+	attempts      int
+	backoff       time.Duration
+	maxBackoff    time.Duration
+	statusCodes   map[int]bool
+	networkErrors map[string]bool
+}
+
+func newHTTPRetry(cfg load.Retry) (httpRetry, error) {
+	retry := httpRetry{
+		attempts:      cfg.Attempts,
+		backoff:       time.Second,
+		maxBackoff:    30 * time.Second,
+		statusCodes:   map[int]bool{},
+		networkErrors: map[string]bool{},
+	}
+	if retry.attempts < 1 {
+		retry.attempts = 1
+	}
+	var err error
+	if cfg.Backoff != "" {
+		if retry.backoff, err = time.ParseDuration(cfg.Backoff); err != nil {
+			return httpRetry{}, fmt.Errorf("retry: invalid backoff, %v", err)
+		}
+	}
+	if cfg.MaxBackoff != "" {
+		if retry.maxBackoff, err = time.ParseDuration(cfg.MaxBackoff); err != nil {
+			return httpRetry{}, fmt.Errorf("retry: invalid max_backoff, %v", err)
+		}
+	}
+	switch {
+	case retry.backoff <= 0:
+		return httpRetry{}, fmt.Errorf("retry: backoff must be positive, got %v", retry.backoff)
+	case retry.maxBackoff <= 0:
+		return httpRetry{}, fmt.Errorf("retry: max_backoff must be positive, got %v", retry.maxBackoff)
+	case cfg.MaxBackoff != "" && retry.maxBackoff < retry.backoff:
+		return httpRetry{}, fmt.Errorf("retry: max_backoff %v is shorter than backoff %v", retry.maxBackoff, retry.backoff)
+	case retry.maxBackoff < retry.backoff:
+		// only the default max_backoff is raised to a longer backoff
+		retry.maxBackoff = retry.backoff
+	}
+
+	statusCodes := cfg.StatusCodes
+	if len(statusCodes) == 0 {
+		statusCodes = []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+	}
+	for _, code := range statusCodes {
+		retry.statusCodes[code] = true
+	}
+
+	networkErrors := cfg.NetworkErrors
+	if len(networkErrors) == 0 {
+		networkErrors = []string{retryTimeout, retryConnection}
+	}
+	for _, kind := range networkErrors {
+		switch kind {
+		case retryTimeout, retryConnection, retryDNS:
+			retry.networkErrors[kind] = true
+		case retryNone:
+		default:
+			return httpRetry{}, fmt.Errorf("retry: unsupported network error %s, use timeout, connection, dns or none", kind)
+		}
+	}
+	return retry, nil
+}
+
+// networkError returns the kind of the error of a request that got no response, empty if it is none of them
+func networkError(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var opErr *net.OpError
+	switch {
+	case errors.As(err, &dnsErr):
+		return retryDNS
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return retryTimeout
+	case errors.As(err, &opErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return retryConnection
+	}
+	return ""
+}
+
+// retryAfter returns the wait a 429 or 503 response asks for in its Retry-After header, in seconds or as an http date
+func retryAfter(resp gorequest.Response) (time.Duration, bool) {
+	if resp == nil || (resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable) {
+		return 0, false
+	}
+	value := resp.Header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// wait returns how long to wait before sending a request again after the attempt, and why
+// the reason is empty when the request is not sent again
+func (r httpRetry) wait(resp gorequest.Response, errs []error, attempt int) (time.Duration, string) {
+	var reason string
+	switch {
+	case resp != nil && r.statusCodes[resp.StatusCode]:
+		reason = fmt.Sprintf("status code %d", resp.StatusCode)
+	case resp == nil && len(errs) > 0:
+		if kind := networkError(errs[0]); r.networkErrors[kind] {
+			reason = kind + " error"
+		}
+	}
+	if reason == "" || attempt >= r.attempts {
+		return 0, ""
+	}
+
+	if wait, ok := retryAfter(resp); ok {
+		if wait > r.maxBackoff {
+			return 0, ""
+		}
+		return wait, reason
+	}
+	backoff := r.backoff
+	for i := 1; i < attempt && backoff < r.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > r.maxBackoff {
+		backoff = r.maxBackoff
+	}
+	// a random half of the backoff is added, so apis failing together do not retry together
+	// #nosec
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1)), reason
+}
+
+// endWithRetries sends a request until it gets a response that is not retryable, its attempts run out
+// or the config run is cancelled
+func endWithRetries(request *gorequest.SuperAgent, yml *load.Config, api load.API, reqURL string) (gorequest.Response, []error) {
+	retry, err := newHTTPRetry(api.Retry)
+	if err != nil {
+		load.Logrus.WithFields(logrus.Fields{"name": yml.Name}).WithError(err).Error("http: not retrying requests")
+	}
+
+	for attempt := 1; ; attempt++ {
+		// errors of a previous attempt would fail every following one
+		request.Errors = nil
+		resp, _, errs := request.End()
+		wait, reason := retry.wait(resp, errs, attempt)
+		if reason == "" {
+			return resp, errs
+		}
+		if deadline, ok := yml.Context().Deadline(); ok && time.Until(deadline) < wait {
+			load.Logrus.WithFields(logrus.Fields{"name": yml.Name}).Debugf("http: URL %v %s, not retrying past the run timeout", reqURL, reason)
+			return resp, errs
+		}
+
+		load.Logrus.WithFields(logrus.Fields{"name": yml.Name}).Debugf("http: URL %v %s, retrying in %v, attempt %d of %d", reqURL, reason, wait, attempt+1, retry.attempts)
+		yml.State().StatusCounterIncrement("HttpRetries")
+		yml.State().APIStatusUpdate(yml.Name, api.Name, func(status *load.APIStatus) {
+			status.HTTPRetries++
+		})
+		timer := time.NewTimer(wait)
+		select {
+		case <-yml.Context().Done():
+			timer.Stop()
+			return resp, errs
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,185 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package inputs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/newrelic/nri-flex/internal/load"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPRetryWait(t *testing.T) {
+	retry, err := newHTTPRetry(load.Retry{Attempts: 4, Backoff: "100ms", MaxBackoff: "300ms"})
+	require.NoError(t, err)
+	response := func(code int, retryAfter string) *http.Response {
+		resp := &http.Response{StatusCode: code, Header: http.Header{}}
+		if retryAfter != "" {
+			resp.Header.Set("Retry-After", retryAfter)
+		}
+		return resp
+	}
+
+	tests := map[string]struct {
+		resp     *http.Response
+		errs     []error
+		attempt  int
+		reason   string
+		min, max time.Duration
+	}{
+		"ok":                     {resp: response(200, ""), attempt: 1},
+		"not retryable":          {resp: response(500, ""), attempt: 1},
+		"bad gateway":            {resp: response(502, ""), attempt: 1, reason: "status code 502", min: 50 * time.Millisecond, max: 100 * time.Millisecond},
+		"backoff doubles":        {resp: response(504, ""), attempt: 2, reason: "status code 504", min: 100 * time.Millisecond, max: 200 * time.Millisecond},
+		"backoff is capped":      {resp: response(504, ""), attempt: 3, reason: "status code 504", min: 150 * time.Millisecond, max: 300 * time.Millisecond},
+		"attempts run out":       {resp: response(502, ""), attempt: 4},
+		"retry after":            {resp: response(429, "0"), attempt: 1, reason: "status code 429"},
+		"retry after too long":   {resp: response(503, "60"), attempt: 1},
+		"retry after http date":  {resp: response(503, time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat)), attempt: 1, reason: "status code 503"},
+		"timeout":                {errs: []error{&url.Error{Op: "Get", Err: timeoutError{}}}, attempt: 1, reason: "timeout error", min: 50 * time.Millisecond, max: 100 * time.Millisecond},
+		"connection refused":     {errs: []error{&url.Error{Op: "Get", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}}, attempt: 1, reason: "connection error", min: 50 * time.Millisecond, max: 100 * time.Millisecond},
+		"connection closed":      {errs: []error{&url.Error{Op: "Get", Err: io.EOF}}, attempt: 1, reason: "connection error", min: 50 * time.Millisecond, max: 100 * time.Millisecond},
+		"dns is not retried":     {errs: []error{&url.Error{Op: "Get", Err: &net.DNSError{Name: "nowhere"}}}, attempt: 1},
+		"other errors are final": {errs: []error{errors.New("unsupported protocol scheme")}, attempt: 1},
+	}
+	for name, tc := range tests {
+		wait, reason := retry.wait(tc.resp, tc.errs, tc.attempt)
+		assert.Equal(t, tc.reason, reason, name)
+		assert.True(t, wait >= tc.min && wait <= tc.max, "%s: %v", name, wait)
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestNewHTTPRetry(t *testing.T) {
+	retry, err := newHTTPRetry(load.Retry{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, retry.attempts)
+	assert.Equal(t, map[int]bool{429: true, 502: true, 503: true, 504: true}, retry.statusCodes)
+	assert.Equal(t, map[string]bool{"timeout": true, "connection": true}, retry.networkErrors)
+
+	retry, err = newHTTPRetry(load.Retry{StatusCodes: []int{500}, NetworkErrors: []string{"none"}})
+	require.NoError(t, err)
+	assert.Equal(t, map[int]bool{500: true}, retry.statusCodes)
+	assert.Empty(t, retry.networkErrors)
+
+	tests := map[string]struct {
+		cfg load.Retry
+		err string
+	}{
+		"backoff":       {load.Retry{Backoff: "soon"}, `retry: invalid backoff, time: invalid duration "soon"`},
+		"max backoff":   {load.Retry{MaxBackoff: "1"}, `retry: invalid max_backoff, time: missing unit in duration "1"`},
+		"network error": {load.Retry{NetworkErrors: []string{"tls"}}, "retry: unsupported network error tls, use timeout, connection, dns or none"},
+		"negative":      {load.Retry{Backoff: "-1s"}, "retry: backoff must be positive, got -1s"},
+		"zero":          {load.Retry{Backoff: "0s"}, "retry: backoff must be positive, got 0s"},
+		"negative max":  {load.Retry{MaxBackoff: "-5s"}, "retry: max_backoff must be positive, got -5s"},
+		"max too short": {load.Retry{Backoff: "10s", MaxBackoff: "1s"}, "retry: max_backoff 1s is shorter than backoff 10s"},
+	}
+	for name, tc := range tests {
+		_, err := newHTTPRetry(tc.cfg)
+		assert.EqualError(t, err, tc.err, name)
+	}
+
+	// validation reports it, rather than the first retry panicking
+	assert.EqualError(t, Validate(load.API{URL: "http://localhost", Retry: load.Retry{Attempts: 2, Backoff: "-1s"}}),
+		"http input: retry: backoff must be positive, got -1s")
+
+	// a longer backoff raises the default max_backoff
+	retry, err = newHTTPRetry(load.Retry{Backoff: "1m"})
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, retry.maxBackoff)
+}
+
+func TestRunHTTPRetries(t *testing.T) {
+	load.Refresh()
+	// the second page fails twice before it is served, the pages before it are not fetched again
+	var lock sync.Mutex
+	hits := map[string]int{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		hits[r.URL.Path]++
+		n := hits[r.URL.Path]
+		lock.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/page/1":
+			w.Header().Set("Link", fmt.Sprintf(`<http://%s/page/2>; rel="next"`, r.Host))
+		case n == 1:
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		case n == 2:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"page":%q}`, r.URL.Path)
+	}))
+	defer ts.Close()
+
+	config := load.Config{
+		Name: "retries",
+		APIs: []load.API{{
+			Name:      "pages",
+			EventType: "retrySample",
+			URL:       ts.URL + "/page/1",
+			Retry:     load.Retry{Attempts: 3, Backoff: "1ms"},
+		}},
+	}
+	loop := true
+	var dataStore []interface{}
+	reqURL := config.APIs[0].URL
+	RunHTTP(&dataStore, &loop, &config, config.APIs[0], &reqURL)
+
+	require.Len(t, dataStore, 2)
+	assert.Equal(t, "/page/1", dataStore[0].(map[string]interface{})["page"])
+	assert.Equal(t, "/page/2", dataStore[1].(map[string]interface{})["page"])
+	assert.Equal(t, map[string]int{"/page/1": 1, "/page/2": 3}, hits)
+	assert.Equal(t, 2, load.StatusCounterRead("HttpRetries"))
+	assert.Equal(t, 2, load.RunStatusRead()["retries"].APIs["pages"].HTTPRetries)
+}
+
+func TestRunHTTPRetriesCancelled(t *testing.T) {
+	load.Refresh()
+	var hits int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer ts.Close()
+
+	config := load.Config{
+		Name: "cancelled",
+		APIs: []load.API{{EventType: "retrySample", URL: ts.URL, Retry: load.Retry{Attempts: 5, Backoff: "1h"}}},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	config.SetContext(ctx)
+
+	start := time.Now()
+	loop := true
+	var dataStore []interface{}
+	reqURL := config.APIs[0].URL
+	RunHTTP(&dataStore, &loop, &config, config.APIs[0], &reqURL)
+
+	// a wait past the deadline of the run is not started
+	assert.Equal(t, 1, hits)
+	assert.True(t, time.Since(start) < time.Second)
+}
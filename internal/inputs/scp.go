@@ -15,6 +15,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/newrelic/nri-flex/internal/fixture"
 	"github.com/newrelic/nri-flex/internal/load"
 	"github.com/newrelic/nri-flex/internal/utils"
 	"github.com/pkg/sftp"
@@ -26,6 +27,15 @@ import (
 func RunScpWithTimeout(dataStore *[]interface{}, cfg *load.Config, api load.API) error {
 	load.Logrus.Debugf("%v - running scp requests", cfg.Name)
 	remoteFile := api.Scp.RemoteFile
+	fixtureKey := api.Scp.Host + ":" + remoteFile
+
+	if fixture.Replaying() {
+		fileContent, err := replayContent(cfg.Name, api.Name, fixture.KindSCP, fixtureKey)
+		if err != nil {
+			return err
+		}
+		return handleScpJSON(dataStore, fileContent)
+	}
 
 	client, err := getSSHConnection(cfg, api)
 	if err != nil {
@@ -38,6 +48,8 @@ func RunScpWithTimeout(dataStore *[]interface{}, cfg *load.Config, api load.API)
 	}
 
 	fileContent, err := ioutil.ReadAll(srcFile)
+	recordContent(cfg.Name, api.Name, fixture.KindSCP, fixtureKey, fileContent, err)
+	addBytesRead(cfg, api, len(fileContent))
 	if err != nil {
 		return fmt.Errorf("ssh: failed to read file: %s, error: %v", remoteFile, err)
 	}
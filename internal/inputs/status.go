@@ -0,0 +1,31 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package inputs
+
+import (
+	"io"
+
+	"github.com/newrelic/nri-flex/internal/load"
+)
+
+// addBytesRead adds the size of a raw input to the status of the api
+func addBytesRead(yml *load.Config, api load.API, n int) {
+	yml.State().APIStatusUpdate(yml.Name, api.Name, func(status *load.APIStatus) {
+		status.BytesRead += n
+	})
+}
+
+// countingReader counts the bytes read from a response body, which is read differently by each content type
+type countingReader struct {
+	io.ReadCloser
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += n
+	return n, err
+}
@@ -0,0 +1,317 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package inputs
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/newrelic/nri-flex/internal/fixture"
+	"github.com/newrelic/nri-flex/internal/load"
+	"github.com/parnurzeal/gorequest"
+	"github.com/sirupsen/logrus"
+)
+
+// stepVariables finds the ${step:name} values set in a step
+var stepVariables = regexp.MustCompile(`\${step:([^}]+)}`)
+
+// RunHTTPSteps sends the requests of the steps of an http api in order
+// the steps share one agent, so the cookies set by a step are sent by the later ones, and connections are reused
+// values extracted from a step are set in later steps, the bodies of the sampled steps become samples
+func RunHTTPSteps(dataStore *[]interface{}, yml *load.Config, api load.API) error {
+	base, err := url.Parse(yml.Global.BaseURL + api.URL)
+	if err != nil {
+		return fmt.Errorf("http: invalid url, %v", err)
+	}
+
+	request := gorequest.New()
+	sessions := tls.NewLRUClientSessionCache(0)
+	values := map[string]string{}
+	sampled := len(api.Steps) - 1
+	for _, step := range api.Steps {
+		if step.Sample {
+			sampled = -1
+		}
+	}
+
+	for i, step := range api.Steps {
+		if err := yml.Context().Err(); err != nil {
+			load.Logrus.WithFields(logrus.Fields{"name": yml.Name}).WithError(err).Debug("http: run cancelled, not running next step")
+			return nil
+		}
+		name := step.Name
+		if name == "" {
+			name = strconv.Itoa(i + 1)
+		}
+
+		stepURL, err := base.Parse(stepValue(step.URL, values))
+		if err != nil {
+			return fmt.Errorf("http: step %s has an invalid url, %v", name, err)
+		}
+		stepAPI := api
+		stepAPI.Steps = nil
+		stepAPI.URL = stepURL.String()
+		stepAPI.Method = strings.ToUpper(step.Method)
+		if stepAPI.Method == "" {
+			stepAPI.Method = http.MethodGet
+		}
+		stepAPI.Payload = stepValue(stepPayload(api, step), values)
+		stepAPI.Headers = map[string]string{}
+		for h, v := range api.Headers {
+			stepAPI.Headers[h] = v
+		}
+		for h, v := range step.Headers {
+			stepAPI.Headers[h] = stepValue(v, values)
+		}
+
+		request = request.CustomMethod(stepAPI.Method, stepAPI.URL)
+		if stepAPI.Payload != "" {
+			request = request.Send(stepAPI.Payload)
+		}
+		request = setRequestOptions(request, *yml, stepAPI)
+		// gorequest closes connections after every request, the steps keep them open and resume tls sessions
+		// its timeout is a deadline on the connection, which a reused one would reach, so it applies to each request instead
+		request.Transport.DisableKeepAlives = false
+		request.Transport.Dial = nil
+		request.Client.Timeout = httpTimeout(*yml, stepAPI)
+		if request.Transport.TLSClientConfig != nil {
+			request.Transport.TLSClientConfig.ClientSessionCache = sessions
+		}
+
+		load.Logrus.Debugf("http: step %s sending %v request to %v", name, stepAPI.Method, stepAPI.URL)
+		var resp gorequest.Response
+		var errs []error
+		if fixture.Replaying() {
+			resp, errs = replayHTTP(yml, api, stepAPI.URL)
+		} else {
+			resp, errs = endWithOAuth2(request, yml, stepAPI, stepAPI.URL)
+			if fixture.Recording() {
+				recordHTTP(yml, api, stepAPI.URL, resp, errs)
+			}
+		}
+		yml.State().StatusCounterIncrement("HttpRequests")
+		if resp == nil {
+			return fmt.Errorf("http: step %s request to %s failed, %s", name, stepAPI.URL, joinErrors(errs))
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		yml.State().APIStatusUpdate(yml.Name, api.Name, func(status *load.APIStatus) {
+			status.BytesRead += len(body)
+			status.HTTPStatus = resp.StatusCode
+		})
+		if err != nil {
+			return fmt.Errorf("http: step %s failed to read body, %v", name, err)
+		}
+		if api.Debug {
+			load.Logrus.Debugf("HTTP Debug:\nURL: %v\nBody:\n%v\n", stepAPI.URL, string(body))
+		}
+		// later steps would run without the session or values of a failed one
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("http: step %s request to %s failed, status code: %d", name, stepAPI.URL, resp.StatusCode)
+		}
+
+		for key, extract := range step.Extract {
+			value, err := extractStepValue(extract, resp, body, request.Client.Jar)
+			if err != nil {
+				return fmt.Errorf("http: step %s failed to extract %s, %v", name, key, err)
+			}
+			values[key] = value
+		}
+
+		if step.Sample || i == sampled {
+			if err := decodeStep(dataStore, body, resp, yml, stepAPI); err != nil {
+				return fmt.Errorf("http: step %s failed to decode body, %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// stepPayload is the payload of a step, POST and PUT steps without one send the payload of the api
+func stepPayload(api load.API, step load.HTTPStep) string {
+	method := strings.ToUpper(step.Method)
+	if step.Payload == "" && (method == http.MethodPost || method == http.MethodPut) {
+		return api.Payload
+	}
+	return step.Payload
+}
+
+// stepValue sets the values extracted from previous steps in s
+func stepValue(s string, values map[string]string) string {
+	return stepVariables.ReplaceAllStringFunc(s, func(variable string) string {
+		if value, ok := values[stepVariables.FindStringSubmatch(variable)[1]]; ok {
+			return value
+		}
+		return variable
+	})
+}
+
+// decodeStep creates the samples of the body of a step, decoded as the body of a single request would be
+func decodeStep(dataStore *[]interface{}, body []byte, resp gorequest.Response, yml *load.Config, api load.API) error {
+	contentType := resp.Header.Get("Content-Type")
+	_, decode := lookupDecoder(api, contentType)
+	if decode == nil {
+		if output, _ := detectCommandOutput(string(body), ""); output != load.TypeJSON {
+			return fmt.Errorf("unsupported content type %s, set format on the api", contentType)
+		}
+		decode = decodeJSON
+	}
+	value, err := decode(dataStore, body, yml, &api)
+	if err != nil || value == nil {
+		return err
+	}
+	doLoop := false
+	reqURL := api.URL
+	handleBody(dataStore, value, &resp, &doLoop, &reqURL, "", api.ReturnHeaders)
+	return nil
+}
+
+// extractStepValue extracts a value from the response of a step
+func extractStepValue(extract load.HTTPExtract, resp gorequest.Response, body []byte, jar http.CookieJar) (string, error) {
+	var value string
+	switch {
+	case extract.JSONPath != "":
+		v, err := jsonPathValue(body, extract.JSONPath)
+		if err != nil {
+			return "", err
+		}
+		value = v
+	case extract.Header != "":
+		if value = resp.Header.Get(extract.Header); value == "" {
+			return "", fmt.Errorf("no header %s", extract.Header)
+		}
+	case extract.Cookie != "":
+		cookies := (*http.Response)(resp).Cookies()
+		// cookies set before a redirect are only in the jar
+		if jar != nil && resp.Request != nil {
+			cookies = append(cookies, jar.Cookies(resp.Request.URL)...)
+		}
+		for _, cookie := range cookies {
+			if cookie.Name == extract.Cookie {
+				value = cookie.Value
+				break
+			}
+		}
+		if value == "" {
+			return "", fmt.Errorf("no cookie %s", extract.Cookie)
+		}
+	default:
+		value = string(body)
+	}
+
+	if extract.Regex == "" {
+		return value, nil
+	}
+	re, err := regexp.Compile(extract.Regex)
+	if err != nil {
+		return "", fmt.Errorf("invalid regex, %v", err)
+	}
+	match := re.FindStringSubmatch(value)
+	switch {
+	case match == nil:
+		return "", fmt.Errorf("regex %s does not match", extract.Regex)
+	case len(match) > 1:
+		return match[1], nil
+	}
+	return match[0], nil
+}
+
+// jsonPathValue returns the value at a path of keys and array indexes separated by dots, eg. data.tokens.0
+// objects and arrays are returned as json
+func jsonPathValue(body []byte, path string) (string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return "", fmt.Errorf("body is not json, %v", err)
+	}
+
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	for _, key := range strings.Split(path, ".") {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			value, ok := t[key]
+			if !ok {
+				return "", fmt.Errorf("no key %s in json path %s", key, path)
+			}
+			v = value
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(t) {
+				return "", fmt.Errorf("no index %s in json path %s", key, path)
+			}
+			v = t[i]
+		default:
+			return "", fmt.Errorf("no key %s in json path %s", key, path)
+		}
+	}
+
+	switch t := v.(type) {
+	case string:
+		return t, nil
+	case json.Number:
+		return t.String(), nil
+	case map[string]interface{}, []interface{}:
+		b, err := json.Marshal(t)
+		return string(b), err
+	case nil:
+		return "", fmt.Errorf("json path %s is null", path)
+	}
+	return fmt.Sprintf("%v", v), nil
+}
+
+// validateSteps checks the steps of an api, and that the values they set are extracted by a previous step
+func validateSteps(api load.API) error {
+	extracted := map[string]bool{}
+	for i, step := range api.Steps {
+		name := step.Name
+		if name == "" {
+			name = strconv.Itoa(i + 1)
+		}
+		if step.URL == "" && api.URL == "" {
+			return fmt.Errorf("step %s requires url, or a url on the api", name)
+		}
+
+		used := []string{step.URL, stepPayload(api, step)}
+		for _, v := range step.Headers {
+			used = append(used, v)
+		}
+		for _, s := range used {
+			for _, match := range stepVariables.FindAllStringSubmatch(s, -1) {
+				if !extracted[match[1]] {
+					return fmt.Errorf("step %s uses ${step:%s}, which no previous step extracts", name, match[1])
+				}
+			}
+		}
+
+		for key, extract := range step.Extract {
+			sources := 0
+			for _, source := range []string{extract.JSONPath, extract.Header, extract.Cookie} {
+				if source != "" {
+					sources++
+				}
+			}
+			if sources > 1 {
+				return fmt.Errorf("step %s extracts %s from more than one of json_path, header and cookie", name, key)
+			}
+			if _, err := regexp.Compile(extract.Regex); err != nil {
+				return fmt.Errorf("step %s extracts %s with an invalid regex, %v", name, key, err)
+			}
+		}
+		// values are extracted after the step is sent, so a step cannot use its own
+		for key := range step.Extract {
+			extracted[key] = true
+		}
+	}
+	return nil
+}
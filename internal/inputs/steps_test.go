@@ -0,0 +1,259 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package inputs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/newrelic/nri-flex/internal/load"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunHTTPSteps(t *testing.T) {
+	load.Refresh()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/login":
+			body, _ := ioutil.ReadAll(r.Body)
+			if r.Method != http.MethodPost || string(body) != `{"user":"flex"}` {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "s3cr3t", Path: "/"})
+			w.Header().Set("X-Csrf-Token", "csrf-1")
+			_, _ = fmt.Fprint(w, `{"data":{"accounts":[{"id":42}]}}`)
+		case "/accounts/42/stats":
+			cookie, err := r.Cookie("session")
+			if err != nil || cookie.Value != "s3cr3t" || r.Header.Get("X-Csrf-Token") != "csrf-1" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, _ = fmt.Fprint(w, `{"queued":3,"session":"`+cookie.Value+`"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	api := load.API{
+		Name:      "steps",
+		EventType: "stepSample",
+		URL:       ts.URL,
+		Steps: []load.HTTPStep{
+			{
+				Name:    "login",
+				URL:     "/login",
+				Method:  "post",
+				Payload: `{"user":"flex"}`,
+				Extract: map[string]load.HTTPExtract{
+					"account": {JSONPath: "data.accounts.0.id"},
+					"csrf":    {Header: "X-Csrf-Token"},
+					"session": {Cookie: "session", Regex: "s3(.*)"},
+				},
+			},
+			{
+				Name:    "stats",
+				URL:     "/accounts/${step:account}/stats",
+				Headers: map[string]string{"X-Csrf-Token": "${step:csrf}"},
+			},
+		},
+	}
+	config := load.Config{Name: "steps", APIs: []load.API{api}}
+	require.NoError(t, Validate(api))
+
+	var dataStore []interface{}
+	require.NoError(t, RunHTTPSteps(&dataStore, &config, api))
+
+	// only the body of the last step becomes a sample
+	require.Len(t, dataStore, 1)
+	assert.Equal(t, map[string]interface{}{"queued": float64(3), "session": "s3cr3t", "api.StatusCode": 200}, dataStore[0])
+	assert.Equal(t, 2, load.StatusCounterRead("HttpRequests"))
+
+	// a failed step stops the steps after it
+	api.Steps[0].Payload = `{"user":"other"}`
+	dataStore = nil
+	err := RunHTTPSteps(&dataStore, &config, api)
+	assert.EqualError(t, err, fmt.Sprintf("http: step login request to %s/login failed, status code: 401", ts.URL))
+	assert.Empty(t, dataStore)
+}
+
+func TestRunHTTPStepsSample(t *testing.T) {
+	load.Refresh()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"path":%q}`, r.URL.Path)
+	}))
+	defer ts.Close()
+
+	api := load.API{
+		URL: ts.URL + "/api/",
+		Steps: []load.HTTPStep{
+			{URL: "one", Sample: true},
+			{URL: "two"},
+			{URL: "three", Sample: true},
+		},
+	}
+	config := load.Config{Name: "steps", APIs: []load.API{api}}
+	var dataStore []interface{}
+	require.NoError(t, RunHTTPSteps(&dataStore, &config, api))
+
+	require.Len(t, dataStore, 2)
+	assert.Equal(t, "/api/one", dataStore[0].(map[string]interface{})["path"])
+	assert.Equal(t, "/api/three", dataStore[1].(map[string]interface{})["path"])
+}
+
+func TestRunHTTPStepsPayload(t *testing.T) {
+	load.Refresh()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"method":%q,"body":%q}`, r.Method, string(body))
+	}))
+	defer ts.Close()
+
+	// steps without a payload send the payload of the api when the request has a body
+	api := load.API{
+		URL:     ts.URL,
+		Payload: `{"query":"up"}`,
+		Steps: []load.HTTPStep{
+			{URL: "/one", Method: "post", Sample: true},
+			{URL: "/two", Method: "put", Payload: `{"query":"down"}`, Sample: true},
+			{URL: "/three", Sample: true},
+		},
+	}
+	config := load.Config{Name: "steps", APIs: []load.API{api}}
+	var dataStore []interface{}
+	require.NoError(t, RunHTTPSteps(&dataStore, &config, api))
+
+	require.Len(t, dataStore, 3)
+	assert.Equal(t, map[string]interface{}{"method": "POST", "body": `{"query":"up"}`, "api.StatusCode": 200}, dataStore[0])
+	assert.Equal(t, map[string]interface{}{"method": "PUT", "body": `{"query":"down"}`, "api.StatusCode": 200}, dataStore[1])
+	assert.Equal(t, map[string]interface{}{"method": "GET", "body": "", "api.StatusCode": 200}, dataStore[2])
+}
+
+func TestRunHTTPStepsTimeout(t *testing.T) {
+	load.Refresh()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delay, _ := time.ParseDuration(r.URL.Query().Get("delay"))
+		time.Sleep(delay)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"path":%q}`, r.URL.Path)
+	}))
+	defer ts.Close()
+
+	// the steps share a connection, they take longer than the timeout together but not each
+	// they post, as a failed get on a reused connection would be sent again on a new one
+	api := load.API{
+		URL:     ts.URL,
+		Timeout: 300,
+		Steps: []load.HTTPStep{
+			{URL: "/one?delay=150ms", Method: "post"},
+			{URL: "/two?delay=150ms", Method: "post"},
+			{URL: "/three?delay=150ms", Method: "post"},
+		},
+	}
+	config := load.Config{Name: "steps", APIs: []load.API{api}}
+	var dataStore []interface{}
+	require.NoError(t, RunHTTPSteps(&dataStore, &config, api))
+	require.Len(t, dataStore, 1)
+	assert.Equal(t, "/three", dataStore[0].(map[string]interface{})["path"])
+
+	// a single step longer than the timeout still fails
+	api.Steps = append(api.Steps, load.HTTPStep{Name: "slow", URL: "/four?delay=400ms", Method: "post"})
+	dataStore = nil
+	err := RunHTTPSteps(&dataStore, &config, api)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "http: step slow request to "+ts.URL+"/four?delay=400ms failed")
+	assert.Empty(t, dataStore)
+}
+
+func TestExtractStepValue(t *testing.T) {
+	resp := &http.Response{Header: http.Header{"Location": []string{"/items/7?page=2"}}}
+	body := []byte(`{"token":"abc","count":12,"items":[{"id":"x"}],"none":null}`)
+
+	tests := map[string]struct {
+		extract load.HTTPExtract
+		want    string
+		err     string
+	}{
+		"string":         {extract: load.HTTPExtract{JSONPath: "token"}, want: "abc"},
+		"number":         {extract: load.HTTPExtract{JSONPath: "$.count"}, want: "12"},
+		"array index":    {extract: load.HTTPExtract{JSONPath: "items.0.id"}, want: "x"},
+		"object":         {extract: load.HTTPExtract{JSONPath: "items.0"}, want: `{"id":"x"}`},
+		"missing key":    {extract: load.HTTPExtract{JSONPath: "items.1"}, err: "no index 1 in json path items.1"},
+		"null":           {extract: load.HTTPExtract{JSONPath: "none"}, err: "json path none is null"},
+		"header regex":   {extract: load.HTTPExtract{Header: "Location", Regex: `/items/(\d+)`}, want: "7"},
+		"missing header": {extract: load.HTTPExtract{Header: "X-Token"}, err: "no header X-Token"},
+		"missing cookie": {extract: load.HTTPExtract{Cookie: "session"}, err: "no cookie session"},
+		"body regex":     {extract: load.HTTPExtract{Regex: `"token":"[a-z]+"`}, want: `"token":"abc"`},
+		"regex no match": {extract: load.HTTPExtract{Regex: `csrf=(\w+)`}, err: `regex csrf=(\w+) does not match`},
+		"body not json":  {extract: load.HTTPExtract{JSONPath: "token"}, err: "body is not json, invalid character 'o' in literal null (expecting 'u')"},
+		"whole body":     {extract: load.HTTPExtract{}, want: string(body)},
+		"header query":   {extract: load.HTTPExtract{Header: "Location", Regex: `page=(\d+)`}, want: "2"},
+	}
+	for name, tc := range tests {
+		b := body
+		if name == "body not json" {
+			b = []byte("not json")
+		}
+		got, err := extractStepValue(tc.extract, resp, b, nil)
+		if tc.err != "" {
+			assert.EqualError(t, err, tc.err, name)
+			continue
+		}
+		assert.NoError(t, err, name)
+		assert.Equal(t, tc.want, got, name)
+	}
+}
+
+func TestValidateSteps(t *testing.T) {
+	tests := map[string]struct {
+		api load.API
+		err string
+	}{
+		"no url": {
+			api: load.API{Steps: []load.HTTPStep{{Name: "login"}}},
+			err: "http input: step login requires url, or a url on the api",
+		},
+		"value not extracted": {
+			api: load.API{Steps: []load.HTTPStep{{URL: "http://localhost/${step:token}"}}},
+			err: "http input: step 1 uses ${step:token}, which no previous step extracts",
+		},
+		"value not extracted in the payload of the api": {
+			api: load.API{URL: "http://localhost", Payload: `{"token":"${step:token}"}`, Steps: []load.HTTPStep{{Method: "post"}}},
+			err: "http input: step 1 uses ${step:token}, which no previous step extracts",
+		},
+		"value extracted by the same step": {
+			api: load.API{URL: "http://localhost", Steps: []load.HTTPStep{{
+				Headers: map[string]string{"Authorization": "${step:token}"},
+				Extract: map[string]load.HTTPExtract{"token": {Header: "X-Token"}},
+			}}},
+			err: "http input: step 1 uses ${step:token}, which no previous step extracts",
+		},
+		"more than one source": {
+			api: load.API{URL: "http://localhost", Steps: []load.HTTPStep{{
+				Extract: map[string]load.HTTPExtract{"token": {Header: "X-Token", Cookie: "token"}},
+			}}},
+			err: "http input: step 1 extracts token from more than one of json_path, header and cookie",
+		},
+		"invalid regex": {
+			api: load.API{URL: "http://localhost", Steps: []load.HTTPStep{{
+				Extract: map[string]load.HTTPExtract{"token": {Regex: "("}},
+			}}},
+			err: "http input: step 1 extracts token with an invalid regex, error parsing regexp: missing closing ): `(`",
+		},
+	}
+	for name, tc := range tests {
+		assert.EqualError(t, Validate(tc.api), tc.err, name)
+	}
+}
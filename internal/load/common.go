@@ -1,6 +1,9 @@
 package load
 
 import (
+	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
@@ -10,33 +13,85 @@ func TimestampMs() int64 {
 	return time.Now().UnixNano() / int64(time.Millisecond)
 }
 
-// FlexStatusCounter count internal metrics
-var FlexStatusCounter = struct {
+// statusCounter counts internal metrics by key
+type statusCounter struct {
 	sync.RWMutex
 	M map[string]int
-}{M: make(map[string]int)}
+}
+
+// FlexStatusCounter count internal metrics
+var FlexStatusCounter = statusCounter{M: make(map[string]int)}
 
 // StatusCounterIncrement increment the status counter for a particular key
 func StatusCounterIncrement(key string) {
+	FlexStatusCounter.increment(key)
+}
+
+// StatusCounterReset starts every counter from zero again, once the results are published
+func StatusCounterReset() {
 	FlexStatusCounter.Lock()
-	FlexStatusCounter.M[key]++
+	FlexStatusCounter.M = newStatusCounters()
 	FlexStatusCounter.Unlock()
 }
 
 // StatusCounterRead the status counter for a particular key
 func StatusCounterRead(key string) int {
-	FlexStatusCounter.Lock()
-	value := FlexStatusCounter.M[key]
-	FlexStatusCounter.Unlock()
-	return value
+	return FlexStatusCounter.read(key)
+}
+
+// newStatusCounters returns the counters flexStatusSample always reports, at zero
+func newStatusCounters() map[string]int {
+	return map[string]int{"EventCount": 0, "EventDropCount": 0, "ConfigsProcessed": 0}
+}
+
+func (c *statusCounter) increment(key string) {
+	c.Lock()
+	c.M[key]++
+	c.Unlock()
+}
+
+func (c *statusCounter) read(key string) int {
+	c.RLock()
+	defer c.RUnlock()
+	return c.M[key]
+}
+
+// ConfigReloadErrors holds the last failed reload of each config file, while its previous version keeps running
+var ConfigReloadErrors = struct {
+	sync.RWMutex
+	M map[string]string
+}{M: make(map[string]string)}
+
+// ConfigReloadErrorSet records a failed reload of a config file
+func ConfigReloadErrorSet(file string, err error) {
+	ConfigReloadErrors.Lock()
+	ConfigReloadErrors.M[file] = err.Error()
+	ConfigReloadErrors.Unlock()
+}
+
+// ConfigReloadErrorClear removes the failed reload of a config file, once it loads again or is removed
+func ConfigReloadErrorClear(file string) {
+	ConfigReloadErrors.Lock()
+	delete(ConfigReloadErrors.M, file)
+	ConfigReloadErrors.Unlock()
+}
+
+// ConfigReloadErrorsRead returns the failed reloads as "file: error" joined in file order, empty if none
+func ConfigReloadErrorsRead() string {
+	ConfigReloadErrors.RLock()
+	defer ConfigReloadErrors.RUnlock()
+	messages := make([]string, 0, len(ConfigReloadErrors.M))
+	for file, err := range ConfigReloadErrors.M {
+		messages = append(messages, fmt.Sprintf("%s: %s", file, err))
+	}
+	sort.Strings(messages)
+	return strings.Join(messages, "; ")
 }
 
 // Refresh Helper function used for testing
 func Refresh() {
-	FlexStatusCounter.M = make(map[string]int)
-	FlexStatusCounter.M["EventCount"] = 0
-	FlexStatusCounter.M["EventDropCount"] = 0
-	FlexStatusCounter.M["ConfigsProcessed"] = 0
+	StatusCounterReset()
+	RunStatusReset()
 	Args.ConfigDir = ""
 	Args.ConfigFile = ""
 	Args.ContainerDiscovery = false
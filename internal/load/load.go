@@ -6,12 +6,16 @@
 package load
 
 import (
+	"context"
+	"errors"
 	"os"
 	"sync"
 	"time"
 
 	sdkArgs "github.com/newrelic/infra-integrations-sdk/args"
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
 	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/infra-integrations-sdk/persist"
 	logrus "github.com/sirupsen/logrus"
 )
 
@@ -42,6 +46,8 @@ type ArgumentList struct {
 	InsightBatchSize        int    `default:"5000" help:"Batch Size - number of metrics per post call to Insight endpoint"`
 	MetricAPIUrl            string `default:"https://metric-api.newrelic.com/metric/v1" help:"Set Metric API URL"`
 	MetricAPIKey            string `default:"" help:"Set Metric API key"`
+	MetricAPIBatchSize      int    `default:"5000" help:"Batch Size - number of metrics per post call to Metric API endpoint, posts over 1MB compressed are split further"`
+	MetricAPIWorkers        int    `default:"4" help:"Number of posts to the Metric API sent concurrently"`
 	GitFlexDir              string `default:"flexGitConfigs/" help:"Set directory to store configs from git repository"`
 	GitService              string `default:"github" help:"Set git service"`
 	GitToken                string `default:"" help:"Set git token"`
@@ -61,6 +67,22 @@ type ArgumentList struct {
 	StructuredLogs       bool   `default:"false" help:"output logs in Json structure format for external tool parsing"`
 	AllowEnvCommands     bool   `default:"false" help:"enable to allow the use of FLEX_CMD_PREPEND, FLEX_CMD_APPEND & FLEX_CMD_WRAP"`
 	StdinPipe            bool   `default:"false" help:"use cmd.StdinPipe for commands"`
+	Daemon               bool   `default:"false" help:"Run continuously, scheduling each config on its own interval"`
+	DaemonInterval       string `default:"30s" help:"Default interval for configs that do not set one, when running as a daemon"`
+	ConfigTimeout        string `default:"" help:"Default time budget of a config run, for configs that do not set a timeout, eg. 2m"`
+	DaemonReloadInterval string `default:"10s" help:"How often config files are checked for changes when running as a daemon, 0 disables reloading"`
+	GitSyncInterval      string `default:"5m" help:"How often the git_repo of git sync is pulled when running as a daemon, 0 only syncs it at start-up"`
+	ConfigStatusSamples  bool   `default:"false" help:"Create a flexConfigStatusSample for each config that ran"`
+	APIStatusSamples     bool   `default:"false" help:"Create a flexApiStatusSample for each api that ran, in addition to the flexConfigStatusSample of each config"`
+	StatusAddr           string `default:"" help:"Serve /healthz, /readyz and /metrics on this address in daemon mode, eg. :8080"`
+	Explain              string `default:"" help:"Write a json trace of every processing step applied to each sample to this file"`
+	Fixtures             string `default:"" help:"Directory of recorded inputs and expected samples, used by the test command"`
+	Record               string `default:"" help:"Record every input fetched, and the samples produced, into this fixture directory"`
+	Replay               string `default:"" help:"Serve inputs recorded in this fixture directory instead of fetching them"`
+	OutputsFile          string `default:"" help:"YAML file with an outputs section listing every destination results are sent to, replaces the insights, log and metric api arguments"`
+	PostRetries          int    `default:"3" help:"Retries of a post to the insights, log or metric api that failed with a network error, 5xx or 429, with exponential backoff, 0 disables retries"`
+	SpoolMaxMB           int    `default:"50" help:"Size in MB of the spool under TEMP_DIR keeping posts that still failed after their retries, replayed by the next execution, 0 disables the spool"`
+	SpoolMaxAge          string `default:"24h" help:"Spooled posts older than this are dropped instead of replayed"`
 }
 
 // Args Infrastructure SDK Arguments List
@@ -72,6 +94,9 @@ var StartTime int64
 // Integration Infrastructure SDK Integration
 var Integration *integration.Integration
 
+// Storer persistent store of the integration, keeps the previous values of rates and deltas between executions
+var Storer persist.Storer
+
 // IgnoredIntegrationData this is used for lookups with ignored output
 var IgnoredIntegrationData []map[string]interface{}
 
@@ -142,11 +167,14 @@ const (
 	Contains           = "contains"
 )
 
-// MetricsStore for Dimensional Metrics to store data and lock and unlock when needed
-var MetricsStore = struct {
+// metricsStore holds dimensional metrics
+type metricsStore struct {
 	sync.RWMutex
 	Data []Metrics
-}{}
+}
+
+// MetricsStore for Dimensional Metrics to store data and lock and unlock when needed
+var MetricsStore = metricsStore{}
 
 var CacheStoreLock = struct {
 	sync.RWMutex
@@ -154,9 +182,7 @@ var CacheStoreLock = struct {
 
 // MetricsStoreAppend Append data to store
 func MetricsStoreAppend(metrics Metrics) {
-	MetricsStore.Lock()
-	MetricsStore.Data = append(MetricsStore.Data, metrics)
-	MetricsStore.Unlock()
+	MetricsStore.append(metrics)
 }
 
 // MetricsStoreEmpty empties stored data
@@ -166,6 +192,111 @@ func MetricsStoreEmpty() {
 	MetricsStore.Unlock()
 }
 
+func (m *metricsStore) append(metrics Metrics) {
+	m.Lock()
+	m.Data = append(m.Data, metrics)
+	m.Unlock()
+}
+
+func (m *metricsStore) read() []Metrics {
+	m.RLock()
+	defer m.RUnlock()
+	return append([]Metrics{}, m.Data...)
+}
+
+// metricTypeStore holds the metric_parser type of the keys of metric sets that are not gauges or attributes
+type metricTypeStore struct {
+	sync.RWMutex
+	M map[*metric.Set]map[string]metricKind
+}
+
+// metricKind is the metric_parser type of a key, and the cumulative value a RATE or PRATE was computed from with its increment
+type metricKind struct {
+	metricType string
+	cumulative float64
+	increment  float64
+}
+
+// metricTypes holds the metric types of the process
+// the infrastructure payload has no type, outputs that keep the type of a metric look it up with MetricType
+var metricTypes = metricTypeStore{M: map[*metric.Set]map[string]metricKind{}}
+
+// MetricTypeSet records the metric_parser type of a key of a metric set, eg. RATE or DELTA
+func MetricTypeSet(metricSet *metric.Set, key string, metricType string) {
+	metricTypes.set(metricSet, key, metricType)
+}
+
+// MetricType returns the metric_parser type of a key of a metric set, empty for gauges and attributes
+func MetricType(metricSet *metric.Set, key string) string {
+	return metricTypes.get(metricSet, key).metricType
+}
+
+// MetricCumulativeSet records the cumulative value a RATE or PRATE key of a metric set was computed from
+func MetricCumulativeSet(metricSet *metric.Set, key string, value float64) {
+	metricTypes.setCumulative(metricSet, key, value)
+}
+
+// MetricCumulative returns the cumulative value a RATE or PRATE key of a metric set was computed from
+func MetricCumulative(metricSet *metric.Set, key string) float64 {
+	return metricTypes.get(metricSet, key).cumulative
+}
+
+// MetricIncrementSet records the increase since its previous value of the cumulative value a RATE or PRATE key was computed from
+func MetricIncrementSet(metricSet *metric.Set, key string, value float64) {
+	metricTypes.setIncrement(metricSet, key, value)
+}
+
+// MetricIncrement returns the increase since its previous value of the cumulative value a RATE or PRATE key was computed from
+func MetricIncrement(metricSet *metric.Set, key string) float64 {
+	return metricTypes.get(metricSet, key).increment
+}
+
+// MetricTypesReset forgets the types of the metric sets published
+func MetricTypesReset() {
+	metricTypes.Lock()
+	metricTypes.M = map[*metric.Set]map[string]metricKind{}
+	metricTypes.Unlock()
+}
+
+func (t *metricTypeStore) set(metricSet *metric.Set, key string, metricType string) {
+	t.Lock()
+	defer t.Unlock()
+	if t.M[metricSet] == nil {
+		t.M[metricSet] = map[string]metricKind{}
+	}
+	kind := t.M[metricSet][key]
+	kind.metricType = metricType
+	t.M[metricSet][key] = kind
+}
+
+func (t *metricTypeStore) setCumulative(metricSet *metric.Set, key string, value float64) {
+	t.Lock()
+	defer t.Unlock()
+	if t.M[metricSet] == nil {
+		t.M[metricSet] = map[string]metricKind{}
+	}
+	kind := t.M[metricSet][key]
+	kind.cumulative = value
+	t.M[metricSet][key] = kind
+}
+
+func (t *metricTypeStore) setIncrement(metricSet *metric.Set, key string, value float64) {
+	t.Lock()
+	defer t.Unlock()
+	if t.M[metricSet] == nil {
+		t.M[metricSet] = map[string]metricKind{}
+	}
+	kind := t.M[metricSet][key]
+	kind.increment = value
+	t.M[metricSet][key] = kind
+}
+
+func (t *metricTypeStore) get(metricSet *metric.Set, key string) metricKind {
+	t.RLock()
+	defer t.RUnlock()
+	return t.M[metricSet][key]
+}
+
 // Metrics struct
 type Metrics struct {
 	TimestampMs      int64                    `json:"timestamp.ms,omitempty"` // required for every metric at root or nested
@@ -188,19 +319,46 @@ type ConfigEntry struct {
 
 // Config YAML Struct
 type Config struct {
-	FileName           string             `yaml:"file_name"`           // set when file is read
-	FilePath           string             `yaml:"file_path"`           // set when file is read
-	ContainerDiscovery ContainerDiscovery `yaml:"container_discovery"` // provide container discovery parameter at config level
-	Name               string
-	Global             Global
-	APIs               []API
-	Datastore          map[string][]interface{}       `yaml:"datastore"`
-	LookupStore        map[string]map[string]struct{} `yaml:"lookup_store"` // ensures uniqueness vs a slice
-	LookupFile         string                         `yaml:"lookup_file"`
-	VariableStore      map[string]string              `yaml:"variable_store"`
-	Secrets            map[string]Secret              `yaml:"secrets"`
+	FileName           string                         `yaml:"file_name"`           // set when file is read
+	FilePath           string                         `yaml:"file_path"`           // set when file is read
+	ContainerDiscovery ContainerDiscovery             `yaml:"container_discovery"` // provide container discovery parameter at config level
+	Name               string                         // name of the config, required
+	Global             Global                         // settings shared by all apis
+	APIs               []API                          // apis to run, handled in order
+	Datastore          map[string][]interface{}       `yaml:"datastore"`         // internal use, caches the output of each api
+	LookupStore        map[string]map[string]struct{} `yaml:"lookup_store"`      // ensures uniqueness vs a slice
+	LookupFile         string                         `yaml:"lookup_file"`       // json file with an array of objects, creates a config per object substituting ${lf:key}
+	VariableStore      map[string]string              `yaml:"variable_store"`    // variables available to apis as ${var:key}
+	Secrets            map[string]Secret              `yaml:"secrets"`           // secrets available to the config as ${secret.name:key}
 	CustomAttributes   map[string]string              `yaml:"custom_attributes"` // set additional custom attributes
 	MetricAPI          bool                           `yaml:"metric_api"`        // enable use of the dimensional data models metric api
+	Interval           string                         `yaml:"interval"`          // collection interval when running as a daemon eg. 15s, 5m
+	Timeout            string                         `yaml:"timeout"`           // time budget of a whole run eg. 30s, inputs still running are cancelled once exceeded
+	ctx                context.Context                // cancelled once the run exceeds its timeout
+	state              *State                         // state of the current run, nil for the state of the process
+}
+
+// Context returns the context of the current run of the config
+func (c *Config) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// SetContext sets the context of the current run of the config, copies of the config share it
+func (c *Config) SetContext(ctx context.Context) {
+	c.ctx = ctx
+}
+
+// State returns the state of the current run of the config, nil for the state of the process
+func (c *Config) State() *State {
+	return c.state
+}
+
+// SetState sets the state of the current run of the config, copies of the config share it
+func (c *Config) SetState(state *State) {
+	c.state = state
 }
 
 // Secret Struct
@@ -232,15 +390,16 @@ type ContainerDiscovery struct {
 
 // Global struct
 type Global struct {
-	BaseURL    string `yaml:"base_url"`
-	User, Pass string
-	Proxy      string
-	Timeout    int
-	Headers    map[string]string `yaml:"headers"`
+	BaseURL    string            `yaml:"base_url"` // prefixed to the url of every api
+	User, Pass string            // basic auth credentials
+	Proxy      string            // proxy url used by http apis
+	Timeout    int               // request timeout in milliseconds
+	Headers    map[string]string `yaml:"headers"` // http headers sent by every api
+	OAuth2     OAuth2            `yaml:"oauth2"`  // bearer token sent by every http api
 	Jmx        JMX               `yaml:"jmx"`
-	TLSConfig  TLSConfig         `yaml:"tls_config"`
-	Passphrase string            `yaml:"pass_phrase"`
-	SSHPEMFile string            `yaml:"ssh_pem_file"`
+	TLSConfig  TLSConfig         `yaml:"tls_config"`   // tls settings used by http apis
+	Passphrase string            `yaml:"pass_phrase"`  // passphrase for the ssh pem file
+	SSHPEMFile string            `yaml:"ssh_pem_file"` // ssh pem file used by scp apis
 }
 
 // TLSConfig struct
@@ -263,11 +422,11 @@ type SampleMerge struct {
 
 // API YAML Struct
 type API struct {
-	Name              string            `yaml:"name"`
-	EventType         string            `yaml:"event_type"`  // override eventType
-	Entity            string            `yaml:"entity"`      // define a custom entity name
-	EntityType        string            `yaml:"entity_type"` // define a custom entity type (namespace)
-	Ingest            bool              `yaml:"ingest"`
+	Name              string            `yaml:"name"`           // name of the api, used to create the event type when event_type is not set
+	EventType         string            `yaml:"event_type"`     // override eventType
+	Entity            string            `yaml:"entity"`         // define a custom entity name
+	EntityType        string            `yaml:"entity_type"`    // define a custom entity type (namespace)
+	Ingest            bool              `yaml:"ingest"`         // process data ingested by the lambda runtime
 	Inventory         map[string]string `yaml:"inventory"`      // set as inventory
 	InventoryOnly     bool              `yaml:"inventory_only"` // only generate inventory data
 	Events            map[string]string `yaml:"events"`         // set as events
@@ -277,61 +436,70 @@ type API struct {
 	AsyncRate         int               `yaml:"async_rate"`     //Async Request Throttle Rate
 	JoinKey           string            `yaml:"join_key"`       // merge into another eventType
 	Prefix            string            `yaml:"prefix"`         // prefix attribute keys
-	File              string            `yaml:"file"`
-	URL               string            `yaml:"url"`
-	Pagination        Pagination        `yaml:"pagination"`
-	EscapeURL         bool              `yaml:"escape_url"`
+	File              string            `yaml:"file"`           // read a json or csv file
+	URL               string            `yaml:"url"`            // http(s) endpoint to request, prefixed by global base_url
+	Pagination        Pagination        `yaml:"pagination"`     // walk paginated http responses
+	EscapeURL         bool              `yaml:"escape_url"`     // query escape the url
 	Prometheus        Prometheus        `yaml:"prometheus"`
-	Cache             string            `yaml:"cache"` // read data from datastore
-	Database          string            `yaml:"database"`
-	DBDriver          string            `yaml:"db_driver"`
-	DBConn            string            `yaml:"db_conn"`
-	Shell             string            `yaml:"shell"`
+	Cache             string            `yaml:"cache"`          // read data from datastore
+	Database          string            `yaml:"database"`       // database type eg. postgres, mysql, mssql
+	DBDriver          string            `yaml:"db_driver"`      // override the database driver
+	DBConn            string            `yaml:"db_conn"`        // database connection string
+	Shell             string            `yaml:"shell"`          // shell used to run commands
 	CommandsAsync     bool              `yaml:"commands_async"` // run commands async
-	Commands          []Command         `yaml:"commands"`
-	DBQueries         []Command         `yaml:"db_queries"`
-	DBAsync           bool              `yaml:"db_async"`   // perform db queries async
-	Jq                string            `yaml:"jq"`         // parse data using jq
-	ParseHTML         bool              `yaml:"parse_html"` // parse text/html content type table element to JSON
+	Commands          []Command         `yaml:"commands"`       // commands to run, their output is merged into one sample
+	DBQueries         []Command         `yaml:"db_queries"`     // queries to run against the database
+	DBAsync           bool              `yaml:"db_async"`       // perform db queries async
+	Jq                string            `yaml:"jq"`             // parse data using jq
+	ParseHTML         bool              `yaml:"parse_html"`     // parse text/html content type table element to JSON
+	Format            string            `yaml:"format"`         // decode http responses in this format whatever their content type, eg. json, ndjson, yaml
 	Jmx               JMX               `yaml:"jmx"`
 	IgnoreLines       []int             // not implemented - idea is to ignore particular lines starting from 0 of the command output
-	User, Pass        string
-	Proxy             string
-	TLSConfig         TLSConfig `yaml:"tls_config"`
-	Timeout           int
-	Method            string
-	Payload           string
-	Headers           map[string]string `yaml:"headers"`
-	DisableParentAttr bool              `yaml:"disable_parent_attr"`
-	StartKey          []string          `yaml:"start_key"` // start from a different section of the payload
-	StoreLookups      map[string]string `yaml:"store_lookups"`
-	DedupeLookups     []string          `yaml:"dedupe_lookups"`
-	StoreVariables    map[string]string `yaml:"store_variables"`
-	LazyFlatten       []string          `yaml:"lazy_flatten"`
-	SampleKeys        map[string]string `yaml:"sample_keys"`
-	RenameSamples     map[string]string `yaml:"rename_samples"`     // using regex if sample has a key that matches, make that a different sample
-	SkipProcessing    []string          `yaml:"skip_processing"`    // skip processing particular keys using an array of regex strings
-	InheritAttributes bool              `yaml:"inherit_attributes"` // attempts to inherit attributes were possible
-	CustomAttributes  map[string]string `yaml:"custom_attributes"`  // set additional custom attributes
-	SplitObjects      bool              `yaml:"split_objects"`      // convert object with nested objects to array
-	SplitArray        bool              `yaml:"split_array"`        // convert array to samples, use SetHeader to set attribute name
-	LeafArray         bool              `yaml:"leaf_array"`         // convert array element to samples when SplitArray, use SetHeader to set attribute name
-	Scp               SCP               `yaml:"scp"`
-	HWSigner          HWSigner          `yaml:"hw_signer"`     // Huawei Cloud Service API signer
-	AliyunSigner      AliyunSigner      `yaml:"aliyun_signer"` // Huawei Cloud Service API signer
+	User, Pass        string            // basic auth credentials
+	Proxy             string            // proxy url
+	TLSConfig         TLSConfig         `yaml:"tls_config"`
+	Timeout           int               // timeout in milliseconds
+	Method            string            // http method, GET by default
+	Payload           string            // http body sent with POST or PUT
+	Headers           map[string]string `yaml:"headers"`             // http headers, take precedence over global headers
+	OAuth2            OAuth2            `yaml:"oauth2"`              // bearer token sent with every request, takes precedence over global oauth2
+	DisableParentAttr bool              `yaml:"disable_parent_attr"` // do not add parent attributes to nested samples
+	StartKey          []string          `yaml:"start_key"`           // start from a different section of the payload
+	StoreLookups      map[string]string `yaml:"store_lookups"`       // store values of a key to create lookups in later apis
+	DedupeLookups     []string          `yaml:"dedupe_lookups"`      // keys used to dedupe automatic lookups
+	StoreVariables    map[string]string `yaml:"store_variables"`     // store values to be used as ${var:key} in later apis
+	LazyFlatten       []string          `yaml:"lazy_flatten"`        // keys to flatten into a single sample instead of splitting
+	SampleKeys        map[string]string `yaml:"sample_keys"`         // create samples from nested keys
+	RenameSamples     map[string]string `yaml:"rename_samples"`      // using regex if sample has a key that matches, make that a different sample
+	SkipProcessing    []string          `yaml:"skip_processing"`     // skip processing particular keys using an array of regex strings
+	InheritAttributes bool              `yaml:"inherit_attributes"`  // attempts to inherit attributes were possible
+	CustomAttributes  map[string]string `yaml:"custom_attributes"`   // set additional custom attributes
+	SplitObjects      bool              `yaml:"split_objects"`       // convert object with nested objects to array
+	SplitArray        bool              `yaml:"split_array"`         // convert array to samples, use SetHeader to set attribute name
+	LeafArray         bool              `yaml:"leaf_array"`          // convert array element to samples when SplitArray, use SetHeader to set attribute name
+	Scp               SCP               `yaml:"scp"`                 // read a remote file over scp
+	Signer            Signer            `yaml:"signer"`              // signs every request, see Signer
+	Retry             Retry             `yaml:"retry"`               // send failed http requests again
+	Steps             []HTTPStep        `yaml:"steps"`               // requests sent in order, sharing cookies and connections, see HTTPStep
+	HWSigner          HWSigner          `yaml:"hw_signer"`           // deprecated, use signer with type huawei
+	AliyunSigner      AliyunSigner      `yaml:"aliyun_signer"`       // deprecated, use signer with type aliyun
+	// Processing order, see PipelineStep
+	Pipeline []PipelineStep `yaml:"pipeline"` // stages to run in order instead of the default pipeline
+	// Inputs registered by other packages
+	Input map[string]interface{} `yaml:"input"` // config blocks keyed by input name
 	// Key manipulation
 	ToLower      bool              `yaml:"to_lower"`       // convert all unicode letters mapped to their lower case.
 	ConvertSpace string            `yaml:"convert_space"`  // convert spaces to another char
 	SnakeToCamel bool              `yaml:"snake_to_camel"` // snake_case to camelCase
 	ReplaceKeys  map[string]string `yaml:"replace_keys"`   // uses rename_keys functionality
 	RenameKeys   map[string]string `yaml:"rename_keys"`    // use regex to find keys, then replace value
-	AddAttribute map[string]string `yaml:"add_attribute"`  // add attribute // needs description
+	AddAttribute map[string]string `yaml:"add_attribute"`  // add attributes built from other keys of the sample eg. ${key}
 
 	// Value manipulation
-	PercToDecimal    bool              `yaml:"perc_to_decimal"` // will check strings, and perform a trimRight for the %
-	PluckNumbers     bool              `yaml:"pluck_numbers"`   // plucks numbers out of the value
-	Math             map[string]string `yaml:"math"`            // perform match across processed metrics
-	SubParse         []Parse           `yaml:"sub_parse"`
+	PercToDecimal    bool              `yaml:"perc_to_decimal"`   // will check strings, and perform a trimRight for the %
+	PluckNumbers     bool              `yaml:"pluck_numbers"`     // plucks numbers out of the value
+	Math             map[string]string `yaml:"math"`              // perform match across processed metrics
+	SubParse         []Parse           `yaml:"sub_parse"`         // split values into additional keys
 	ValueParser      map[string]string `yaml:"value_parser"`      // find keys with regex, and parse the value with regex
 	ValueTransformer map[string]string `yaml:"value_transformer"` // find key(s) with regex, and modify the value
 	MetricParser     MetricParser      `yaml:"metric_parser"`     // to use the MetricParser for setting deltas and gauges a namespace needs to be set
@@ -350,10 +518,10 @@ type API struct {
 	RowStart  int      `yaml:"row_start"`  // start from this line, to be used with SplitBy
 
 	// Filtering Options
-	EventFilter                 []Filter            `yaml:"event_filter"` // filters events in/out
-	KeyFilter                   []Filter            `yaml:"key_filter"`   // filters keys in/out
-	StripKeys                   []string            `yaml:"strip_keys"`
-	RemoveKeys                  []string            `yaml:"remove_keys"`
+	EventFilter                 []Filter            `yaml:"event_filter"`                    // filters events in/out
+	KeyFilter                   []Filter            `yaml:"key_filter"`                      // filters keys in/out
+	StripKeys                   []string            `yaml:"strip_keys"`                      // remove keys before flattening
+	RemoveKeys                  []string            `yaml:"remove_keys"`                     // remove keys using regex, case insensitive
 	KeepKeys                    []string            `yaml:"keep_keys"`                       // inverse of removing keys
 	SampleFilter                []map[string]string `yaml:"sample_filter"`                   // exclude sample filter key pair values with regex === sample_exclude_filter
 	SampleIncludeFilter         []map[string]string `yaml:"sample_include_filter"`           // include sample filter key pair values with regex
@@ -369,7 +537,7 @@ type API struct {
 		Open bool `yaml:"open"` // log open related errors
 	}
 
-	ReturnHeaders bool `yaml:"return_headers"`
+	ReturnHeaders bool `yaml:"return_headers"` // add the http response headers to the samples
 }
 
 // Filter struct
@@ -520,6 +688,135 @@ type AliyunSigner struct {
 	Secret string `yaml:"secret"`
 }
 
+// Retry struct, http requests failing with a retryable status code or network error are sent again
+type Retry struct {
+	Attempts      int      `yaml:"attempts"`       // requests sent in total, 1 by default so none is sent again
+	Backoff       string   `yaml:"backoff"`        // wait before the first retry, doubled after each, 1s by default
+	MaxBackoff    string   `yaml:"max_backoff"`    // longest wait, a longer Retry-After is not waited for, 30s by default
+	StatusCodes   []int    `yaml:"status_codes"`   // 429, 502, 503 and 504 by default
+	NetworkErrors []string `yaml:"network_errors"` // timeout, connection, dns or none, timeout and connection by default
+}
+
+// HTTPStep struct, a request of an http api with steps, eg. a login before the requests of the samples
+// values extracted from a step are set in the url, payload and headers of later steps with ${step:name}
+type HTTPStep struct {
+	Name    string                 `yaml:"name"`
+	URL     string                 `yaml:"url"`     // resolved against the url of the api, which is used when not set
+	Method  string                 `yaml:"method"`  // GET by default
+	Payload string                 `yaml:"payload"` // body sent with the request
+	Headers map[string]string      `yaml:"headers"` // take precedence over the headers of the api
+	Extract map[string]HTTPExtract `yaml:"extract"` // values extracted from the response, by name
+	Sample  bool                   `yaml:"sample"`  // create samples from the body, the last step does when no step sets it
+}
+
+// HTTPExtract struct, a value extracted from the response of a step, from the body when no json_path, header or cookie is set
+type HTTPExtract struct {
+	JSONPath string `yaml:"json_path"` // keys and array indexes of a json body separated by dots, eg. data.tokens.0
+	Header   string `yaml:"header"`    // response header
+	Cookie   string `yaml:"cookie"`    // cookie set by the response
+	Regex    string `yaml:"regex"`     // matched against the value, its first group is extracted when it has one
+}
+
+// Signer struct, signs every request of an http api with the signer of its type
+type Signer struct {
+	Type   string `yaml:"type"`   // huawei, aliyun, aws_sigv4, hmac, or a signer registered by another package
+	Key    string `yaml:"key"`    // access key, for aws_sigv4 the credential chain is used when it is not set
+	Secret string `yaml:"secret"` // eg. ${secret.name:key}
+	// aws_sigv4
+	Service      string `yaml:"service"`       // eg. es, aoss, execute-api
+	Region       string `yaml:"region"`        // region of the aws config when not set
+	Profile      string `yaml:"profile"`       // shared config profile
+	SessionToken string `yaml:"session_token"` // for temporary credentials set with key and secret
+	// hmac
+	Template   string `yaml:"template"`    // string to sign, a text/template, see docs/apis/url.md
+	Algorithm  string `yaml:"algorithm"`   // sha256 by default, sha1 or sha512
+	Encoding   string `yaml:"encoding"`    // hex by default, or base64
+	Header     string `yaml:"header"`      // header set to the signature, Authorization by default
+	Value      string `yaml:"value"`       // template of the header value, {{.Signature}} by default
+	DateHeader string `yaml:"date_header"` // header set to the date signed, not set by default
+}
+
+// OAuth2 struct, a token is fetched from the token url, cached until it expires and sent as the Authorization header
+type OAuth2 struct {
+	TokenURL         string            `yaml:"token_url"`
+	GrantType        string            `yaml:"grant_type"` // client_credentials by default, or token_exchange
+	ClientID         string            `yaml:"client_id"`
+	ClientSecret     string            `yaml:"client_secret"` // eg. ${secret.name:key}
+	ClientAuth       string            `yaml:"client_auth"`   // basic sends the client credentials as basic auth (default), post in the form
+	Scopes           []string          `yaml:"scopes"`
+	Audience         string            `yaml:"audience"`
+	SubjectToken     string            `yaml:"subject_token"`      // token_exchange: the token exchanged
+	SubjectTokenFile string            `yaml:"subject_token_file"` // token_exchange: file read for the token exchanged, eg. a service account token
+	SubjectTokenType string            `yaml:"subject_token_type"` // token_exchange: defaults to urn:ietf:params:oauth:token-type:access_token
+	Params           map[string]string `yaml:"params"`             // additional form parameters of the token request
+}
+
+// PipelineStep is a stage of a pipeline, written as the name of the stage
+// or as a mapping with a stage key whose other keys override the keys of the api for this step only
+type PipelineStep struct {
+	Stage  string
+	Config map[string]interface{} // keys of the step other than stage
+}
+
+// UnmarshalYAML decodes a step written either as a name or as a mapping
+func (s *PipelineStep) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+	if err := unmarshal(&name); err == nil {
+		s.Stage = name
+		return nil
+	}
+	var block map[string]interface{}
+	if err := unmarshal(&block); err != nil {
+		return err
+	}
+	stage, ok := block["stage"].(string)
+	if !ok || stage == "" {
+		return errors.New("pipeline step requires a stage")
+	}
+	delete(block, "stage")
+	s.Stage = stage
+	if len(block) > 0 {
+		s.Config = block
+	}
+	return nil
+}
+
+// MarshalYAML encodes a step the way it was written, apis are marshalled to create lookups
+func (s PipelineStep) MarshalYAML() (interface{}, error) {
+	if len(s.Config) == 0 {
+		return s.Stage, nil
+	}
+	block := map[string]interface{}{"stage": s.Stage}
+	for k, v := range s.Config {
+		block[k] = v
+	}
+	return block, nil
+}
+
+// Outputs is the file set by outputs_file
+type Outputs struct {
+	Outputs []OutputConfig `yaml:"outputs"`
+}
+
+// OutputConfig is a destination results are sent to
+type OutputConfig struct {
+	Type      string `yaml:"type"`       // stdout, insights, log_api, metric_api, ndjson, otlp, prometheus_remote_write, statsd or dogstatsd
+	Name      string `yaml:"name"`       // identifies the output in logs, defaults to the type
+	URL       string `yaml:"url"`        // endpoint, eg. https://, or udp:// and unixgram:// for statsd
+	APIKey    string `yaml:"api_key"`    // key of insights, log_api and metric_api
+	BatchSize int    `yaml:"batch_size"` // samples, or metrics for metric_api and series for prometheus_remote_write, per post
+	Path      string `yaml:"path"`       // file ndjson appends samples to
+
+	// otlp
+	Headers            map[string]string `yaml:"headers"`             // sent with every request, eg. api-key
+	ResourceAttributes []string          `yaml:"resource_attributes"` // string attributes set on the resource rather than on each data point
+	LogEventTypes      []string          `yaml:"log_event_types"`     // samples of these event types are sent as log records rather than metrics
+
+	// prometheus_remote_write, also uses headers
+	Labels  []string `yaml:"labels"`  // string attributes that become labels, or dogstatsd tags, every string attribute when empty
+	Retries int      `yaml:"retries"` // retries of a failed batch, defaults to 3, -1 disables them
+}
+
 // Parse struct
 type Parse struct {
 	Type    string   `yaml:"type"` // perform a contains, match, hasPrefix or regex for specified key
@@ -0,0 +1,59 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package load
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+// Panic is a panic recovered while running an api
+type Panic struct {
+	API   string
+	Value interface{}
+	Stack []byte
+}
+
+func (p *Panic) Error() string {
+	return fmt.Sprintf("panic: %v", p.Value)
+}
+
+// NewPanic wraps a recovered value with the api it was raised in, keeping the api of a panic already wrapped
+func NewPanic(api string, value interface{}) *Panic {
+	if p, ok := value.(*Panic); ok {
+		return p
+	}
+	return &Panic{API: api, Value: value, Stack: debug.Stack()}
+}
+
+// Panics collects the first panic of apis running on their own goroutines
+// a recover only catches panics of its own goroutine, so they are raised again on the goroutine running the config
+type Panics struct {
+	lock  sync.Mutex
+	first *Panic
+}
+
+// Recover must be deferred directly by the goroutine running the api
+func (p *Panics) Recover(api string) {
+	if r := recover(); r != nil {
+		p.lock.Lock()
+		if p.first == nil {
+			p.first = NewPanic(api, r)
+		}
+		p.lock.Unlock()
+	}
+}
+
+// Raise panics again with the first panic collected, if any, once the goroutines have completed
+func (p *Panics) Raise() {
+	p.lock.Lock()
+	first := p.first
+	p.lock.Unlock()
+	if first != nil {
+		panic(first)
+	}
+}
@@ -0,0 +1,370 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package load
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/data/attribute"
+	"github.com/newrelic/infra-integrations-sdk/data/event"
+	"github.com/newrelic/infra-integrations-sdk/data/inventory"
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/persist"
+)
+
+// EntityWriter is an entity the samples, inventory and events of apis are written to
+// *integration.Entity is one, the entities of a State are others
+type EntityWriter interface {
+	NewMetricSet(eventType string, nameSpacingAttributes ...attribute.Attribute) *metric.Set
+	SetInventoryItem(key string, field string, value interface{}) error
+	AddEvent(e *event.Event) error
+}
+
+// State is what configs read and write while they run: arguments, the store of rates and deltas, entities and statuses
+// a nil *State is the state of the process, held in the package variables
+// embedders give each run its own State, so runs do not share results and can run concurrently
+type State struct {
+	args           ArgumentList
+	storer         persist.Storer
+	startTime      int64
+	entities       stateEntities
+	ignored        ignoredSamples
+	statusCounter  statusCounter
+	runStatuses    runStatuses
+	configStatuses configStatuses
+	metrics        metricsStore
+	metricTypes    metricTypeStore
+}
+
+// NewState returns an empty state using args, rates and deltas are kept in storer across states
+// samples are attached to the entity set by args, the local entity unless local is false
+func NewState(args ArgumentList, storer persist.Storer) *State {
+	s := &State{
+		args:           args,
+		storer:         storer,
+		startTime:      MakeTimestamp(),
+		statusCounter:  statusCounter{M: newStatusCounters()},
+		runStatuses:    runStatuses{M: make(map[string]*ConfigRunStatus)},
+		configStatuses: configStatuses{M: make(map[string]*ConfigStatus)},
+		metricTypes:    metricTypeStore{M: map[*metric.Set]map[string]metricKind{}},
+	}
+	if args.Local {
+		s.entities.local = s.entities.entity("", "", storer)
+	} else {
+		s.entities.local = s.entities.entity(args.Entity, IntegrationNameShort, storer)
+	}
+	return s
+}
+
+// Args returns the arguments of the state
+func (s *State) Args() *ArgumentList {
+	if s == nil {
+		return &Args
+	}
+	return &s.args
+}
+
+// Storer returns the store rates and deltas keep their previous values in, nil if there is none
+func (s *State) Storer() persist.Storer {
+	if s == nil {
+		return Storer
+	}
+	return s.storer
+}
+
+// StartTime returns when the state started, in milliseconds
+func (s *State) StartTime() int64 {
+	if s == nil {
+		return StartTime
+	}
+	return s.startTime
+}
+
+// Entity returns the entity samples are attached to when an api sets none
+func (s *State) Entity() EntityWriter {
+	if s == nil {
+		return Entity
+	}
+	return s.entities.local
+}
+
+// EntityNamed returns the entity of a name and namespace, created on first use
+func (s *State) EntityNamed(name string, namespace string) (EntityWriter, error) {
+	if s == nil {
+		return Integration.Entity(name, namespace)
+	}
+	if name == "" || namespace == "" {
+		return nil, fmt.Errorf("entity name and type are required when defining one")
+	}
+	return s.entities.entity(name, namespace, s.storer), nil
+}
+
+// MetricSets returns the metric sets of every entity
+func (s *State) MetricSets() []*metric.Set {
+	var sets []*metric.Set
+	if s == nil {
+		if Integration != nil {
+			for _, entity := range Integration.Entities {
+				sets = append(sets, entity.Metrics...)
+			}
+		}
+		return sets
+	}
+	s.entities.Lock()
+	defer s.entities.Unlock()
+	for _, entity := range s.entities.M {
+		entity.lock.Lock()
+		sets = append(sets, entity.metrics...)
+		entity.lock.Unlock()
+	}
+	return sets
+}
+
+// IgnoredAppend keeps a sample of an api with ignore_output, for lookups only
+func (s *State) IgnoredAppend(sample map[string]interface{}) {
+	if s == nil {
+		IgnoredIntegrationData = append(IgnoredIntegrationData, sample)
+		return
+	}
+	s.ignored.Lock()
+	s.ignored.samples = append(s.ignored.samples, sample)
+	s.ignored.Unlock()
+}
+
+// IgnoredSamples returns the samples of apis with ignore_output
+func (s *State) IgnoredSamples() []map[string]interface{} {
+	if s == nil {
+		return IgnoredIntegrationData
+	}
+	s.ignored.Lock()
+	defer s.ignored.Unlock()
+	return append([]map[string]interface{}{}, s.ignored.samples...)
+}
+
+// StatusCounterIncrement increments the status counter of a key
+func (s *State) StatusCounterIncrement(key string) {
+	if s == nil {
+		StatusCounterIncrement(key)
+		return
+	}
+	s.statusCounter.increment(key)
+}
+
+// StatusCounterRead returns the status counter of a key
+func (s *State) StatusCounterRead(key string) int {
+	if s == nil {
+		return StatusCounterRead(key)
+	}
+	return s.statusCounter.read(key)
+}
+
+// ConfigRunCompleted records a completed run of a config that started at start
+func (s *State) ConfigRunCompleted(cfg *Config, start time.Time) {
+	configs, runs := s.statuses()
+	configRunCompleted(configs, runs, cfg, start)
+}
+
+// ConfigError records an error while running an api of a config, api is empty for errors of the run itself
+func (s *State) ConfigError(config string, api string, err error) {
+	configs, runs := s.statuses()
+	configError(configs, runs, config, api, err)
+}
+
+// ConfigEvent records an event created by an api of a config
+func (s *State) ConfigEvent(config string, api string) {
+	configs, runs := s.statuses()
+	configEvent(configs, runs, config, api)
+}
+
+// APIStatusUpdate updates the status of an api of a config while holding the lock
+func (s *State) APIStatusUpdate(config string, api string, update func(status *APIStatus)) {
+	_, runs := s.statuses()
+	runs.update(config, api, update)
+}
+
+// RunStatusRead returns a copy of the status of each config that ran
+func (s *State) RunStatusRead() map[string]ConfigRunStatus {
+	_, runs := s.statuses()
+	return runs.read()
+}
+
+// ConfigStatusRead returns a copy of the status of each config
+func (s *State) ConfigStatusRead() map[string]ConfigStatus {
+	configs, _ := s.statuses()
+	return configs.read()
+}
+
+func (s *State) statuses() (*configStatuses, *runStatuses) {
+	if s == nil {
+		return &ConfigStatuses, &RunStatuses
+	}
+	return &s.configStatuses, &s.runStatuses
+}
+
+// MetricsStoreAppend appends dimensional metrics
+func (s *State) MetricsStoreAppend(metrics Metrics) {
+	if s == nil {
+		MetricsStoreAppend(metrics)
+		return
+	}
+	s.metrics.append(metrics)
+}
+
+// Metrics returns a copy of the dimensional metrics
+func (s *State) Metrics() []Metrics {
+	if s == nil {
+		return MetricsStore.read()
+	}
+	return s.metrics.read()
+}
+
+// MetricTypeSet records the metric_parser type of a key of a metric set, eg. RATE or DELTA
+func (s *State) MetricTypeSet(metricSet *metric.Set, key string, metricType string) {
+	if s == nil {
+		MetricTypeSet(metricSet, key, metricType)
+		return
+	}
+	s.metricTypes.set(metricSet, key, metricType)
+}
+
+// MetricType returns the metric_parser type of a key of a metric set, empty for gauges and attributes
+func (s *State) MetricType(metricSet *metric.Set, key string) string {
+	if s == nil {
+		return MetricType(metricSet, key)
+	}
+	return s.metricTypes.get(metricSet, key).metricType
+}
+
+// MetricCumulativeSet records the cumulative value a RATE or PRATE key of a metric set was computed from
+func (s *State) MetricCumulativeSet(metricSet *metric.Set, key string, value float64) {
+	if s == nil {
+		MetricCumulativeSet(metricSet, key, value)
+		return
+	}
+	s.metricTypes.setCumulative(metricSet, key, value)
+}
+
+// MetricIncrementSet records the increase since its previous value of the cumulative value a RATE or PRATE key was computed from
+func (s *State) MetricIncrementSet(metricSet *metric.Set, key string, value float64) {
+	if s == nil {
+		MetricIncrementSet(metricSet, key, value)
+		return
+	}
+	s.metricTypes.setIncrement(metricSet, key, value)
+}
+
+// ignoredSamples holds the samples of apis with ignore_output
+type ignoredSamples struct {
+	sync.Mutex
+	samples []map[string]interface{}
+}
+
+// stateEntities holds the entities of a State, the local one included
+type stateEntities struct {
+	sync.Mutex
+	local *stateEntity
+	M     []*stateEntity
+}
+
+// entity returns the entity of a name and namespace, created on first use
+func (e *stateEntities) entity(name string, namespace string, storer persist.Storer) *stateEntity {
+	e.Lock()
+	defer e.Unlock()
+	for _, entity := range e.M {
+		if entity.name == name && entity.namespace == namespace {
+			return entity
+		}
+	}
+	entity := &stateEntity{name: name, namespace: namespace, storer: storer, inventory: inventory.New()}
+	e.M = append(e.M, entity)
+	return entity
+}
+
+// stateEntity holds what is written to an entity of a State, as an integration entity does
+type stateEntity struct {
+	name      string
+	namespace string
+	storer    persist.Storer
+	lock      sync.Mutex
+	metrics   []*metric.Set
+	inventory *inventory.Inventory
+	events    []*event.Event
+}
+
+// NewMetricSet returns a metric set attached to the entity, with the entityKey of a remote entity
+func (e *stateEntity) NewMetricSet(eventType string, nameSpacingAttributes ...attribute.Attribute) *metric.Set {
+	s := metric.NewSet(eventType, e.storer, nameSpacingAttributes...)
+	if e.name != "" {
+		s.AddNamespaceAttributes(attribute.Attr("entityKey", e.namespace+":"+e.name))
+	}
+	e.lock.Lock()
+	e.metrics = append(e.metrics, s)
+	e.lock.Unlock()
+	return s
+}
+
+// SetInventoryItem sets an inventory item of the entity
+func (e *stateEntity) SetInventoryItem(key string, field string, value interface{}) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.inventory.SetItem(key, field, value)
+}
+
+// AddEvent adds an event to the entity
+func (e *stateEntity) AddEvent(evnt *event.Event) error {
+	if evnt.Summary == "" {
+		return fmt.Errorf("summary of the event cannot be empty")
+	}
+	e.lock.Lock()
+	e.events = append(e.events, evnt)
+	e.lock.Unlock()
+	return nil
+}
+
+// DefaultArguments returns the argument list with the value of each default tag, as if no flags were passed
+func DefaultArguments() (ArgumentList, error) {
+	var args ArgumentList
+	err := setDefaults(reflect.ValueOf(&args).Elem())
+	return args, err
+}
+
+func setDefaults(value reflect.Value) error {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		structField := value.Type().Field(i)
+		if structField.Anonymous && field.Kind() == reflect.Struct {
+			if err := setDefaults(field); err != nil {
+				return err
+			}
+			continue
+		}
+		def, ok := structField.Tag.Lookup("default")
+		if !ok || def == "" || !field.CanSet() {
+			continue
+		}
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(def)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(def)
+			if err != nil {
+				return fmt.Errorf("load: invalid default of %s, %v", structField.Name, err)
+			}
+			field.SetBool(b)
+		case reflect.Int:
+			n, err := strconv.Atoi(def)
+			if err != nil {
+				return fmt.Errorf("load: invalid default of %s, %v", structField.Name, err)
+			}
+			field.SetInt(int64(n))
+		}
+	}
+	return nil
+}
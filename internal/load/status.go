@@ -0,0 +1,204 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package load
+
+import (
+	"sync"
+	"time"
+)
+
+// ConfigStatus is the outcome of the runs of a config since Flex started
+type ConfigStatus struct {
+	Runs           int
+	Errors         int
+	Events         int
+	LastRunMs      int64 // when the last run completed
+	LastDurationMs int64
+}
+
+// configStatuses holds the status of each config by name
+type configStatuses struct {
+	sync.RWMutex
+	M map[string]*ConfigStatus
+}
+
+// ConfigStatuses holds the status of each config by name, unlike FlexStatusCounter it is never reset
+var ConfigStatuses = configStatuses{M: make(map[string]*ConfigStatus)}
+
+// APIStatus is the outcome of an api since results were last published
+type APIStatus struct {
+	InputType       string // eg. http, commands, database
+	FetchDurationMs int64
+	BytesRead       int
+	HTTPStatus      int // status code of the last http response
+	HTTPRetries     int // http requests sent again after a failure
+	Samples         int
+	SamplesFiltered int
+	EventLimitDrops int
+	Errors          int
+	LastError       string
+}
+
+// ConfigRunStatus is the outcome of a config since results were last published
+type ConfigRunStatus struct {
+	FileName   string
+	DurationMs int64 // duration of the last run
+	Errors     int
+	LastError  string // last error of any of its apis, or of the run itself
+	APIs       map[string]*APIStatus
+}
+
+// runStatuses holds the status of each config that ran by name
+type runStatuses struct {
+	sync.Mutex
+	M map[string]*ConfigRunStatus
+}
+
+// RunStatuses holds the status of each config that ran since results were last published
+var RunStatuses = runStatuses{M: make(map[string]*ConfigRunStatus)}
+
+// ConfigRunCompleted records a completed run of a config that started at start
+func ConfigRunCompleted(cfg *Config, start time.Time) {
+	configRunCompleted(&ConfigStatuses, &RunStatuses, cfg, start)
+}
+
+// ConfigError records an error while running an api of a config, api is empty for errors of the run itself
+func ConfigError(config string, api string, err error) {
+	configError(&ConfigStatuses, &RunStatuses, config, api, err)
+}
+
+// ConfigEvent records an event created by an api of a config
+func ConfigEvent(config string, api string) {
+	configEvent(&ConfigStatuses, &RunStatuses, config, api)
+}
+
+// APIStatusUpdate updates the status of an api of a config while holding the lock
+func APIStatusUpdate(config string, api string, update func(status *APIStatus)) {
+	RunStatuses.update(config, api, update)
+}
+
+// ConfigStatusRead returns a copy of the status of each config
+func ConfigStatusRead() map[string]ConfigStatus {
+	return ConfigStatuses.read()
+}
+
+// RunStatusRead returns a copy of the status of each config that ran since results were last published
+func RunStatusRead() map[string]ConfigRunStatus {
+	return RunStatuses.read()
+}
+
+// RunStatusReset forgets the configs that ran, once their results are published
+func RunStatusReset() {
+	RunStatuses.Lock()
+	RunStatuses.M = make(map[string]*ConfigRunStatus)
+	RunStatuses.Unlock()
+}
+
+func configRunCompleted(configs *configStatuses, runs *runStatuses, cfg *Config, start time.Time) {
+	durationMs := time.Since(start).Milliseconds()
+
+	configs.Lock()
+	status := configs.status(cfg.Name)
+	status.Runs++
+	status.LastRunMs = TimestampMs()
+	status.LastDurationMs = durationMs
+	configs.Unlock()
+
+	runs.Lock()
+	run := runs.status(cfg.Name)
+	run.FileName = cfg.FileName
+	run.DurationMs = durationMs
+	runs.Unlock()
+}
+
+func configError(configs *configStatuses, runs *runStatuses, config string, api string, err error) {
+	configs.Lock()
+	configs.status(config).Errors++
+	configs.Unlock()
+
+	runs.Lock()
+	run := runs.status(config)
+	run.Errors++
+	run.LastError = err.Error()
+	if api != "" {
+		status := apiStatus(run, api)
+		status.Errors++
+		status.LastError = err.Error()
+	}
+	runs.Unlock()
+}
+
+func configEvent(configs *configStatuses, runs *runStatuses, config string, api string) {
+	configs.Lock()
+	configs.status(config).Events++
+	configs.Unlock()
+
+	runs.update(config, api, func(status *APIStatus) {
+		status.Samples++
+	})
+}
+
+func (c *configStatuses) read() map[string]ConfigStatus {
+	c.RLock()
+	defer c.RUnlock()
+	statuses := make(map[string]ConfigStatus, len(c.M))
+	for name, status := range c.M {
+		statuses[name] = *status
+	}
+	return statuses
+}
+
+// status returns the status of a config, the lock must be held
+func (c *configStatuses) status(name string) *ConfigStatus {
+	status, ok := c.M[name]
+	if !ok {
+		status = &ConfigStatus{}
+		c.M[name] = status
+	}
+	return status
+}
+
+func (r *runStatuses) update(config string, api string, update func(status *APIStatus)) {
+	r.Lock()
+	update(apiStatus(r.status(config), api))
+	r.Unlock()
+}
+
+func (r *runStatuses) read() map[string]ConfigRunStatus {
+	r.Lock()
+	defer r.Unlock()
+	statuses := make(map[string]ConfigRunStatus, len(r.M))
+	for name, run := range r.M {
+		runCopy := *run
+		runCopy.APIs = make(map[string]*APIStatus, len(run.APIs))
+		for api, status := range run.APIs {
+			statusCopy := *status
+			runCopy.APIs[api] = &statusCopy
+		}
+		statuses[name] = runCopy
+	}
+	return statuses
+}
+
+// status returns the run status of a config, the lock must be held
+func (r *runStatuses) status(name string) *ConfigRunStatus {
+	run, ok := r.M[name]
+	if !ok {
+		run = &ConfigRunStatus{APIs: map[string]*APIStatus{}}
+		r.M[name] = run
+	}
+	return run
+}
+
+// apiStatus returns the status of an api of a config run, the lock must be held
+func apiStatus(run *ConfigRunStatus, api string) *APIStatus {
+	status, ok := run.APIs[api]
+	if !ok {
+		status = &APIStatus{}
+		run.APIs[api] = status
+	}
+	return status
+}
@@ -11,33 +11,68 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/newrelic/nri-flex/internal/load"
 	"github.com/pkg/errors"
 )
 
+// maxBackoff caps the exponential backoff between retries, and the Retry-After of a server
+var maxBackoff = 30 * time.Second
+
+// postBackoff is the wait before the first retry of a post, doubled for each retry after it
+var postBackoff = 500 * time.Millisecond
+
 // postRequest wraps request and attaches needed headers and zlib compression
 func postRequest(url string, key string, data []byte) error {
+	payload, err := compress(data)
+	if err != nil {
+		return err
+	}
+	return postPayload(url, key, payload)
+}
+
+// compress returns data zlib compressed
+func compress(data []byte) ([]byte, error) {
 	var zlibCompressedPayload bytes.Buffer
 	w := zlib.NewWriter(&zlibCompressedPayload)
 	_, err := w.Write(data)
 	if err != nil {
-		return fmt.Errorf("http: failed to compress payload, %v", err)
+		return nil, fmt.Errorf("http: failed to compress payload, %v", err)
 	}
 	err = w.Close()
 	if err != nil {
-		return fmt.Errorf("http: failed to close zlib writer, %v", err)
+		return nil, fmt.Errorf("http: failed to close zlib writer, %v", err)
 	}
 
 	load.Logrus.
 		Debugf("http: bytes %d events %d", len(zlibCompressedPayload.Bytes()), len(load.Entity.Metrics))
+	return zlibCompressedPayload.Bytes(), nil
+}
+
+// postPayload posts a zlib compressed payload
+// failed posts are retried post_retries times, then spooled to be replayed by the next execution when the failure was temporary
+func postPayload(url string, key string, payload []byte) error {
+	temporary, err := withRetries("http", load.Args.PostRetries, postBackoff, func() (time.Duration, error) {
+		return postCompressed(url, key, payload)
+	})
+	if err != nil && temporary {
+		if spoolErr := spoolPost(url, payload); spoolErr != nil {
+			load.Logrus.WithError(spoolErr).Error("http: failed to spool")
+		}
+	}
+	return err
+}
 
+// postCompressed posts a zlib compressed payload once
+// the returned wait is negative when the post must not be retried, and set when the server asked to retry after it
+func postCompressed(url string, key string, payload []byte) (time.Duration, error) {
 	tr := &http.Transport{IdleConnTimeout: 15 * time.Second, Proxy: http.ProxyFromEnvironment}
 	client := &http.Client{Transport: tr}
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(zlibCompressedPayload.Bytes()))
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(payload))
 	if err != nil {
-		return fmt.Errorf("http: unable to create http.Request, %v", err)
+		return -1, fmt.Errorf("http: unable to create http.Request, %v", err)
 	}
 
 	req.Header.Set("Content-Encoding", "deflate")
@@ -47,7 +82,7 @@ func postRequest(url string, key string, data []byte) error {
 	resp, err := client.Do(req)
 	if err != nil {
 		load.Logrus.WithError(err).Error("http: failed to send")
-		return errors.Wrap(err, "http: failed to send")
+		return 0, errors.Wrap(err, "http: failed to send")
 	}
 
 	defer func() {
@@ -55,8 +90,73 @@ func postRequest(url string, key string, data []byte) error {
 		_ = resp.Body.Close()
 	}()
 
+	return retryWait(resp), statusError("http", resp)
+}
+
+// statusCodeError is the error of a post that got a response other than 2xx
+type statusCodeError struct {
+	name string
+	code int
+}
+
+func (e *statusCodeError) Error() string {
+	return fmt.Sprintf("%s: post failed, status code: %d", e.name, e.code)
+}
+
+// statusError returns a *statusCodeError when the response is not a 2xx
+func statusError(name string, resp *http.Response) error {
 	if resp.StatusCode > 299 || resp.StatusCode < 200 {
-		return fmt.Errorf("http: post failed, status code: %d", resp.StatusCode)
+		return &statusCodeError{name: name, code: resp.StatusCode}
+	}
+	return nil
+}
+
+// retryWait tells if a failed response can be retried: 5xx and 429 can, after the Retry-After of a 429 when set
+// other statuses return a negative wait, as sending the same request again fails the same way
+func retryWait(resp *http.Response) time.Duration {
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		retryAfter := resp.Header.Get("Retry-After")
+		if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+		if date, err := http.ParseTime(retryAfter); err == nil && time.Until(date) > 0 {
+			return time.Until(date)
+		}
+		return 0
+	case resp.StatusCode >= 500:
+		return 0
+	default:
+		return -1
+	}
+}
+
+// withRetries calls post until it succeeds, retrying up to retries times with exponential backoff
+// post returns a negative wait when its error must not be retried, and a positive one to wait that long instead of the backoff, up to maxBackoff
+// temporary reports if the last error could have been retried
+func withRetries(name string, retries int, backoff time.Duration, post func() (time.Duration, error)) (temporary bool, err error) {
+	for attempt := 0; ; attempt++ {
+		wait, err := post()
+		if err == nil {
+			return false, nil
+		}
+		if wait < 0 {
+			return false, err
+		}
+		if attempt >= retries {
+			return true, err
+		}
+		if wait == 0 {
+			wait = backoff
+		}
+		// a server can ask for any wait, the daemon cannot stop collecting for that long
+		if wait > maxBackoff {
+			wait = maxBackoff
+		}
+		load.Logrus.WithError(err).Debugf("%s: retrying in %v", name, wait)
+		time.Sleep(wait)
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
 	}
-	return err
 }
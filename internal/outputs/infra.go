@@ -28,6 +28,7 @@ func InfraIntegration() error {
 		return fmt.Errorf("can't create custom store: %s", err)
 	}
 
+	load.Storer = storer
 	load.Integration, err = Integration.New(load.IntegrationName, load.IntegrationVersion, Integration.Args(&load.Args), Integration.Storer(storer))
 	if err != nil {
 		return fmt.Errorf("flex: failed to create integration %v", err)
@@ -49,6 +50,17 @@ func InfraIntegration() error {
 	return nil
 }
 
+// RefreshEntity re-attaches the default entity to the integration
+// publishing clears all entities, so long-running processes need to call this after every publish
+func RefreshEntity() error {
+	var err error
+	load.Entity, err = createEntity(load.Args.Local, load.Args.Entity)
+	if err != nil {
+		return fmt.Errorf("flex: failed create entity: %v", err)
+	}
+	return nil
+}
+
 func createEntity(isLocalEntity bool, entityName string) (*Integration.Entity, error) {
 	if isLocalEntity {
 		return load.Integration.LocalEntity(), nil
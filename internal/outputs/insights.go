@@ -7,6 +7,7 @@ package outputs
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 
 	"github.com/newrelic/infra-integrations-sdk/data/metric"
@@ -101,3 +102,48 @@ func modifyEventType(entity *integration.Entity) {
 // 		}
 // 	}
 // }
+
+// eventsOutput posts samples to an events endpoint, insights or the log api, in batches
+type eventsOutput struct {
+	name         string
+	url          string
+	apiKey       string
+	batchSize    int
+	eventTypeKey string // the key the endpoint expects the event type under
+}
+
+func newInsightsOutput(cfg load.OutputConfig) (Output, error) {
+	return newEventsOutput(cfg, load.Args.InsightBatchSize, "eventType")
+}
+
+func newEventsOutput(cfg load.OutputConfig, defaultBatchSize int, eventTypeKey string) (Output, error) {
+	if cfg.URL == "" || cfg.APIKey == "" {
+		return nil, fmt.Errorf("requires url and api_key")
+	}
+	o := eventsOutput{name: cfg.Name, url: cfg.URL, apiKey: cfg.APIKey, batchSize: cfg.BatchSize, eventTypeKey: eventTypeKey}
+	if o.batchSize == 0 {
+		o.batchSize = defaultBatchSize
+	}
+	return o, nil
+}
+
+func (o eventsOutput) Name() string { return o.name }
+
+func (o eventsOutput) keyFor(url string) (string, bool) { return o.apiKey, url == o.url }
+
+// Send posts every batch, a failed batch does not stop the others
+func (o eventsOutput) Send() error {
+	var errs []error
+	for _, batch := range batches(samples(o.eventTypeKey), o.batchSize) {
+		jsonData, err := json.Marshal(batch)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: failed to marshal json, %v", o.name, err))
+			continue
+		}
+		load.Logrus.Debugf("posting %d events to %s", len(batch), o.name)
+		if err := postRequest(o.url, o.apiKey, jsonData); err != nil {
+			errs = append(errs, fmt.Errorf("%s: sending events failed, %v", o.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
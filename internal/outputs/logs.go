@@ -69,3 +69,7 @@ func modifyLogEventType(entity *integration.Entity) {
 		delete(event.Metrics, "event_type")
 	}
 }
+
+func newLogAPIOutput(cfg load.OutputConfig) (Output, error) {
+	return newEventsOutput(cfg, load.Args.LogBatchSize, "flexEventType")
+}
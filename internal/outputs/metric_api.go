@@ -7,29 +7,179 @@ package outputs
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"sync"
 
 	"github.com/newrelic/nri-flex/internal/load"
 )
 
+// metricAPIMaxBytes is the limit of the metric api on the compressed size of a post
+var metricAPIMaxBytes = 1000000
+
 // SendToMetricAPI - Send processed events to insights
 func SendToMetricAPI() error {
-	key := load.Args.InsightsAPIKey
+	key := metricAPIKey()
+
+	if load.Args.InsightsOutput {
+		jsonData, err := json.Marshal(load.MetricsStore.Data)
+		if err != nil {
+			return fmt.Errorf("metrics api: failed to marshal json, %v", err)
+		}
+		fmt.Println(string(jsonData))
+	}
+
+	return sendMetrics("metrics api", load.Args.MetricAPIUrl, key, load.MetricsStore.Data, load.Args.MetricAPIBatchSize)
+}
+
+// metricAPIKey is the metric_api_key argument, or insights_api_key when it is not set
+func metricAPIKey() string {
 	if load.Args.MetricAPIKey != "" {
-		key = load.Args.MetricAPIKey
+		return load.Args.MetricAPIKey
 	}
-	jsonData, err := json.Marshal(load.MetricsStore.Data) // may need to implement some sort of chunking or batching
-	if err != nil {
-		return fmt.Errorf("metrics api: failed to marshal json, %v", err)
+	return load.Args.InsightsAPIKey
+}
+
+// metricAPIOutput posts the metrics of metric_api apis to the metric api
+type metricAPIOutput struct {
+	name      string
+	url       string
+	apiKey    string
+	batchSize int
+}
+
+func newMetricAPIOutput(cfg load.OutputConfig) (Output, error) {
+	if cfg.APIKey == "" {
+		return nil, fmt.Errorf("requires api_key")
+	}
+	o := metricAPIOutput{name: cfg.Name, url: cfg.URL, apiKey: cfg.APIKey, batchSize: cfg.BatchSize}
+	if o.url == "" {
+		o.url = load.Args.MetricAPIUrl
 	}
+	if o.batchSize == 0 {
+		o.batchSize = load.Args.MetricAPIBatchSize
+	}
+	return o, nil
+}
 
-	if load.Args.InsightsOutput {
-		fmt.Println(string(jsonData))
+func (o metricAPIOutput) Name() string { return o.name }
+
+func (o metricAPIOutput) keyFor(url string) (string, bool) { return o.apiKey, url == o.url }
+
+func (o metricAPIOutput) Send() error {
+	load.MetricsStore.RLock()
+	data := append([]load.Metrics{}, load.MetricsStore.Data...)
+	load.MetricsStore.RUnlock()
+
+	return sendMetrics(o.name, o.url, o.apiKey, data, o.batchSize)
+}
+
+// sendMetrics posts metrics in chunks of batchSize metrics, metric_api_workers chunks at a time
+// a failed chunk does not stop the others, the error tells which chunks failed
+func sendMetrics(name string, url string, key string, data []load.Metrics, batchSize int) error {
+	chunks := splitMetrics(data, batchSize)
+	if len(chunks) == 0 {
+		return nil
+	}
+	workers := load.Args.MetricAPIWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	load.Logrus.Debugf("%s: posting %d metrics in %d chunks", name, countMetrics(data), len(chunks))
+
+	errs := make([]error, len(chunks))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(chunks); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if err := sendMetricChunk(url, key, chunks[i]); err != nil {
+					errs[i] = fmt.Errorf("%s: chunk %d of %d with %d metrics failed, %v", name, i+1, len(chunks), countMetrics(chunks[i]), err)
+				}
+			}
+		}()
+	}
+	for i := range chunks {
+		jobs <- i
 	}
+	close(jobs)
+	wg.Wait()
+	return errors.Join(errs...)
+}
 
-	err = postRequest(load.Args.MetricAPIUrl, key, jsonData)
+// sendMetricChunk posts a chunk, split in halves when it is over the compressed size limit or rejected as too large
+func sendMetricChunk(url string, key string, chunk []load.Metrics) error {
+	jsonData, err := json.Marshal(chunk)
 	if err != nil {
-		return fmt.Errorf("insights: sending events failed, %v", err)
+		return fmt.Errorf("failed to marshal json, %v", err)
+	}
+	payload, err := compress(jsonData)
+	if err != nil {
+		return err
+	}
+
+	count := countMetrics(chunk)
+	if len(payload) > metricAPIMaxBytes && count > 1 {
+		load.Logrus.Debugf("metrics api: %d metrics are %d bytes compressed, splitting", count, len(payload))
+		return sendMetricHalves(url, key, chunk)
+	}
+	err = postPayload(url, key, payload)
+	var statusErr *statusCodeError
+	if errors.As(err, &statusErr) && statusErr.code == http.StatusRequestEntityTooLarge && count > 1 {
+		load.Logrus.Debugf("metrics api: %d metrics are too large, splitting", count)
+		return sendMetricHalves(url, key, chunk)
+	}
+	return err
+}
+
+func sendMetricHalves(url string, key string, chunk []load.Metrics) error {
+	var errs []error
+	for _, half := range splitMetrics(chunk, (countMetrics(chunk)+1)/2) {
+		errs = append(errs, sendMetricChunk(url, key, half))
+	}
+	return errors.Join(errs...)
+}
+
+// splitMetrics splits payloads into chunks of at most size metrics, a payload split across chunks keeps its common attributes in each
+// size 0 or less keeps every metric in one chunk
+func splitMetrics(data []load.Metrics, size int) [][]load.Metrics {
+	if size <= 0 {
+		size = countMetrics(data)
+	}
+	var chunks [][]load.Metrics
+	var chunk []load.Metrics
+	count := 0
+	for _, payload := range data {
+		metrics := payload.Metrics
+		for len(metrics) > 0 {
+			n := size - count
+			if n > len(metrics) {
+				n = len(metrics)
+			}
+			part := payload
+			part.Metrics = metrics[:n]
+			chunk = append(chunk, part)
+			count += n
+			metrics = metrics[n:]
+			if count == size {
+				chunks = append(chunks, chunk)
+				chunk, count = nil, 0
+			}
+		}
+	}
+	if len(chunk) > 0 {
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+func countMetrics(data []load.Metrics) int {
+	count := 0
+	for _, payload := range data {
+		count += len(payload.Metrics)
 	}
-	return nil
+	return count
 }
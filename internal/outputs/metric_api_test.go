@@ -0,0 +1,199 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package outputs
+
+import (
+	"compress/zlib"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/newrelic/nri-flex/internal/load"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testMetrics(payloads ...int) []load.Metrics {
+	var data []load.Metrics
+	n := 0
+	for p, count := range payloads {
+		payload := load.Metrics{TimestampMs: 1000, CommonAttributes: map[string]interface{}{"payload": p}}
+		for i := 0; i < count; i++ {
+			payload.Metrics = append(payload.Metrics, map[string]interface{}{"name": fmt.Sprintf("metric%d", n), "type": "gauge", "value": float64(n)})
+			n++
+		}
+		data = append(data, payload)
+	}
+	return data
+}
+
+func TestSplitMetrics(t *testing.T) {
+	tests := map[string]struct {
+		payloads []int
+		size     int
+		chunks   [][]int // metrics of each payload of each chunk
+	}{
+		"fits in one chunk":       {[]int{2, 3}, 10, [][]int{{2, 3}}},
+		"payload split in chunks": {[]int{5}, 2, [][]int{{2}, {2}, {1}}},
+		"payloads share a chunk":  {[]int{1, 2, 3}, 3, [][]int{{1, 2}, {3}}},
+		"no size":                 {[]int{4, 4}, 0, [][]int{{4, 4}}},
+		"empty payloads skipped":  {[]int{0, 2, 0}, 1, [][]int{{1}, {1}}},
+		"no metrics":              {[]int{0}, 5, nil},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var chunks [][]int
+			for _, chunk := range splitMetrics(testMetrics(tc.payloads...), tc.size) {
+				var counts []int
+				for _, payload := range chunk {
+					counts = append(counts, len(payload.Metrics))
+					assert.NotNil(t, payload.CommonAttributes["payload"])
+				}
+				chunks = append(chunks, counts)
+			}
+			assert.Equal(t, tc.chunks, chunks)
+		})
+	}
+}
+
+// metricServer records the metric names of every post, fail decides the status of a post from its metrics
+type metricServer struct {
+	*httptest.Server
+	lock      sync.Mutex
+	posts     [][]string
+	inFlight  int
+	maxFlight int
+}
+
+func newMetricServer(t *testing.T, fail func(names []string) int) *metricServer {
+	s := &metricServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reader, err := zlib.NewReader(r.Body)
+		require.NoError(t, err)
+		var data []load.Metrics
+		require.NoError(t, json.NewDecoder(reader).Decode(&data))
+		var names []string
+		for _, payload := range data {
+			for _, metric := range payload.Metrics {
+				names = append(names, metric["name"].(string))
+			}
+		}
+
+		s.lock.Lock()
+		s.inFlight++
+		if s.inFlight > s.maxFlight {
+			s.maxFlight = s.inFlight
+		}
+		s.lock.Unlock()
+		time.Sleep(10 * time.Millisecond)
+
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.inFlight--
+		if status := fail(names); status != 0 {
+			w.WriteHeader(status)
+			return
+		}
+		s.posts = append(s.posts, names)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// received returns the metrics of every successful post, sorted
+func (s *metricServer) received() []string {
+	var names []string
+	for _, post := range s.posts {
+		names = append(names, post...)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func metricNames(data []load.Metrics) []string {
+	var names []string
+	for _, payload := range data {
+		for _, metric := range payload.Metrics {
+			names = append(names, metric["name"].(string))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func setupMetricAPI(t *testing.T, workers int) {
+	setupSpool(t, 0, 0)
+	load.Args.MetricAPIWorkers = workers
+}
+
+func TestSendMetricsChunks(t *testing.T) {
+	setupMetricAPI(t, 2)
+	ts := newMetricServer(t, func([]string) int { return 0 })
+	data := testMetrics(3, 4, 3)
+
+	require.NoError(t, sendMetrics("metric_api", ts.URL, "key", data, 2))
+	assert.Len(t, ts.posts, 5)
+	assert.Equal(t, metricNames(data), ts.received())
+	// chunks are sent concurrently, never more than the workers at a time
+	assert.Equal(t, 2, ts.maxFlight)
+}
+
+func TestSendMetricsTooLarge(t *testing.T) {
+	setupMetricAPI(t, 1)
+	// the endpoint rejects posts of more than 2 metrics
+	ts := newMetricServer(t, func(names []string) int {
+		if len(names) > 2 {
+			return http.StatusRequestEntityTooLarge
+		}
+		return 0
+	})
+	data := testMetrics(7)
+
+	require.NoError(t, sendMetrics("metric_api", ts.URL, "key", data, 10))
+	assert.Equal(t, metricNames(data), ts.received())
+	for _, post := range ts.posts {
+		assert.True(t, len(post) <= 2)
+	}
+}
+
+func TestSendMetricsCompressedSize(t *testing.T) {
+	setupMetricAPI(t, 1)
+	maxBytes := metricAPIMaxBytes
+	defer func() { metricAPIMaxBytes = maxBytes }()
+	data := testMetrics(8)
+	chunk, err := json.Marshal(splitMetrics(data, 2)[0])
+	require.NoError(t, err)
+	payload, err := compress(chunk)
+	require.NoError(t, err)
+	// chunks of 2 metrics fit, larger chunks are split before they are posted
+	metricAPIMaxBytes = len(payload) + 10
+
+	ts := newMetricServer(t, func([]string) int { return 0 })
+	require.NoError(t, sendMetrics("metric_api", ts.URL, "key", data, 0))
+	assert.Equal(t, metricNames(data), ts.received())
+	assert.Len(t, ts.posts, 4)
+}
+
+func TestSendMetricsChunkErrors(t *testing.T) {
+	setupMetricAPI(t, 3)
+	ts := newMetricServer(t, func(names []string) int {
+		if names[0] == "metric2" {
+			return http.StatusForbidden
+		}
+		return 0
+	})
+	data := testMetrics(5)
+
+	err := sendMetrics("metric_api", ts.URL, "key", data, 2)
+	assert.EqualError(t, err, "metric_api: chunk 2 of 3 with 2 metrics failed, http: post failed, status code: 403")
+	// the other chunks are sent
+	assert.Equal(t, []string{"metric0", "metric1", "metric4"}, ts.received())
+}
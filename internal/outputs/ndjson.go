@@ -0,0 +1,55 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package outputs
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/newrelic/nri-flex/internal/load"
+)
+
+// ndjsonOutput appends every sample to a file, one json object per line
+type ndjsonOutput struct {
+	name string
+	path string
+}
+
+func newNDJSONOutput(cfg load.OutputConfig) (Output, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("requires path")
+	}
+	return ndjsonOutput{name: cfg.Name, path: cfg.Path}, nil
+}
+
+func (o ndjsonOutput) Name() string { return o.name }
+
+func (o ndjsonOutput) Send() error {
+	f, err := os.OpenFile(o.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("%s: failed to open %s, %v", o.name, o.path, err)
+	}
+
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	for _, sample := range samples("event_type") {
+		if err = encoder.Encode(sample); err != nil {
+			err = fmt.Errorf("%s: failed to write sample, %v", o.name, err)
+			break
+		}
+	}
+	if err == nil {
+		if err = w.Flush(); err != nil {
+			err = fmt.Errorf("%s: failed to write %s, %v", o.name, o.path, err)
+		}
+	}
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("%s: failed to close %s, %v", o.name, o.path, closeErr)
+	}
+	return err
+}
@@ -0,0 +1,499 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package outputs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/nri-flex/internal/load"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// otlpOutput exports samples to an OpenTelemetry collector over OTLP/HTTP, encoded as protobuf
+// MetricsData and LogsData are sent as they share the wire format of the export requests of the collector
+type otlpOutput struct {
+	name               string
+	url                string // base url, /v1/metrics and /v1/logs are appended
+	headers            map[string]string
+	batchSize          int
+	resourceAttributes map[string]bool
+	logEventTypes      map[string]bool
+}
+
+func newOTLPOutput(cfg load.OutputConfig) (Output, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("requires url")
+	}
+	o := otlpOutput{
+		name:               cfg.Name,
+		url:                strings.TrimSuffix(cfg.URL, "/"),
+		headers:            cfg.Headers,
+		batchSize:          cfg.BatchSize,
+		resourceAttributes: map[string]bool{},
+		logEventTypes:      map[string]bool{},
+	}
+	if o.batchSize == 0 {
+		o.batchSize = load.Args.InsightBatchSize
+	}
+	for _, key := range cfg.ResourceAttributes {
+		o.resourceAttributes[key] = true
+	}
+	for _, eventType := range cfg.LogEventTypes {
+		o.logEventTypes[eventType] = true
+	}
+	return o, nil
+}
+
+func (o otlpOutput) Name() string { return o.name }
+
+// otlpSample is a sample of an entity waiting to be exported
+type otlpSample struct {
+	entity *integration.Entity
+	set    *metric.Set
+}
+
+// Send exports the samples of every entity, then the metrics of metric_api apis, in batches of batch_size samples
+func (o otlpOutput) Send() error {
+	now := uint64(time.Now().UnixNano())
+	var errs []error
+
+	var samples []otlpSample
+	for _, entity := range load.Integration.Entities {
+		for _, set := range entity.Metrics {
+			samples = append(samples, otlpSample{entity: entity, set: set})
+		}
+	}
+	for start := 0; start < len(samples); start += o.batchSize {
+		end := start + o.batchSize
+		if end > len(samples) {
+			end = len(samples)
+		}
+		metrics := newOTLPMetrics()
+		logs := newOTLPLogs()
+		for _, sample := range samples[start:end] {
+			eventType, _ := sample.set.Metrics["event_type"].(string)
+			if o.logEventTypes[eventType] {
+				o.addLogRecord(logs, sample, eventType, now)
+			} else {
+				o.addSampleMetrics(metrics, sample, eventType, now)
+			}
+		}
+		if err := o.export("/v1/metrics", metrics.data()); err != nil {
+			errs = append(errs, err)
+		}
+		if err := o.export("/v1/logs", logs.data()); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	load.MetricsStore.RLock()
+	stored := load.MetricsStore.Data
+	load.MetricsStore.RUnlock()
+	for start := 0; start < len(stored); start += o.batchSize {
+		end := start + o.batchSize
+		if end > len(stored) {
+			end = len(stored)
+		}
+		metrics := newOTLPMetrics()
+		for _, payload := range stored[start:end] {
+			o.addMetricAPIMetrics(metrics, payload)
+		}
+		if err := o.export("/v1/metrics", metrics.data()); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// addSampleMetrics adds a metric for each numeric attribute of a sample
+// metric_parser DELTA keys are delta sums, RATE keys are cumulative sums of the counter the rate was computed from
+func (o otlpOutput) addSampleMetrics(metrics *otlpMetrics, sample otlpSample, eventType string, now uint64) {
+	resource, attributes, values := o.split(sample.entity, sample.set.Metrics)
+	for _, key := range sortedKeys(values) {
+		point := &metricspb.NumberDataPoint{
+			Attributes:   attributes,
+			TimeUnixNano: now,
+			Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: values[key]},
+		}
+		m := &metricspb.Metric{Name: key}
+		switch metricType := load.MetricType(sample.set, key); metricType {
+		case "DELTA", "PDELTA":
+			m.Data = &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+				DataPoints:             []*metricspb.NumberDataPoint{point},
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+				IsMonotonic:            metricType == "PDELTA",
+			}}
+		case "RATE", "PRATE":
+			// the start of the counter is unknown, so it is left unset
+			point.Value = &metricspb.NumberDataPoint_AsDouble{AsDouble: load.MetricCumulative(sample.set, key)}
+			m.Data = &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+				DataPoints:             []*metricspb.NumberDataPoint{point},
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+				IsMonotonic:            metricType == "PRATE",
+			}}
+		default:
+			m.Data = &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: []*metricspb.NumberDataPoint{point}}}
+		}
+		metrics.add(resource, eventType, m)
+	}
+}
+
+// addMetricAPIMetrics adds the metrics of a metric_api payload, gauges stay gauges, counts are delta sums over their interval and summaries are summaries
+func (o otlpOutput) addMetricAPIMetrics(metrics *otlpMetrics, payload load.Metrics) {
+	resource := o.resource(nil, payload.CommonAttributes)
+	common := keyValues(o.attributes(payload.CommonAttributes))
+	timestamp := uint64(payload.TimestampMs) * uint64(time.Millisecond)
+
+	for _, stored := range payload.Metrics {
+		name, _ := stored["name"].(string)
+		if name == "" {
+			continue
+		}
+		attributes := common
+		if extra, ok := stored["attributes"].(map[string]interface{}); ok && len(extra) > 0 {
+			attributes = append(append([]*commonpb.KeyValue{}, common...), keyValues(extra)...)
+		}
+		interval, _ := numericValue(stored["interval.ms"])
+		if interval == 0 {
+			interval = float64(payload.IntervalMs)
+		}
+		start := timestamp - uint64(interval)*uint64(time.Millisecond)
+
+		m := &metricspb.Metric{Name: name}
+		switch stored["type"] {
+		case "count":
+			value, ok := numericValue(stored["value"])
+			if !ok {
+				continue
+			}
+			m.Data = &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+				DataPoints: []*metricspb.NumberDataPoint{{
+					Attributes:        attributes,
+					StartTimeUnixNano: start,
+					TimeUnixNano:      timestamp,
+					Value:             &metricspb.NumberDataPoint_AsDouble{AsDouble: value},
+				}},
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+				IsMonotonic:            true,
+			}}
+		case "summary":
+			summary, ok := stored["value"].(map[string]float64)
+			if !ok {
+				continue
+			}
+			m.Data = &metricspb.Metric_Summary{Summary: &metricspb.Summary{
+				DataPoints: []*metricspb.SummaryDataPoint{{
+					Attributes:        attributes,
+					StartTimeUnixNano: start,
+					TimeUnixNano:      timestamp,
+					Count:             uint64(summary["count"]),
+					Sum:               summary["sum"],
+					QuantileValues: []*metricspb.SummaryDataPoint_ValueAtQuantile{
+						{Quantile: 0, Value: summary["min"]},
+						{Quantile: 1, Value: summary["max"]},
+					},
+				}},
+			}}
+		default:
+			value, ok := numericValue(stored["value"])
+			if !ok {
+				continue
+			}
+			m.Data = &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+				DataPoints: []*metricspb.NumberDataPoint{{
+					Attributes:   attributes,
+					TimeUnixNano: timestamp,
+					Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: value},
+				}},
+			}}
+		}
+		metrics.add(resource, load.IntegrationName, m)
+	}
+}
+
+// addLogRecord adds a sample as a log record, its message attribute becomes the body
+func (o otlpOutput) addLogRecord(logs *otlpLogs, sample otlpSample, eventType string, now uint64) {
+	resource := o.resource(sample.entity, sample.set.Metrics)
+	attributes := o.attributes(sample.set.Metrics)
+	record := &logspb.LogRecord{TimeUnixNano: now, ObservedTimeUnixNano: now}
+	if message, ok := attributes["message"].(string); ok {
+		record.Body = anyValue(message)
+		delete(attributes, "message")
+	}
+	record.Attributes = keyValues(attributes)
+	logs.add(resource, eventType, record)
+}
+
+// split separates the numeric values of a sample from the attributes of its data points, and returns its resource
+func (o otlpOutput) split(entity *integration.Entity, sample map[string]interface{}) (resource []*commonpb.KeyValue, attributes []*commonpb.KeyValue, values map[string]float64) {
+	values = map[string]float64{}
+	others := o.attributes(sample)
+	for k, v := range others {
+		if value, ok := numericValue(v); ok {
+			values[k] = value
+			delete(others, k)
+		}
+	}
+	return o.resource(entity, sample), keyValues(others), values
+}
+
+// attributes returns the attributes of a sample that are not set on the resource, or the scope
+func (o otlpOutput) attributes(sample map[string]interface{}) map[string]interface{} {
+	attributes := map[string]interface{}{}
+	for k, v := range sample {
+		if k != "event_type" && !o.resourceAttributes[k] {
+			attributes[k] = v
+		}
+	}
+	return attributes
+}
+
+// resource describes where samples come from, Flex and its entity plus the resource_attributes of the sample
+func (o otlpOutput) resource(entity *integration.Entity, sample map[string]interface{}) []*commonpb.KeyValue {
+	attributes := map[string]interface{}{
+		"service.name":    load.IntegrationNameShort,
+		"service.version": load.IntegrationVersion,
+	}
+	if entity != nil && entity.Metadata != nil {
+		attributes["entity.name"] = entity.Metadata.Name
+		attributes["entity.type"] = entity.Metadata.Namespace
+	} else if load.Hostname != "" {
+		attributes["host.name"] = load.Hostname
+	}
+	for k := range o.resourceAttributes {
+		if v, ok := sample[k]; ok {
+			attributes[k] = v
+		}
+	}
+	return keyValues(attributes)
+}
+
+// export posts a message to the collector, gzip compressed
+func (o otlpOutput) export(path string, message proto.Message) error {
+	if message == nil {
+		return nil
+	}
+	b, err := proto.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("%s: failed to encode %s, %v", o.name, path, err)
+	}
+
+	var compressed bytes.Buffer
+	w := gzip.NewWriter(&compressed)
+	if _, err := w.Write(b); err != nil {
+		return fmt.Errorf("%s: failed to compress payload, %v", o.name, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("%s: failed to close gzip writer, %v", o.name, err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, o.url+path, &compressed)
+	if err != nil {
+		return fmt.Errorf("%s: unable to create http.Request, %v", o.name, err)
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "gzip")
+	for k, v := range o.headers {
+		req.Header.Set(k, v)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second, Transport: &http.Transport{IdleConnTimeout: 15 * time.Second, Proxy: http.ProxyFromEnvironment}}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: failed to send %s, %v", o.name, path, err)
+	}
+	defer func() {
+		_, _ = ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode > 299 || resp.StatusCode < 200 {
+		return fmt.Errorf("%s: post to %s failed, status code: %d", o.name, path, resp.StatusCode)
+	}
+	return nil
+}
+
+// otlpMetrics groups metrics by resource, then by scope, merging the data points of metrics of the same name and kind
+type otlpMetrics struct {
+	resources []*metricspb.ResourceMetrics
+	byKey     map[string]*metricspb.ResourceMetrics
+	scopes    map[string]*metricspb.ScopeMetrics
+	metrics   map[string]*metricspb.Metric
+}
+
+func newOTLPMetrics() *otlpMetrics {
+	return &otlpMetrics{
+		byKey:   map[string]*metricspb.ResourceMetrics{},
+		scopes:  map[string]*metricspb.ScopeMetrics{},
+		metrics: map[string]*metricspb.Metric{},
+	}
+}
+
+func (m *otlpMetrics) add(resource []*commonpb.KeyValue, scope string, metric *metricspb.Metric) {
+	resourceKey := attributesKey(resource)
+	rm, ok := m.byKey[resourceKey]
+	if !ok {
+		rm = &metricspb.ResourceMetrics{Resource: &resourcepb.Resource{Attributes: resource}}
+		m.byKey[resourceKey] = rm
+		m.resources = append(m.resources, rm)
+	}
+
+	scopeKey := resourceKey + "\x00" + scope
+	sm, ok := m.scopes[scopeKey]
+	if !ok {
+		sm = &metricspb.ScopeMetrics{Scope: &commonpb.InstrumentationScope{Name: scope, Version: load.IntegrationVersion}}
+		m.scopes[scopeKey] = sm
+		rm.ScopeMetrics = append(rm.ScopeMetrics, sm)
+	}
+
+	metricKey := fmt.Sprintf("%s\x00%s\x00%T", scopeKey, metric.Name, metric.Data)
+	existing, ok := m.metrics[metricKey]
+	if !ok {
+		m.metrics[metricKey] = metric
+		sm.Metrics = append(sm.Metrics, metric)
+		return
+	}
+	switch data := existing.Data.(type) {
+	case *metricspb.Metric_Gauge:
+		data.Gauge.DataPoints = append(data.Gauge.DataPoints, metric.GetGauge().DataPoints...)
+	case *metricspb.Metric_Sum:
+		if data.Sum.IsMonotonic != metric.GetSum().IsMonotonic {
+			sm.Metrics = append(sm.Metrics, metric)
+			return
+		}
+		data.Sum.DataPoints = append(data.Sum.DataPoints, metric.GetSum().DataPoints...)
+	case *metricspb.Metric_Summary:
+		data.Summary.DataPoints = append(data.Summary.DataPoints, metric.GetSummary().DataPoints...)
+	}
+}
+
+// data returns the metrics to export, nil when there are none
+func (m *otlpMetrics) data() proto.Message {
+	if len(m.resources) == 0 {
+		return nil
+	}
+	return &metricspb.MetricsData{ResourceMetrics: m.resources}
+}
+
+// otlpLogs groups log records by resource, then by scope
+type otlpLogs struct {
+	resources []*logspb.ResourceLogs
+	byKey     map[string]*logspb.ResourceLogs
+	scopes    map[string]*logspb.ScopeLogs
+}
+
+func newOTLPLogs() *otlpLogs {
+	return &otlpLogs{byKey: map[string]*logspb.ResourceLogs{}, scopes: map[string]*logspb.ScopeLogs{}}
+}
+
+func (l *otlpLogs) add(resource []*commonpb.KeyValue, scope string, record *logspb.LogRecord) {
+	resourceKey := attributesKey(resource)
+	rl, ok := l.byKey[resourceKey]
+	if !ok {
+		rl = &logspb.ResourceLogs{Resource: &resourcepb.Resource{Attributes: resource}}
+		l.byKey[resourceKey] = rl
+		l.resources = append(l.resources, rl)
+	}
+
+	scopeKey := resourceKey + "\x00" + scope
+	sl, ok := l.scopes[scopeKey]
+	if !ok {
+		sl = &logspb.ScopeLogs{Scope: &commonpb.InstrumentationScope{Name: scope, Version: load.IntegrationVersion}}
+		l.scopes[scopeKey] = sl
+		rl.ScopeLogs = append(rl.ScopeLogs, sl)
+	}
+	sl.LogRecords = append(sl.LogRecords, record)
+}
+
+// data returns the log records to export, nil when there are none
+func (l *otlpLogs) data() proto.Message {
+	if len(l.resources) == 0 {
+		return nil
+	}
+	return &logspb.LogsData{ResourceLogs: l.resources}
+}
+
+// keyValues converts attributes to OTLP attributes, sorted by key
+func keyValues(attributes map[string]interface{}) []*commonpb.KeyValue {
+	var kvs []*commonpb.KeyValue
+	for _, k := range sortedKeys(attributes) {
+		kvs = append(kvs, &commonpb.KeyValue{Key: k, Value: anyValue(attributes[k])})
+	}
+	return kvs
+}
+
+func anyValue(v interface{}) *commonpb.AnyValue {
+	switch value := v.(type) {
+	case string:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}
+	case bool:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: value}}
+	case int:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: int64(value)}}
+	case int64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: value}}
+	}
+	if value, ok := numericValue(v); ok {
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: value}}
+	}
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: fmt.Sprint(v)}}
+}
+
+// numericValue returns v as a float64 if it is a number
+func numericValue(v interface{}) (float64, bool) {
+	switch value := v.(type) {
+	case float64:
+		return value, true
+	case float32:
+		return float64(value), true
+	case int:
+		return float64(value), true
+	case int32:
+		return float64(value), true
+	case int64:
+		return float64(value), true
+	case uint:
+		return float64(value), true
+	case uint32:
+		return float64(value), true
+	case uint64:
+		return float64(value), true
+	}
+	return 0, false
+}
+
+func attributesKey(attributes []*commonpb.KeyValue) string {
+	var key strings.Builder
+	for _, kv := range attributes {
+		key.WriteString(kv.Key)
+		key.WriteByte('=')
+		key.WriteString(fmt.Sprint(kv.Value.GetValue()))
+		key.WriteByte('\x00')
+	}
+	return key.String()
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,228 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package outputs
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/data/attribute"
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/nri-flex/internal/load"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func attributeMap(kvs []*commonpb.KeyValue) map[string]interface{} {
+	attributes := map[string]interface{}{}
+	for _, kv := range kvs {
+		switch v := kv.Value.Value.(type) {
+		case *commonpb.AnyValue_StringValue:
+			attributes[kv.Key] = v.StringValue
+		case *commonpb.AnyValue_DoubleValue:
+			attributes[kv.Key] = v.DoubleValue
+		default:
+			attributes[kv.Key] = kv.Value.String()
+		}
+	}
+	return attributes
+}
+
+func TestOTLPOutput(t *testing.T) {
+	load.Refresh()
+	defer load.MetricTypesReset()
+	defer load.MetricsStoreEmpty()
+	i, err := integration.New(load.IntegrationName, load.IntegrationVersion)
+	require.NoError(t, err)
+	load.Integration = i
+	load.Entity, _ = i.Entity("TestOTLPOutput", "nri-flex")
+
+	redis := load.Entity.NewMetricSet("redisSample", attribute.Attr("namespace", "redis-1"))
+	require.NoError(t, redis.SetMetric("connectedClients", 5, metric.GAUGE))
+	require.NoError(t, redis.SetMetric("cluster", "east", metric.ATTRIBUTE))
+	require.NoError(t, redis.SetMetric("role", "master", metric.ATTRIBUTE))
+	// deltas need a previous value, the processor records the type once one is computed
+	redis.Metrics["commandsProcessed"] = float64(30)
+	load.MetricTypeSet(redis, "commandsProcessed", "PDELTA")
+	// rates are sent as the counter they were computed from
+	redis.Metrics["opsPerSec"] = float64(2.5)
+	load.MetricTypeSet(redis, "opsPerSec", "PRATE")
+	load.MetricCumulativeSet(redis, "opsPerSec", 1500)
+	redis.Metrics["queueRate"] = float64(-1)
+	load.MetricTypeSet(redis, "queueRate", "RATE")
+	load.MetricCumulativeSet(redis, "queueRate", 40)
+
+	syslog := load.Entity.NewMetricSet("syslogSample")
+	require.NoError(t, syslog.SetMetric("message", "disk full", metric.ATTRIBUTE))
+	require.NoError(t, syslog.SetMetric("cluster", "east", metric.ATTRIBUTE))
+	require.NoError(t, syslog.SetMetric("pid", 42, metric.GAUGE))
+
+	load.MetricsStoreEmpty()
+	load.MetricsStoreAppend(load.Metrics{
+		TimestampMs:      1000,
+		CommonAttributes: map[string]interface{}{"cluster": "east", "host": "db-1"},
+		Metrics: []map[string]interface{}{
+			{"name": "queries", "type": "count", "value": float64(12), "interval.ms": int64(500)},
+			{"name": "latency", "type": "summary", "value": map[string]float64{"min": 1, "max": 9, "sum": 20, "count": 4}, "interval.ms": float64(500)},
+			{"name": "connections", "type": "gauge", "value": float64(7)},
+		},
+	})
+
+	var lock sync.Mutex
+	var metrics []*metricspb.MetricsData
+	var logs []*logspb.LogsData
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		assert.Equal(t, "secret", r.Header.Get("api-key"))
+		reader, err := gzip.NewReader(r.Body)
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+
+		lock.Lock()
+		defer lock.Unlock()
+		switch r.URL.Path {
+		case "/v1/metrics":
+			data := &metricspb.MetricsData{}
+			require.NoError(t, proto.Unmarshal(b, data))
+			metrics = append(metrics, data)
+		case "/v1/logs":
+			data := &logspb.LogsData{}
+			require.NoError(t, proto.Unmarshal(b, data))
+			logs = append(logs, data)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	outputs, err := New([]load.OutputConfig{{
+		Type:               "otlp",
+		URL:                ts.URL + "/",
+		Headers:            map[string]string{"api-key": "secret"},
+		ResourceAttributes: []string{"cluster"},
+		LogEventTypes:      []string{"syslogSample"},
+	}})
+	require.NoError(t, err)
+	require.NoError(t, outputs[0].Send())
+
+	require.Len(t, metrics, 2)
+	require.Len(t, logs, 1)
+
+	// samples, the event type is the scope and resource_attributes go on the resource
+	require.Len(t, metrics[0].ResourceMetrics, 1)
+	resource := attributeMap(metrics[0].ResourceMetrics[0].Resource.Attributes)
+	assert.Equal(t, "east", resource["cluster"])
+	assert.Equal(t, "nri-flex", resource["service.name"])
+	assert.Equal(t, "TestOTLPOutput", resource["entity.name"])
+	scope := metrics[0].ResourceMetrics[0].ScopeMetrics[0]
+	assert.Equal(t, "redisSample", scope.Scope.Name)
+	require.Len(t, scope.Metrics, 4)
+
+	commands := scope.Metrics[0]
+	assert.Equal(t, "commandsProcessed", commands.Name)
+	require.NotNil(t, commands.GetSum())
+	assert.Equal(t, metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA, commands.GetSum().AggregationTemporality)
+	assert.True(t, commands.GetSum().IsMonotonic)
+	assert.Equal(t, float64(30), commands.GetSum().DataPoints[0].GetAsDouble())
+
+	ops := scope.Metrics[2]
+	assert.Equal(t, "opsPerSec", ops.Name)
+	require.NotNil(t, ops.GetSum())
+	assert.Equal(t, metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE, ops.GetSum().AggregationTemporality)
+	assert.True(t, ops.GetSum().IsMonotonic)
+	assert.Equal(t, float64(1500), ops.GetSum().DataPoints[0].GetAsDouble())
+
+	queue := scope.Metrics[3]
+	assert.Equal(t, "queueRate", queue.Name)
+	require.NotNil(t, queue.GetSum())
+	assert.Equal(t, metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE, queue.GetSum().AggregationTemporality)
+	assert.False(t, queue.GetSum().IsMonotonic)
+	assert.Equal(t, float64(40), queue.GetSum().DataPoints[0].GetAsDouble())
+
+	clients := scope.Metrics[1]
+	assert.Equal(t, "connectedClients", clients.Name)
+	require.NotNil(t, clients.GetGauge())
+	point := attributeMap(clients.GetGauge().DataPoints[0].Attributes)
+	assert.Equal(t, "master", point["role"])
+	assert.Equal(t, "redis-1", point["namespace"])
+	assert.NotContains(t, point, "cluster")
+	assert.NotContains(t, point, "event_type")
+
+	// metric api metrics keep their type
+	apiMetrics := metrics[1].ResourceMetrics[0].ScopeMetrics[0]
+	assert.Equal(t, load.IntegrationName, apiMetrics.Scope.Name)
+	require.Len(t, apiMetrics.Metrics, 3)
+	queries := apiMetrics.Metrics[0].GetSum()
+	require.NotNil(t, queries)
+	assert.Equal(t, uint64(1000e6), queries.DataPoints[0].TimeUnixNano)
+	assert.Equal(t, uint64(500e6), queries.DataPoints[0].StartTimeUnixNano)
+	assert.Equal(t, "db-1", attributeMap(queries.DataPoints[0].Attributes)["host"])
+	latency := apiMetrics.Metrics[1].GetSummary()
+	require.NotNil(t, latency)
+	assert.Equal(t, uint64(4), latency.DataPoints[0].Count)
+	assert.Equal(t, float64(20), latency.DataPoints[0].Sum)
+	assert.Equal(t, float64(9), latency.DataPoints[0].QuantileValues[1].Value)
+	assert.NotNil(t, apiMetrics.Metrics[2].GetGauge())
+
+	// samples of log_event_types are log records
+	scopeLogs := logs[0].ResourceLogs[0].ScopeLogs[0]
+	assert.Equal(t, "syslogSample", scopeLogs.Scope.Name)
+	require.Len(t, scopeLogs.LogRecords, 1)
+	record := scopeLogs.LogRecords[0]
+	assert.Equal(t, "disk full", record.Body.GetStringValue())
+	assert.Equal(t, float64(42), attributeMap(record.Attributes)["pid"])
+
+	// other outputs send the same samples
+	assert.Equal(t, "redisSample", redis.Metrics["event_type"])
+}
+
+func TestOTLPOutputBatches(t *testing.T) {
+	load.Refresh()
+	load.MetricsStoreEmpty()
+	i, err := integration.New(load.IntegrationName, load.IntegrationVersion)
+	require.NoError(t, err)
+	load.Integration = i
+	load.Entity, _ = i.Entity("TestOTLPOutputBatches", "nri-flex")
+	for _, name := range []string{"a", "b", "c"} {
+		set := load.Entity.NewMetricSet("testSample", attribute.Attr("name", name))
+		require.NoError(t, set.SetMetric("value", 1, metric.GAUGE))
+	}
+
+	var lock sync.Mutex
+	var points []int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reader, err := gzip.NewReader(r.Body)
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+		data := &metricspb.MetricsData{}
+		require.NoError(t, proto.Unmarshal(b, data))
+		lock.Lock()
+		points = append(points, len(data.ResourceMetrics[0].ScopeMetrics[0].Metrics[0].GetGauge().DataPoints))
+		lock.Unlock()
+		if len(points) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	outputs, err := New([]load.OutputConfig{{Type: "otlp", URL: ts.URL, BatchSize: 2}})
+	require.NoError(t, err)
+	err = outputs[0].Send()
+	assert.EqualError(t, err, "otlp: post to /v1/metrics failed, status code: 503")
+	// data points of the same metric are merged, a failed batch does not stop the next
+	assert.Equal(t, []int{2, 1}, points)
+}
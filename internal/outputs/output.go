@@ -0,0 +1,219 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package outputs
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/newrelic/nri-flex/internal/load"
+	"github.com/sirupsen/logrus"
+)
+
+// Output types that can be listed under outputs
+const (
+	OutputStdout    = "stdout"
+	OutputInsights  = "insights"
+	OutputLogAPI    = "log_api"
+	OutputMetricAPI = "metric_api"
+	OutputNDJSON    = "ndjson"
+	OutputOTLP      = "otlp"
+	OutputStatsD    = "statsd"
+	OutputDogStatsD = "dogstatsd"
+
+	OutputPrometheusRemoteWrite = "prometheus_remote_write"
+)
+
+// Output is a destination the results of a run are sent to
+type Output interface {
+	// Name identifies the output in logs
+	Name() string
+	// Send delivers the samples and metrics collected so far
+	// other outputs send the same results, so it must not modify them
+	Send() error
+}
+
+// builders create each type of output from its entry under outputs
+var builders = map[string]func(cfg load.OutputConfig) (Output, error){
+	OutputStdout:    newStdoutOutput,
+	OutputInsights:  newInsightsOutput,
+	OutputLogAPI:    newLogAPIOutput,
+	OutputMetricAPI: newMetricAPIOutput,
+	OutputNDJSON:    newNDJSONOutput,
+	OutputOTLP:      newOTLPOutput,
+	OutputStatsD:    newStatsDOutput,
+	OutputDogStatsD: newDogStatsDOutput,
+
+	OutputPrometheusRemoteWrite: newRemoteWriteOutput,
+}
+
+// New creates the outputs listed under outputs, in the order they are listed
+func New(configs []load.OutputConfig) ([]Output, error) {
+	var outputs []Output
+	names := map[string]bool{}
+	stdout := false
+	for i, cfg := range configs {
+		build, ok := builders[cfg.Type]
+		if !ok {
+			return nil, fmt.Errorf("outputs[%d]: unknown type %q", i, cfg.Type)
+		}
+		if cfg.Name == "" {
+			cfg.Name = cfg.Type
+		}
+		if names[cfg.Name] {
+			return nil, fmt.Errorf("outputs[%d]: duplicate output %s, set a name to tell them apart", i, cfg.Name)
+		}
+		names[cfg.Name] = true
+		// publishing empties the entities, so results can only be published once
+		if cfg.Type == OutputStdout {
+			if stdout {
+				return nil, fmt.Errorf("outputs[%d]: only one stdout output can be listed", i)
+			}
+			stdout = true
+		}
+		if cfg.BatchSize < 0 {
+			return nil, fmt.Errorf("outputs[%d]: batch_size cannot be negative", i)
+		}
+
+		output, err := build(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("outputs[%d]: %s %v", i, cfg.Type, err)
+		}
+		outputs = append(outputs, output)
+	}
+	return outputs, nil
+}
+
+// FromArgs returns the outputs set by arguments, used when there is no outputs file
+// results go to the first api output set, as they always have, then are published to stdout
+func FromArgs() []Output {
+	return []Output{argsOutput{}, stdoutOutput{name: OutputStdout}}
+}
+
+// Send sends the results to every output except stdout, which Publish sends to
+// an output failing is logged and does not stop the others
+func Send(outputs []Output) {
+	for _, output := range outputs {
+		if isStdout(output) {
+			continue
+		}
+		if err := output.Send(); err != nil {
+			load.Logrus.WithFields(logrus.Fields{
+				"output": output.Name(),
+			}).WithError(err).Error("outputs: failed to send")
+		}
+	}
+}
+
+// Publish publishes the results to stdout for the agent, if a stdout output is listed
+// it runs after Send as publishing empties the entities
+func Publish(outputs []Output) error {
+	for _, output := range outputs {
+		if isStdout(output) {
+			return output.Send()
+		}
+	}
+	// publishing saves the store, it is saved here instead so deltas are kept for the next execution
+	if load.Storer != nil {
+		return load.Storer.Save()
+	}
+	return nil
+}
+
+func isStdout(output Output) bool {
+	_, ok := output.(stdoutOutput)
+	return ok
+}
+
+// stdoutOutput publishes the integration payload for the infrastructure agent
+type stdoutOutput struct {
+	name string
+}
+
+func newStdoutOutput(cfg load.OutputConfig) (Output, error) {
+	return stdoutOutput{name: cfg.Name}, nil
+}
+
+func (o stdoutOutput) Name() string { return o.name }
+func (o stdoutOutput) Send() error  { return load.Integration.Publish() }
+
+// argsOutput sends to insights, the log api or the metric api as set by arguments
+// it sends to the first of them set and moves the samples out of the entities, so they are not published as well
+type argsOutput struct{}
+
+func (argsOutput) Name() string { return "arguments" }
+
+func (argsOutput) keyFor(url string) (string, bool) {
+	switch url {
+	case "":
+		return "", false
+	case load.Args.InsightsURL:
+		return load.Args.InsightsAPIKey, true
+	case load.Args.LogApiURL:
+		return load.Args.LogApiKey, true
+	case load.Args.MetricAPIUrl:
+		return metricAPIKey(), true
+	}
+	return "", false
+}
+
+func (argsOutput) Send() error {
+	var errs []error
+	if load.Args.InsightsURL != "" && load.Args.InsightsAPIKey != "" {
+		for _, batch := range GetMetricBatches() {
+			if err := SendBatchToInsights(batch); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	} else if load.Args.LogApiURL != "" && load.Args.LogApiKey != "" {
+		for _, batch := range GetLogMetricBatches() {
+			if err := SendBatchToLogApi(batch); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	} else if load.Args.MetricAPIUrl != "" && (load.Args.InsightsAPIKey != "" || load.Args.MetricAPIKey != "") && len(load.MetricsStore.Data) > 0 {
+		if err := SendToMetricAPI(); err != nil {
+			errs = append(errs, err)
+		}
+	} else if len(load.MetricsStore.Data) > 0 && (load.Args.MetricAPIUrl == "" || (load.Args.InsightsAPIKey == "" || load.Args.MetricAPIKey == "")) {
+		load.Logrus.Debug("outputs: metric_api is being used, but metric url and/or key has not been set")
+	}
+	return errors.Join(errs...)
+}
+
+// samples returns a copy of the samples of every entity, with the event type moved to eventTypeKey
+func samples(eventTypeKey string) []map[string]interface{} {
+	var copied []map[string]interface{}
+	for _, entity := range load.Integration.Entities {
+		for _, set := range entity.Metrics {
+			sample := make(map[string]interface{}, len(set.Metrics))
+			for k, v := range set.Metrics {
+				sample[k] = v
+			}
+			if eventTypeKey != "event_type" {
+				if eventType, ok := sample["event_type"]; ok {
+					sample[eventTypeKey] = eventType
+					delete(sample, "event_type")
+				}
+			}
+			copied = append(copied, sample)
+		}
+	}
+	return copied
+}
+
+// batches splits samples into batches of at most size samples
+func batches(samples []map[string]interface{}, size int) [][]map[string]interface{} {
+	var result [][]map[string]interface{}
+	for start := 0; start < len(samples); start += size {
+		end := start + size
+		if end > len(samples) {
+			end = len(samples)
+		}
+		result = append(result, samples[start:end])
+	}
+	return result
+}
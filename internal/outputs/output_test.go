@@ -0,0 +1,119 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package outputs
+
+import (
+	"compress/zlib"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/data/attribute"
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/nri-flex/internal/load"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	tests := map[string]struct {
+		configs []load.OutputConfig
+		err     string
+	}{
+		"valid": {configs: []load.OutputConfig{
+			{Type: "stdout"},
+			{Type: "insights", URL: "http://localhost", APIKey: "key"},
+			{Type: "insights", Name: "migration", URL: "http://localhost", APIKey: "key"},
+			{Type: "ndjson", Path: "samples.ndjson"},
+		}},
+		"unknown type":      {configs: []load.OutputConfig{{Type: "kafka"}}, err: `outputs[0]: unknown type "kafka"`},
+		"duplicate name":    {configs: []load.OutputConfig{{Type: "ndjson", Path: "a"}, {Type: "ndjson", Path: "b"}}, err: "outputs[1]: duplicate output ndjson, set a name to tell them apart"},
+		"two stdout":        {configs: []load.OutputConfig{{Type: "stdout"}, {Type: "stdout", Name: "agent"}}, err: "outputs[1]: only one stdout output can be listed"},
+		"missing key":       {configs: []load.OutputConfig{{Type: "log_api", URL: "http://localhost"}}, err: "outputs[0]: log_api requires url and api_key"},
+		"missing path":      {configs: []load.OutputConfig{{Type: "ndjson"}}, err: "outputs[0]: ndjson requires path"},
+		"negative batching": {configs: []load.OutputConfig{{Type: "insights", BatchSize: -1}}, err: "outputs[0]: batch_size cannot be negative"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			outputs, err := New(tc.configs)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, outputs, len(tc.configs))
+			assert.Equal(t, "migration", outputs[2].Name())
+		})
+	}
+}
+
+func TestSendFanOut(t *testing.T) {
+	load.Refresh()
+	i, err := integration.New(load.IntegrationName, load.IntegrationVersion)
+	require.NoError(t, err)
+	load.Integration = i
+	load.Entity, _ = i.Entity("TestSendFanOut", "nri-flex")
+	for _, name := range []string{"a", "b", "c"} {
+		set := load.Entity.NewMetricSet("testSample", attribute.Attribute{Key: "name", Value: name})
+		require.NoError(t, set.SetMetric("value", 1, metric.GAUGE))
+	}
+
+	var lock sync.Mutex
+	var posts [][]map[string]interface{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reader, err := zlib.NewReader(r.Body)
+		require.NoError(t, err)
+		var batch []map[string]interface{}
+		require.NoError(t, json.NewDecoder(reader).Decode(&batch))
+		lock.Lock()
+		posts = append(posts, batch)
+		lock.Unlock()
+	}))
+	defer ts.Close()
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer failing.Close()
+
+	path := filepath.Join(t.TempDir(), "samples.ndjson")
+	outputs, err := New([]load.OutputConfig{
+		{Type: "insights", Name: "old account", URL: failing.URL, APIKey: "old"},
+		{Type: "insights", Name: "new account", URL: ts.URL, APIKey: "new", BatchSize: 2},
+		{Type: "ndjson", Path: path},
+	})
+	require.NoError(t, err)
+
+	Send(outputs)
+	Send(outputs)
+
+	// the failing output does not stop the others, each batches on its own
+	require.Len(t, posts, 4)
+	assert.Len(t, posts[0], 2)
+	assert.Len(t, posts[1], 1)
+	assert.Equal(t, "testSample", posts[0][0]["eventType"])
+	assert.NotContains(t, posts[0][0], "event_type")
+
+	b, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	require.Len(t, lines, 6)
+	var sample map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &sample))
+	assert.Equal(t, "testSample", sample["event_type"])
+
+	// sending leaves the samples to publish
+	require.Len(t, load.Entity.Metrics, 3)
+	assert.Equal(t, "testSample", load.Entity.Metrics[0].Metrics["event_type"])
+	assert.NoError(t, Publish(outputs), "no stdout output to publish to")
+}
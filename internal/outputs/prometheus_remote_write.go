@@ -0,0 +1,316 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package outputs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/newrelic/nri-flex/internal/load"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	remoteWriteBatchSize = 2000
+	remoteWriteRetries   = 3
+)
+
+// remoteWriteOutput sends the numeric attributes of samples as time series to a Prometheus remote write endpoint, eg. Mimir or Thanos
+type remoteWriteOutput struct {
+	name      string
+	url       string
+	headers   map[string]string
+	batchSize int
+	labels    map[string]bool // allowlist of the string attributes that become labels, nil allows all
+	retries   int
+	backoff   time.Duration // before the first retry, doubled for each retry after it
+}
+
+func newRemoteWriteOutput(cfg load.OutputConfig) (Output, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("requires url")
+	}
+	if cfg.Retries < -1 {
+		return nil, fmt.Errorf("retries must be -1 or more")
+	}
+	o := remoteWriteOutput{
+		name:      cfg.Name,
+		url:       cfg.URL,
+		headers:   cfg.Headers,
+		batchSize: cfg.BatchSize,
+		retries:   cfg.Retries,
+		backoff:   500 * time.Millisecond,
+	}
+	if o.batchSize == 0 {
+		o.batchSize = remoteWriteBatchSize
+	}
+	switch o.retries {
+	case 0:
+		o.retries = remoteWriteRetries
+	case -1:
+		o.retries = 0
+	}
+	if len(cfg.Labels) > 0 {
+		o.labels = map[string]bool{}
+		for _, label := range cfg.Labels {
+			o.labels[label] = true
+		}
+	}
+	return o, nil
+}
+
+func (o remoteWriteOutput) Name() string { return o.name }
+
+// promLabel is a label of a time series
+type promLabel struct {
+	name  string
+	value string
+}
+
+// promSeries is a time series with a single sample
+type promSeries struct {
+	labels    []promLabel // sorted by name, including __name__
+	value     float64
+	timestamp int64 // milliseconds
+}
+
+// Send writes the series of every sample, then of the metrics of metric_api apis, in batches of batch_size series
+func (o remoteWriteOutput) Send() error {
+	series := o.series(time.Now().UnixNano() / int64(time.Millisecond))
+
+	var errs []error
+	for start := 0; start < len(series); start += o.batchSize {
+		end := start + o.batchSize
+		if end > len(series) {
+			end = len(series)
+		}
+		if err := o.write(series[start:end]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// series converts samples and metric_api metrics to time series
+// a series is only sent once per request, the last sample for the same labels wins
+func (o remoteWriteOutput) series(nowMs int64) []promSeries {
+	var series []promSeries
+	index := map[string]int{}
+	add := func(s promSeries) {
+		sort.Slice(s.labels, func(i, j int) bool { return s.labels[i].name < s.labels[j].name })
+		key := seriesKey(s.labels)
+		if i, ok := index[key]; ok {
+			series[i] = s
+			return
+		}
+		index[key] = len(series)
+		series = append(series, s)
+	}
+
+	for _, sample := range samples("event_type") {
+		eventType, _ := sample["event_type"].(string)
+		labels := o.attributeLabels(sample)
+		for _, key := range sortedKeys(sample) {
+			value, ok := numericValue(sample[key])
+			if !ok {
+				continue
+			}
+			name := sanitizeMetricName(key)
+			if eventType != "" {
+				name = sanitizeMetricName(eventType + "_" + key)
+			}
+			add(promSeries{labels: withName(labels, name), value: value, timestamp: nowMs})
+		}
+	}
+
+	load.MetricsStore.RLock()
+	stored := load.MetricsStore.Data
+	load.MetricsStore.RUnlock()
+	for _, payload := range stored {
+		common := o.attributeLabels(payload.CommonAttributes)
+		for _, metric := range payload.Metrics {
+			name, _ := metric["name"].(string)
+			if name == "" {
+				continue
+			}
+			labels := common
+			if extra, ok := metric["attributes"].(map[string]interface{}); ok && len(extra) > 0 {
+				// attributes of a metric tell its series apart, eg. le and quantile, so they are always labels
+				labels = mergeLabels(common, extra)
+			}
+			if summary, ok := metric["value"].(map[string]float64); ok {
+				add(promSeries{labels: withName(labels, sanitizeMetricName(name+"_sum")), value: summary["sum"], timestamp: payload.TimestampMs})
+				add(promSeries{labels: withName(labels, sanitizeMetricName(name+"_count")), value: summary["count"], timestamp: payload.TimestampMs})
+				add(promSeries{labels: withName(mergeLabels(labels, map[string]interface{}{"quantile": "0"}), sanitizeMetricName(name)), value: summary["min"], timestamp: payload.TimestampMs})
+				add(promSeries{labels: withName(mergeLabels(labels, map[string]interface{}{"quantile": "1"}), sanitizeMetricName(name)), value: summary["max"], timestamp: payload.TimestampMs})
+				continue
+			}
+			if value, ok := numericValue(metric["value"]); ok {
+				add(promSeries{labels: withName(labels, sanitizeMetricName(name)), value: value, timestamp: payload.TimestampMs})
+			}
+		}
+	}
+	return series
+}
+
+// attributeLabels returns the string attributes of a sample allowed as labels
+func (o remoteWriteOutput) attributeLabels(sample map[string]interface{}) []promLabel {
+	var labels []promLabel
+	for _, key := range sortedKeys(sample) {
+		value, ok := sample[key].(string)
+		if !ok || key == "event_type" || (o.labels != nil && !o.labels[key]) {
+			continue
+		}
+		labels = append(labels, promLabel{name: sanitizeLabelName(key), value: value})
+	}
+	return labels
+}
+
+func mergeLabels(labels []promLabel, attributes map[string]interface{}) []promLabel {
+	merged := append([]promLabel{}, labels...)
+	for _, key := range sortedKeys(attributes) {
+		merged = append(merged, promLabel{name: sanitizeLabelName(key), value: fmt.Sprint(attributes[key])})
+	}
+	return merged
+}
+
+// withName returns a copy of labels with the metric name, the last label of the same name wins
+func withName(labels []promLabel, name string) []promLabel {
+	byName := map[string]string{}
+	for _, label := range labels {
+		byName[label.name] = label.value
+	}
+	byName["__name__"] = name
+	result := make([]promLabel, 0, len(byName))
+	for labelName, value := range byName {
+		result = append(result, promLabel{name: labelName, value: value})
+	}
+	return result
+}
+
+func seriesKey(labels []promLabel) string {
+	var key strings.Builder
+	for _, label := range labels {
+		key.WriteString(label.name)
+		key.WriteByte('=')
+		key.WriteString(label.value)
+		key.WriteByte('\x00')
+	}
+	return key.String()
+}
+
+// sanitizeMetricName makes a Flex key a valid metric name, eg. leaderInfo.abc.def becomes leaderInfo_abc_def
+func sanitizeMetricName(name string) string {
+	return sanitizeName(name, true)
+}
+
+// sanitizeLabelName makes a Flex key a valid label name, names starting with __ are reserved by Prometheus
+func sanitizeLabelName(name string) string {
+	name = sanitizeName(name, false)
+	if strings.HasPrefix(name, "__") {
+		name = "_" + strings.TrimLeft(name, "_")
+	}
+	return name
+}
+
+func sanitizeName(name string, allowColons bool) string {
+	var sanitized strings.Builder
+	for i, r := range name {
+		valid := r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (allowColons && r == ':') || (i > 0 && r >= '0' && r <= '9')
+		if valid {
+			sanitized.WriteRune(r)
+			continue
+		}
+		if i == 0 && r >= '0' && r <= '9' {
+			sanitized.WriteRune('_')
+			sanitized.WriteRune(r)
+			continue
+		}
+		sanitized.WriteRune('_')
+	}
+	if sanitized.Len() == 0 {
+		return "_"
+	}
+	return sanitized.String()
+}
+
+// write sends a batch, retrying server errors and 429s with exponential backoff as the remote write spec recommends
+func (o remoteWriteOutput) write(series []promSeries) error {
+	body := snappy.Encode(nil, encodeWriteRequest(series))
+	_, err := withRetries(o.name, o.retries, o.backoff, func() (time.Duration, error) {
+		return o.post(body)
+	})
+	return err
+}
+
+// post sends a snappy compressed write request once
+// the returned wait is negative when the request must not be retried, and set when the server asked to retry after it
+func (o remoteWriteOutput) post(body []byte) (time.Duration, error) {
+	req, err := http.NewRequest(http.MethodPost, o.url, bytes.NewReader(body))
+	if err != nil {
+		return -1, fmt.Errorf("%s: unable to create http.Request, %v", o.name, err)
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set("User-Agent", load.IntegrationNameShort+"/"+load.IntegrationVersion)
+	for k, v := range o.headers {
+		req.Header.Set(k, v)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second, Transport: &http.Transport{IdleConnTimeout: 15 * time.Second, Proxy: http.ProxyFromEnvironment}}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("%s: failed to send, %v", o.name, err)
+	}
+	defer func() {
+		_, _ = ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+	}()
+
+	return retryWait(resp), statusError(o.name, resp)
+}
+
+// encodeWriteRequest encodes a prometheus.WriteRequest, the schema is small enough to not need generated code
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(series []promSeries) []byte {
+	var request []byte
+	for _, s := range series {
+		var ts []byte
+		for _, label := range s.labels {
+			var l []byte
+			l = protowire.AppendTag(l, 1, protowire.BytesType)
+			l = protowire.AppendString(l, label.name)
+			l = protowire.AppendTag(l, 2, protowire.BytesType)
+			l = protowire.AppendString(l, label.value)
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, l)
+		}
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(s.timestamp))
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sample)
+
+		request = protowire.AppendTag(request, 1, protowire.BytesType)
+		request = protowire.AppendBytes(request, ts)
+	}
+	return request
+}
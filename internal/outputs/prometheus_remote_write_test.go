@@ -0,0 +1,215 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package outputs
+
+import (
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/newrelic/infra-integrations-sdk/data/attribute"
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/nri-flex/internal/load"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// decodedSeries is a time series read back from a write request
+type decodedSeries struct {
+	labels    map[string]string
+	value     float64
+	timestamp int64
+}
+
+// eachField calls fn with every field of a protobuf message
+func eachField(t *testing.T, b []byte, fn func(num protowire.Number, typ protowire.Type, field []byte)) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		require.True(t, n > 0)
+		b = b[n:]
+		m := protowire.ConsumeFieldValue(num, typ, b)
+		require.True(t, m > 0)
+		fn(num, typ, b[:m])
+		b = b[m:]
+	}
+}
+
+func decodeWriteRequest(t *testing.T, b []byte) []decodedSeries {
+	var series []decodedSeries
+	eachField(t, b, func(_ protowire.Number, _ protowire.Type, field []byte) {
+		ts, _ := protowire.ConsumeBytes(field)
+		s := decodedSeries{labels: map[string]string{}}
+		eachField(t, ts, func(num protowire.Number, _ protowire.Type, field []byte) {
+			message, _ := protowire.ConsumeBytes(field)
+			if num == 1 {
+				var name, value string
+				eachField(t, message, func(num protowire.Number, _ protowire.Type, field []byte) {
+					str, _ := protowire.ConsumeString(field)
+					if num == 1 {
+						name = str
+					} else {
+						value = str
+					}
+				})
+				s.labels[name] = value
+				return
+			}
+			eachField(t, message, func(num protowire.Number, _ protowire.Type, field []byte) {
+				if num == 1 {
+					bits, _ := protowire.ConsumeFixed64(field)
+					s.value = math.Float64frombits(bits)
+				} else {
+					v, _ := protowire.ConsumeVarint(field)
+					s.timestamp = int64(v)
+				}
+			})
+		})
+		series = append(series, s)
+	})
+	return series
+}
+
+func TestSanitizeNames(t *testing.T) {
+	tests := map[string]struct {
+		metric string
+		label  string
+	}{
+		"leaderInfo.abc.def": {"leaderInfo_abc_def", "leaderInfo_abc_def"},
+		"redis:cpu-used":     {"redis:cpu_used", "redis_cpu_used"},
+		"5xxCount":           {"_5xxCount", "_5xxCount"},
+		"__internal":         {"__internal", "_internal"},
+		"":                   {"_", "_"},
+	}
+	for key, tc := range tests {
+		assert.Equal(t, tc.metric, sanitizeMetricName(key), key)
+		assert.Equal(t, tc.label, sanitizeLabelName(key), key)
+	}
+}
+
+func TestRemoteWriteOutput(t *testing.T) {
+	load.Refresh()
+	defer load.MetricsStoreEmpty()
+	i, err := integration.New(load.IntegrationName, load.IntegrationVersion)
+	require.NoError(t, err)
+	load.Integration = i
+	load.Entity, _ = i.Entity("TestRemoteWriteOutput", "nri-flex")
+
+	etcd := load.Entity.NewMetricSet("etcdSample", attribute.Attr("name", "etcd-1"))
+	require.NoError(t, etcd.SetMetric("leaderInfo.abc.def", 3, metric.GAUGE))
+	require.NoError(t, etcd.SetMetric("state", "leader", metric.ATTRIBUTE))
+	require.NoError(t, etcd.SetMetric("requestId", "d41d8cd9", metric.ATTRIBUTE))
+
+	load.MetricsStoreEmpty()
+	load.MetricsStoreAppend(load.Metrics{
+		TimestampMs:      1000,
+		CommonAttributes: map[string]interface{}{"state": "leader"},
+		Metrics: []map[string]interface{}{
+			{"name": "http_requests_bucket", "type": "gauge", "value": uint64(4), "attributes": map[string]interface{}{"le": 0.5}},
+			{"name": "latency", "type": "summary", "value": map[string]float64{"min": 1, "max": 9, "sum": 20, "count": 4}},
+		},
+	})
+
+	var lock sync.Mutex
+	var requests [][]decodedSeries
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "snappy", r.Header.Get("Content-Encoding"))
+		assert.Equal(t, "0.1.0", r.Header.Get("X-Prometheus-Remote-Write-Version"))
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		compressed, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		b, err := snappy.Decode(nil, compressed)
+		require.NoError(t, err)
+
+		lock.Lock()
+		defer lock.Unlock()
+		requests = append(requests, decodeWriteRequest(t, b))
+		if len(requests) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	outputs, err := New([]load.OutputConfig{{
+		Type:    "prometheus_remote_write",
+		URL:     ts.URL,
+		Headers: map[string]string{"Authorization": "Bearer token"},
+		Labels:  []string{"name", "state"},
+	}})
+	require.NoError(t, err)
+	output := outputs[0].(remoteWriteOutput)
+	output.backoff = time.Millisecond
+	require.NoError(t, output.Send())
+
+	// the first attempt failed and was retried
+	require.Len(t, requests, 2)
+	assert.Equal(t, requests[0], requests[1])
+
+	series := map[string]decodedSeries{}
+	for _, s := range requests[1] {
+		series[s.labels["__name__"]+s.labels["quantile"]] = s
+	}
+	require.Len(t, series, 6)
+
+	leader := series["etcdSample_leaderInfo_abc_def"]
+	assert.Equal(t, float64(3), leader.value)
+	assert.Equal(t, map[string]string{"__name__": "etcdSample_leaderInfo_abc_def", "name": "etcd-1", "state": "leader"}, leader.labels)
+
+	bucket := series["http_requests_bucket"]
+	assert.Equal(t, float64(4), bucket.value)
+	assert.Equal(t, int64(1000), bucket.timestamp)
+	assert.Equal(t, "0.5", bucket.labels["le"])
+
+	assert.Equal(t, float64(20), series["latency_sum"].value)
+	assert.Equal(t, float64(4), series["latency_count"].value)
+	assert.Equal(t, float64(1), series["latency0"].value)
+	assert.Equal(t, float64(9), series["latency1"].value)
+}
+
+func TestRemoteWriteRetries(t *testing.T) {
+	load.Refresh()
+	load.MetricsStoreEmpty()
+	i, err := integration.New(load.IntegrationName, load.IntegrationVersion)
+	require.NoError(t, err)
+	load.Integration = i
+	load.Entity, _ = i.Entity("TestRemoteWriteRetries", "nri-flex")
+	set := load.Entity.NewMetricSet("testSample")
+	require.NoError(t, set.SetMetric("value", 1, metric.GAUGE))
+
+	tests := map[string]struct {
+		status   int
+		retries  int
+		attempts int
+	}{
+		"client errors are not retried": {http.StatusBadRequest, 0, 1},
+		"server errors are retried":     {http.StatusInternalServerError, 2, 3},
+		"too many requests are retried": {http.StatusTooManyRequests, 1, 2},
+		"retries disabled":              {http.StatusInternalServerError, -1, 1},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			attempts := 0
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				w.WriteHeader(tc.status)
+			}))
+			defer ts.Close()
+
+			outputs, err := New([]load.OutputConfig{{Type: "prometheus_remote_write", URL: ts.URL, Retries: tc.retries}})
+			require.NoError(t, err)
+			output := outputs[0].(remoteWriteOutput)
+			output.backoff = time.Millisecond
+			assert.Error(t, output.Send())
+			assert.Equal(t, tc.attempts, attempts)
+		})
+	}
+}
@@ -0,0 +1,196 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package outputs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/persist"
+	"github.com/newrelic/nri-flex/internal/load"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultSpoolMaxAge is used when spool_max_age is not a valid duration
+const defaultSpoolMaxAge = 24 * time.Hour
+
+// spoolLock serializes changes to the spool directory
+var spoolLock sync.Mutex
+
+// spoolSeq tells apart posts spooled within the same nanosecond
+var spoolSeq uint64
+
+// spooledPost is a post to the insights, log or metric api that failed after its retries, kept to be replayed
+// the api key is not spooled, replay posts with the key of the output that posts to the url
+type spooledPost struct {
+	URL     string `json:"url"`
+	Payload []byte `json:"payload"` // zlib compressed
+}
+
+// keyedOutput is an output that posts with an api key
+type keyedOutput interface {
+	// keyFor returns the api key the output posts to url with, if it posts to url
+	keyFor(url string) (string, bool)
+}
+
+// spoolKey returns the api key of the first output that posts to url
+func spoolKey(outputs []Output, url string) (string, bool) {
+	for _, output := range outputs {
+		if keyed, ok := output.(keyedOutput); ok {
+			if key, ok := keyed.keyFor(url); ok && key != "" {
+				return key, true
+			}
+		}
+	}
+	return "", false
+}
+
+// spoolDir is the nri-flex-spool directory under TEMP_DIR, or the integrations directory of the OS temp dir
+func spoolDir() string {
+	return filepath.Join(filepath.Dir(persist.TmpPath(os.Getenv("TEMP_DIR"), load.IntegrationName)), "nri-flex-spool")
+}
+
+func spoolMaxAge() time.Duration {
+	maxAge, err := time.ParseDuration(load.Args.SpoolMaxAge)
+	if err != nil || maxAge <= 0 {
+		return defaultSpoolMaxAge
+	}
+	return maxAge
+}
+
+// spoolPost writes a failed post to the spool, then drops the oldest posts over spool_max_mb or spool_max_age
+func spoolPost(url string, payload []byte) error {
+	if load.Args.SpoolMaxMB <= 0 {
+		return nil
+	}
+	data, err := json.Marshal(spooledPost{URL: url, Payload: payload})
+	if err != nil {
+		return fmt.Errorf("spool: failed to marshal, %v", err)
+	}
+
+	spoolLock.Lock()
+	defer spoolLock.Unlock()
+
+	dir := spoolDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("spool: failed to create %s, %v", dir, err)
+	}
+	// the name sorts posts in the order they were spooled, the rename makes a post visible only once complete
+	name := fmt.Sprintf("%020d-%06d.json", time.Now().UnixNano(), atomic.AddUint64(&spoolSeq, 1)%1000000)
+	tmp := filepath.Join(dir, name+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("spool: failed to write %s, %v", tmp, err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("spool: failed to write %s, %v", name, err)
+	}
+	load.Logrus.WithFields(logrus.Fields{"url": url, "bytes": len(payload)}).Warn("spool: post failed, spooled for the next execution")
+
+	pruneSpool(dir, int64(load.Args.SpoolMaxMB)*1024*1024, spoolMaxAge())
+	return nil
+}
+
+// spoolFiles lists the spooled posts, oldest first
+func spoolFiles(dir string) []os.FileInfo {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var files []os.FileInfo
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			files = append(files, entry)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+	return files
+}
+
+// pruneSpool drops posts older than maxAge, then the oldest posts until the spool fits in maxBytes
+func pruneSpool(dir string, maxBytes int64, maxAge time.Duration) {
+	files := spoolFiles(dir)
+	var size int64
+	for _, file := range files {
+		size += file.Size()
+	}
+
+	dropped := 0
+	for _, file := range files {
+		if time.Since(file.ModTime()) <= maxAge && size <= maxBytes {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, file.Name())); err != nil {
+			load.Logrus.WithError(err).Errorf("spool: failed to remove %s", file.Name())
+			continue
+		}
+		size -= file.Size()
+		dropped++
+	}
+	if dropped > 0 {
+		load.Logrus.WithFields(logrus.Fields{"dropped": dropped}).Warn("spool: dropped posts over spool_max_mb or spool_max_age")
+	}
+}
+
+// ReplaySpool posts the spooled posts of previous executions, oldest first, with the api keys of outputs
+// replay stops at the first post that fails again with a temporary error, the rest are kept for the next execution
+func ReplaySpool(outputs []Output) {
+	spoolLock.Lock()
+	defer spoolLock.Unlock()
+
+	dir := spoolDir()
+	maxAge := spoolMaxAge()
+	replayed := 0
+	for _, file := range spoolFiles(dir) {
+		path := filepath.Join(dir, file.Name())
+		if time.Since(file.ModTime()) > maxAge {
+			load.Logrus.Warnf("spool: dropped %s, older than spool_max_age", file.Name())
+			_ = os.Remove(path)
+			continue
+		}
+
+		var post spooledPost
+		data, err := ioutil.ReadFile(path)
+		if err == nil {
+			err = json.Unmarshal(data, &post)
+		}
+		if err != nil {
+			load.Logrus.WithError(err).Errorf("spool: dropped unreadable %s", file.Name())
+			_ = os.Remove(path)
+			continue
+		}
+
+		key, ok := spoolKey(outputs, post.URL)
+		if !ok {
+			load.Logrus.Warnf("spool: no output posts to %s, %s kept until one does or it is older than spool_max_age", post.URL, file.Name())
+			continue
+		}
+		wait, err := postCompressed(post.URL, key, post.Payload)
+		if err != nil && wait >= 0 {
+			load.Logrus.WithError(err).Warnf("spool: replay failed, %d posts kept for the next execution", len(spoolFiles(dir)))
+			break
+		}
+		if err != nil {
+			load.Logrus.WithError(err).Errorf("spool: dropped %s, rejected by %s", file.Name(), post.URL)
+		} else {
+			replayed++
+		}
+		if err := os.Remove(path); err != nil {
+			load.Logrus.WithError(err).Errorf("spool: failed to remove %s", file.Name())
+		}
+	}
+	if replayed > 0 {
+		load.Logrus.WithFields(logrus.Fields{"replayed": replayed}).Info("spool: replayed posts of previous executions")
+	}
+}
@@ -0,0 +1,239 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package outputs
+
+import (
+	"compress/zlib"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/nri-flex/internal/load"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupSpool points the spool at a temporary directory and sets the retry and spool arguments
+func setupSpool(t *testing.T, retries int, maxMB int) string {
+	load.Refresh()
+	load.Entity = &integration.Entity{Metrics: []*metric.Set{}}
+	t.Setenv("TEMP_DIR", t.TempDir())
+
+	args := load.Args
+	load.Args.PostRetries = retries
+	load.Args.SpoolMaxMB = maxMB
+	load.Args.SpoolMaxAge = "1h"
+	backoff := postBackoff
+	postBackoff = time.Millisecond
+	t.Cleanup(func() {
+		load.Args = args
+		postBackoff = backoff
+	})
+	return spoolDir()
+}
+
+// postServer replies with the statuses in order, then 200, and records the bodies it received
+type postServer struct {
+	*httptest.Server
+	lock     sync.Mutex
+	statuses []int
+	bodies   []string
+	keys     []string
+}
+
+func newPostServer(t *testing.T, statuses ...int) *postServer {
+	s := &postServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reader, err := zlib.NewReader(r.Body)
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.bodies = append(s.bodies, string(b))
+		s.keys = append(s.keys, r.Header.Get("X-Insert-Key"))
+		if len(s.bodies) <= len(s.statuses) {
+			w.WriteHeader(s.statuses[len(s.bodies)-1])
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestPostRequestRetries(t *testing.T) {
+	tests := map[string]struct {
+		retries  int
+		statuses []int
+		attempts int
+		err      string
+		spooled  int
+	}{
+		"server errors are retried":      {retries: 3, statuses: []int{503, 500}, attempts: 3},
+		"too many requests are retried":  {retries: 1, statuses: []int{429}, attempts: 2},
+		"client errors are not retried":  {retries: 3, statuses: []int{400}, attempts: 1, err: "http: post failed, status code: 400"},
+		"retries disabled":               {retries: 0, statuses: []int{503}, attempts: 1, err: "http: post failed, status code: 503", spooled: 1},
+		"temporary failures are spooled": {retries: 2, statuses: []int{503, 503, 502}, attempts: 3, err: "http: post failed, status code: 502", spooled: 1},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := setupSpool(t, tc.retries, 1)
+			ts := newPostServer(t, tc.statuses...)
+
+			err := postRequest(ts.URL, "key", []byte(`[{"eventType":"testSample"}]`))
+			if tc.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.err)
+			}
+			assert.Len(t, ts.bodies, tc.attempts)
+			assert.Len(t, spoolFiles(dir), tc.spooled)
+		})
+	}
+}
+
+func TestRetryWait(t *testing.T) {
+	tests := map[string]struct {
+		status     int
+		retryAfter string
+		wait       time.Duration
+	}{
+		"server error":            {status: 503, wait: 0},
+		"too many requests":       {status: 429, wait: 0},
+		"retry after seconds":     {status: 429, retryAfter: "120", wait: 2 * time.Minute},
+		"invalid retry after":     {status: 429, retryAfter: "soon", wait: 0},
+		"client error":            {status: 403, wait: -1},
+		"retry after only on 429": {status: 503, retryAfter: "120", wait: 0},
+	}
+	for name, tc := range tests {
+		resp := &http.Response{StatusCode: tc.status, Header: http.Header{}}
+		if tc.retryAfter != "" {
+			resp.Header.Set("Retry-After", tc.retryAfter)
+		}
+		assert.Equal(t, tc.wait, retryWait(resp), name)
+	}
+
+	date := &http.Response{StatusCode: 429, Header: http.Header{}}
+	date.Header.Set("Retry-After", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	assert.True(t, retryWait(date) > 59*time.Minute)
+}
+
+func TestRetryAfterCapped(t *testing.T) {
+	load.Refresh()
+	capped := maxBackoff
+	maxBackoff = 10 * time.Millisecond
+	defer func() { maxBackoff = capped }()
+
+	attempts := 0
+	start := time.Now()
+	temporary, err := withRetries("test", 1, time.Millisecond, func() (time.Duration, error) {
+		attempts++
+		return time.Hour, fmt.Errorf("too many requests")
+	})
+	assert.True(t, temporary)
+	assert.EqualError(t, err, "too many requests")
+	assert.Equal(t, 2, attempts)
+	assert.True(t, time.Since(start) < time.Minute)
+}
+
+func TestSpoolKey(t *testing.T) {
+	load.Refresh()
+	load.Args.InsightsURL, load.Args.InsightsAPIKey = "https://insights", "insightsKey"
+	load.Args.MetricAPIUrl = "https://metrics"
+	outputs := append([]Output{metricAPIOutput{name: "metrics", url: "https://other", apiKey: "otherKey"}}, FromArgs()...)
+
+	tests := map[string]struct {
+		url string
+		key string
+	}{
+		"output":                     {url: "https://other", key: "otherKey"},
+		"argument":                   {url: "https://insights", key: "insightsKey"},
+		"metric api falls back":      {url: "https://metrics", key: "insightsKey"},
+		"no output posts to the url": {url: "https://unknown"},
+	}
+	for name, tc := range tests {
+		key, ok := spoolKey(outputs, tc.url)
+		assert.Equal(t, tc.key != "", ok, name)
+		assert.Equal(t, tc.key, key, name)
+	}
+}
+
+func TestReplaySpool(t *testing.T) {
+	dir := setupSpool(t, 0, 1)
+	down := newPostServer(t, 503, 503, 503)
+	require.Error(t, postRequest(down.URL, "key", []byte("first")))
+	require.Error(t, postRequest(down.URL, "key", []byte("second")))
+	require.Len(t, spoolFiles(dir), 2)
+	spooled, err := ioutil.ReadFile(filepath.Join(dir, spoolFiles(dir)[0].Name()))
+	require.NoError(t, err)
+	assert.NotContains(t, string(spooled), `"key"`)
+
+	// posts no output sends to are kept
+	ReplaySpool(nil)
+	assert.Len(t, down.bodies, 2)
+	require.Len(t, spoolFiles(dir), 2)
+
+	// the endpoint is still down, replay stops at the first post and keeps both
+	outputs := []Output{eventsOutput{name: "insights", url: down.URL, apiKey: "replayKey"}}
+	ReplaySpool(outputs)
+	assert.Equal(t, []string{"first", "second", "first"}, down.bodies)
+	require.Len(t, spoolFiles(dir), 2)
+
+	// posts are replayed once the endpoint is back, in the order they failed
+	down.statuses = nil
+	ReplaySpool(outputs)
+	assert.Equal(t, []string{"first", "second"}, down.bodies[3:])
+	assert.Equal(t, []string{"replayKey", "replayKey"}, down.keys[3:])
+	assert.Empty(t, spoolFiles(dir))
+}
+
+func TestReplaySpoolRejected(t *testing.T) {
+	dir := setupSpool(t, 0, 1)
+	ts := newPostServer(t, 503, 403)
+	require.Error(t, postRequest(ts.URL, "key", []byte("rejected")))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "00000000000000000000-000000.json"), []byte("{"), 0600))
+
+	// unreadable and rejected posts are dropped, as replaying them again fails the same way
+	load.Args.InsightsURL, load.Args.InsightsAPIKey = ts.URL, "key"
+	ReplaySpool(FromArgs())
+	assert.Equal(t, []string{"rejected", "rejected"}, ts.bodies)
+	assert.Empty(t, spoolFiles(dir))
+}
+
+func TestPruneSpool(t *testing.T) {
+	dir := setupSpool(t, 0, 1)
+	ts := newPostServer(t, 503, 503, 503, 503)
+	for _, body := range []string{"old", "a", "b", "c"} {
+		require.Error(t, postRequest(ts.URL, "key", []byte(strings.Repeat(body, 100))))
+	}
+	files := spoolFiles(dir)
+	require.Len(t, files, 4)
+	old := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, files[0].Name()), old, old))
+
+	// posts older than the max age are dropped, then the oldest posts until the spool fits
+	pruneSpool(dir, files[2].Size()+files[3].Size(), time.Hour)
+	remaining := spoolFiles(dir)
+	require.Len(t, remaining, 2)
+	assert.Equal(t, files[2].Name(), remaining[0].Name())
+	assert.Equal(t, files[3].Name(), remaining[1].Name())
+}
+
+func TestSpoolDisabled(t *testing.T) {
+	dir := setupSpool(t, 0, 0)
+	ts := newPostServer(t, 503)
+	require.Error(t, postRequest(ts.URL, "key", []byte("lost")))
+	assert.Empty(t, spoolFiles(dir))
+}
@@ -0,0 +1,225 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package outputs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/newrelic/nri-flex/internal/load"
+)
+
+const (
+	// statsdUDPPacketSize keeps datagrams under the usual network MTU, as DogStatsD clients do
+	statsdUDPPacketSize = 1432
+	// statsdUnixPacketSize is the default buffer of the DogStatsD unix socket
+	statsdUnixPacketSize = 8192
+)
+
+// statsdOutput emits the numeric attributes of samples as StatsD metrics over UDP or a unix datagram socket
+// with tags set, string attributes are added as DogStatsD tags
+type statsdOutput struct {
+	name       string
+	network    string
+	address    string
+	packetSize int
+	tags       bool
+	labels     map[string]bool // allowlist of the string attributes that become tags, nil allows all
+}
+
+func newStatsDOutput(cfg load.OutputConfig) (Output, error) {
+	return newStatsD(cfg, false)
+}
+
+func newDogStatsDOutput(cfg load.OutputConfig) (Output, error) {
+	return newStatsD(cfg, true)
+}
+
+func newStatsD(cfg load.OutputConfig, tags bool) (Output, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("requires url, eg. udp://127.0.0.1:8125 or unixgram:///var/run/statsd.sock")
+	}
+	o := statsdOutput{name: cfg.Name, tags: tags}
+
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url, %v", err)
+	}
+	switch u.Scheme {
+	case "udp":
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid url %s, udp requires host:port", cfg.URL)
+		}
+		o.network, o.address, o.packetSize = "udp", u.Host, statsdUDPPacketSize
+	case "unix", "unixgram":
+		if u.Path == "" {
+			return nil, fmt.Errorf("invalid url %s, unixgram requires a socket path", cfg.URL)
+		}
+		o.network, o.address, o.packetSize = "unixgram", u.Path, statsdUnixPacketSize
+	default:
+		return nil, fmt.Errorf("invalid url %s, the scheme must be udp or unixgram", cfg.URL)
+	}
+
+	if len(cfg.Labels) > 0 {
+		o.labels = map[string]bool{}
+		for _, label := range cfg.Labels {
+			o.labels[label] = true
+		}
+	}
+	return o, nil
+}
+
+func (o statsdOutput) Name() string { return o.name }
+
+// Send writes every metric, packing as many as fit in a datagram separated by new lines
+func (o statsdOutput) Send() error {
+	lines := o.lines()
+	if len(lines) == 0 {
+		return nil
+	}
+
+	conn, err := net.Dial(o.network, o.address)
+	if err != nil {
+		return fmt.Errorf("%s: failed to connect to %s, %v", o.name, o.address, err)
+	}
+	defer conn.Close()
+
+	var errs []error
+	var packet bytes.Buffer
+	flush := func() {
+		if packet.Len() == 0 {
+			return
+		}
+		if _, err := conn.Write(packet.Bytes()); err != nil {
+			errs = append(errs, fmt.Errorf("%s: failed to write, %v", o.name, err))
+		}
+		packet.Reset()
+	}
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > o.packetSize {
+			flush()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	flush()
+	return errors.Join(errs...)
+}
+
+// lines formats every metric of the samples, then of metric_api apis
+// metric_parser RATE and DELTA keys are counters of their increment, other keys are gauges
+func (o statsdOutput) lines() []string {
+	var lines []string
+	for _, entity := range load.Integration.Entities {
+		for _, set := range entity.Metrics {
+			eventType, _ := set.Metrics["event_type"].(string)
+			tags := o.formatTags(set.Metrics)
+			for _, key := range sortedKeys(set.Metrics) {
+				value, ok := numericValue(set.Metrics[key])
+				if !ok {
+					continue
+				}
+				metricType := "g"
+				switch load.MetricType(set, key) {
+				case "RATE", "PRATE":
+					// a counter is an increment, the rate is per second
+					value = load.MetricIncrement(set, key)
+					metricType = "c"
+				case "DELTA", "PDELTA":
+					metricType = "c"
+				}
+				name := key
+				if eventType != "" {
+					name = eventType + "." + key
+				}
+				lines = append(lines, formatStatsD(name, value, metricType, tags))
+			}
+		}
+	}
+
+	load.MetricsStore.RLock()
+	stored := load.MetricsStore.Data
+	load.MetricsStore.RUnlock()
+	for _, payload := range stored {
+		common := o.formatTags(payload.CommonAttributes)
+		for _, metric := range payload.Metrics {
+			name, _ := metric["name"].(string)
+			if name == "" {
+				continue
+			}
+			tags := common
+			if extra, ok := metric["attributes"].(map[string]interface{}); ok && o.tags {
+				tags = append(append([]string{}, common...), allTags(extra)...)
+			}
+			if summary, ok := metric["value"].(map[string]float64); ok {
+				for _, part := range []string{"count", "sum", "min", "max"} {
+					lines = append(lines, formatStatsD(name+"."+part, summary[part], "g", tags))
+				}
+				continue
+			}
+			value, ok := numericValue(metric["value"])
+			if !ok {
+				continue
+			}
+			metricType := "g"
+			if metric["type"] == "count" {
+				metricType = "c"
+			}
+			lines = append(lines, formatStatsD(name, value, metricType, tags))
+		}
+	}
+	return lines
+}
+
+// formatTags returns the string attributes of a sample allowed as tags, none when tags are not enabled
+func (o statsdOutput) formatTags(sample map[string]interface{}) []string {
+	if !o.tags {
+		return nil
+	}
+	var tags []string
+	for _, key := range sortedKeys(sample) {
+		value, ok := sample[key].(string)
+		if !ok || key == "event_type" || (o.labels != nil && !o.labels[key]) {
+			continue
+		}
+		tags = append(tags, sanitizeStatsD(key)+":"+statsdTagValueReplacer.Replace(value))
+	}
+	return tags
+}
+
+func allTags(attributes map[string]interface{}) []string {
+	var tags []string
+	for _, key := range sortedKeys(attributes) {
+		tags = append(tags, sanitizeStatsD(key)+":"+statsdTagValueReplacer.Replace(fmt.Sprint(attributes[key])))
+	}
+	return tags
+}
+
+// formatStatsD formats a metric as name:value|type, with DogStatsD tags as |#tag:value,...
+func formatStatsD(name string, value float64, metricType string, tags []string) string {
+	line := sanitizeStatsD(name) + ":" + strconv.FormatFloat(value, 'f', -1, 64) + "|" + metricType
+	if len(tags) > 0 {
+		line += "|#" + strings.Join(tags, ",")
+	}
+	return line
+}
+
+var (
+	statsdNameReplacer     = strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", ",", "_", "\n", "_", " ", "_")
+	statsdTagValueReplacer = strings.NewReplacer("|", "_", ",", "_", "\n", "_")
+)
+
+// sanitizeStatsD replaces the characters that separate the fields of a metric or its tags in names and tag keys
+func sanitizeStatsD(s string) string {
+	return statsdNameReplacer.Replace(s)
+}
@@ -0,0 +1,181 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package outputs
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/data/attribute"
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/nri-flex/internal/load"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readPackets returns the lines of every datagram received until the connection is idle
+func readPackets(t *testing.T, conn net.PacketConn) [][]string {
+	var packets [][]string
+	buf := make([]byte, 65536)
+	for {
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(200*time.Millisecond)))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return packets
+		}
+		packets = append(packets, strings.Split(string(buf[:n]), "\n"))
+	}
+}
+
+func TestStatsDConfig(t *testing.T) {
+	tests := map[string]struct {
+		url     string
+		network string
+		address string
+		err     string
+	}{
+		"udp":          {url: "udp://127.0.0.1:8125", network: "udp", address: "127.0.0.1:8125"},
+		"unixgram":     {url: "unixgram:///var/run/statsd.sock", network: "unixgram", address: "/var/run/statsd.sock"},
+		"unix":         {url: "unix:///var/run/statsd.sock", network: "unixgram", address: "/var/run/statsd.sock"},
+		"missing url":  {err: "outputs[0]: statsd requires url, eg. udp://127.0.0.1:8125 or unixgram:///var/run/statsd.sock"},
+		"tcp":          {url: "tcp://127.0.0.1:8125", err: "outputs[0]: statsd invalid url tcp://127.0.0.1:8125, the scheme must be udp or unixgram"},
+		"missing host": {url: "udp://", err: "outputs[0]: statsd invalid url udp://, udp requires host:port"},
+		"missing path": {url: "unixgram://", err: "outputs[0]: statsd invalid url unixgram://, unixgram requires a socket path"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			outputs, err := New([]load.OutputConfig{{Type: "statsd", URL: tc.url}})
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			output := outputs[0].(statsdOutput)
+			assert.Equal(t, tc.network, output.network)
+			assert.Equal(t, tc.address, output.address)
+		})
+	}
+}
+
+func TestStatsDOutput(t *testing.T) {
+	load.Refresh()
+	defer load.MetricTypesReset()
+	defer load.MetricsStoreEmpty()
+	i, err := integration.New(load.IntegrationName, load.IntegrationVersion)
+	require.NoError(t, err)
+	load.Integration = i
+	load.Entity, _ = i.Entity("TestStatsDOutput", "nri-flex")
+
+	redis := load.Entity.NewMetricSet("redisSample", attribute.Attr("namespace", "redis-1"))
+	require.NoError(t, redis.SetMetric("connected clients", 5, metric.GAUGE))
+	require.NoError(t, redis.SetMetric("role", "master", metric.ATTRIBUTE))
+	require.NoError(t, redis.SetMetric("url", "http://redis:6379/a,b", metric.ATTRIBUTE))
+	redis.Metrics["commandsProcessed"] = float64(30)
+	load.MetricTypeSet(redis, "commandsProcessed", "DELTA")
+	redis.Metrics["opsPerSec"] = float64(2.5)
+	load.MetricTypeSet(redis, "opsPerSec", "RATE")
+	load.MetricIncrementSet(redis, "opsPerSec", 25)
+
+	load.MetricsStoreEmpty()
+	load.MetricsStoreAppend(load.Metrics{
+		TimestampMs:      1000,
+		CommonAttributes: map[string]interface{}{"role": "master"},
+		Metrics: []map[string]interface{}{
+			{"name": "queries", "type": "count", "value": float64(12), "attributes": map[string]interface{}{"db": 0}},
+			{"name": "latency", "type": "summary", "value": map[string]float64{"min": 1, "max": 9, "sum": 20, "count": 4}},
+		},
+	})
+
+	tests := map[string]struct {
+		typ    string
+		labels []string
+		lines  []string
+	}{
+		"statsd": {
+			typ: "statsd",
+			lines: []string{
+				"redisSample.commandsProcessed:30|c",
+				"redisSample.connected_clients:5|g",
+				"redisSample.opsPerSec:25|c",
+				"queries:12|c",
+				"latency.count:4|g",
+				"latency.sum:20|g",
+				"latency.min:1|g",
+				"latency.max:9|g",
+			},
+		},
+		"dogstatsd with labels": {
+			typ:    "dogstatsd",
+			labels: []string{"role", "url"},
+			lines: []string{
+				"redisSample.commandsProcessed:30|c|#role:master,url:http://redis:6379/a_b",
+				"redisSample.connected_clients:5|g|#role:master,url:http://redis:6379/a_b",
+				"redisSample.opsPerSec:25|c|#role:master,url:http://redis:6379/a_b",
+				"queries:12|c|#role:master,db:0",
+				"latency.count:4|g|#role:master",
+				"latency.sum:20|g|#role:master",
+				"latency.min:1|g|#role:master",
+				"latency.max:9|g|#role:master",
+			},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			require.NoError(t, err)
+			defer conn.Close()
+
+			outputs, err := New([]load.OutputConfig{{Type: tc.typ, URL: "udp://" + conn.LocalAddr().String(), Labels: tc.labels}})
+			require.NoError(t, err)
+			require.NoError(t, outputs[0].Send())
+
+			packets := readPackets(t, conn)
+			require.Len(t, packets, 1)
+			assert.Equal(t, tc.lines, packets[0])
+		})
+	}
+
+	// other outputs send the same samples
+	assert.Equal(t, "redisSample", redis.Metrics["event_type"])
+}
+
+func TestStatsDOutputPackets(t *testing.T) {
+	load.Refresh()
+	load.MetricsStoreEmpty()
+	i, err := integration.New(load.IntegrationName, load.IntegrationVersion)
+	require.NoError(t, err)
+	load.Integration = i
+	load.Entity, _ = i.Entity("TestStatsDOutputPackets", "nri-flex")
+	set := load.Entity.NewMetricSet("testSample")
+	for i := 0; i < 200; i++ {
+		require.NoError(t, set.SetMetric(fmt.Sprintf("value%03d", i), i, metric.GAUGE))
+	}
+
+	conn, err := net.ListenPacket("unixgram", filepath.Join(t.TempDir(), "statsd.sock"))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	outputs, err := New([]load.OutputConfig{{Type: "statsd", URL: "unixgram://" + conn.LocalAddr().String()}})
+	require.NoError(t, err)
+	output := outputs[0].(statsdOutput)
+	output.packetSize = 1000
+	require.NoError(t, output.Send())
+
+	// every metric arrives once, packed in datagrams under the packet size
+	packets := readPackets(t, conn)
+	assert.True(t, len(packets) > 1)
+	lines := 0
+	for _, packet := range packets {
+		assert.True(t, len(strings.Join(packet, "\n")) <= 1000)
+		lines += len(packet)
+	}
+	assert.Equal(t, 200, lines)
+}
@@ -43,12 +43,79 @@ func StatusSample() {
 	if load.ServerlessExecutionEnv != "" {
 		statusLog(flexStatusSample.SetMetric("flex.ServerlessExecutionEnv", load.ServerlessExecutionEnv, metric.ATTRIBUTE))
 	}
+	if reloadErrors := load.ConfigReloadErrorsRead(); reloadErrors != "" {
+		statusLog(flexStatusSample.SetMetric("flex.reloadError", reloadErrors, metric.ATTRIBUTE))
+	}
 	for counter, value := range load.FlexStatusCounter.M {
 		statusLog(flexStatusSample.SetMetric("flex.counter."+counter, value, metric.GAUGE))
 	}
 	for pid, val := range load.DiscoveredProcesses {
 		statusLog(flexStatusSample.SetMetric("flex.pd."+pid, val, metric.ATTRIBUTE))
 	}
+
+	runStatusSamples()
+}
+
+// runStatusSamples creates a flexConfigStatusSample for each config that ran since results were last published
+// and a flexApiStatusSample for each of its apis, if enabled
+func runStatusSamples() {
+	if !load.Args.ConfigStatusSamples && !load.Args.APIStatusSamples {
+		return
+	}
+	for name, run := range load.RunStatusRead() {
+		var samples, filtered, drops, bytesRead, retries int
+		var fetchDurationMs int64
+		for apiName, api := range run.APIs {
+			samples += api.Samples
+			filtered += api.SamplesFiltered
+			drops += api.EventLimitDrops
+			bytesRead += api.BytesRead
+			retries += api.HTTPRetries
+			fetchDurationMs += api.FetchDurationMs
+			if load.Args.APIStatusSamples {
+				apiStatusSample(name, run.FileName, apiName, api)
+			}
+		}
+
+		configSample := load.Entity.NewMetricSet("flexConfigStatusSample")
+		statusLog(configSample.SetMetric("configName", name, metric.ATTRIBUTE))
+		statusLog(configSample.SetMetric("fileName", run.FileName, metric.ATTRIBUTE))
+		statusLog(configSample.SetMetric("durationMs", run.DurationMs, metric.GAUGE))
+		statusLog(configSample.SetMetric("apis", len(run.APIs), metric.GAUGE))
+		statusLog(configSample.SetMetric("fetchDurationMs", fetchDurationMs, metric.GAUGE))
+		statusLog(configSample.SetMetric("bytesRead", bytesRead, metric.GAUGE))
+		statusLog(configSample.SetMetric("httpRetries", retries, metric.GAUGE))
+		statusLog(configSample.SetMetric("samples", samples, metric.GAUGE))
+		statusLog(configSample.SetMetric("samplesFiltered", filtered, metric.GAUGE))
+		statusLog(configSample.SetMetric("eventLimitDrops", drops, metric.GAUGE))
+		statusLog(configSample.SetMetric("errors", run.Errors, metric.GAUGE))
+		if run.LastError != "" {
+			statusLog(configSample.SetMetric("lastError", run.LastError, metric.ATTRIBUTE))
+		}
+	}
+}
+
+func apiStatusSample(config string, fileName string, name string, api *load.APIStatus) {
+	apiSample := load.Entity.NewMetricSet("flexApiStatusSample")
+	statusLog(apiSample.SetMetric("configName", config, metric.ATTRIBUTE))
+	statusLog(apiSample.SetMetric("fileName", fileName, metric.ATTRIBUTE))
+	statusLog(apiSample.SetMetric("apiName", name, metric.ATTRIBUTE))
+	if api.InputType != "" {
+		statusLog(apiSample.SetMetric("inputType", api.InputType, metric.ATTRIBUTE))
+	}
+	statusLog(apiSample.SetMetric("fetchDurationMs", api.FetchDurationMs, metric.GAUGE))
+	statusLog(apiSample.SetMetric("bytesRead", api.BytesRead, metric.GAUGE))
+	if api.HTTPStatus != 0 {
+		statusLog(apiSample.SetMetric("httpStatus", api.HTTPStatus, metric.GAUGE))
+		statusLog(apiSample.SetMetric("httpRetries", api.HTTPRetries, metric.GAUGE))
+	}
+	statusLog(apiSample.SetMetric("samples", api.Samples, metric.GAUGE))
+	statusLog(apiSample.SetMetric("samplesFiltered", api.SamplesFiltered, metric.GAUGE))
+	statusLog(apiSample.SetMetric("eventLimitDrops", api.EventLimitDrops, metric.GAUGE))
+	statusLog(apiSample.SetMetric("errors", api.Errors, metric.GAUGE))
+	if api.LastError != "" {
+		statusLog(apiSample.SetMetric("lastError", api.LastError, metric.ATTRIBUTE))
+	}
 }
 
 func statusLog(err error) {
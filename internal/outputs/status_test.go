@@ -0,0 +1,102 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package outputs
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/nri-flex/internal/load"
+)
+
+func samplesByEventType(eventType string) []map[string]interface{} {
+	var samples []map[string]interface{}
+	for _, sample := range load.Entity.Metrics {
+		if sample.Metrics["event_type"] == eventType {
+			samples = append(samples, sample.Metrics)
+		}
+	}
+	return samples
+}
+
+func TestRunStatusSamples(t *testing.T) {
+	defer func() {
+		load.Args.ConfigStatusSamples = false
+		load.Args.APIStatusSamples = false
+	}()
+
+	tests := map[string]struct {
+		configSamples bool
+		apiSamples    bool
+		expectConfig  int
+		expectAPI     int
+	}{
+		"disabled":       {false, false, 0, 0},
+		"config samples": {true, false, 2, 0},
+		"api samples":    {false, true, 2, 3},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			load.Refresh()
+			load.Args.ConfigStatusSamples = tc.configSamples
+			load.Args.APIStatusSamples = tc.apiSamples
+			i, _ := integration.New(load.IntegrationName, load.IntegrationVersion)
+			load.Entity, _ = i.Entity("TestRunStatusSamples", "nri-flex")
+
+			load.ConfigRunCompleted(&load.Config{Name: "redis", FileName: "redis.yml"}, time.Now())
+			load.APIStatusUpdate("redis", "info", func(status *load.APIStatus) {
+				status.InputType = "commands"
+				status.BytesRead = 100
+				status.SamplesFiltered = 1
+			})
+			load.ConfigEvent("redis", "info")
+			load.ConfigEvent("redis", "clients")
+			load.APIStatusUpdate("nginx", "status", func(status *load.APIStatus) {
+				status.InputType = "http"
+				status.HTTPStatus = 503
+				status.HTTPRetries = 2
+			})
+			load.ConfigError("nginx", "status", errors.New("unexpected status code 503"))
+
+			runStatusSamples()
+
+			configSamples := samplesByEventType("flexConfigStatusSample")
+			require.Len(t, configSamples, tc.expectConfig)
+			assert.Len(t, samplesByEventType("flexApiStatusSample"), tc.expectAPI)
+
+			for _, sample := range configSamples {
+				switch sample["configName"] {
+				case "redis":
+					assert.Equal(t, "redis.yml", sample["fileName"])
+					assert.Equal(t, float64(2), sample["apis"])
+					assert.Equal(t, float64(2), sample["samples"])
+					assert.Equal(t, float64(1), sample["samplesFiltered"])
+					assert.Equal(t, float64(100), sample["bytesRead"])
+					assert.NotContains(t, sample, "lastError")
+				case "nginx":
+					assert.Equal(t, float64(1), sample["errors"])
+					assert.Equal(t, float64(2), sample["httpRetries"])
+					assert.Equal(t, "unexpected status code 503", sample["lastError"])
+				}
+			}
+
+			for _, sample := range samplesByEventType("flexApiStatusSample") {
+				if sample["apiName"] == "status" {
+					assert.Equal(t, "http", sample["inputType"])
+					assert.Equal(t, float64(503), sample["httpStatus"])
+					assert.Equal(t, float64(2), sample["httpRetries"])
+					assert.Equal(t, "unexpected status code 503", sample["lastError"])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package processor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/newrelic/infra-integrations-sdk/persist"
+	"github.com/newrelic/nri-flex/internal/formatter"
+	"github.com/newrelic/nri-flex/internal/load"
+)
+
+// counterState is the previous value of a cumulative counter sent to the metric api
+type counterState struct {
+	Value       float64 `json:"value"`
+	TimestampMs int64   `json:"timestamp.ms"`
+}
+
+// pendingCounter is a cumulative counter of a sample, converted once every common attribute of the sample is known
+type pendingCounter struct {
+	name       string
+	value      float64
+	metricType string // DELTA or PDELTA
+}
+
+// counterType returns DELTA or PDELTA when metric_parser.metrics sets either for the key, matched as on the infra path
+func counterType(k string, metrics map[string]string, autoSet bool, mode string) string {
+	for metricKey, metricVal := range metrics {
+		if (k == metricKey) || (autoSet && formatter.KvFinder(regex, k, metricKey)) || (mode != "" && formatter.KvFinder(mode, k, metricKey)) {
+			if metricVal == "DELTA" || metricVal == "PDELTA" {
+				return metricVal
+			}
+			return ""
+		}
+	}
+	return ""
+}
+
+// counterKey identifies a counter in the store by its name and the common attributes of its sample
+func counterKey(name string, commonAttributes map[string]interface{}) string {
+	keys := make([]string, 0, len(commonAttributes))
+	for k := range commonAttributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var key strings.Builder
+	key.WriteString("metricapi:")
+	key.WriteString(name)
+	for _, k := range keys {
+		key.WriteString(fmt.Sprintf(",%s=%v", k, commonAttributes[k]))
+	}
+	return key.String()
+}
+
+// counterDelta converts the cumulative value of a counter to a count of the increase since its previous value
+// the interval of the count is the time since the previous value, nothing is returned for the first value
+// a PDELTA lower than its previous value was reset, the count is then the value since the reset
+// a DELTA is any difference, negative included, as metric.DELTA gives on the infra path
+// previous values are kept in store, the store of the state the config runs with
+func counterDelta(store persist.Storer, counter pendingCounter, commonAttributes map[string]interface{}, nowMs int64) map[string]interface{} {
+	if store == nil {
+		load.Logrus.Errorf("flex: no store to compute the delta of %s", counter.name)
+		return nil
+	}
+
+	key := counterKey(counter.name, commonAttributes)
+	var previous counterState
+	_, err := store.Get(key, &previous)
+	if err != nil && err != persist.ErrNotFound {
+		load.Logrus.WithError(err).Errorf("flex: failed to read the previous value of %s", counter.name)
+	}
+	store.Set(key, counterState{Value: counter.value, TimestampMs: nowMs})
+	if err != nil {
+		return nil
+	}
+
+	intervalMs := nowMs - previous.TimestampMs
+	if intervalMs <= 0 {
+		load.Logrus.Debugf("flex: %s sampled too close to its previous value, skipping", counter.name)
+		return nil
+	}
+	delta := counter.value - previous.Value
+	if delta < 0 && counter.metricType == "PDELTA" {
+		load.Logrus.Debugf("flex: %s was reset, counting from zero", counter.name)
+		delta = counter.value
+	}
+
+	return map[string]interface{}{
+		"name":        counter.name,
+		"value":       delta,
+		"type":        "count",
+		"interval.ms": intervalMs,
+	}
+}
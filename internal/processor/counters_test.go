@@ -0,0 +1,112 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+package processor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/persist"
+	"github.com/newrelic/nri-flex/internal/load"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCounterType(t *testing.T) {
+	metrics := map[string]string{"requests_total": "PDELTA", "bytes": "DELTA", "cpu": "RATE"}
+	tests := map[string]struct {
+		key     string
+		autoSet bool
+		mode    string
+		want    string
+	}{
+		"exact pdelta":     {key: "requests_total", want: "PDELTA"},
+		"exact delta":      {key: "bytes", want: "DELTA"},
+		"rate is ignored":  {key: "cpu", want: ""},
+		"no match":         {key: "bytes_in", want: ""},
+		"prefix mode":      {key: "bytes_in", mode: "prefix", want: "DELTA"},
+		"auto set matches": {key: "http_requests_total", autoSet: true, want: "PDELTA"},
+	}
+	for name, tc := range tests {
+		assert.Equal(t, tc.want, counterType(tc.key, metrics, tc.autoSet, tc.mode), name)
+	}
+}
+
+func TestCounterDelta(t *testing.T) {
+	store := persist.NewInMemoryStore()
+	attributes := map[string]interface{}{"job": "api", "instance": "10.0.0.1:9090"}
+
+	tests := []struct {
+		name    string
+		counter pendingCounter
+		nowMs   int64
+		want    map[string]interface{}
+	}{
+		{"first value has no previous", pendingCounter{"requests_total", 100, "PDELTA"}, 1000, nil},
+		{"increase", pendingCounter{"requests_total", 130, "PDELTA"}, 11000, map[string]interface{}{"name": "requests_total", "value": float64(30), "type": "count", "interval.ms": int64(10000)}},
+		{"too close", pendingCounter{"requests_total", 140, "PDELTA"}, 11000, nil},
+		{"reset counts from zero", pendingCounter{"requests_total", 5, "PDELTA"}, 21000, map[string]interface{}{"name": "requests_total", "value": float64(5), "type": "count", "interval.ms": int64(10000)}},
+		{"delta first value", pendingCounter{"queue", 10, "DELTA"}, 1000, nil},
+		{"delta can be negative", pendingCounter{"queue", 4, "DELTA"}, 3000, map[string]interface{}{"name": "queue", "value": float64(-6), "type": "count", "interval.ms": int64(2000)}},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, counterDelta(store, tc.counter, attributes, tc.nowMs), tc.name)
+	}
+
+	// counters are told apart by their common attributes
+	other := map[string]interface{}{"job": "api", "instance": "10.0.0.2:9090"}
+	assert.Nil(t, counterDelta(store, pendingCounter{"requests_total", 500, "PDELTA"}, other, 31000))
+	assert.Nil(t, counterDelta(nil, pendingCounter{"requests_total", 600, "PDELTA"}, attributes, 41000), "no store, no delta")
+	assert.NotEqual(t, counterKey("requests_total", attributes), counterKey("requests_total", other))
+}
+
+func TestAutoSetMetricAPICounters(t *testing.T) {
+	load.Refresh()
+	// counters are kept in the store of the state, not in the store of the process
+	store := persist.NewInMemoryStore()
+	state := load.NewState(load.Args, store)
+
+	api := load.API{MetricParser: load.MetricParser{
+		Metrics: map[string]string{"requests_total": "PDELTA"},
+		Counts:  map[string]int64{"errors": 60000},
+	}}
+	sample := func(requests float64) map[string]interface{} {
+		return map[string]interface{}{"job": "api", "requests_total": requests, "errors": float64(2), "up": float64(1)}
+	}
+
+	// the first execution only stores the counter
+	first := sample(100)
+	AutoSetMetricAPI(&first, &api, state)
+	require.Len(t, state.Metrics(), 1)
+	assert.Len(t, state.Metrics()[0].Metrics, 2)
+	assert.Nil(t, load.Storer)
+
+	// age the stored value as if the previous execution ran 30s ago
+	key := counterKey("requests_total", state.Metrics()[0].CommonAttributes)
+	var previous counterState
+	_, err := store.Get(key, &previous)
+	require.NoError(t, err)
+	previous.TimestampMs -= 30000
+	store.Set(key, previous)
+
+	// the next execution runs with a new state sharing the store
+	state = load.NewState(load.Args, store)
+	second := sample(160)
+	AutoSetMetricAPI(&second, &api, state)
+	require.Len(t, state.Metrics(), 1)
+	metrics := map[string]map[string]interface{}{}
+	for _, metric := range state.Metrics()[0].Metrics {
+		metrics[metric["name"].(string)] = metric
+	}
+	require.Len(t, metrics, 3)
+	assert.Equal(t, "gauge", metrics["up"]["type"])
+	assert.Equal(t, int64(60000), metrics["errors"]["interval.ms"])
+
+	requests := metrics["requests_total"]
+	assert.Equal(t, "count", requests["type"])
+	assert.Equal(t, float64(60), requests["value"])
+	interval := requests["interval.ms"].(int64)
+	assert.True(t, interval >= 30000 && interval < 30000+int64(time.Minute/time.Millisecond), interval)
+}
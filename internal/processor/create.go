@@ -15,7 +15,8 @@ import (
 	"github.com/newrelic/infra-integrations-sdk/data/attribute"
 	"github.com/newrelic/infra-integrations-sdk/data/event"
 	"github.com/newrelic/infra-integrations-sdk/data/metric"
-	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/nri-flex/internal/explain"
+	"github.com/newrelic/nri-flex/internal/fixture"
 	"github.com/newrelic/nri-flex/internal/formatter"
 	"github.com/newrelic/nri-flex/internal/load"
 	"github.com/newrelic/nri-flex/internal/outputs"
@@ -26,12 +27,21 @@ const regex = "regex"
 // CreateMetricSets creates metric sets
 // hren added samplesToMerge parameter, moved merge operation to CreateMetricSets so that the "Run...." functions still apply before merge
 func CreateMetricSets(samples []interface{}, config *load.Config, i int, mergeMetric bool, samplesToMerge *load.SamplesToMerge, originalAPINo int) {
+	createMetricSets(samples, config, i, mergeMetric, samplesToMerge, originalAPINo, nil)
+}
+
+// createMetricSets records every step applied to each sample in trace, when not nil
+func createMetricSets(samples []interface{}, config *load.Config, i int, mergeMetric bool, samplesToMerge *load.SamplesToMerge, originalAPINo int, trace *explain.DataSet) {
 	api := config.APIs[i]
+	state := config.State()
+	pipeline := buildPipeline(config.Name, api)
 	// as it stands we know that this always receives map[string]interface{}'s
-	for _, sample := range samples {
+	for sampleNo, sample := range samples {
 		currentSample := sample.(map[string]interface{})
+		sampleTrace := trace.NewSample(currentSample)
 		eventType := "UnknownSample" // set an UnknownSample event name
 		SetEventType(&currentSample, &eventType, api.EventType, api.Merge, api.Name)
+		sampleTrace.Step("SetEventType", currentSample)
 
 		// add custom attribute(s)
 		// global
@@ -42,6 +52,7 @@ func CreateMetricSets(samples []interface{}, config *load.Config, i int, mergeMe
 		for k, v := range api.CustomAttributes {
 			currentSample[k] = v
 		}
+		sampleTrace.Step("custom_attributes", currentSample)
 
 		// init lookup store
 		if (&config.LookupStore) == nil { //nolint
@@ -49,125 +60,40 @@ func CreateMetricSets(samples []interface{}, config *load.Config, i int, mergeMe
 		}
 
 		// event limiter
-		if (load.StatusCounterRead("EventCount") > load.Args.EventLimit) && load.Args.EventLimit != 0 {
-			load.StatusCounterIncrement("EventDropCount")
-			if load.StatusCounterRead("EventDropCount") == 1 { // don't output the message more then once
-				load.Logrus.Errorf("flex: event limit %d has been reached, please increase if required", load.Args.EventLimit)
+		eventLimit := state.Args().EventLimit
+		if (state.StatusCounterRead("EventCount") > eventLimit) && eventLimit != 0 {
+			state.StatusCounterIncrement("EventDropCount")
+			if state.StatusCounterRead("EventDropCount") == 1 { // don't output the message more then once
+				load.Logrus.Errorf("flex: event limit %d has been reached, please increase if required", eventLimit)
 			}
+			sampleTrace.Drop("event_limit")
+			sampleTrace.Done(eventType, currentSample)
+			state.APIStatusUpdate(config.Name, api.Name, func(status *load.APIStatus) {
+				status.EventLimitDrops += len(samples) - sampleNo
+			})
 			break
 		}
 
-		// modify existing sample before final processing
-		SkipProcessing := api.SkipProcessing
-
-		var modifiedKeys []string
-		for k, v := range currentSample { // k == original key
-			key := k
-			RunKeyConversion(&key, api, v, &SkipProcessing)
-			RunValConversion(&v, api, &key)
-			RunValueParser(&v, api, &key)
-			RunPluckNumbers(&v, api, &key)
-			RunSubParse(api.SubParse, &currentSample, key, v)        // subParse key pairs (see redis example)
-			RunValueTransformer(&v, api, &key)                       // Needs to be run before KeyRenamer and KeyReplacer
-			RunValueMapper(api.ValueMapper, &currentSample, key, &v) // valueMapper
-
-			RunTimestampConversion(&v, api, &key)
-			// find keys with regex, convert date<=>timestamp
-			// timestamp_conversion:
-			//   started_at: TIMESTAMP::RFC3339
-			//   endtime: DATE::RFC3339
-			// do not rename a key again, this is to avoid continuous replacement loops
-			// eg. if you replace id with project.id
-			// this could then again attempt to replace id within project.id to project.project.id
-			if !sliceContains(modifiedKeys, k) {
-				RunKeyRenamer(api.RenameKeys, &key)  // use key renamer if key replace hasn't occurred
-				RunKeyRenamer(api.ReplaceKeys, &key) // kept for backwards compatibility with replace_keys
-			}
-
-			currentSample[key] = v
-			if key != k {
-				modifiedKeys = append(modifiedKeys, key)
-				delete(currentSample, k)
-			}
-
-			// if keepkeys used will do inverse
-			RunKeepKeys(api.KeepKeys, &key, &currentSample)
-			RunSampleRenamer(api.RenameSamples, &currentSample, key, &eventType)
+		var createSample bool
+		if len(api.Pipeline) > 0 {
+			currentSample, createSample = runPipeline(pipeline, config, api, currentSample, &eventType, sampleTrace)
+		} else {
+			currentSample, createSample = runDefaultPipeline(config, api, currentSample, &eventType, sampleTrace)
 		}
 
-		// addAttribute is kept outside the first currentSample loop intentionally
-		// if an attribute is added to the currentSample while in the loop it will restart the loop
-		addAttribute(currentSample, api.AddAttribute)
-
-		// lookups should be performed after addAttribute to ensure anything constructed is available for lookup creation
-		// if run_async is set to true for the API, we will skip StoreLookups and VariableLookups processing due to potential concurrent map write operation
-		// we will address this in the future. However, for run_async=true usecase, we do not expect these two functions to be used.
-		if !api.RunAsync {
-			for k, v := range currentSample {
-				StoreLookups(api.StoreLookups, &config.LookupStore, k, v)        // store lookups
-				VariableLookups(api.StoreVariables, &config.VariableStore, k, v) // store variable
-			}
-		}
-		// else {
-		// 	load.Logrus.WithFields(logrus.Fields{
-		// 		"API name":  api.Name,
-		// 		"run_async": api.RunAsync,
-		// 		"key":       key,
-		// 	}).Debug("create: skipping StoreLookups VariableLookups due to run_async is true: ")
-		// }
-
-		createSample := false
-		runSampleFilterExperimental := true
-		// check if we should ignore this output completely
-		// useful when requests are made to generate a lookup, but the data is not needed
-		if api.IgnoreOutput {
-			createSample = false
-			currentSample["event_type"] = eventType
-			load.IgnoredIntegrationData = append(load.IgnoredIntegrationData, currentSample)
-		} else {
-			// check if this contains any key pair values to filter out
-			excludeSample := true
-			// evalute sample_include_filter if sample_include_match_all_filter is not specified
-			if len(api.SampleIncludeMatchAllFilter) != 0 {
-				// don't exclude sample if the multi key filter is specified
-				excludeSample = false
-			} else {
-				// check if the sample passes sample_include_filter, if no sample_include_filter defined, the sample will pass by default.
-				if api.SampleIncludeFilter == nil || len(api.SampleIncludeFilter) == 0 {
-					excludeSample = false
-				} else {
-					RunSampleFilter(currentSample, api.SampleIncludeFilter, &excludeSample)
-					runSampleFilterExperimental = false
-				}
-			}
-			// check sample_exclude_filter and sample_filter, only if it passes sample_include_filter filter or there is no sample_include_filter defined
-			if !excludeSample {
-				createSample = true
-				if runSampleFilterExperimental {
-					RunSampleFilterMatchAll(currentSample, api.SampleIncludeMatchAllFilter, &createSample)
-				}
-				RunSampleFilter(currentSample, api.SampleFilter, &createSample)
-				RunSampleFilter(currentSample, api.SampleExcludeFilter, &createSample)
-			}
+		if !createSample && !api.IgnoreOutput {
+			state.APIStatusUpdate(config.Name, api.Name, func(status *load.APIStatus) {
+				status.SamplesFiltered++
+			})
 		}
 
 		if createSample {
-			RunMathCalculations(&api.Math, &currentSample)
-
-			// inject some additional attributes if set
-			if config.Global.BaseURL != "" {
-				currentSample["baseUrl"] = config.Global.BaseURL
-			}
-
-			// remove keys from sample
-			// this should be kept last
-			RunKeyRemover(&currentSample, api.RemoveKeys)
-
 			// hren: if it is not mergeMetric, it will proceed to publish metric
 			if !mergeMetric {
-				workingEntity := setEntity(api.Entity, api.EntityType) // default type instance
+				workingEntity := setEntity(state, api.Entity, api.EntityType) // default type instance
 				if config.MetricAPI {
-					AutoSetMetricAPI(&currentSample, &api)
+					AutoSetMetricAPI(&currentSample, &api, state)
+					state.ConfigEvent(config.Name, api.Name)
 				} else {
 					AutoSetStandard(&currentSample, &api, workingEntity, eventType, config)
 				}
@@ -182,6 +108,7 @@ func CreateMetricSets(samples []interface{}, config *load.Config, i int, mergeMe
 			}
 
 		}
+		sampleTrace.Done(eventType, currentSample)
 
 	}
 	//Save samples if specified
@@ -190,8 +117,139 @@ func CreateMetricSets(samples []interface{}, config *load.Config, i int, mergeMe
 	}
 }
 
+// runDefaultPipeline runs the stages of DefaultPipeline, the key stages up to rename_samples run key by key in a single pass
+// returns the sample and whether it should be created
+func runDefaultPipeline(config *load.Config, api load.API, currentSample map[string]interface{}, eventType *string, sampleTrace *explain.Sample) (map[string]interface{}, bool) {
+	// modify existing sample before final processing
+	SkipProcessing := api.SkipProcessing
+
+	var modifiedKeys []string
+	for k, v := range currentSample { // k == original key
+		key := k
+		RunKeyConversion(&key, api, v, &SkipProcessing)
+		sampleTrace.KeyStep("RunKeyConversion", k, currentSample, key, v)
+		RunValConversion(&v, api, &key)
+		sampleTrace.KeyStep("RunValConversion", k, currentSample, key, v)
+		RunValueParser(&v, api, &key)
+		sampleTrace.KeyStep("RunValueParser", k, currentSample, key, v)
+		RunPluckNumbers(&v, api, &key)
+		sampleTrace.KeyStep("RunPluckNumbers", k, currentSample, key, v)
+		RunSubParse(api.SubParse, &currentSample, key, v) // subParse key pairs (see redis example)
+		sampleTrace.KeyStep("RunSubParse", k, currentSample, key, v)
+		RunValueTransformer(&v, api, &key) // Needs to be run before KeyRenamer and KeyReplacer
+		sampleTrace.KeyStep("RunValueTransformer", k, currentSample, key, v)
+		RunValueMapper(api.ValueMapper, &currentSample, key, &v) // valueMapper
+		sampleTrace.KeyStep("RunValueMapper", k, currentSample, key, v)
+
+		RunTimestampConversion(&v, api, &key)
+		sampleTrace.KeyStep("RunTimestampConversion", k, currentSample, key, v)
+		// find keys with regex, convert date<=>timestamp
+		// timestamp_conversion:
+		//   started_at: TIMESTAMP::RFC3339
+		//   endtime: DATE::RFC3339
+		// do not rename a key again, this is to avoid continuous replacement loops
+		// eg. if you replace id with project.id
+		// this could then again attempt to replace id within project.id to project.project.id
+		if !sliceContains(modifiedKeys, k) {
+			RunKeyRenamer(api.RenameKeys, &key)  // use key renamer if key replace hasn't occurred
+			RunKeyRenamer(api.ReplaceKeys, &key) // kept for backwards compatibility with replace_keys
+			sampleTrace.KeyStep("RunKeyRenamer", k, currentSample, key, v)
+		}
+
+		currentSample[key] = v
+		if key != k {
+			modifiedKeys = append(modifiedKeys, key)
+			delete(currentSample, k)
+		}
+
+		// if keepkeys used will do inverse
+		RunKeepKeys(api.KeepKeys, &key, &currentSample)
+		sampleTrace.Step("RunKeepKeys", currentSample)
+		RunSampleRenamer(api.RenameSamples, &currentSample, key, eventType)
+		sampleTrace.Step("RunSampleRenamer", currentSample)
+	}
+
+	// the stages after the key stages run one after the other
+	// addAttribute is kept outside the key loop intentionally
+	// if an attribute is added to the currentSample while in the loop it will restart the loop
+	data := &StageData{Config: config, API: api, Sample: currentSample, EventType: *eventType}
+	for _, name := range DefaultPipeline[keyStages():] {
+		// samples of an api with ignore_output are kept for lookups, and not filtered or processed further
+		if name == "sample_filter" && api.IgnoreOutput {
+			ignoreSample(config.State(), data.Sample, data.EventType, sampleTrace)
+			return data.Sample, false
+		}
+		if name == "remove_keys" {
+			setBaseURL(config, api, data.Sample)
+		}
+		builtinStages[name](data)
+		sampleTrace.Step(name, data.Sample)
+		if data.Drop {
+			sampleTrace.Drop(data.DropReason)
+			return data.Sample, false
+		}
+	}
+	*eventType = data.EventType
+	return data.Sample, true
+}
+
+// setBaseURL injects the base url of the config in a sample, before remove_keys so it can be removed
+func setBaseURL(config *load.Config, api load.API, currentSample map[string]interface{}) {
+	if config.Global.BaseURL != "" && !api.IgnoreOutput {
+		currentSample["baseUrl"] = config.Global.BaseURL
+	}
+}
+
+// ignoreSample keeps a sample of an api with ignore_output, for lookups only
+// useful when requests are made to generate a lookup, but the data is not needed
+func ignoreSample(state *load.State, currentSample map[string]interface{}, eventType string, sampleTrace *explain.Sample) {
+	currentSample["event_type"] = eventType
+	state.IgnoredAppend(currentSample)
+	sampleTrace.Drop("ignore_output")
+}
+
+// filterSample applies the sample filters of an api, returns whether the sample passes or the filter that dropped it
+func filterSample(api load.API, currentSample map[string]interface{}) (bool, string) {
+	runSampleFilterExperimental := true
+	// check if this contains any key pair values to filter out
+	excludeSample := true
+	// evalute sample_include_filter if sample_include_match_all_filter is not specified
+	if len(api.SampleIncludeMatchAllFilter) != 0 {
+		// don't exclude sample if the multi key filter is specified
+		excludeSample = false
+	} else {
+		// check if the sample passes sample_include_filter, if no sample_include_filter defined, the sample will pass by default.
+		if api.SampleIncludeFilter == nil || len(api.SampleIncludeFilter) == 0 {
+			excludeSample = false
+		} else {
+			RunSampleFilter(currentSample, api.SampleIncludeFilter, &excludeSample)
+			runSampleFilterExperimental = false
+			if excludeSample {
+				return false, "sample_include_filter"
+			}
+		}
+	}
+	// check sample_exclude_filter and sample_filter, only if it passes sample_include_filter filter or there is no sample_include_filter defined
+	createSample := true
+	if runSampleFilterExperimental {
+		RunSampleFilterMatchAll(currentSample, api.SampleIncludeMatchAllFilter, &createSample)
+		if !createSample {
+			return false, "sample_include_match_all_filter"
+		}
+	}
+	RunSampleFilter(currentSample, api.SampleFilter, &createSample)
+	if !createSample {
+		return false, "sample_filter"
+	}
+	RunSampleFilter(currentSample, api.SampleExcludeFilter, &createSample)
+	if !createSample {
+		return false, "sample_exclude_filter"
+	}
+	return true, ""
+}
+
 // setInventory sets infrastructure inventory metrics
-func setInventory(entity *integration.Entity, inventory map[string]string, k string, v interface{}) {
+func setInventory(entity load.EntityWriter, inventory map[string]string, k string, v interface{}) {
 	if inventory[k] != "" {
 		if inventory[k] == "value" {
 			checkError(entity.SetInventoryItem(k, "value", v))
@@ -202,7 +260,7 @@ func setInventory(entity *integration.Entity, inventory map[string]string, k str
 }
 
 // setInventory sets infrastructure inventory metrics
-func setEvents(entity *integration.Entity, inventory map[string]string, k string, v interface{}) {
+func setEvents(entity load.EntityWriter, inventory map[string]string, k string, v interface{}) {
 	if inventory[k] != "" {
 		value := cleanValue(&v)
 		if inventory[k] != "default" {
@@ -219,19 +277,19 @@ func setEvents(entity *integration.Entity, inventory map[string]string, k string
 	}
 }
 
-// setEntity sets the entity to be used for the configured API
+// setEntity sets the entity of the state to be used for the configured API
 // defaults the type aka namespace to instance
-func setEntity(entity string, customNamespace string) *integration.Entity {
+func setEntity(state *load.State, entity string, customNamespace string) load.EntityWriter {
 	if entity != "" {
 		if customNamespace == "" {
 			customNamespace = "instance"
 		}
-		workingEntity, err := load.Integration.Entity(entity, customNamespace)
+		workingEntity, err := state.EntityNamed(entity, customNamespace)
 		if err == nil {
 			return workingEntity
 		}
 	}
-	return load.Entity
+	return state.Entity()
 }
 
 func cleanEvent(event string) string {
@@ -378,7 +436,7 @@ func RunKeyFilter(filters []load.Filter, currentSample *map[string]interface{},
 }
 
 // AutoSetMetricAPI automatically set metrics for use with the metric api
-func AutoSetMetricAPI(currentSample *map[string]interface{}, api *load.API) {
+func AutoSetMetricAPI(currentSample *map[string]interface{}, api *load.API, state *load.State) {
 	// set current time
 	currentTime := time.Now().UnixNano() / 1e+6
 	// set common attributes
@@ -390,6 +448,7 @@ func AutoSetMetricAPI(currentSample *map[string]interface{}, api *load.API) {
 	// store numeric values, as metrics within Metrics
 	var Metrics []map[string]interface{}
 	SummaryMetrics := map[string]map[string]float64{}
+	var Counters []pendingCounter
 
 	//add sample metrics
 	for k, v := range *currentSample {
@@ -414,12 +473,20 @@ func AutoSetMetricAPI(currentSample *map[string]interface{}, api *load.API) {
 				if k == metricKey {
 					currentMetric["type"] = "count"
 					currentMetric["interval.ms"] = intervalMs
-					load.StatusCounterIncrement("CounterMetrics")
+					state.StatusCounterIncrement("CounterMetrics")
 					Metrics = append(Metrics, currentMetric)
 					break
 				}
 			}
 
+			// check if cumulative counter, converted to a count once all common attributes are known
+			if currentMetric["type"] == "" {
+				if metricType := counterType(k, api.MetricParser.Metrics, api.MetricParser.AutoSet, api.MetricParser.Mode); metricType != "" {
+					Counters = append(Counters, pendingCounter{name: k, value: parsed, metricType: metricType})
+					currentMetric["type"] = "count"
+				}
+			}
+
 			// check if summary
 			if currentMetric["type"] == "" {
 				for rootSummary, metricTypes := range (*api).MetricParser.Summaries {
@@ -443,7 +510,7 @@ func AutoSetMetricAPI(currentSample *map[string]interface{}, api *load.API) {
 			// if type still not set, default to gauge
 			if currentMetric["type"] == "" {
 				currentMetric["type"] = "gauge"
-				load.StatusCounterIncrement("GaugeMetrics")
+				state.StatusCounterIncrement("GaugeMetrics")
 				Metrics = append(Metrics, currentMetric)
 			}
 		}
@@ -461,7 +528,15 @@ func AutoSetMetricAPI(currentSample *map[string]interface{}, api *load.API) {
 				"type":        "summary",
 				"interval.ms": intervalParsed,
 			}
-			load.StatusCounterIncrement("SummaryMetrics")
+			state.StatusCounterIncrement("SummaryMetrics")
+			Metrics = append(Metrics, currentMetric)
+		}
+	}
+
+	// add cumulative counters as counts of their increase since the previous execution
+	for _, counter := range Counters {
+		if currentMetric := counterDelta(state.Storer(), counter, commonAttributes, currentTime); currentMetric != nil {
+			state.StatusCounterIncrement("CounterMetrics")
 			Metrics = append(Metrics, currentMetric)
 		}
 	}
@@ -472,13 +547,15 @@ func AutoSetMetricAPI(currentSample *map[string]interface{}, api *load.API) {
 		Metrics:          Metrics,
 	}
 
-	load.MetricsStoreAppend(MetricsPayload)
+	state.MetricsStoreAppend(MetricsPayload)
 }
 
 // AutoSetStandard x
-func AutoSetStandard(currentSample *map[string]interface{}, api *load.API, workingEntity *integration.Entity, eventType string, config *load.Config) {
-	load.StatusCounterIncrement("EventCount")
-	load.StatusCounterIncrement(eventType)
+func AutoSetStandard(currentSample *map[string]interface{}, api *load.API, workingEntity load.EntityWriter, eventType string, config *load.Config) {
+	state := config.State()
+	state.StatusCounterIncrement("EventCount")
+	state.StatusCounterIncrement(eventType)
+	state.ConfigEvent(config.Name, api.Name)
 
 	var metricSet *metric.Set
 	// if metric parser is used, we need to namespace metrics for rate and delta support
@@ -541,26 +618,34 @@ func AutoSetStandard(currentSample *map[string]interface{}, api *load.API, worki
 		} else {
 			// these can be set async
 			var wg sync.WaitGroup
+			var panics load.Panics
 			wg.Add(3)
 			go func() {
 				defer wg.Done()
+				defer panics.Recover(api.Name)
 				setInventory(workingEntity, api.Inventory, k, v)
 			}()
 			go func() {
 				defer wg.Done()
+				defer panics.Recover(api.Name)
 				setEvents(workingEntity, api.Events, k, v)
 			}()
 			go func() {
 				defer wg.Done()
-				AutoSetMetricInfra(k, v, metricSet, api.MetricParser.Metrics, api.MetricParser.AutoSet, api.MetricParser.Mode)
+				defer panics.Recover(api.Name)
+				AutoSetMetricInfra(k, v, metricSet, api.MetricParser.Metrics, api.MetricParser.AutoSet, api.MetricParser.Mode, state)
 			}()
 			wg.Wait()
+			panics.Raise()
 		}
 	}
+
+	// when recording fixtures, the samples produced become the expected samples of the config
+	fixture.AddSample(config.Name, metricSet.Metrics)
 }
 
 // AutoSetMetricInfra parse to number
-func AutoSetMetricInfra(k string, v interface{}, metricSet *metric.Set, metrics map[string]string, autoSet bool, mode string) {
+func AutoSetMetricInfra(k string, v interface{}, metricSet *metric.Set, metrics map[string]string, autoSet bool, mode string, state *load.State) {
 	value := cleanValue(&v)
 	parsed, err := strconv.ParseFloat(value, 64)
 
@@ -572,19 +657,19 @@ func AutoSetMetricInfra(k string, v interface{}, metricSet *metric.Set, metrics
 			if (k == metricKey) || (autoSet && formatter.KvFinder(regex, k, metricKey)) || (mode != "" && formatter.KvFinder(mode, k, metricKey)) {
 				if metricVal == "RATE" {
 					foundKey = true
-					checkError(metricSet.SetMetric(k, parsed, metric.RATE))
+					setRateMetric(state, metricSet, k, metricVal, parsed, metric.RATE, metric.DELTA)
 					break
 				} else if metricVal == "PRATE" {
 					foundKey = true
-					checkError(metricSet.SetMetric(k, parsed, metric.PRATE))
+					setRateMetric(state, metricSet, k, metricVal, parsed, metric.PRATE, metric.PDELTA)
 					break
 				} else if metricVal == "DELTA" {
 					foundKey = true
-					checkError(metricSet.SetMetric(k, parsed, metric.DELTA))
+					setMetricType(state, metricSet, k, metricVal, parsed, metricSet.SetMetric(k, parsed, metric.DELTA))
 					break
 				} else if metricVal == "PDELTA" {
 					foundKey = true
-					checkError(metricSet.SetMetric(k, parsed, metric.PDELTA))
+					setMetricType(state, metricSet, k, metricVal, parsed, metricSet.SetMetric(k, parsed, metric.PDELTA))
 					break
 				} else if metricVal == "ATTRIBUTE" {
 					foundKey = true
@@ -599,6 +684,33 @@ func AutoSetMetricInfra(k string, v interface{}, metricSet *metric.Set, metrics
 	}
 }
 
+// setRateMetric sets a RATE or PRATE key, and records the increment of its counter since the previous value for outputs that send counters
+// the sdk computes the increment as a delta of a key of its own, so the previous value is kept next to the one of the rate
+func setRateMetric(state *load.State, metricSet *metric.Set, k string, metricType string, value float64, rateType metric.SourceType, deltaType metric.SourceType) {
+	incrementKey := k + ".flexIncrement"
+	incrementErr := metricSet.SetMetric(incrementKey, value, deltaType)
+	increment, _ := metricSet.Metrics[incrementKey].(float64)
+	delete(metricSet.Metrics, incrementKey)
+
+	err := metricSet.SetMetric(k, value, rateType)
+	setMetricType(state, metricSet, k, metricType, value, err)
+	if err == nil && incrementErr == nil {
+		state.MetricIncrementSet(metricSet, k, increment)
+	}
+}
+
+// setMetricType records the type of a metric that was set, for outputs that keep it
+// rates also keep the cumulative value they were computed from, for outputs that send counters as they are
+func setMetricType(state *load.State, metricSet *metric.Set, k string, metricType string, cumulative float64, err error) {
+	checkError(err)
+	if err == nil {
+		state.MetricTypeSet(metricSet, k, metricType)
+		if metricType == "RATE" || metricType == "PRATE" {
+			state.MetricCumulativeSet(metricSet, k, cumulative)
+		}
+	}
+}
+
 func addAttribute(currentSample map[string]interface{}, addAttribute map[string]string) {
 	// add attribute, use attributes from current sample to create new attributes like http links
 	for key, val := range addAttribute {
@@ -6,7 +6,11 @@ package processor
 
 import (
 	"testing"
+	"time"
 
+	"github.com/newrelic/infra-integrations-sdk/data/attribute"
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/persist"
 	"github.com/newrelic/nri-flex/internal/load"
 	"github.com/stretchr/testify/assert"
 )
@@ -69,3 +73,42 @@ func TestRunSampleFilter(t *testing.T) {
 	assert.Equal(t, expectedResult, createSample)
 
 }
+
+func TestAutoSetMetricInfraTypes(t *testing.T) {
+	store := persist.NewInMemoryStore()
+	set := metric.NewSet("redisSample", store, attribute.Attr("namespace", "redis-1"))
+	metrics := map[string]string{"ops": "PRATE", "commands": "DELTA"}
+
+	AutoSetMetricInfra("ops", 1500, set, metrics, false, "", nil)
+	AutoSetMetricInfra("commands", 30, set, metrics, false, "", nil)
+	AutoSetMetricInfra("clients", 5, set, metrics, false, "", nil)
+	defer load.MetricTypesReset()
+
+	// rates keep the counter they were computed from
+	assert.Equal(t, "PRATE", load.MetricType(set, "ops"))
+	assert.Equal(t, float64(1500), load.MetricCumulative(set, "ops"))
+	assert.Equal(t, "DELTA", load.MetricType(set, "commands"))
+	assert.Zero(t, load.MetricCumulative(set, "commands"))
+	assert.Empty(t, load.MetricType(set, "clients"))
+}
+
+func TestAutoSetMetricInfraRateIncrement(t *testing.T) {
+	store := persist.NewInMemoryStore()
+	metrics := map[string]string{"ops": "PRATE"}
+	defer load.MetricTypesReset()
+
+	first := metric.NewSet("redisSample", store, attribute.Attr("namespace", "redis-1"))
+	AutoSetMetricInfra("ops", 1500, first, metrics, false, "", nil)
+	assert.Zero(t, load.MetricIncrement(first, "ops"), "the first value has nothing to compare to")
+
+	// the store keeps values by the second
+	time.Sleep(1100 * time.Millisecond)
+	second := metric.NewSet("redisSample", store, attribute.Attr("namespace", "redis-1"))
+	AutoSetMetricInfra("ops", 1530, second, metrics, false, "", nil)
+
+	assert.Equal(t, float64(30), load.MetricIncrement(second, "ops"))
+	assert.Equal(t, float64(1530), load.MetricCumulative(second, "ops"))
+	assert.Greater(t, second.Metrics["ops"], float64(0))
+	assert.LessOrEqual(t, second.Metrics["ops"], float64(30), "the rate is the increment per second")
+	assert.NotContains(t, second.Metrics, "ops.flexIncrement")
+}
@@ -10,6 +10,7 @@ import (
 	"strings"
 
 	"github.com/itchyny/gojq"
+	"github.com/newrelic/nri-flex/internal/explain"
 	"github.com/newrelic/nri-flex/internal/load"
 	"github.com/sirupsen/logrus"
 )
@@ -50,9 +51,13 @@ func processDataSet(dataSet *map[string]interface{}, samplesToMerge *load.Sample
 		cfg.LookupStore = map[string]map[string]struct{}{}
 	}
 
+	trace := explain.NewDataSet(cfg.Name, cfg.APIs[i].Name, ds) // nil unless running with explain
+
 	FindStartKey(&ds, cfg.APIs[i].StartKey, cfg.APIs[i].InheritAttributes) // start at a later part in the received data
-	StripKeys(&ds, cfg.APIs[i].StripKeys)                                  // remove before flattening
-	RunLazyFlatten(&ds, cfg, i)                                            // perform lazy flatten if needed
+	trace.StartKey(ds)
+	StripKeys(&ds, cfg.APIs[i].StripKeys) // remove before flattening
+	trace.StripKeys(ds)
+	RunLazyFlatten(&ds, cfg, i) // perform lazy flatten if needed
 	flattenedData := FlattenData(ds, map[string]interface{}{}, "", cfg.APIs[i].SampleKeys, &cfg.APIs[i])
 
 	// also strip from flattened data
@@ -62,11 +67,12 @@ func processDataSet(dataSet *map[string]interface{}, samplesToMerge *load.Sample
 	}
 
 	mergedData := FinalMerge(flattenedData)
+	trace.Flattened(mergedData)
 
 	if cfg.APIs[i].Merge == "" {
-		CreateMetricSets(mergedData, cfg, i, false, nil, originalAPINo)
+		createMetricSets(mergedData, cfg, i, false, nil, originalAPINo, trace)
 	} else {
-		CreateMetricSets(mergedData, cfg, i, true, samplesToMerge, originalAPINo)
+		createMetricSets(mergedData, cfg, i, true, samplesToMerge, originalAPINo, trace)
 	}
 }
 
@@ -3,6 +3,7 @@ package processor
 import (
 	"testing"
 
+	"github.com/newrelic/nri-flex/internal/explain"
 	"github.com/newrelic/nri-flex/internal/load"
 	"github.com/stretchr/testify/assert"
 )
@@ -70,3 +71,58 @@ func TestRunJqMultipleResults(t *testing.T) {
 
 	assert.Equal(t, expectedResult, dataSets)
 }
+
+func TestProcessDataSetExplain(t *testing.T) {
+	explain.Start()
+	defer explain.Stop()
+
+	cfg := load.Config{
+		Name: "explainConfig",
+		APIs: []load.API{{
+			Name:                "items",
+			Merge:               "mergedSample",
+			StartKey:            []string{"data"},
+			RenameKeys:          map[string]string{"name": "itemName"},
+			ValueParser:         map[string]string{"latency": "[0-9]+"},
+			SampleExcludeFilter: []map[string]string{{"itemName": "two"}},
+		}},
+	}
+	var samplesToMerge load.SamplesToMerge
+	samplesToMerge.Data = map[string][]interface{}{}
+
+	ds := map[string]interface{}{
+		"data": map[string]interface{}{
+			"items": []interface{}{
+				map[string]interface{}{"name": "one", "latency": "5ms"},
+				map[string]interface{}{"name": "two", "latency": "7ms"},
+			},
+		},
+	}
+	processDataSet(&ds, &samplesToMerge, 0, &cfg, 0)
+
+	trace := explain.Stop()
+	assert.Len(t, trace.DataSets, 1)
+	dataSet := trace.DataSets[0]
+	assert.Equal(t, "items", dataSet.API)
+	assert.Contains(t, dataSet.Raw, "data")
+	assert.Contains(t, dataSet.AfterStartKey, "items")
+	assert.Len(t, dataSet.Samples, 2)
+
+	for _, sample := range dataSet.Samples {
+		steps := map[string]bool{}
+		for _, step := range sample.Steps {
+			steps[step.Name] = true
+		}
+		assert.True(t, steps["RunKeyRenamer"])
+		assert.True(t, steps["RunValueParser"])
+
+		switch sample.Input["name"] {
+		case "one":
+			assert.Empty(t, sample.DroppedBy)
+			assert.Equal(t, "5", sample.Output["latency"])
+		case "two":
+			assert.Equal(t, "sample_exclude_filter", sample.DroppedBy)
+			assert.Nil(t, sample.Output)
+		}
+	}
+}
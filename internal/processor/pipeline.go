@@ -0,0 +1,308 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package processor
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/newrelic/nri-flex/internal/explain"
+	"github.com/newrelic/nri-flex/internal/load"
+	"github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// StageData is the sample a stage of a pipeline processes
+type StageData struct {
+	Config     *load.Config
+	API        load.API // for built in stages, the keys of the step override those of the api
+	Step       load.PipelineStep
+	Sample     map[string]interface{}
+	EventType  string
+	Drop       bool   // set to drop the sample, the stages after it do not run
+	DropReason string // reported by the explain trace
+}
+
+// Stage processes a sample at a step of a pipeline
+type Stage func(data *StageData)
+
+// DefaultPipeline is the order stages run in for apis that do not set a pipeline
+// it is run by runDefaultPipeline, which applies the stages up to rename_samples key by key in a single pass
+var DefaultPipeline = []string{
+	"key_conversion",
+	"value_conversion",
+	"value_parser",
+	"pluck_numbers",
+	"sub_parse",
+	"value_transformer",
+	"value_mapper",
+	"timestamp_conversion",
+	"rename_keys",
+	"keep_keys",
+	"rename_samples",
+	"add_attribute",
+	"store_lookups",
+	"sample_filter",
+	"math",
+	"remove_keys",
+}
+
+// keyStages returns the number of stages of DefaultPipeline the default pipeline applies key by key
+func keyStages() int {
+	for n, name := range DefaultPipeline {
+		if name == "rename_samples" {
+			return n + 1
+		}
+	}
+	return 0
+}
+
+// builtinStages are configured by the keys of the api
+var builtinStages = map[string]Stage{
+	"key_conversion": func(data *StageData) {
+		eachKey(data.Sample, func(key *string, v *interface{}) {
+			RunKeyConversion(key, data.API, *v, nil)
+		})
+	},
+	"value_conversion": func(data *StageData) {
+		eachKey(data.Sample, func(key *string, v *interface{}) {
+			RunValConversion(v, data.API, key)
+		})
+	},
+	"value_parser": func(data *StageData) {
+		eachKey(data.Sample, func(key *string, v *interface{}) {
+			RunValueParser(v, data.API, key)
+		})
+	},
+	"pluck_numbers": func(data *StageData) {
+		eachKey(data.Sample, func(key *string, v *interface{}) {
+			RunPluckNumbers(v, data.API, key)
+		})
+	},
+	"sub_parse": func(data *StageData) {
+		eachKey(data.Sample, func(key *string, v *interface{}) {
+			RunSubParse(data.API.SubParse, &data.Sample, *key, *v)
+		})
+	},
+	"value_transformer": func(data *StageData) {
+		eachKey(data.Sample, func(key *string, v *interface{}) {
+			RunValueTransformer(v, data.API, key)
+		})
+	},
+	"value_mapper": func(data *StageData) {
+		eachKey(data.Sample, func(key *string, v *interface{}) {
+			RunValueMapper(data.API.ValueMapper, &data.Sample, *key, v)
+		})
+	},
+	"timestamp_conversion": func(data *StageData) {
+		eachKey(data.Sample, func(key *string, v *interface{}) {
+			RunTimestampConversion(v, data.API, key)
+		})
+	},
+	"rename_keys": func(data *StageData) {
+		eachKey(data.Sample, func(key *string, v *interface{}) {
+			RunKeyRenamer(data.API.RenameKeys, key)
+			RunKeyRenamer(data.API.ReplaceKeys, key) // kept for backwards compatibility with replace_keys
+		})
+	},
+	"keep_keys": func(data *StageData) {
+		for _, key := range sortedKeys(data.Sample) {
+			RunKeepKeys(data.API.KeepKeys, &key, &data.Sample)
+		}
+	},
+	"rename_samples": func(data *StageData) {
+		for _, key := range sortedKeys(data.Sample) {
+			RunSampleRenamer(data.API.RenameSamples, &data.Sample, key, &data.EventType)
+		}
+	},
+	"add_attribute": func(data *StageData) {
+		addAttribute(data.Sample, data.API.AddAttribute)
+	},
+	"store_lookups": func(data *StageData) {
+		// skipped with run_async due to potential concurrent map writes, as in the default pipeline
+		if data.API.RunAsync {
+			return
+		}
+		for k, v := range data.Sample {
+			StoreLookups(data.API.StoreLookups, &data.Config.LookupStore, k, v)
+			VariableLookups(data.API.StoreVariables, &data.Config.VariableStore, k, v)
+		}
+	},
+	"sample_filter": func(data *StageData) {
+		createSample, dropReason := filterSample(data.API, data.Sample)
+		data.Drop, data.DropReason = !createSample, dropReason
+	},
+	"math": func(data *StageData) {
+		RunMathCalculations(&data.API.Math, &data.Sample)
+	},
+	"remove_keys": func(data *StageData) {
+		RunKeyRemover(&data.Sample, data.API.RemoveKeys)
+	},
+}
+
+// stages holds the stages registered by other packages
+var stages = struct {
+	sync.RWMutex
+	M map[string]Stage
+}{M: make(map[string]Stage)}
+
+// RegisterStage adds a stage that pipelines can refer to by name
+// it panics if a stage of the same name exists, like database/sql does for drivers
+func RegisterStage(name string, stage Stage) {
+	stages.Lock()
+	defer stages.Unlock()
+	if _, ok := builtinStages[name]; ok {
+		panic(fmt.Sprintf("processor: RegisterStage called for built in stage %s", name))
+	}
+	if _, ok := stages.M[name]; ok {
+		panic(fmt.Sprintf("processor: RegisterStage called twice for stage %s", name))
+	}
+	stages.M[name] = stage
+}
+
+// pipelineStep is a step of a pipeline ready to run
+type pipelineStep struct {
+	stage Stage
+	api   load.API
+	step  load.PipelineStep
+}
+
+// ValidatePipeline checks that every stage of the pipeline of an api exists, and that built in stages can apply their keys
+func ValidatePipeline(api load.API) []error {
+	var errors []error
+	for n, step := range api.Pipeline {
+		if _, err := newPipelineStep(api, step); err != nil {
+			errors = append(errors, fmt.Errorf("pipeline[%d]: %v", n, err))
+		}
+	}
+	return errors
+}
+
+// buildPipeline prepares the pipeline of an api, steps that cannot run are logged and left out
+func buildPipeline(configName string, api load.API) []pipelineStep {
+	var pipeline []pipelineStep
+	for n, step := range api.Pipeline {
+		ready, err := newPipelineStep(api, step)
+		if err != nil {
+			load.Logrus.WithFields(logrus.Fields{
+				"name": configName,
+				"api":  api.Name,
+				"step": n,
+			}).WithError(err).Error("processor: skipping pipeline step")
+			continue
+		}
+		pipeline = append(pipeline, ready)
+	}
+	return pipeline
+}
+
+func newPipelineStep(api load.API, step load.PipelineStep) (pipelineStep, error) {
+	if stage, ok := builtinStages[step.Stage]; ok {
+		stepAPI, err := applyStepKeys(api, step)
+		if err != nil {
+			return pipelineStep{}, fmt.Errorf("stage %s: %v", step.Stage, err)
+		}
+		return pipelineStep{stage: stage, api: stepAPI, step: step}, nil
+	}
+
+	stages.RLock()
+	stage, ok := stages.M[step.Stage]
+	stages.RUnlock()
+	if !ok {
+		return pipelineStep{}, fmt.Errorf("unknown stage %q", step.Stage)
+	}
+	return pipelineStep{stage: stage, api: api, step: step}, nil
+}
+
+// applyStepKeys returns a copy of api with the keys of step in place of its own
+func applyStepKeys(api load.API, step load.PipelineStep) (load.API, error) {
+	if len(step.Config) == 0 {
+		return api, nil
+	}
+	b, err := yaml.Marshal(api)
+	if err != nil {
+		return api, err
+	}
+	keys := map[string]interface{}{}
+	if err := yaml.Unmarshal(b, &keys); err != nil {
+		return api, err
+	}
+	for k, v := range step.Config {
+		keys[k] = v
+	}
+	if b, err = yaml.Marshal(keys); err != nil {
+		return api, err
+	}
+	var stepAPI load.API
+	if err := yaml.UnmarshalStrict(b, &stepAPI); err != nil {
+		return api, err
+	}
+	return stepAPI, nil
+}
+
+// runPipeline runs each step of a pipeline on a sample
+// returns the sample, which stages may replace, and whether it should be created
+func runPipeline(pipeline []pipelineStep, config *load.Config, api load.API, currentSample map[string]interface{}, eventType *string, sampleTrace *explain.Sample) (map[string]interface{}, bool) {
+	data := &StageData{Config: config, Sample: currentSample, EventType: *eventType}
+	baseURL := false
+	for _, step := range pipeline {
+		data.API, data.Step = step.api, step.step
+		// the base url is injected before remove_keys, as in the default pipeline
+		if step.step.Stage == "remove_keys" && !baseURL {
+			setBaseURL(config, api, data.Sample)
+			baseURL = true
+		}
+		step.stage(data)
+		sampleTrace.Step(step.step.Stage, data.Sample)
+		if data.Drop {
+			if data.DropReason == "" {
+				data.DropReason = step.step.Stage
+			}
+			break
+		}
+	}
+	*eventType = data.EventType
+
+	// a dropped sample is not kept for lookups either, the stages declared filter what lookups see
+	if data.Drop {
+		sampleTrace.Drop(data.DropReason)
+		return data.Sample, false
+	}
+	if api.IgnoreOutput {
+		ignoreSample(config.State(), data.Sample, *eventType, sampleTrace)
+		return data.Sample, false
+	}
+	if !baseURL {
+		setBaseURL(config, api, data.Sample)
+	}
+	return data.Sample, true
+}
+
+// eachKey calls fn with every key and value of a sample, renaming the key or replacing the value as fn sets them
+func eachKey(sample map[string]interface{}, fn func(key *string, v *interface{})) {
+	for _, k := range sortedKeys(sample) {
+		v, ok := sample[k]
+		if !ok {
+			continue
+		}
+		key := k
+		fn(&key, &v)
+		if key != k {
+			delete(sample, k)
+		}
+		sample[key] = v
+	}
+}
+
+func sortedKeys(sample map[string]interface{}) []string {
+	keys := make([]string, 0, len(sample))
+	for k := range sample {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
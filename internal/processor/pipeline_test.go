@@ -0,0 +1,296 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+package processor
+
+import (
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/nri-flex/internal/load"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// runPipelineConfig creates the samples of the first api of a config and returns them
+func runPipelineConfig(t *testing.T, yml string, samples ...map[string]interface{}) []map[string]interface{} {
+	load.Refresh()
+	i, _ := integration.New(load.IntegrationName, load.IntegrationVersion)
+	load.Entity, _ = i.Entity("TestPipeline", "nri-flex")
+
+	var config load.Config
+	require.NoError(t, yaml.UnmarshalStrict([]byte(yml), &config))
+
+	var data []interface{}
+	for _, sample := range samples {
+		data = append(data, sample)
+	}
+	CreateMetricSets(data, &config, 0, false, nil, 0)
+
+	var created []map[string]interface{}
+	for _, metricSet := range load.Entity.Metrics {
+		created = append(created, metricSet.Metrics)
+	}
+	return created
+}
+
+func TestPipelineOrder(t *testing.T) {
+	tests := map[string]struct {
+		yml      string
+		sample   map[string]interface{}
+		expected map[string]interface{}
+	}{
+		"rename before value_transformer": {
+			yml: `
+name: order
+apis:
+  - name: status
+    pipeline: [rename_keys, value_transformer]
+    rename_keys:
+      state: status
+    value_transformer:
+      status: "up"
+`,
+			sample:   map[string]interface{}{"state": "1"},
+			expected: map[string]interface{}{"status": "up"},
+		},
+		"add_attribute before math": {
+			yml: `
+name: order
+apis:
+  - name: status
+    pipeline: [add_attribute, math]
+    add_attribute:
+      total: ${used}
+    math:
+      free: ${total} - ${used}
+`,
+			sample:   map[string]interface{}{"used": 2},
+			expected: map[string]interface{}{"used": float64(2), "total": float64(2), "free": float64(0)},
+		},
+		"repeated stage with its own keys": {
+			yml: `
+name: repeat
+apis:
+  - name: status
+    pipeline:
+      - rename_keys
+      - math
+      - stage: rename_keys
+        rename_keys:
+          double: twice
+    rename_keys:
+      count: value
+    math:
+      double: ${value} * 2
+`,
+			sample:   map[string]interface{}{"count": 3},
+			expected: map[string]interface{}{"value": float64(3), "twice": float64(6)},
+		},
+		"only the declared stages run": {
+			yml: `
+name: declared
+apis:
+  - name: status
+    pipeline: [math]
+    rename_keys:
+      count: value
+    math:
+      double: ${count} * 2
+`,
+			sample:   map[string]interface{}{"count": 3},
+			expected: map[string]interface{}{"count": float64(3), "double": float64(6)},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			created := runPipelineConfig(t, tc.yml, tc.sample)
+			require.Len(t, created, 1)
+			for k, v := range tc.expected {
+				assert.Equal(t, v, created[0][k], k)
+			}
+			assert.Equal(t, "statusSample", created[0]["event_type"])
+		})
+	}
+}
+
+func TestPipelineFilter(t *testing.T) {
+	yml := `
+name: filter
+apis:
+  - name: disk
+    pipeline: [sample_filter, rename_keys]
+    sample_exclude_filter:
+      - mount: /boot
+    rename_keys:
+      mount: mountPoint
+`
+	created := runPipelineConfig(t, yml,
+		map[string]interface{}{"mount": "/boot"},
+		map[string]interface{}{"mount": "/"},
+	)
+	require.Len(t, created, 1)
+	assert.Equal(t, "/", created[0]["mountPoint"])
+}
+
+func TestPipelineFilterIgnoreOutput(t *testing.T) {
+	load.IgnoredIntegrationData = nil
+	defer func() { load.IgnoredIntegrationData = nil }()
+	yml := `
+name: filter
+apis:
+  - name: disk
+    ignore_output: true
+    pipeline: [sample_filter, rename_keys]
+    sample_exclude_filter:
+      - mount: /boot
+    rename_keys:
+      mount: mountPoint
+`
+	created := runPipelineConfig(t, yml,
+		map[string]interface{}{"mount": "/boot"},
+		map[string]interface{}{"mount": "/"},
+	)
+	assert.Empty(t, created)
+	// lookups only see the samples the declared filter kept
+	require.Len(t, load.IgnoredIntegrationData, 1)
+	assert.Equal(t, "/", load.IgnoredIntegrationData[0]["mountPoint"])
+	assert.Equal(t, "diskSample", load.IgnoredIntegrationData[0]["event_type"])
+}
+
+func TestRegisterStage(t *testing.T) {
+	RegisterStage("testDouble", func(data *StageData) {
+		if v, ok := data.Sample["value"].(int); ok {
+			data.Sample["value"] = v * 2
+		}
+		data.Drop = data.Sample["value"] == 0
+	})
+	assert.Panics(t, func() { RegisterStage("testDouble", func(data *StageData) {}) })
+	assert.Panics(t, func() { RegisterStage("math", func(data *StageData) {}) })
+
+	yml := `
+name: custom
+apis:
+  - name: custom
+    pipeline: [testDouble, testDouble]
+`
+	created := runPipelineConfig(t, yml,
+		map[string]interface{}{"value": 3},
+		map[string]interface{}{"value": 0},
+	)
+	require.Len(t, created, 1)
+	assert.Equal(t, float64(12), created[0]["value"])
+}
+
+func TestValidatePipeline(t *testing.T) {
+	api := load.API{Pipeline: []load.PipelineStep{
+		{Stage: "math"},
+		{Stage: "unknown"},
+		{Stage: "rename_keys", Config: map[string]interface{}{"rename_kyes": map[string]interface{}{"a": "b"}}},
+	}}
+	errors := ValidatePipeline(api)
+	require.Len(t, errors, 2)
+	assert.Equal(t, `pipeline[1]: unknown stage "unknown"`, errors[0].Error())
+	assert.Contains(t, errors[1].Error(), "pipeline[2]: stage rename_keys: ")
+	assert.Contains(t, errors[1].Error(), "field rename_kyes not found")
+}
+
+func TestPipelineStepYAML(t *testing.T) {
+	yml := `
+- math
+- stage: rename_keys
+  rename_keys:
+    a: b
+`
+	var steps []load.PipelineStep
+	require.NoError(t, yaml.Unmarshal([]byte(yml), &steps))
+	require.Len(t, steps, 2)
+	assert.Equal(t, load.PipelineStep{Stage: "math"}, steps[0])
+	assert.Equal(t, "rename_keys", steps[1].Stage)
+	assert.Contains(t, steps[1].Config, "rename_keys")
+
+	// apis are marshalled and read back to create lookups
+	b, err := yaml.Marshal(steps)
+	require.NoError(t, err)
+	var decoded []load.PipelineStep
+	require.NoError(t, yaml.Unmarshal(b, &decoded))
+	assert.Equal(t, steps, decoded)
+
+	assert.Error(t, yaml.Unmarshal([]byte(`- rename_keys: {a: b}`), &steps), "a mapping requires a stage")
+}
+
+func TestPipelineBaseURL(t *testing.T) {
+	tests := map[string]struct {
+		yml     string
+		baseURL bool
+	}{
+		"default pipeline": {
+			yml: `
+name: base
+global:
+  base_url: http://localhost:8080/
+apis:
+  - name: status
+`,
+			baseURL: true,
+		},
+		"default pipeline remove_keys": {
+			yml: `
+name: base
+global:
+  base_url: http://localhost:8080/
+apis:
+  - name: status
+    remove_keys: [baseUrl]
+`,
+		},
+		"pipeline without remove_keys": {
+			yml: `
+name: base
+global:
+  base_url: http://localhost:8080/
+apis:
+  - name: status
+    pipeline: [math]
+    math:
+      double: ${count} * 2
+`,
+			baseURL: true,
+		},
+		"pipeline remove_keys": {
+			yml: `
+name: base
+global:
+  base_url: http://localhost:8080/
+apis:
+  - name: status
+    pipeline: [math, remove_keys]
+    remove_keys: [baseUrl]
+    math:
+      double: ${count} * 2
+`,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			created := runPipelineConfig(t, tc.yml, map[string]interface{}{"count": 3})
+			require.Len(t, created, 1)
+			if tc.baseURL {
+				assert.Equal(t, "http://localhost:8080/", created[0]["baseUrl"])
+			} else {
+				assert.NotContains(t, created[0], "baseUrl")
+			}
+		})
+	}
+}
+
+func TestDefaultPipelineStages(t *testing.T) {
+	require.NotZero(t, keyStages())
+	for _, name := range DefaultPipeline {
+		assert.Contains(t, builtinStages, name)
+	}
+}
@@ -0,0 +1,215 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/newrelic/nri-flex/internal/config"
+	"github.com/newrelic/nri-flex/internal/load"
+	"github.com/newrelic/nri-flex/internal/outputs"
+	"github.com/newrelic/nri-flex/internal/status"
+	"github.com/sirupsen/logrus"
+)
+
+// RunFlexDaemon runs Flex as a long-running process
+// each config is scheduled on its own interval and results are published after every tick
+func RunFlexDaemon(instance Instance) error {
+	setStatusCounters()
+
+	log.WithFields(logrus.Fields{
+		"version": load.IntegrationVersion,
+		"GOOS":    runtime.GOOS,
+		"GOARCH":  runtime.GOARCH,
+	}).Info(load.IntegrationName + " daemon")
+
+	defaultInterval, err := time.ParseDuration(load.Args.DaemonInterval)
+	if err != nil || defaultInterval <= 0 {
+		return fmt.Errorf("runtime.RunFlexDaemon: invalid daemon_interval '%s'", load.Args.DaemonInterval)
+	}
+
+	stopStatus, err := serveStatus()
+	if err != nil {
+		return err
+	}
+	defer stopStatus()
+
+	if err := setupOutputs(); err != nil {
+		return err
+	}
+
+	var configs []load.Config
+	err = instance.loadConfigs(&configs)
+	if err != nil {
+		return err
+	}
+	if len(configs) == 0 {
+		return fmt.Errorf("runtime.RunFlexDaemon: no configs to schedule")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	reloadInterval, err := time.ParseDuration(load.Args.DaemonReloadInterval)
+	if err != nil || reloadInterval < 0 {
+		return fmt.Errorf("runtime.RunFlexDaemon: invalid daemon_reload_interval '%s'", load.Args.DaemonReloadInterval)
+	}
+
+	gitSyncInterval, err := time.ParseDuration(load.Args.GitSyncInterval)
+	if err != nil || gitSyncInterval < 0 {
+		return fmt.Errorf("runtime.RunFlexDaemon: invalid git_sync_interval '%s'", load.Args.GitSyncInterval)
+	}
+
+	d := newDaemon(defaultInterval)
+	r := newReloader(d, gitSyncInterval)
+	r.start(ctx, configs)
+	status.SetReady(true)
+	if reloadInterval > 0 {
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			r.watch(ctx, reloadInterval)
+		}()
+	}
+
+	<-ctx.Done()
+	log.Info("runtime.RunFlexDaemon: stopping, waiting for running configs to complete")
+	status.SetReady(false)
+	d.wait()
+	return nil
+}
+
+// daemon schedules configs independently and flushes through the outputs after each run
+type daemon struct {
+	defaultInterval time.Duration
+	// collections hold the read lock while running, a flush takes the write lock
+	// so a publish never serializes samples that are still being written
+	publishLock sync.RWMutex
+	wg          sync.WaitGroup
+	// sources hold the cancel func of the configs scheduled from each file, so they can be replaced on reload
+	sourcesLock sync.Mutex
+	sources     map[string]context.CancelFunc
+}
+
+func newDaemon(defaultInterval time.Duration) *daemon {
+	return &daemon{defaultInterval: defaultInterval, sources: map[string]context.CancelFunc{}}
+}
+
+// start schedules the configs of a source, replacing the configs previously scheduled from it
+// collections of the previous configs that are running complete and are published, they are not scheduled again
+func (d *daemon) start(ctx context.Context, source string, configs []load.Config) {
+	sourceCtx, cancel := context.WithCancel(ctx)
+
+	d.sourcesLock.Lock()
+	if previous, ok := d.sources[source]; ok {
+		previous()
+	}
+	d.sources[source] = cancel
+	d.sourcesLock.Unlock()
+
+	for _, cfg := range configs {
+		d.schedule(sourceCtx, cfg)
+	}
+}
+
+// stop stops scheduling the configs of a source
+func (d *daemon) stop(source string) {
+	d.sourcesLock.Lock()
+	defer d.sourcesLock.Unlock()
+	if cancel, ok := d.sources[source]; ok {
+		cancel()
+		delete(d.sources, source)
+	}
+}
+
+// schedule starts a goroutine that runs the config on its interval until the context is cancelled
+func (d *daemon) schedule(ctx context.Context, cfg load.Config) {
+	interval := configInterval(cfg, d.defaultInterval)
+	log.WithFields(logrus.Fields{
+		"name":     cfg.Name,
+		"interval": interval,
+	}).Debug("runtime.daemon: scheduling config")
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			d.tick(cfg)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// both may be ready at once, never start a new run once cancelled
+				if ctx.Err() != nil {
+					return
+				}
+			}
+		}
+	}()
+}
+
+// tick runs a single config then flushes the results
+func (d *daemon) tick(cfg load.Config) {
+	d.publishLock.RLock()
+	errors := config.RunFiles(nil, &[]load.Config{cfg})
+	d.publishLock.RUnlock()
+
+	for _, err := range errors {
+		log.WithFields(logrus.Fields{"name": cfg.Name}).WithError(err).Error("runtime.daemon: failed to run config")
+	}
+
+	d.flush()
+}
+
+// flush sends and publishes everything collected so far, then resets the per execution state
+func (d *daemon) flush() {
+	d.publishLock.Lock()
+	defer d.publishLock.Unlock()
+
+	outputs.StatusSample()
+	outputs.ReplaySpool(runOutputs)
+	sendOutputs()
+	if err := publishOutputs(); err != nil {
+		log.WithError(err).Error("runtime.daemon: failed to publish")
+	}
+	if err := outputs.RefreshEntity(); err != nil {
+		log.WithError(err).Error("runtime.daemon: failed to refresh entity")
+	}
+
+	load.MetricsStoreEmpty()
+	load.IgnoredIntegrationData = nil
+	load.StartTime = load.MakeTimestamp()
+	setStatusCounters()
+}
+
+func (d *daemon) wait() {
+	d.wg.Wait()
+}
+
+// configInterval returns the interval set on the config, or the default if unset or invalid
+func configInterval(cfg load.Config, defaultInterval time.Duration) time.Duration {
+	if cfg.Interval == "" {
+		return defaultInterval
+	}
+	interval, err := time.ParseDuration(cfg.Interval)
+	if err != nil || interval <= 0 {
+		log.WithFields(logrus.Fields{
+			"name":     cfg.Name,
+			"interval": cfg.Interval,
+		}).Warnf("runtime.daemon: invalid interval, using default %v", defaultInterval)
+		return defaultInterval
+	}
+	return interval
+}
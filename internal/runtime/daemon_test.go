@@ -0,0 +1,37 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package runtime
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/newrelic/nri-flex/internal/load"
+)
+
+func TestConfigInterval(t *testing.T) {
+	defaultInterval := 30 * time.Second
+
+	tests := map[string]struct {
+		interval string
+		expected time.Duration
+	}{
+		"unset":    {"", defaultInterval},
+		"seconds":  {"15s", 15 * time.Second},
+		"minutes":  {"5m", 5 * time.Minute},
+		"invalid":  {"abc", defaultInterval},
+		"negative": {"-10s", defaultInterval},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := load.Config{Name: name, Interval: tc.interval}
+			assert.Equal(t, tc.expected, configInterval(cfg, defaultInterval))
+		})
+	}
+}
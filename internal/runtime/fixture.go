@@ -0,0 +1,115 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/newrelic/nri-flex/internal/config"
+	"github.com/newrelic/nri-flex/internal/fixture"
+	"github.com/newrelic/nri-flex/internal/load"
+	"github.com/newrelic/nri-flex/internal/outputs"
+)
+
+// fixtureResult is the outcome of testing a single config against its fixtures
+type fixtureResult struct {
+	Config      string               `json:"config"`
+	Passed      bool                 `json:"passed"`
+	Error       string               `json:"error,omitempty"`
+	Differences []fixture.Difference `json:"differences,omitempty"`
+}
+
+// RunFixtureTests runs the configs under config_path or config_dir against the recorded inputs in fixturesDir
+// and compares the samples produced with the expected samples, nothing is fetched from live services
+// the result of each config is written to w as json, returns the number of configs that failed
+func RunFixtureTests(w io.Writer, fixturesDir string) (int, error) {
+	setStatusCounters()
+
+	store, err := fixture.NewStore(fixturesDir)
+	if err != nil {
+		return 0, err
+	}
+
+	var configs []load.Config
+	configPath := load.Args.ConfigDir
+	if load.Args.ConfigFile != "" {
+		configPath = load.Args.ConfigFile
+	}
+	if info, err := os.Stat(configPath); err == nil && info.IsDir() {
+		if errors := addConfigsFromPath(configPath, &configs); len(errors) > 0 {
+			return 0, fmt.Errorf("runtime.RunFixtureTests: failed to load configs, %v", errors[0])
+		}
+	} else if err := addSingleConfigFile(configPath, &configs); err != nil {
+		return 0, fmt.Errorf("runtime.RunFixtureTests: failed to load config, %v", err)
+	}
+	if len(configs) == 0 {
+		return 0, fmt.Errorf("runtime.RunFixtureTests: no configs found in %s", configPath)
+	}
+
+	fixture.Replay(store)
+	defer fixture.Replay(nil)
+
+	var results []fixtureResult
+	failed := 0
+	for _, cfg := range configs {
+		result := testConfig(cfg, fixturesDir)
+		if !result.Passed {
+			failed++
+		}
+		results = append(results, result)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(results); err != nil {
+		return failed, fmt.Errorf("runtime.RunFixtureTests: failed to write results, %v", err)
+	}
+	return failed, nil
+}
+
+// testConfig runs a single config then resets the integration, so each config is compared with its own samples only
+func testConfig(cfg load.Config, fixturesDir string) fixtureResult {
+	result := fixtureResult{Config: cfg.Name}
+
+	expected, err := fixture.LoadExpected(fixturesDir, cfg.Name)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	for _, err := range config.RunFiles(nil, &[]load.Config{cfg}) {
+		result.Error = err.Error()
+	}
+	actual := producedSamples()
+
+	load.Integration.Clear()
+	if err := outputs.RefreshEntity(); err != nil {
+		log.WithError(err).Error("runtime.RunFixtureTests: failed to refresh entity")
+	}
+	load.MetricsStoreEmpty()
+	load.IgnoredIntegrationData = nil
+
+	if result.Error != "" {
+		return result
+	}
+	result.Differences = fixture.Compare(expected, actual)
+	result.Passed = len(result.Differences) == 0
+	return result
+}
+
+// producedSamples returns the attributes of every metric set created so far
+func producedSamples() []map[string]interface{} {
+	var samples []map[string]interface{}
+	for _, entity := range load.Integration.Entities {
+		for _, metricSet := range entity.Metrics {
+			samples = append(samples, metricSet.Metrics)
+		}
+	}
+	return samples
+}
@@ -0,0 +1,37 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package runtime
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-flex/internal/load"
+)
+
+func TestRunFixtureTests(t *testing.T) {
+	load.Refresh()
+	load.Integration, _ = integration.New(load.IntegrationName, load.IntegrationVersion)
+	load.Entity, _ = load.Integration.Entity("TestRunFixtureTests", "nri-flex")
+	load.Args.ConfigFile = filepath.Join("..", "..", "test", "fixtures", "fixture-example.yml")
+
+	var out bytes.Buffer
+	failed, err := RunFixtureTests(&out, filepath.Join("..", "..", "test", "fixtures"))
+	require.NoError(t, err)
+
+	var results []fixtureResult
+	require.NoError(t, json.Unmarshal(out.Bytes(), &results))
+	require.Len(t, results, 1)
+	assert.Equal(t, "fixtureExample", results[0].Config)
+	assert.Empty(t, results[0].Differences)
+	assert.Equal(t, 0, failed)
+}
@@ -15,8 +15,11 @@ import (
 	"strings"
 
 	"github.com/newrelic/nri-flex/internal/config"
+	"github.com/newrelic/nri-flex/internal/explain"
+	"github.com/newrelic/nri-flex/internal/fixture"
 	"github.com/newrelic/nri-flex/internal/load"
 	"github.com/newrelic/nri-flex/internal/outputs"
+	"github.com/newrelic/nri-flex/internal/status"
 	"github.com/newrelic/nri-flex/internal/utils"
 	"github.com/sirupsen/logrus"
 )
@@ -54,8 +57,7 @@ func GetTestRuntime() Instance {
 
 // Post-initialization common to all runtime types here
 func CommonPostInit() {
-	err := load.Integration.Publish()
-	if err != nil {
+	if err := publishOutputs(); err != nil {
 		load.Logrus.WithError(err).Fatal("runtime.CommonPostInit: failed to publish")
 	}
 }
@@ -82,6 +84,16 @@ func RunFlex(instance Instance) error {
 		"GOARCH":  runtime.GOARCH,
 	}).Info(load.IntegrationName)
 
+	// a single run is over before a probe could check it, the endpoint is only served by the daemon
+	if load.Args.StatusAddr != "" {
+		log.Warn("runtime.RunFlex: status_addr is only served in daemon mode, ignoring it")
+	}
+
+	if err := setupOutputs(); err != nil {
+		return err
+	}
+	outputs.ReplaySpool(runOutputs)
+
 	var configs []load.Config
 
 	// runtime instance specific run
@@ -89,36 +101,121 @@ func RunFlex(instance Instance) error {
 	if err != nil {
 		return err
 	}
+	status.SetReady(true)
+
+	if load.Args.Explain != "" {
+		explain.Start()
+		defer writeExplain(load.Args.Explain)
+	}
+
+	if load.Args.Replay != "" {
+		store, err := fixture.NewStore(load.Args.Replay)
+		if err != nil {
+			return err
+		}
+		fixture.Replay(store)
+		defer fixture.Replay(nil)
+	}
+	if load.Args.Record != "" {
+		fixture.Record(fixture.NewRecorder(load.Args.Record))
+		defer writeRecording(load.Args.Record)
+	}
 
-	errors := config.RunFiles(&configs)
+	errors := config.RunFiles(nil, &configs)
 	if len(errors) > 0 {
 		return fmt.Errorf("runtime.RunFlex: failed to run configuration files")
 	}
 
 	outputs.StatusSample()
+	sendOutputs()
+	return nil
+}
 
-	if load.Args.InsightsURL != "" && load.Args.InsightsAPIKey != "" {
-		for _, batch := range outputs.GetMetricBatches() {
-			if err := outputs.SendBatchToInsights(batch); err != nil {
-				log.WithError(err).Error("runtime.RunFlex: failed to send batch to insights")
-			}
-		}
-	} else if load.Args.LogApiURL != "" && load.Args.LogApiKey != "" {
-		for _, batch := range outputs.GetLogMetricBatches() {
-			if err := outputs.SendBatchToLogApi(batch); err != nil {
-				log.WithError(err).Error("runtime.RunFlex: failed to send batch to log api")
-			}
-		}
-	} else if load.Args.MetricAPIUrl != "" && (load.Args.InsightsAPIKey != "" || load.Args.MetricAPIKey != "") && len(load.MetricsStore.Data) > 0 {
-		if err := outputs.SendToMetricAPI(); err != nil {
-			log.WithError(err).Error("runtime.RunFlex: failed to send metrics")
-		}
-	} else if len(load.MetricsStore.Data) > 0 && (load.Args.MetricAPIUrl == "" || (load.Args.InsightsAPIKey == "" || load.Args.MetricAPIKey == "")) {
-		log.Debug("runtime.RunFlex: metric_api is being used, but metric url and/or key has not been set")
+// runOutputs are the destinations of the results, listed in outputs_file or set by arguments
+var runOutputs []outputs.Output
+
+// setupOutputs creates the outputs results are sent to
+func setupOutputs() error {
+	if load.Args.OutputsFile == "" {
+		runOutputs = outputs.FromArgs()
+		return nil
+	}
+	configs, err := config.LoadOutputs(load.Args.OutputsFile)
+	if err != nil {
+		return err
+	}
+	runOutputs, err = outputs.New(configs)
+	if err != nil {
+		return fmt.Errorf("runtime: invalid outputs file %s, %v", load.Args.OutputsFile, err)
 	}
 	return nil
 }
 
+// sendOutputs sends the collected samples to every output, except stdout which publishOutputs sends to
+func sendOutputs() {
+	if runOutputs == nil {
+		runOutputs = outputs.FromArgs()
+	}
+	outputs.Send(runOutputs)
+	// types are only kept for the outputs sent to, the stdout payload has none
+	load.MetricTypesReset()
+}
+
+// publishOutputs publishes the results to stdout for the agent, when a stdout output is set
+func publishOutputs() error {
+	if runOutputs == nil {
+		runOutputs = outputs.FromArgs()
+	}
+	err := outputs.Publish(runOutputs)
+	status.PublishCompleted(err)
+	return err
+}
+
+// serveStatus starts the status endpoint when status_addr is set, the returned func stops it
+func serveStatus() (func(), error) {
+	if load.Args.StatusAddr == "" {
+		return func() {}, nil
+	}
+	server, err := status.Serve(load.Args.StatusAddr)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		if err := server.Close(); err != nil {
+			log.WithError(err).Debug("runtime: failed to stop status server")
+		}
+	}, nil
+}
+
+// writeExplain stops recording and writes the pipeline trace
+func writeExplain(path string) {
+	trace := explain.Stop()
+	if trace == nil {
+		return
+	}
+	if err := trace.WriteFile(path); err != nil {
+		log.WithError(err).Error("runtime.RunFlex: failed to write explain trace")
+		return
+	}
+	log.WithFields(logrus.Fields{
+		"file":     path,
+		"dataSets": len(trace.DataSets),
+	}).Info("runtime.RunFlex: explain trace written")
+}
+
+// writeRecording stops recording and writes the fixture directory
+func writeRecording(dir string) {
+	recorder := fixture.StopRecording()
+	if recorder == nil {
+		return
+	}
+	if err := recorder.Write(); err != nil {
+		log.WithError(err).Error("runtime.RunFlex: failed to write recorded fixtures")
+		return
+	}
+	log.WithFields(logrus.Fields{"dir": dir}).Info("runtime.RunFlex: recorded fixtures written")
+}
+
 func addSingleConfigFile(configFile string, configs *[]load.Config) error {
 	file, err := os.Stat(configFile)
 	if err != nil {
@@ -165,12 +262,11 @@ func logEncryptPass() error {
 	return nil
 }
 
+// setStatusCounters resets the counters under their lock, the status endpoint may be reading them
 func setStatusCounters() {
 	log.Out = os.Stderr
-	load.FlexStatusCounter.M = make(map[string]int)
-	load.FlexStatusCounter.M["EventCount"] = 0
-	load.FlexStatusCounter.M["EventDropCount"] = 0
-	load.FlexStatusCounter.M["ConfigsProcessed"] = 0
+	load.StatusCounterReset()
+	load.RunStatusReset()
 }
 
 // setEnvs set environment variable argument overrides
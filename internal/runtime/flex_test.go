@@ -0,0 +1,31 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package runtime
+
+import (
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/newrelic/nri-flex/internal/load"
+	"github.com/newrelic/nri-flex/internal/outputs"
+)
+
+func TestSendOutputsResetsMetricTypes(t *testing.T) {
+	previous := runOutputs
+	runOutputs = []outputs.Output{}
+	defer func() { runOutputs = previous }()
+
+	// a single run sends once, the types of its metric sets must not outlive it
+	set := metric.NewSet("redisSample", nil)
+	load.MetricTypeSet(set, "opsPerSec", "RATE")
+	load.MetricCumulativeSet(set, "opsPerSec", 1500)
+	sendOutputs()
+
+	assert.Empty(t, load.MetricType(set, "opsPerSec"))
+	assert.Zero(t, load.MetricCumulative(set, "opsPerSec"))
+}
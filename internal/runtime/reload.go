@@ -0,0 +1,240 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/newrelic/nri-flex/internal/config"
+	"github.com/newrelic/nri-flex/internal/discovery"
+	"github.com/newrelic/nri-flex/internal/load"
+	"github.com/sirupsen/logrus"
+)
+
+// discoverySource groups the configs created by container discovery, which are replaced together
+const discoverySource = "discovery"
+
+// fileState is what the watcher compares to detect a changed file
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// configWatcher polls config files and directories for changes
+// polling keeps it working on any platform and on mounted volumes, where file events are not always delivered
+type configWatcher struct {
+	paths []string
+	files map[string]fileState
+}
+
+func newConfigWatcher(paths []string) *configWatcher {
+	w := &configWatcher{paths: paths}
+	w.files = w.snapshot()
+	return w
+}
+
+// changes returns the config files changed or added, and removed, since the previous call
+func (w *configWatcher) changes() (changed []string, removed []string) {
+	files := w.snapshot()
+	for file, state := range files {
+		if previous, ok := w.files[file]; !ok || previous != state {
+			changed = append(changed, file)
+		}
+	}
+	for file := range w.files {
+		if _, ok := files[file]; !ok {
+			removed = append(removed, file)
+		}
+	}
+	w.files = files
+	sort.Strings(changed)
+	sort.Strings(removed)
+	return changed, removed
+}
+
+// snapshot walks the watched paths the same way configs are loaded, yml files only and skipping .git and nr-integrations
+func (w *configWatcher) snapshot() map[string]fileState {
+	files := map[string]fileState{}
+	for _, root := range w.paths {
+		_ = filepath.Walk(filepath.Clean(root), func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if info.IsDir() {
+				if strings.Contains(file, ".git") || strings.Contains(file, "nr-integrations") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !strings.HasSuffix(info.Name(), "yml") && !strings.HasSuffix(info.Name(), "yaml") {
+				return nil
+			}
+			files[file] = fileState{modTime: info.ModTime(), size: info.Size()}
+			return nil
+		})
+	}
+	return files
+}
+
+// watches returns true if the file is one of the config files being watched
+func (w *configWatcher) watches(file string) bool {
+	_, ok := w.files[file]
+	return ok
+}
+
+// watchedConfigPaths returns the config file or directories to watch, and the container discovery directory if used
+func watchedConfigPaths() (paths []string, discoveryDir string) {
+	if load.Args.ConfigFile != "" {
+		paths = append(paths, load.Args.ConfigFile)
+	} else if load.Args.ConfigDir != "" {
+		paths = append(paths, load.Args.ConfigDir)
+	}
+	if (load.Args.ContainerDiscovery || load.Args.Fargate) && load.Args.ContainerDiscoveryDir != "" {
+		discoveryDir = filepath.Clean(load.Args.ContainerDiscoveryDir)
+	}
+	return paths, discoveryDir
+}
+
+// sourceKey returns the file a config was loaded from
+func sourceKey(cfg load.Config) string {
+	return filepath.Join(cfg.FilePath, cfg.FileName)
+}
+
+// isWithin returns true if the file is inside the directory
+func isWithin(dir string, file string) bool {
+	if dir == "" {
+		return false
+	}
+	rel, err := filepath.Rel(dir, file)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// syncGit pulls the git_repo of git sync into config_dir, replaced in tests
+var syncGit = config.SyncGitConfigs
+
+// reloader swaps configs into the daemon schedule as their files change
+type reloader struct {
+	d            *daemon
+	watcher      *configWatcher
+	discoveryDir string
+	gitSync      time.Duration // how often git sync pulls, 0 when it only syncs at start-up
+	lastGitSync  time.Time
+}
+
+// newReloader is created once the configs are loaded, git sync has pulled the repo just before
+func newReloader(d *daemon, gitSync time.Duration) *reloader {
+	paths, discoveryDir := watchedConfigPaths()
+	if discoveryDir != "" {
+		paths = append(paths, discoveryDir)
+	}
+	return &reloader{d: d, watcher: newConfigWatcher(paths), discoveryDir: discoveryDir, gitSync: gitSync, lastGitSync: time.Now()}
+}
+
+// start schedules the configs loaded at start-up, grouped by the file they were loaded from
+// configs that do not come from a watched config file were created by discovery
+func (r *reloader) start(ctx context.Context, configs []load.Config) {
+	sources := map[string][]load.Config{}
+	for _, cfg := range configs {
+		key := sourceKey(cfg)
+		if !r.watcher.watches(key) || isWithin(r.discoveryDir, key) {
+			key = discoverySource
+		}
+		sources[key] = append(sources[key], cfg)
+	}
+	for key, sourceConfigs := range sources {
+		r.d.start(ctx, key, sourceConfigs)
+	}
+}
+
+// watch checks for changes on every interval until the context is cancelled
+func (r *reloader) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.reload(ctx)
+		}
+	}
+}
+
+// reload applies the changes found since the previous check
+func (r *reloader) reload(ctx context.Context) {
+	r.pullGit()
+	changed, removed := r.watcher.changes()
+	rediscover := false
+	for _, file := range changed {
+		if isWithin(r.discoveryDir, file) {
+			rediscover = true
+			continue
+		}
+		r.reloadFile(ctx, file)
+	}
+	for _, file := range removed {
+		if isWithin(r.discoveryDir, file) {
+			rediscover = true
+			continue
+		}
+		log.WithFields(logrus.Fields{"file": file}).Info("runtime.reload: config removed, stopping")
+		load.ConfigReloadErrorClear(file)
+		r.d.stop(file)
+	}
+	if rediscover {
+		log.WithFields(logrus.Fields{"dir": r.discoveryDir}).Info("runtime.reload: discovery configs changed, rediscovering")
+		var configs []load.Config
+		discovery.Run(&configs)
+		r.d.start(ctx, discoverySource, configs)
+	}
+}
+
+// pullGit pulls the git_repo of git sync every gitSync, the configs it changes in config_dir are reloaded like any other
+func (r *reloader) pullGit() {
+	if r.gitSync <= 0 || time.Since(r.lastGitSync) < r.gitSync {
+		return
+	}
+	r.lastGitSync = time.Now()
+	if _, err := syncGit(""); err != nil {
+		log.WithError(err).Warn("runtime.reload: failed to sync git configs, keeping the previous versions")
+	}
+}
+
+// reloadFile parses a changed config file and replaces the configs previously loaded from it
+// if the file fails to load, the previous version keeps running and the error is reported on flexStatusSample
+func (r *reloader) reloadFile(ctx context.Context, file string) {
+	configs, err := loadConfigFile(file)
+	if err != nil {
+		log.WithFields(logrus.Fields{"file": file}).WithError(err).Error("runtime.reload: rejected config change, keeping the previous version")
+		load.ConfigReloadErrorSet(file, err)
+		return
+	}
+	log.WithFields(logrus.Fields{"file": file}).Info("runtime.reload: config changed, reloading")
+	load.ConfigReloadErrorClear(file)
+	r.d.start(ctx, file, configs)
+}
+
+// loadConfigFile loads the configs of a single file, a file that loads no config is an error
+func loadConfigFile(file string) ([]load.Config, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, fmt.Errorf("runtime.reload: failed to read %s, %v", file, err)
+	}
+	var configs []load.Config
+	if err := config.LoadFile(&configs, info, filepath.Dir(file)); err != nil {
+		return nil, fmt.Errorf("runtime.reload: failed to load %s, %v", file, err)
+	}
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("runtime.reload: no config loaded from %s, check it sets a name", file)
+	}
+	return configs, nil
+}
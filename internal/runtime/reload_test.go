@@ -0,0 +1,132 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package runtime
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-flex/internal/load"
+)
+
+func TestConfigWatcherChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flex-reload")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	redis := filepath.Join(dir, "redis.yml")
+	nested := filepath.Join(dir, "nested", "nginx.yaml")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "nested"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, ".git"), 0755))
+	require.NoError(t, ioutil.WriteFile(redis, []byte("name: redis"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte("docs"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, ".git", "ignored.yml"), []byte("name: ignored"), 0644))
+
+	w := newConfigWatcher([]string{dir})
+	assert.True(t, w.watches(redis))
+	assert.False(t, w.watches(filepath.Join(dir, ".git", "ignored.yml")))
+
+	changed, removed := w.changes()
+	assert.Empty(t, changed)
+	assert.Empty(t, removed)
+
+	require.NoError(t, ioutil.WriteFile(redis, []byte("name: redis2"), 0644))
+	require.NoError(t, os.Chtimes(redis, time.Now(), time.Now().Add(time.Minute)))
+	require.NoError(t, ioutil.WriteFile(nested, []byte("name: nginx"), 0644))
+	changed, removed = w.changes()
+	assert.Equal(t, []string{nested, redis}, changed)
+	assert.Empty(t, removed)
+
+	require.NoError(t, os.Remove(redis))
+	changed, removed = w.changes()
+	assert.Empty(t, changed)
+	assert.Equal(t, []string{redis}, removed)
+}
+
+func TestReloadFileKeepsPreviousVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flex-reload")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer load.ConfigReloadErrorClear(filepath.Join(dir, "broken.yml"))
+
+	tests := map[string]struct {
+		content string
+		err     string
+	}{
+		"invalid yaml": {"name: broken\napis:\n  - name: [", "failed to load"},
+		"missing name": {"apis:\n  - name: status\n", "no config loaded"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			file := filepath.Join(dir, "broken.yml")
+			require.NoError(t, ioutil.WriteFile(file, []byte(tc.content), 0644))
+
+			d := newDaemon(time.Minute)
+			r := &reloader{d: d, watcher: newConfigWatcher([]string{dir})}
+			r.reloadFile(context.Background(), file)
+
+			assert.Empty(t, d.sources, "broken config must not replace the running one")
+			assert.Contains(t, load.ConfigReloadErrorsRead(), file+": runtime.reload: "+tc.err)
+		})
+	}
+}
+
+func TestReloadPullsGit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flex-reload")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	pulls := 0
+	sync := syncGit
+	syncGit = func(customDir string) (bool, error) {
+		pulls++
+		return true, nil
+	}
+	defer func() { syncGit = sync }()
+
+	r := &reloader{d: newDaemon(time.Minute), watcher: newConfigWatcher([]string{dir}), gitSync: time.Hour, lastGitSync: time.Now()}
+	r.reload(context.Background())
+	assert.Equal(t, 0, pulls, "the repo is pulled at start-up, not again before git_sync_interval")
+
+	r.lastGitSync = time.Now().Add(-2 * time.Hour)
+	r.reload(context.Background())
+	r.reload(context.Background())
+	assert.Equal(t, 1, pulls)
+
+	// a git_sync_interval of 0 only syncs at start-up
+	r.gitSync, r.lastGitSync = 0, time.Time{}
+	r.reload(context.Background())
+	assert.Equal(t, 1, pulls)
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flex-reload")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "status.yml")
+	require.NoError(t, ioutil.WriteFile(file, []byte("name: status\napis:\n  - name: status\n    commands:\n      - run: echo ok\n"), 0644))
+
+	configs, err := loadConfigFile(file)
+	require.NoError(t, err)
+	require.Len(t, configs, 1)
+	assert.Equal(t, "status", configs[0].Name)
+	assert.Equal(t, file, sourceKey(configs[0]))
+}
+
+func TestIsWithin(t *testing.T) {
+	assert.True(t, isWithin("flexContainerDiscovery", filepath.Join("flexContainerDiscovery", "cd-redis.yml")))
+	assert.False(t, isWithin("flexContainerDiscovery", filepath.Join("flexConfigs", "redis.yml")))
+	assert.False(t, isWithin("", filepath.Join("flexConfigs", "redis.yml")))
+}
@@ -0,0 +1,34 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package runtime
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/newrelic/nri-flex/internal/config"
+	"github.com/newrelic/nri-flex/internal/load"
+)
+
+// Validate strictly validates the files under config_path or config_dir, writing every problem found to w
+// returns the number of problems found
+func Validate(w io.Writer) int {
+	configPath := load.Args.ConfigDir
+	if load.Args.ConfigFile != "" {
+		configPath = load.Args.ConfigFile
+	}
+
+	errors := config.ValidatePath(configPath)
+	for _, err := range errors {
+		fmt.Fprintln(w, err.Error())
+	}
+	if len(errors) > 0 {
+		fmt.Fprintf(w, "validate: %d problem(s) found in %s\n", len(errors), configPath)
+	} else {
+		fmt.Fprintf(w, "validate: %s ok\n", configPath)
+	}
+	return len(errors)
+}
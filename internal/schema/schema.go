@@ -0,0 +1,279 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package schema generates the JSON Schema of the Flex config format from the structs in the load package
+package schema
+
+import (
+	_ "embed" // embeds the generated schema
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"strings"
+
+	"github.com/newrelic/nri-flex/internal/load"
+)
+
+// Schema the generated JSON Schema, kept in sync with the load package by TestSchemaUpToDate
+//
+//go:embed flex-config.schema.json
+var Schema []byte
+
+// enums known values for fields, keyed by Type.Field
+var enums = map[string][]string{
+	"Filter.Mode":             {"regex", "prefix", "suffix", "contains"},
+	"MetricParser.Mode":       {"regex", "prefix", "suffix", "contains"},
+	"Parse.Type":              {"regex", "prefix", "suffix", "contains"},
+	"Secret.Kind":             {"aws-kms", "vault", "local"},
+	"Secret.Type":             {"basic", "equal", "json"},
+	"ContainerDiscovery.Type": {"container", "image"},
+	"ContainerDiscovery.Mode": {"contains", "prefix", "exact"},
+	"ContainerDiscovery.IPMode": {
+		"public", "private",
+	},
+	"API.Method": {"GET", "POST", "PUT"},
+}
+
+// valueEnums known values for the values of map fields, keyed by Type.Field
+var valueEnums = map[string][]string{
+	"MetricParser.Metrics": {"RATE", "DELTA", "PRATE", "PDELTA", "ATTRIBUTE"},
+}
+
+// deprecated fields still accepted for backwards compatibility, keyed by Type.Field
+var deprecated = map[string]string{
+	"Config.ContainerDiscovery": "use the infrastructure agent container auto-discovery",
+	"API.ReplaceKeys":           "use rename_keys",
+	"API.SampleFilter":          "use sample_exclude_filter",
+	"API.Prometheus":            "use the New Relic Prometheus OpenMetrics integration",
+	"API.Jmx":                   "use the nri-jmx integration",
+	"API.IgnoreLines":           "not implemented",
+	"Global.Jmx":                "use the nri-jmx integration",
+	"Command.Jmx":               "use the nri-jmx integration",
+	"Command.CompressBean":      "unused",
+}
+
+// required fields, keyed by type
+var required = map[string][]string{
+	"Config": {"name"},
+}
+
+// open types allow keys that Flex does not know about, eg. agent settings in V4 integration entries
+var open = map[string]bool{
+	"ConfigEntry": true,
+}
+
+// custom definitions of types that decode themselves, keyed by type
+var custom = map[string]map[string]interface{}{
+	"PipelineStep": {
+		"oneOf": []interface{}{
+			map[string]interface{}{"type": "string"},
+			map[string]interface{}{
+				"type":       "object",
+				"properties": map[string]interface{}{"stage": map[string]interface{}{"type": "string"}},
+				"required":   []string{"stage"},
+			},
+		},
+	},
+}
+
+// Generate builds the JSON Schema using the doc comments found in the source of the load package
+func Generate(loadSource []byte) ([]byte, error) {
+	comments, err := fieldComments(loadSource)
+	if err != nil {
+		return nil, err
+	}
+
+	g := generator{
+		comments:    comments,
+		definitions: map[string]interface{}{},
+	}
+	g.definition(reflect.TypeOf(load.Config{}))
+	g.definition(reflect.TypeOf(load.AgentConfig{}))
+
+	root := map[string]interface{}{
+		"$schema":     "http://json-schema.org/draft-07/schema#",
+		"$id":         "https://github.com/newrelic/nri-flex/flex-config.schema.json",
+		"title":       "New Relic Flex config",
+		"description": "A Flex config file, either standalone or wrapped in infrastructure agent V4 integrations",
+		"anyOf": []interface{}{
+			map[string]interface{}{"$ref": "#/definitions/Config"},
+			map[string]interface{}{"$ref": "#/definitions/AgentConfig"},
+		},
+		"definitions": g.definitions,
+	}
+
+	out, err := json.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("schema: failed to marshal, %v", err)
+	}
+	return append(out, '\n'), nil
+}
+
+type generator struct {
+	comments    map[string]string
+	definitions map[string]interface{}
+}
+
+// definition adds a named struct to the definitions, and returns a reference to it
+func (g *generator) definition(t reflect.Type) map[string]interface{} {
+	ref := map[string]interface{}{"$ref": "#/definitions/" + t.Name()}
+	if _, ok := g.definitions[t.Name()]; ok {
+		return ref
+	}
+	// reserve the name first to handle recursive types, eg. Secret.HTTP is an API
+	g.definitions[t.Name()] = nil
+	if definition, ok := custom[t.Name()]; ok {
+		if description := g.comments[t.Name()]; description != "" {
+			definition["description"] = description
+		}
+		g.definitions[t.Name()] = definition
+		return ref
+	}
+	g.definitions[t.Name()] = g.object(t)
+	return ref
+}
+
+func (g *generator) object(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := yamlName(field)
+		if name == "-" {
+			continue
+		}
+
+		key := t.Name() + "." + field.Name
+		property := g.property(field.Type, key)
+		if description := g.comments[key]; description != "" {
+			property["description"] = description
+		}
+		if reason, ok := deprecated[key]; ok {
+			property["deprecated"] = true
+			property["description"] = strings.TrimSpace("Deprecated: " + reason + ". " + g.comments[key])
+		}
+		if values, ok := enums[key]; ok {
+			property["enum"] = values
+		}
+		properties[name] = property
+	}
+
+	object := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": open[t.Name()],
+	}
+	if t.Name() != "" {
+		if description := g.comments[t.Name()]; description != "" {
+			object["description"] = description
+		}
+		if fields, ok := required[t.Name()]; ok {
+			object["required"] = fields
+		}
+	}
+	return object
+}
+
+func (g *generator) property(t reflect.Type, key string) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": g.property(t.Elem(), "")}
+	case reflect.Map:
+		values := g.property(t.Elem(), "")
+		if enum, ok := valueEnums[key]; ok {
+			values["enum"] = enum
+		}
+		return map[string]interface{}{"type": "object", "additionalProperties": values}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		return g.definition(t)
+	case reflect.Ptr:
+		return g.property(t.Elem(), key)
+	default:
+		// interface{} accepts any value
+		return map[string]interface{}{}
+	}
+}
+
+// yamlName mirrors how yaml.v2 names a field, the tag name or the lowercased field name
+func yamlName(field reflect.StructField) string {
+	name := strings.TrimSpace(strings.Split(field.Tag.Get("yaml"), ",")[0])
+	if name == "" {
+		name = strings.ToLower(field.Name)
+	}
+	return name
+}
+
+// fieldComments collects the comments of the types and fields of the load package, keyed by Type and Type.Field
+func fieldComments(source []byte) (map[string]string, error) {
+	file, err := parser.ParseFile(token.NewFileSet(), "load.go", source, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("schema: failed to parse load source, %v", err)
+	}
+
+	comments := map[string]string{}
+	ast.Inspect(file, func(n ast.Node) bool {
+		decl, ok := n.(*ast.GenDecl)
+		if !ok || decl.Tok != token.TYPE {
+			return true
+		}
+		for _, spec := range decl.Specs {
+			typeSpec := spec.(*ast.TypeSpec)
+			structType, ok := typeSpec.Type.(*ast.StructType)
+			if !ok {
+				continue
+			}
+			doc := typeSpec.Doc
+			if doc == nil {
+				doc = decl.Doc
+			}
+			comments[typeSpec.Name.Name] = typeDescription(typeSpec.Name.Name, doc)
+			for _, field := range structType.Fields.List {
+				text := commentText(field.Comment)
+				if text == "" {
+					text = commentText(field.Doc)
+				}
+				for _, name := range field.Names {
+					comments[typeSpec.Name.Name+"."+name.Name] = text
+				}
+			}
+		}
+		return false
+	})
+	return comments, nil
+}
+
+// typeDescription strips the type name go doc comments start with
+func typeDescription(name string, doc *ast.CommentGroup) string {
+	text := strings.TrimSpace(strings.TrimPrefix(commentText(doc), name))
+	if text == "" || strings.EqualFold(text, "struct") {
+		return ""
+	}
+	return text
+}
+
+func commentText(group *ast.CommentGroup) string {
+	if group == nil {
+		return ""
+	}
+	return strings.Join(strings.Fields(group.Text()), " ")
+}
@@ -0,0 +1,60 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package schema
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "regenerate flex-config.schema.json")
+
+// TestSchemaUpToDate fails when the structs in the load package change without regenerating the schema
+// run `go test ./internal/schema -update` to regenerate it
+func TestSchemaUpToDate(t *testing.T) {
+	source, err := ioutil.ReadFile(filepath.Join("..", "load", "load.go"))
+	require.NoError(t, err)
+
+	generated, err := Generate(source)
+	require.NoError(t, err)
+
+	if *update {
+		require.NoError(t, ioutil.WriteFile("flex-config.schema.json", generated, 0644))
+		return
+	}
+	assert.Equal(t, string(generated), string(Schema), "schema is out of date, run `go test ./internal/schema -update`")
+}
+
+func TestSchemaContent(t *testing.T) {
+	var root map[string]interface{}
+	require.NoError(t, json.Unmarshal(Schema, &root))
+	definitions := root["definitions"].(map[string]interface{})
+
+	property := func(def string, name string) map[string]interface{} {
+		properties := definitions[def].(map[string]interface{})["properties"].(map[string]interface{})
+		require.Contains(t, properties, name)
+		return properties[name].(map[string]interface{})
+	}
+
+	assert.Equal(t, "filters events in/out", property("API", "event_filter")["description"])
+	assert.Equal(t, []interface{}{"regex", "prefix", "suffix", "contains"}, property("Filter", "mode")["enum"])
+	assert.Equal(t, []interface{}{"aws-kms", "vault", "local"}, property("Secret", "kind")["enum"])
+	assert.Equal(t, true, property("API", "replace_keys")["deprecated"])
+
+	metrics := property("MetricParser", "metrics")["additionalProperties"].(map[string]interface{})
+	assert.Equal(t, []interface{}{"RATE", "DELTA", "PRATE", "PDELTA", "ATTRIBUTE"}, metrics["enum"])
+
+	// yaml.v2 lowercases untagged fields
+	property("Config", "apis")
+	property("API", "user")
+	property("API", "run_async")
+}
@@ -0,0 +1,81 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package signer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/newrelic/nri-flex/internal/load"
+)
+
+// awsSigV4Signer signs requests with aws signature version 4, eg. for opensearch or api gateway
+type awsSigV4Signer struct {
+	service     string
+	region      string
+	credentials aws.CredentialsProvider
+	signer      *v4.Signer
+}
+
+// newAWSSigV4Signer uses the key and secret of the signer block when set
+// otherwise the default credential chain, from the environment, the shared config profile or the instance metadata
+func newAWSSigV4Signer(cfg load.Signer) (Signer, error) {
+	if cfg.Service == "" {
+		return nil, fmt.Errorf("requires service")
+	}
+
+	var options []func(*config.LoadOptions) error
+	if cfg.Region != "" {
+		options = append(options, config.WithRegion(cfg.Region))
+	}
+	if cfg.Profile != "" {
+		options = append(options, config.WithSharedConfigProfile(cfg.Profile))
+	}
+	if cfg.Key != "" {
+		credentials := aws.Credentials{AccessKeyID: cfg.Key, SecretAccessKey: cfg.Secret, SessionToken: cfg.SessionToken, Source: "flex signer"}
+		options = append(options, config.WithCredentialsProvider(aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return credentials, nil
+		})))
+	}
+	awsCfg, err := config.LoadDefaultConfig(context.Background(), options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load aws config, %v", err)
+	}
+	if awsCfg.Region == "" {
+		return nil, fmt.Errorf("requires region, set it in the signer or the aws config")
+	}
+
+	return awsSigV4Signer{
+		service:     cfg.Service,
+		region:      awsCfg.Region,
+		credentials: awsCfg.Credentials,
+		signer:      v4.NewSigner(),
+	}, nil
+}
+
+func (s awsSigV4Signer) Sign(r *http.Request) error {
+	credentials, err := s.credentials.Retrieve(r.Context())
+	if err != nil {
+		return fmt.Errorf("failed to retrieve aws credentials, %v", err)
+	}
+	body, err := Body(r)
+	if err != nil {
+		return fmt.Errorf("failed to read body, %v", err)
+	}
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	// s3 verifies the payload against this header rather than the signature alone
+	if s.service == "s3" {
+		r.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+	return s.signer.SignHTTP(r.Context(), credentials, r, payloadHash, s.service, s.region, now())
+}
@@ -0,0 +1,57 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package signer
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/newrelic/nri-flex/internal/aliyun"
+	"github.com/newrelic/nri-flex/internal/huaweihws"
+	"github.com/newrelic/nri-flex/internal/load"
+)
+
+// the cloud signers that used to be configured by hw_signer and aliyun_signer
+
+func requireKey(cfg load.Signer) error {
+	if cfg.Key == "" || cfg.Secret == "" {
+		return fmt.Errorf("requires key and secret")
+	}
+	return nil
+}
+
+func newHuaweiSigner(cfg load.Signer) (Signer, error) {
+	if err := requireKey(cfg); err != nil {
+		return nil, err
+	}
+	return &huaweihws.Signer{Key: cfg.Key, Secret: cfg.Secret}, nil
+}
+
+// aliyunSigner signs the query of the url
+type aliyunSigner struct {
+	signer aliyun.Signer
+}
+
+func newAliyunSigner(cfg load.Signer) (Signer, error) {
+	if err := requireKey(cfg); err != nil {
+		return nil, err
+	}
+	return aliyunSigner{signer: aliyun.Signer{Key: cfg.Key, Secret: cfg.Secret}}, nil
+}
+
+func (s aliyunSigner) Sign(r *http.Request) error {
+	signedURL, err := s.signer.Sign(r)
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(signedURL)
+	if err != nil {
+		return err
+	}
+	r.URL = u
+	return nil
+}
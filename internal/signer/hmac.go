@@ -0,0 +1,148 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package signer
+
+import (
+	"crypto/hmac"
+	"crypto/sha1" // #nosec, some apis still sign with it
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/newrelic/nri-flex/internal/load"
+)
+
+const (
+	hmacDefaultTemplate = "{{.Method}}\n{{.Path}}\n{{.Query}}\n{{.Date}}\n{{.BodySHA256}}"
+	hmacDefaultValue    = "{{.Signature}}"
+)
+
+// hmacSigner signs a string rendered from the request with a shared secret, and sets a header to the signature
+type hmacSigner struct {
+	key        string
+	secret     []byte
+	hash       func() hash.Hash
+	encode     func([]byte) string
+	header     string
+	dateHeader string
+	template   *template.Template
+	value      *template.Template
+}
+
+// hmacData is what the template and value of the signer block render
+type hmacData struct {
+	Method     string // eg. GET
+	Host       string
+	Path       string // escaped path, / when empty
+	Query      string // raw query, without ?
+	Date       string // http date, eg. Mon, 02 Jan 2006 15:04:05 GMT
+	Timestamp  int64  // unix seconds
+	Body       string
+	BodySHA256 string // hex sha256 of the body
+	Key        string
+	Signature  string // only set in value
+	header     http.Header
+}
+
+// Header returns the value of a header of the request, eg. {{.Header "Content-Type"}}
+func (d hmacData) Header(name string) string {
+	return d.header.Get(name)
+}
+
+func newHMACSigner(cfg load.Signer) (Signer, error) {
+	if cfg.Secret == "" {
+		return nil, fmt.Errorf("requires secret")
+	}
+	s := &hmacSigner{key: cfg.Key, secret: []byte(cfg.Secret), header: cfg.Header, dateHeader: cfg.DateHeader}
+	if s.header == "" {
+		s.header = "Authorization"
+	}
+
+	switch strings.ToLower(cfg.Algorithm) {
+	case "", "sha256":
+		s.hash = sha256.New
+	case "sha1":
+		s.hash = sha1.New
+	case "sha512":
+		s.hash = sha512.New
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %s, use sha256, sha1 or sha512", cfg.Algorithm)
+	}
+
+	switch strings.ToLower(cfg.Encoding) {
+	case "", "hex":
+		s.encode = hex.EncodeToString
+	case "base64":
+		s.encode = base64.StdEncoding.EncodeToString
+	default:
+		return nil, fmt.Errorf("unsupported encoding %s, use hex or base64", cfg.Encoding)
+	}
+
+	var err error
+	text := cfg.Template
+	if text == "" {
+		text = hmacDefaultTemplate
+	}
+	if s.template, err = template.New("template").Option("missingkey=error").Parse(text); err != nil {
+		return nil, fmt.Errorf("invalid template, %v", err)
+	}
+	text = cfg.Value
+	if text == "" {
+		text = hmacDefaultValue
+	}
+	if s.value, err = template.New("value").Option("missingkey=error").Parse(text); err != nil {
+		return nil, fmt.Errorf("invalid value, %v", err)
+	}
+	return s, nil
+}
+
+func (s *hmacSigner) Sign(r *http.Request) error {
+	body, err := Body(r)
+	if err != nil {
+		return fmt.Errorf("failed to read body, %v", err)
+	}
+	t := now().UTC()
+	sum := sha256.Sum256(body)
+	data := hmacData{
+		Method:     r.Method,
+		Host:       r.URL.Host,
+		Path:       r.URL.EscapedPath(),
+		Query:      r.URL.RawQuery,
+		Date:       t.Format(http.TimeFormat),
+		Timestamp:  t.Unix(),
+		Body:       string(body),
+		BodySHA256: hex.EncodeToString(sum[:]),
+		Key:        s.key,
+		header:     r.Header,
+	}
+	if data.Path == "" {
+		data.Path = "/"
+	}
+
+	var toSign strings.Builder
+	if err := s.template.Execute(&toSign, data); err != nil {
+		return fmt.Errorf("failed to render template, %v", err)
+	}
+	mac := hmac.New(s.hash, s.secret)
+	_, _ = mac.Write([]byte(toSign.String()))
+	data.Signature = s.encode(mac.Sum(nil))
+
+	var value strings.Builder
+	if err := s.value.Execute(&value, data); err != nil {
+		return fmt.Errorf("failed to render value, %v", err)
+	}
+	r.Header.Set(s.header, value.String())
+	if s.dateHeader != "" {
+		r.Header.Set(s.dateHeader, data.Date)
+	}
+	return nil
+}
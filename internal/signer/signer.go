@@ -0,0 +1,140 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package signer signs the requests of http apis, configured by their signer block
+package signer
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/newrelic/nri-flex/internal/load"
+)
+
+// Signer signs a request before it is sent, by setting its headers or rewriting its url
+type Signer interface {
+	Sign(r *http.Request) error
+}
+
+// Factory creates the signer of a signer block, it checks the config the signer needs
+type Factory func(cfg load.Signer) (Signer, error)
+
+// registry holds the signer factories by type, the built in signers are registered first
+var registry = struct {
+	sync.RWMutex
+	factories map[string]Factory
+}{factories: map[string]Factory{
+	"huawei":    newHuaweiSigner,
+	"aliyun":    newAliyunSigner,
+	"aws_sigv4": newAWSSigV4Signer,
+	"hmac":      newHMACSigner,
+}}
+
+// signers caches the signers created, so credentials are loaded once rather than for every request
+var signers = struct {
+	sync.Mutex
+	m map[load.Signer]Signer
+}{m: map[load.Signer]Signer{}}
+
+// now is the time requests are signed at, replaced in tests
+var now = time.Now
+
+// Register adds the signer of a type
+// it panics if a signer of the same type is registered, like database/sql does for drivers
+func Register(signerType string, factory Factory) {
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := registry.factories[signerType]; ok {
+		panic(fmt.Sprintf("signer: Register called twice for type %s", signerType))
+	}
+	registry.factories[signerType] = factory
+}
+
+// Registered returns the registered signer types, sorted
+func Registered() []string {
+	registry.RLock()
+	defer registry.RUnlock()
+	types := make([]string, 0, len(registry.factories))
+	for signerType := range registry.factories {
+		types = append(types, signerType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Config returns the signer block of an api, converting the deprecated hw_signer and aliyun_signer blocks
+// the type is empty when the api signs nothing
+func Config(api load.API) load.Signer {
+	switch {
+	case api.Signer.Type != "":
+		return api.Signer
+	case api.HWSigner.Key != "" && api.HWSigner.Secret != "":
+		return load.Signer{Type: "huawei", Key: api.HWSigner.Key, Secret: api.HWSigner.Secret}
+	case api.AliyunSigner.Key != "" && api.AliyunSigner.Secret != "":
+		return load.Signer{Type: "aliyun", Key: api.AliyunSigner.Key, Secret: api.AliyunSigner.Secret}
+	}
+	return load.Signer{}
+}
+
+// WritesAuthorization reports whether the signer of a signer block sets the Authorization header
+func WritesAuthorization(cfg load.Signer) bool {
+	switch cfg.Type {
+	case "aws_sigv4", "huawei":
+		return true
+	case "hmac":
+		return cfg.Header == "" || strings.EqualFold(cfg.Header, "Authorization")
+	}
+	return false
+}
+
+// New returns the signer of a signer block, created on first use
+func New(cfg load.Signer) (Signer, error) {
+	signers.Lock()
+	defer signers.Unlock()
+	if s, ok := signers.m[cfg]; ok {
+		return s, nil
+	}
+
+	registry.RLock()
+	factory, ok := registry.factories[cfg.Type]
+	registry.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("signer: unknown type %s, registered types are %s", cfg.Type, strings.Join(Registered(), ", "))
+	}
+	s, err := factory(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("signer: %s, %v", cfg.Type, err)
+	}
+	signers.m[cfg] = s
+	return s, nil
+}
+
+// Body returns the body of a request without consuming it, signers hash it
+func Body(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	if r.GetBody != nil {
+		body, err := r.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		return ioutil.ReadAll(body)
+	}
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	_ = r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(b))
+	return b, nil
+}
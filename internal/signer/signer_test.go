@@ -0,0 +1,181 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package signer
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/newrelic/nri-flex/internal/load"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fixedNow(t *testing.T, at time.Time) {
+	now = func() time.Time { return at }
+	t.Cleanup(func() { now = time.Now })
+}
+
+func TestAWSSigV4Signer(t *testing.T) {
+	// the get-vanilla case of the aws signature version 4 test suite
+	fixedNow(t, time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	s, err := New(load.Signer{
+		Type:    "aws_sigv4",
+		Key:     "AKIDEXAMPLE",
+		Secret:  "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		Service: "service",
+		Region:  "us-east-1",
+	})
+	require.NoError(t, err)
+
+	r, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	require.NoError(t, err)
+	require.NoError(t, s.Sign(r))
+	assert.Equal(t, "20150830T123600Z", r.Header.Get("X-Amz-Date"))
+	assert.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, "+
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31", r.Header.Get("Authorization"))
+}
+
+func TestAWSSigV4SignerSessionToken(t *testing.T) {
+	s, err := New(load.Signer{Type: "aws_sigv4", Key: "AKID", Secret: "secret", SessionToken: "token", Service: "es", Region: "eu-west-1"})
+	require.NoError(t, err)
+
+	r, err := http.NewRequest(http.MethodPost, "https://search.eu-west-1.es.amazonaws.com/_search", strings.NewReader(`{"size":0}`))
+	require.NoError(t, err)
+	require.NoError(t, s.Sign(r))
+	assert.Equal(t, "token", r.Header.Get("X-Amz-Security-Token"))
+	assert.Contains(t, r.Header.Get("Authorization"), "/eu-west-1/es/aws4_request")
+
+	// the body is still there to be sent
+	body, err := ioutil.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Equal(t, `{"size":0}`, string(body))
+}
+
+func TestHMACSigner(t *testing.T) {
+	fixedNow(t, time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC))
+	tests := map[string]struct {
+		cfg     load.Signer
+		method  string
+		url     string
+		body    string
+		headers map[string]string
+	}{
+		"defaults": {
+			cfg:    load.Signer{Type: "hmac", Secret: "s3cr3t", DateHeader: "X-Date"},
+			method: http.MethodGet,
+			url:    "https://api.example.com/metrics?a=1",
+			headers: map[string]string{
+				"Authorization": "8a18d630d78d42973b3a505303bbbd238ccda6136b627a7fa4a3ba1b19887389",
+				"X-Date":        "Sun, 18 Oct 2026 12:00:00 GMT",
+			},
+		},
+		"template and value": {
+			cfg: load.Signer{
+				Type:      "hmac",
+				Key:       "flex",
+				Secret:    "s3cr3t",
+				Algorithm: "sha1",
+				Encoding:  "base64",
+				Header:    "X-Signature",
+				Template:  "{{.Method}} {{.Path}} {{.Timestamp}} {{.Body}}",
+				Value:     "key={{.Key}},ts={{.Timestamp}},sig={{.Signature}}",
+			},
+			method:  http.MethodPost,
+			url:     "https://api.example.com/orders",
+			body:    `{"id":1}`,
+			headers: map[string]string{"X-Signature": "key=flex,ts=1792324800,sig=ZU8tYDXFH4wmdFNXj4QJvV25IUg="},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s, err := New(tc.cfg)
+			require.NoError(t, err)
+			r, err := http.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+			require.NoError(t, err)
+			require.NoError(t, s.Sign(r))
+			for h, v := range tc.headers {
+				assert.Equal(t, v, r.Header.Get(h), h)
+			}
+		})
+	}
+}
+
+func TestAliyunSigner(t *testing.T) {
+	s, err := New(load.Signer{Type: "aliyun", Key: "key", Secret: "secret"})
+	require.NoError(t, err)
+	r, err := http.NewRequest(http.MethodGet, "https://ecs.aliyuncs.com/?Action=DescribeInstances", nil)
+	require.NoError(t, err)
+	require.NoError(t, s.Sign(r))
+	assert.Equal(t, "ecs.aliyuncs.com", r.URL.Host)
+	assert.Equal(t, "DescribeInstances", r.URL.Query().Get("Action"))
+	assert.Equal(t, "key", r.URL.Query().Get("AccessKeyId"))
+	assert.NotEmpty(t, r.URL.Query().Get("Signature"))
+}
+
+func TestNewErrors(t *testing.T) {
+	tests := map[string]struct {
+		cfg load.Signer
+		err string
+	}{
+		"unknown type":          {load.Signer{Type: "md5"}, "signer: unknown type md5, registered types are aliyun, aws_sigv4, hmac, huawei"},
+		"huawei without secret": {load.Signer{Type: "huawei", Key: "key"}, "signer: huawei, requires key and secret"},
+		"aws without service":   {load.Signer{Type: "aws_sigv4", Region: "us-east-1"}, "signer: aws_sigv4, requires service"},
+		"hmac without secret":   {load.Signer{Type: "hmac"}, "signer: hmac, requires secret"},
+		"hmac algorithm":        {load.Signer{Type: "hmac", Secret: "s", Algorithm: "md5"}, "signer: hmac, unsupported algorithm md5, use sha256, sha1 or sha512"},
+		"hmac encoding":         {load.Signer{Type: "hmac", Secret: "s", Encoding: "base32"}, "signer: hmac, unsupported encoding base32, use hex or base64"},
+	}
+	for name, tc := range tests {
+		_, err := New(tc.cfg)
+		assert.EqualError(t, err, tc.err, name)
+	}
+
+	_, err := New(load.Signer{Type: "hmac", Secret: "s", Template: "{{.Method"})
+	assert.Error(t, err)
+}
+
+func TestConfig(t *testing.T) {
+	tests := map[string]struct {
+		api  load.API
+		want load.Signer
+	}{
+		"signer block":   {load.API{Signer: load.Signer{Type: "hmac", Secret: "s"}}, load.Signer{Type: "hmac", Secret: "s"}},
+		"hw_signer":      {load.API{HWSigner: load.HWSigner{Key: "k", Secret: "s"}}, load.Signer{Type: "huawei", Key: "k", Secret: "s"}},
+		"aliyun_signer":  {load.API{AliyunSigner: load.AliyunSigner{Key: "k", Secret: "s"}}, load.Signer{Type: "aliyun", Key: "k", Secret: "s"}},
+		"signer first":   {load.API{Signer: load.Signer{Type: "aws_sigv4"}, HWSigner: load.HWSigner{Key: "k", Secret: "s"}}, load.Signer{Type: "aws_sigv4"}},
+		"nothing signed": {load.API{HWSigner: load.HWSigner{Key: "k"}}, load.Signer{}},
+	}
+	for name, tc := range tests {
+		assert.Equal(t, tc.want, Config(tc.api), name)
+	}
+}
+
+type headerSigner struct{}
+
+func (headerSigner) Sign(r *http.Request) error {
+	r.Header.Set("X-Signed", "yes")
+	return nil
+}
+
+func TestRegister(t *testing.T) {
+	Register("test_header", func(load.Signer) (Signer, error) { return headerSigner{}, nil })
+	defer func() {
+		registry.Lock()
+		delete(registry.factories, "test_header")
+		registry.Unlock()
+	}()
+
+	s, err := New(load.Signer{Type: "test_header"})
+	require.NoError(t, err)
+	r, _ := http.NewRequest(http.MethodGet, "http://localhost", nil)
+	require.NoError(t, s.Sign(r))
+	assert.Equal(t, "yes", r.Header.Get("X-Signed"))
+
+	assert.Panics(t, func() { Register("hmac", newHMACSigner) })
+}
@@ -0,0 +1,169 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package status serves the health, readiness and internal metrics of Flex over http
+// so they are available even when results fail to be published
+package status
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/newrelic/nri-flex/internal/load"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+var state = struct {
+	sync.RWMutex
+	ready         bool
+	publishes     int
+	publishErrors int
+	lastPublishMs int64 // last successful publish
+}{}
+
+// SetReady marks Flex as ready once its configs are loaded and scheduled
+func SetReady(ready bool) {
+	state.Lock()
+	state.ready = ready
+	state.Unlock()
+}
+
+// PublishCompleted records the outcome of publishing results
+func PublishCompleted(err error) {
+	state.Lock()
+	defer state.Unlock()
+	if err != nil {
+		state.publishErrors++
+		return
+	}
+	state.publishes++
+	state.lastPublishMs = load.TimestampMs()
+}
+
+// Serve starts serving /healthz, /readyz and /metrics on addr in the background
+func Serve(addr string) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("status: failed to listen on %s, %v", addr, err)
+	}
+	server := &http.Server{Handler: Handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			load.Logrus.WithError(err).Error("status: server stopped")
+		}
+	}()
+	load.Logrus.Infof("status: serving on %s", listener.Addr())
+	return server, nil
+}
+
+// Handler returns the handler of the status endpoints
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", healthz)
+	mux.HandleFunc("/readyz", readyz)
+	mux.HandleFunc("/metrics", metrics)
+	return mux
+}
+
+// healthz reports the process is alive and able to serve requests
+func healthz(w http.ResponseWriter, r *http.Request) {
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// readyz reports whether configs have been loaded and scheduled
+func readyz(w http.ResponseWriter, r *http.Request) {
+	state.RLock()
+	ready := state.ready
+	state.RUnlock()
+	if !ready {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// metrics writes the internal counters in the Prometheus text exposition format
+func metrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", string(expfmt.NewFormat(expfmt.TypeTextPlain)))
+	for _, family := range metricFamilies() {
+		if len(family.Metric) == 0 {
+			continue
+		}
+		if _, err := expfmt.MetricFamilyToText(w, family); err != nil {
+			load.Logrus.WithError(err).Error("status: failed to write metrics")
+			return
+		}
+	}
+}
+
+func metricFamilies() []*dto.MetricFamily {
+	state.RLock()
+	publishes, publishErrors, lastPublishMs := state.publishes, state.publishErrors, state.lastPublishMs
+	state.RUnlock()
+
+	families := []*dto.MetricFamily{
+		family("flex_publish_total", "Results published successfully.", dto.MetricType_COUNTER, sample{value: float64(publishes)}),
+		family("flex_publish_errors_total", "Results that failed to be published.", dto.MetricType_COUNTER, sample{value: float64(publishErrors)}),
+		family("flex_last_publish_timestamp_seconds", "When results were last published successfully.", dto.MetricType_GAUGE, sample{value: float64(lastPublishMs) / 1000}),
+	}
+
+	load.FlexStatusCounter.RLock()
+	counters := make([]sample, 0, len(load.FlexStatusCounter.M))
+	for name, value := range load.FlexStatusCounter.M {
+		counters = append(counters, sample{label: "counter", labelValue: name, value: float64(value)})
+	}
+	load.FlexStatusCounter.RUnlock()
+	families = append(families, family("flex_status_counter", "Counters reported on flexStatusSample, reset after every publish.", dto.MetricType_GAUGE, counters...))
+
+	var runs, errors, events, lastRun, duration []sample
+	for name, status := range load.ConfigStatusRead() {
+		runs = append(runs, sample{"config", name, float64(status.Runs)})
+		errors = append(errors, sample{"config", name, float64(status.Errors)})
+		events = append(events, sample{"config", name, float64(status.Events)})
+		lastRun = append(lastRun, sample{"config", name, float64(status.LastRunMs) / 1000})
+		duration = append(duration, sample{"config", name, float64(status.LastDurationMs) / 1000})
+	}
+	return append(families,
+		family("flex_config_runs_total", "Completed runs of each config.", dto.MetricType_COUNTER, runs...),
+		family("flex_config_errors_total", "Errors while running each config.", dto.MetricType_COUNTER, errors...),
+		family("flex_config_events_total", "Events created by each config.", dto.MetricType_COUNTER, events...),
+		family("flex_config_last_run_timestamp_seconds", "When each config last completed a run.", dto.MetricType_GAUGE, lastRun...),
+		family("flex_config_last_run_duration_seconds", "How long the last run of each config took.", dto.MetricType_GAUGE, duration...),
+	)
+}
+
+// sample is a single value of a metric, with an optional label
+type sample struct {
+	label      string
+	labelValue string
+	value      float64
+}
+
+// family builds a counter or gauge metric family, samples are sorted by label value for a stable output
+func family(name string, help string, metricType dto.MetricType, samples ...sample) *dto.MetricFamily {
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].labelValue < samples[j].labelValue
+	})
+	metrics := make([]*dto.Metric, 0, len(samples))
+	for i := range samples {
+		s := &samples[i]
+		m := &dto.Metric{}
+		if s.label != "" {
+			m.Label = []*dto.LabelPair{{Name: &s.label, Value: &s.labelValue}}
+		}
+		if metricType == dto.MetricType_COUNTER {
+			m.Counter = &dto.Counter{Value: &s.value}
+		} else {
+			m.Gauge = &dto.Gauge{Value: &s.value}
+		}
+		metrics = append(metrics, m)
+	}
+	return &dto.MetricFamily{Name: &name, Help: &help, Type: &metricType, Metric: metrics}
+}
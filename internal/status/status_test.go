@@ -0,0 +1,99 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package status
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-flex/internal/load"
+)
+
+func get(t *testing.T, server *httptest.Server, path string) (int, string) {
+	resp, err := http.Get(server.URL + path)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(body)
+}
+
+func TestHealthAndReadiness(t *testing.T) {
+	server := httptest.NewServer(Handler())
+	defer server.Close()
+	defer SetReady(false)
+
+	code, _ := get(t, server, "/healthz")
+	assert.Equal(t, http.StatusOK, code)
+
+	SetReady(false)
+	code, _ = get(t, server, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+
+	SetReady(true)
+	code, _ = get(t, server, "/readyz")
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestMetrics(t *testing.T) {
+	load.Refresh()
+	load.StatusCounterIncrement("EventCount")
+	load.ConfigRunCompleted(&load.Config{Name: "redis"}, time.Now().Add(-1500*time.Millisecond))
+	load.ConfigEvent("redis", "info")
+	load.ConfigEvent("redis", "info")
+	load.ConfigError("nginx", "status", errors.New("connection refused"))
+	PublishCompleted(nil)
+	PublishCompleted(errors.New("connection refused"))
+
+	server := httptest.NewServer(Handler())
+	defer server.Close()
+
+	code, body := get(t, server, "/metrics")
+	assert.Equal(t, http.StatusOK, code)
+
+	expected := []string{
+		"# TYPE flex_publish_total counter\nflex_publish_total 1\n",
+		"flex_publish_errors_total 1\n",
+		"# TYPE flex_status_counter gauge\n",
+		`flex_status_counter{counter="EventCount"} 1` + "\n",
+		`flex_config_runs_total{config="redis"} 1` + "\n",
+		`flex_config_events_total{config="redis"} 2` + "\n",
+		`flex_config_errors_total{config="nginx"} 1` + "\n",
+		`flex_config_errors_total{config="redis"} 0` + "\n",
+		`flex_config_last_run_duration_seconds{config="redis"} 1.5`,
+	}
+	for _, line := range expected {
+		assert.Contains(t, body, line)
+	}
+}
+
+func TestMetricsWhileCountersReset(t *testing.T) {
+	load.Refresh()
+	server := httptest.NewServer(Handler())
+	defer server.Close()
+
+	// the daemon resets the counters after every publish while the endpoint may be scraped, run with -race
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			load.StatusCounterIncrement("EventCount")
+			load.StatusCounterReset()
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		code, _ := get(t, server, "/metrics")
+		assert.Equal(t, http.StatusOK, code)
+	}
+	<-done
+}
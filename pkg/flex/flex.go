@@ -0,0 +1,97 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package flex runs Flex configs from within another Go program.
+//
+// A Runner holds its own configs, arguments and results, nothing is published to the infrastructure agent
+// and the samples produced are returned to the caller instead. Runners do not share any state,
+// different runners can run at the same time.
+package flex
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/newrelic/infra-integrations-sdk/persist"
+	"github.com/newrelic/nri-flex/internal/config"
+	"github.com/newrelic/nri-flex/internal/load"
+)
+
+// Config is a Flex config, as read from a config file
+type Config = load.Config
+
+// API is an api of a Flex config
+type API = load.API
+
+// Command is a command run by an api
+type Command = load.Command
+
+// Args are the arguments Flex is run with, as set by flags when running the nri-flex binary
+type Args = load.ArgumentList
+
+// Metrics is a batch of dimensional metrics, created by apis that use the metric api output
+type Metrics = load.Metrics
+
+// Sample is an event produced by a config, its event_type attribute names the kind of sample
+type Sample = map[string]interface{}
+
+// Result holds everything produced by a run
+type Result struct {
+	Samples []Sample
+	Metrics []Metrics
+	Errors  []error // configs that failed to run, errors of single apis are reported as flexError samples
+}
+
+// Runner runs a set of configs, each call to Run starts from a clean state
+// values kept between runs, such as those used to compute RATE and DELTA metrics, stay with the runner
+type Runner struct {
+	configs []Config
+	args    Args
+	storer  persist.Storer
+}
+
+// NewRunner returns a runner for the configs given as options
+func NewRunner(opts ...Option) (*Runner, error) {
+	args, err := load.DefaultArguments()
+	if err != nil {
+		return nil, fmt.Errorf("flex: failed to set default arguments, %v", err)
+	}
+	r := &Runner{args: args, storer: persist.NewInMemoryStore()}
+	for _, opt := range opts {
+		if err := opt(r); err != nil {
+			return nil, err
+		}
+	}
+	if len(r.configs) == 0 {
+		return nil, fmt.Errorf("flex: no configs to run")
+	}
+	return r, nil
+}
+
+// Configs returns the configs the runner runs
+func (r *Runner) Configs() []Config {
+	configs := make([]Config, len(r.configs))
+	copy(configs, r.configs)
+	return configs
+}
+
+// Run runs every config once and returns what they produced
+// cancelling ctx stops the configs still running, Run then returns what was produced so far along with ctx.Err()
+func (r *Runner) Run(ctx context.Context) (*Result, error) {
+	state := load.NewState(r.args, r.storer)
+
+	configs := r.Configs()
+	for n := range configs {
+		configs[n].SetContext(ctx)
+	}
+
+	result := &Result{Errors: config.RunFiles(state, &configs)}
+	for _, metricSet := range state.MetricSets() {
+		result.Samples = append(result.Samples, metricSet.Metrics)
+	}
+	result.Metrics = state.Metrics()
+
+	return result, ctx.Err()
+}
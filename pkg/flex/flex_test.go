@@ -0,0 +1,308 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package flex
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-flex/internal/load"
+)
+
+func samplesByEventType(result *Result, eventType string) []Sample {
+	var samples []Sample
+	for _, sample := range result.Samples {
+		if sample["event_type"] == eventType {
+			samples = append(samples, sample)
+		}
+	}
+	return samples
+}
+
+func TestRunYAML(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses unix commands")
+	}
+	yml := []byte(`
+name: redis
+apis:
+  - name: info
+    commands:
+      - run: echo "connected_clients:5"
+        split_by: ":"
+`)
+	runner, err := NewRunner(WithYAML("redis.yml", yml))
+	require.NoError(t, err)
+	require.Len(t, runner.Configs(), 1)
+	assert.Equal(t, "redis.yml", runner.Configs()[0].FileName)
+
+	result, err := runner.Run(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, result.Errors)
+	samples := samplesByEventType(result, "infoSample")
+	require.Len(t, samples, 1)
+	assert.Equal(t, float64(5), samples[0]["connected_clients"])
+
+	// each run starts from a clean state
+	result, err = runner.Run(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, samplesByEventType(result, "infoSample"), 1)
+}
+
+func TestRunnersAreIndependent(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses unix commands")
+	}
+	entity, args := load.Entity, load.Args
+
+	first, err := NewRunner(WithConfigs(Config{
+		Name: "first",
+		APIs: []API{{Name: "first", Commands: []Command{{Run: "echo value:1", SplitBy: ":"}}}},
+	}))
+	require.NoError(t, err)
+	second, err := NewRunner(WithEventLimit(1), WithConfigs(Config{
+		Name: "second",
+		APIs: []API{{Name: "second", Commands: []Command{{Run: "printf 'value:1\nvalue:2\n'", SplitBy: ":"}}}},
+	}))
+	require.NoError(t, err)
+
+	// runners run at the same time, run with -race to check they share nothing
+	var firstResult, secondResult *Result
+	var firstErr, secondErr error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		firstResult, firstErr = first.Run(context.Background())
+	}()
+	go func() {
+		defer wg.Done()
+		secondResult, secondErr = second.Run(context.Background())
+	}()
+	wg.Wait()
+	require.NoError(t, firstErr)
+	require.NoError(t, secondErr)
+
+	assert.Len(t, samplesByEventType(firstResult, "firstSample"), 1)
+	assert.Empty(t, samplesByEventType(firstResult, "secondSample"))
+	assert.Empty(t, samplesByEventType(secondResult, "firstSample"))
+	assert.Len(t, samplesByEventType(secondResult, "secondSample"), 1, "the event limit applies to its own runner")
+	assert.Equal(t, 0, load.Args.EventLimit, "the package arguments must be left as they were")
+	assert.Equal(t, entity, load.Entity)
+	assert.Equal(t, args, load.Args)
+}
+
+func TestRunInputBlock(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses unix commands")
+	}
+	// built in inputs are configured by their block like any other input
+	yml := []byte(`
+name: redis
+apis:
+  - name: info
+    input:
+      commands:
+        commands:
+          - run: echo "connected_clients:5"
+            split_by: ":"
+`)
+	runner, err := NewRunner(WithYAML("redis.yml", yml))
+	require.NoError(t, err)
+
+	result, err := runner.Run(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, result.Errors)
+	samples := samplesByEventType(result, "infoSample")
+	require.Len(t, samples, 1)
+	assert.Equal(t, float64(5), samples[0]["connected_clients"])
+	assert.Empty(t, runner.Configs()[0].APIs[0].Commands, "the configs of the runner are left as they were")
+}
+
+func TestRunKeepsDeltas(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses unix commands")
+	}
+	counter := filepath.Join(t.TempDir(), "counter")
+	require.NoError(t, ioutil.WriteFile(counter, []byte("requests:10\n"), 0600))
+
+	runner, err := NewRunner(WithConfigs(Config{
+		Name: "deltas",
+		APIs: []API{{
+			Name:     "requests",
+			Commands: []Command{{Run: "cat " + counter, SplitBy: ":"}},
+			MetricParser: load.MetricParser{
+				Metrics:   map[string]string{"requests": "DELTA"},
+				Namespace: load.Namespace{CustomAttr: "requests"},
+			},
+		}},
+	}))
+	require.NoError(t, err)
+
+	result, err := runner.Run(context.Background())
+	require.NoError(t, err)
+	samples := samplesByEventType(result, "requestsSample")
+	require.Len(t, samples, 1)
+	assert.Equal(t, float64(0), samples[0]["requests"], "the first value has nothing to compare to")
+
+	// the store keeps values by the second
+	time.Sleep(1100 * time.Millisecond)
+	require.NoError(t, ioutil.WriteFile(counter, []byte("requests:25\n"), 0600))
+
+	result, err = runner.Run(context.Background())
+	require.NoError(t, err)
+	samples = samplesByEventType(result, "requestsSample")
+	require.Len(t, samples, 1)
+	assert.Equal(t, float64(15), samples[0]["requests"], "the previous run's value is kept by the runner")
+}
+
+func TestRunCancelled(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses unix commands")
+	}
+	runner, err := NewRunner(WithConfigs(Config{
+		Name: "slow",
+		APIs: []API{{Name: "sleepy", Commands: []Command{{Run: "sleep 5", Timeout: 10000}}}},
+	}))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = runner.Run(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Less(t, int64(time.Since(start)), int64(3*time.Second))
+}
+
+func TestNewRunnerErrors(t *testing.T) {
+	tests := map[string][]Option{
+		"no configs":   nil,
+		"unnamed":      {WithConfigs(Config{})},
+		"invalid yaml": {WithYAML("broken.yml", []byte("name: [broken"))},
+	}
+	for name, opts := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewRunner(opts...)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestDefaultArguments(t *testing.T) {
+	runner, err := NewRunner(WithConfigs(Config{Name: "defaults"}))
+	require.NoError(t, err)
+	assert.True(t, runner.args.Local)
+	assert.Equal(t, "flexConfigs/", runner.args.ConfigDir)
+	assert.Equal(t, 5000, runner.args.InsightBatchSize)
+}
+
+// queueInput reads the depth of queues from its own config block
+type queueInput struct{}
+
+type queueConfig struct {
+	Queues map[string]int `yaml:"queues"`
+}
+
+func (queueInput) Name() string { return "testQueue" }
+
+func (queueInput) Matches(api API) bool {
+	_, ok := api.Input["testQueue"]
+	return ok
+}
+
+func (queueInput) Validate(api API) error {
+	return DecodeInputConfig(api, "testQueue", &queueConfig{})
+}
+
+func (queueInput) Fetch(dataStore *[]interface{}, yml *Config, apiNo int) error {
+	var cfg queueConfig
+	if err := DecodeInputConfig(yml.APIs[apiNo], "testQueue", &cfg); err != nil {
+		return err
+	}
+	for name, depth := range cfg.Queues {
+		*dataStore = append(*dataStore, map[string]interface{}{"queue": name, "depth": depth})
+	}
+	return nil
+}
+
+func TestRegisterInput(t *testing.T) {
+	RegisterInput(queueInput{})
+	assert.Panics(t, func() { RegisterInput(queueInput{}) })
+
+	yml := []byte(`
+name: queues
+apis:
+  - name: depth
+    input:
+      testQueue:
+        queues:
+          orders: 3
+`)
+	runner, err := NewRunner(WithYAML("queues.yml", yml))
+	require.NoError(t, err)
+	result, err := runner.Run(context.Background())
+	require.NoError(t, err)
+
+	samples := samplesByEventType(result, "depthSample")
+	require.Len(t, samples, 1)
+	assert.Equal(t, "orders", samples[0]["queue"])
+	assert.Equal(t, float64(3), samples[0]["depth"])
+}
+
+// tokenSigner sets its secret in a header
+type tokenSigner struct {
+	secret string
+}
+
+func (s tokenSigner) Sign(r *http.Request) error {
+	r.Header.Set("X-Token", s.secret)
+	return nil
+}
+
+func TestRegisterSigner(t *testing.T) {
+	RegisterSigner("testToken", func(cfg SignerConfig) (Signer, error) {
+		if cfg.Secret == "" {
+			return nil, fmt.Errorf("requires secret")
+		}
+		return tokenSigner{secret: cfg.Secret}, nil
+	})
+	assert.Panics(t, func() { RegisterSigner("testToken", nil) })
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"token":%q}`, r.Header.Get("X-Token"))
+	}))
+	defer ts.Close()
+
+	yml := []byte(fmt.Sprintf(`
+name: signed
+apis:
+  - name: signed
+    url: %s
+    signer:
+      type: testToken
+      secret: s3cr3t
+`, ts.URL))
+	runner, err := NewRunner(WithYAML("signed.yml", yml))
+	require.NoError(t, err)
+	result, err := runner.Run(context.Background())
+	require.NoError(t, err)
+
+	samples := samplesByEventType(result, "signedSample")
+	require.Len(t, samples, 1)
+	assert.Equal(t, "s3cr3t", samples[0]["token"])
+}
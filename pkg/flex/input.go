@@ -0,0 +1,32 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package flex
+
+import (
+	"github.com/newrelic/nri-flex/internal/inputs"
+)
+
+// Input is a source of data for an api, see RegisterInput
+type Input = inputs.Input
+
+// RegisterInput adds an input to the ones built into Flex, for every config run in the process
+// inputs usually match the apis that have a block named after them under input, eg.
+//
+//	apis:
+//	  - name: lag
+//	    input:
+//	      kafka:
+//	        brokers: localhost:9092
+//
+// it panics if an input of the same name is already registered, so it is best called from an init function
+func RegisterInput(input Input) {
+	inputs.Register(input)
+}
+
+// DecodeInputConfig decodes the block of the input name of api into out, unknown keys are an error
+func DecodeInputConfig(api API, name string, out interface{}) error {
+	return inputs.DecodeConfig(api, name, out)
+}
@@ -0,0 +1,78 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package flex
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/newrelic/nri-flex/internal/config"
+)
+
+// Option configures a Runner
+type Option func(r *Runner) error
+
+// WithConfigs adds configs to run
+func WithConfigs(configs ...Config) Option {
+	return func(r *Runner) error {
+		for _, cfg := range configs {
+			if cfg.Name == "" {
+				return fmt.Errorf("flex: config requires a name")
+			}
+			r.configs = append(r.configs, cfg)
+		}
+		return nil
+	}
+}
+
+// WithYAML adds the configs of a config file, fileName is used to report where samples came from
+// environment variables and timestamps are substituted as when reading a config file
+func WithYAML(fileName string, yml []byte) Option {
+	return func(r *Runner) error {
+		before := len(r.configs)
+		if err := config.LoadBytes(&r.configs, yml, fileName, ""); err != nil {
+			return fmt.Errorf("flex: failed to load %s, %v", fileName, err)
+		}
+		if len(r.configs) == before {
+			return fmt.Errorf("flex: no configs found in %s", fileName)
+		}
+		return nil
+	}
+}
+
+// WithArgs replaces the default arguments, such as event_limit or process_configs_sync
+// arguments that only apply to the nri-flex binary, like config_dir or status_addr, are ignored
+func WithArgs(args Args) Option {
+	return func(r *Runner) error {
+		r.args = args
+		return nil
+	}
+}
+
+// WithEventLimit limits the number of samples a run produces, 0 disables the limit
+func WithEventLimit(limit int) Option {
+	return func(r *Runner) error {
+		r.args.EventLimit = limit
+		return nil
+	}
+}
+
+// WithConfigTimeout sets the time budget of configs that do not set a timeout
+func WithConfigTimeout(timeout time.Duration) Option {
+	return func(r *Runner) error {
+		r.args.ConfigTimeout = timeout.String()
+		return nil
+	}
+}
+
+// WithEntity attaches the samples to a remote entity instead of the local one
+func WithEntity(name string) Option {
+	return func(r *Runner) error {
+		r.args.Local = false
+		r.args.Entity = name
+		return nil
+	}
+}
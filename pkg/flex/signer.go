@@ -0,0 +1,26 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package flex
+
+import (
+	"github.com/newrelic/nri-flex/internal/load"
+	"github.com/newrelic/nri-flex/internal/signer"
+)
+
+// Signer signs the requests of an http api before they are sent, see RegisterSigner
+type Signer = signer.Signer
+
+// SignerConfig is the signer block of an api
+type SignerConfig = load.Signer
+
+// SignerFactory creates the signer of a signer block, it checks the config the signer needs
+type SignerFactory = signer.Factory
+
+// RegisterSigner adds a signer that the signer block of an api can refer to by type, for every config run in the process
+// it panics if a signer of the same type exists, so it is best called from an init function
+func RegisterSigner(signerType string, factory SignerFactory) {
+	signer.Register(signerType, factory)
+}
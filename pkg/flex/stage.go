@@ -0,0 +1,23 @@
+/*
+* Copyright 2019 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package flex
+
+import (
+	"github.com/newrelic/nri-flex/internal/processor"
+)
+
+// StageData is the sample a stage of a pipeline processes
+type StageData = processor.StageData
+
+// Stage processes a sample at a step of a pipeline, see RegisterStage
+type Stage = processor.Stage
+
+// RegisterStage adds a stage that the pipeline of an api can refer to by name, for every config run in the process
+// the keys of the step other than stage are in data.Step.Config
+// it panics if a stage of the same name exists, so it is best called from an init function
+func RegisterStage(name string, stage Stage) {
+	processor.RegisterStage(name, stage)
+}